@@ -6,39 +6,78 @@
 db = db.getSiblingDB('zbiRepo');
 db.createUser({user: 'zbiadmin', pwd: 'password', roles: [{role: 'readWrite', db: 'zbiRepo'}]})
 
+// keep in sync with the index definitions in pkg/mongodb/mongodb.go, the repository reconciles them on startup
 let res = [
     db.createCollection("users"),
-    db.users.createIndex({ "userid": 1, "email": 1 }, { unique: true }),
+    db.users.createIndex({ "userid": 1, "email": 1 }, { name: "userid_email", unique: true }),
+    db.users.createIndex({ "userid": 1 }, { name: "userid", unique: true }),
+    db.users.createIndex({ "email": 1 }, { name: "email", unique: true, partialFilterExpression: { "email": { $gt: "" } } }),
 
-    db.createCollection("passwords"),
-    db.passwords.createIndex({"userid": 1}, {unique: true}),
+    db.createCollection("password"),
+    db.password.createIndex({ "userid": 1 }, { name: "userid", unique: true }),
 
     db.createCollection("user_policy"),
-    db.user_policy.createIndex({ "userid": 1 }, { unique: true }),
+    db.user_policy.createIndex({ "userid": 1 }, { name: "userid", unique: true }),
 
     db.createCollection("apikeys"),
-    db.apikeys.createIndex({ "key": 1, "userid": 1 }, { unique: true }),
+    db.apikeys.createIndex({ "key": 1, "userid": 1 }, { name: "key_userid", unique: true }),
+    db.apikeys.createIndex({ "key": 1 }, { name: "key", unique: true }),
+    db.apikeys.createIndex({ "userid": 1 }, { name: "userid" }),
+    db.apikeys.createIndex({ "expires": 1 }, { name: "expires" }),
 
     db.createCollection("apikey_policy"),
-    db.apikey_policy.createIndex({ "key": 1}, { unique: true }),
+    db.apikey_policy.createIndex({ "key": 1 }, { name: "key", unique: true }),
 
     db.createCollection("teams"),
-    db.teams.createIndex({ "teamid": 1, "owner": 1 }, { unique: true }),
+    db.teams.createIndex({ "teamid": 1, "owner": 1 }, { name: "teamid_owner", unique: true }),
+    db.teams.createIndex({ "teamid": 1 }, { name: "teamid", unique: true }),
+    db.teams.createIndex({ "name": 1 }, { name: "name" }),
+    db.teams.createIndex({ "owner": 1 }, { name: "owner" }),
 
     db.createCollection("team_members"),
-    db.team_members.createIndex({ "teamid": 1, "email": 1, "key": 1}, { unique: true }),
+    db.team_members.createIndex({ "teamid": 1, "email": 1, "key": 1 }, { name: "teamid_email_key", unique: true }),
+    db.team_members.createIndex({ "email": 1 }, { name: "email" }),
+    db.team_members.createIndex({ "key": 1 }, { name: "key", unique: true }),
+
+    db.createCollection("sessions"),
+    db.sessions.createIndex({ "userid": 1, "device": 1 }, { name: "userid_device" }),
+    db.sessions.createIndex({ "expires": 1 }, { name: "expires", expireAfterSeconds: 0 }),
+
+    db.createCollection("revoked_tokens"),
+    db.revoked_tokens.createIndex({ "expires": 1 }, { name: "expires", expireAfterSeconds: 0 }),
+
+    db.createCollection("token_watermarks"),
+    db.token_watermarks.createIndex({ "expires": 1 }, { name: "expires", expireAfterSeconds: 0 }),
+
+    db.createCollection("login_attempts"),
+    db.login_attempts.createIndex({ "expires": 1 }, { name: "expires", expireAfterSeconds: 0 }),
+
+    db.createCollection("password_history"),
+
+    db.createCollection("reset_tokens"),
+    db.reset_tokens.createIndex({ "userid": 1 }, { name: "userid" }),
+    db.reset_tokens.createIndex({ "expires": 1 }, { name: "expires", expireAfterSeconds: 0 }),
+
+    db.createCollection("mfa"),
+
+    db.createCollection("service_accounts"),
+    db.service_accounts.createIndex({ "teamid": 1 }, { name: "teamid" }),
 
     db.createCollection("projects"),
-    db.projects.createIndex({ "name": 1, "owner": 1, "team": 1}, { unique: true }),
+    db.projects.createIndex({ "name": 1, "owner": 1, "team": 1 }, { name: "name_owner_team", unique: true }),
+    db.projects.createIndex({ "owner": 1 }, { name: "owner" }),
+    db.projects.createIndex({ "team": 1 }, { name: "team" }),
 
     db.createCollection("instances"),
-    db.instances.createIndex({ "project": 1, "name": 1, "type": 1, "owner": 1}, { unique: true }),
+    db.instances.createIndex({ "project": 1, "name": 1, "type": 1, "owner": 1 }, { name: "project_name_type_owner", unique: true }),
+    db.instances.createIndex({ "owner": 1 }, { name: "owner" }),
 
     db.createCollection("k8s_resources"),
-    db.k8s_resources.createIndex({ "project": 1, "instance": 1, "type": 1, "name": 1}, { unique: true }),
+    db.k8s_resources.createIndex({ "project": 1, "instance": 1, "resource.type": 1, "resource.name": 1 }, { name: "project_instance_type_name", unique: true }),
+    db.k8s_resources.createIndex({ "level": 1, "project": 1, "instance": 1 }, { name: "level_project_instance" }),
 
     db.createCollection("instance_policy"),
-    db.instance_policy.createIndex({ "project": 1, "instance": 1 }, { unique: true }),
+    db.instance_policy.createIndex({ "project": 1, "instance": 1 }, { name: "project_instance", unique: true }),
 ]
 
 printjson(res)
@@ -0,0 +1,480 @@
+// Package admintest checks that the admin repositories of the backends behave alike. Each backend calls Run from
+// its tests with a function that returns an empty repository.
+package admintest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zbitech/common/interfaces"
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"github.com/zbitech/repo/pkg/lockout"
+	"github.com/zbitech/repo/pkg/mfa"
+	"github.com/zbitech/repo/pkg/password"
+	"github.com/zbitech/repo/pkg/reset"
+	"github.com/zbitech/repo/pkg/revocation"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+	"github.com/zbitech/repo/pkg/session"
+)
+
+// Store is an admin repository with all the optional stores
+type Store interface {
+	interfaces.AdminRepositoryIF
+	session.Store
+	revocation.Store
+	lockout.Store
+	password.Store
+	reset.Store
+	mfa.Store
+	apikeys.Store
+	serviceaccount.Store
+}
+
+// Run runs each check against a new repository from newStore
+func Run(t *testing.T, newStore func(t *testing.T) Store) {
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo Store)
+	}{
+		{"Sessions", testSessions},
+		{"Revocations", testRevocations},
+		{"LoginAttempts", testLoginAttempts},
+		{"PasswordHistory", testPasswordHistory},
+		{"ResetTokens", testResetTokens},
+		{"MFA", testMFA},
+		{"ScopedAPIKeys", testScopedAPIKeys},
+		{"RotateAPIKeyRecord", testRotateAPIKeyRecord},
+		{"APIKeyUsage", testAPIKeyUsage},
+		{"ServiceAccounts", testServiceAccounts},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore(t))
+		})
+	}
+}
+
+func testSessions(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+
+	first, token, err := session.Start(ctx, repo, "tester", "laptop", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected session to start but got err - %s", err)
+	}
+
+	if _, _, err = session.Start(ctx, repo, "tester", "phone", time.Hour); err != nil {
+		t.Fatalf("Expected session to start but got err - %s", err)
+	}
+
+	next, nextToken, err := session.Refresh(ctx, repo, token, time.Hour)
+	if err != nil || next.Id != first.Id || nextToken == token {
+		t.Fatalf("Expected session to be refreshed with a new token but got %v - %v", next, err)
+	}
+
+	if _, _, err = session.Refresh(ctx, repo, token, time.Hour); err != session.ErrRefreshTokenReused {
+		t.Fatalf("Expected reused token to be rejected but got %v", err)
+	}
+
+	if _, _, err = session.Refresh(ctx, repo, nextToken, time.Hour); err != session.ErrInvalidRefreshToken {
+		t.Fatalf("Expected session to be revoked after reuse but got %v", err)
+	}
+
+	if sessions, err := repo.GetSessions(ctx, "tester"); err != nil || len(sessions) != 1 || sessions[0].Device != "phone" {
+		t.Fatalf("Expected the phone session to remain but got %v - %v", sessions, err)
+	}
+
+	if _, _, err = session.Start(ctx, repo, "tester", "phone", -time.Minute); err != nil {
+		t.Fatalf("Expected session to start but got err - %s", err)
+	}
+
+	if sessions, err := repo.GetSessions(ctx, "tester"); err != nil || len(sessions) != 1 {
+		t.Fatalf("Expected new login to replace the device session but got %v - %v", sessions, err)
+	}
+
+	if count, err := repo.PurgeExpiredSessions(ctx, time.Now()); err != nil || count != 1 {
+		t.Fatalf("Expected expired session to be purged but got %d - %v", count, err)
+	}
+
+	if count, err := repo.DeleteSessions(ctx, "tester"); err != nil || count != 0 {
+		t.Fatalf("Expected no sessions left but got %d - %v", count, err)
+	}
+}
+
+func testRevocations(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+	now := time.Now()
+
+	if err := revocation.Check(ctx, repo, "tester", "token", now); err != nil {
+		t.Fatalf("Expected token to be accepted but got %v", err)
+	}
+
+	token := &revocation.RevokedToken{Id: "token", UserId: "tester", Revoked: now, Expires: now.Add(time.Minute)}
+	if err := repo.RevokeToken(ctx, token); err != nil {
+		t.Fatalf("Expected token to be revoked but got err - %s", err)
+	}
+
+	if err := revocation.Check(ctx, repo, "tester", "token", now); err != revocation.ErrTokenRevoked {
+		t.Fatalf("Expected revoked token to be rejected but got %v", err)
+	}
+
+	if err := repo.SetWatermark(ctx, revocation.NewWatermark("tester", now, -time.Second)); err != nil {
+		t.Fatalf("Expected watermark to be set but got err - %s", err)
+	}
+
+	if err := revocation.Check(ctx, repo, "tester", "other", now.Add(-time.Hour)); err != revocation.ErrTokenRevoked {
+		t.Fatalf("Expected token issued before the watermark to be rejected but got %v", err)
+	}
+
+	if err := revocation.Check(ctx, repo, "tester", "other", now.Add(time.Second)); err != nil {
+		t.Fatalf("Expected token issued after the watermark to be accepted but got %v", err)
+	}
+
+	if count, err := repo.PurgeExpiredRevocations(ctx, now); err != nil || count != 1 {
+		t.Fatalf("Expected expired watermark to be purged but got %d - %v", count, err)
+	}
+
+	if count, err := repo.PurgeExpiredRevocations(ctx, now.Add(time.Hour)); err != nil || count != 1 {
+		t.Fatalf("Expected expired token to be purged but got %d - %v", count, err)
+	}
+
+	if revoked, err := repo.IsTokenRevoked(ctx, "token"); err != nil || revoked {
+		t.Fatalf("Expected purged token to be forgotten but got %v - %v", revoked, err)
+	}
+}
+
+func testLoginAttempts(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+	now := time.Now()
+
+	if _, err := repo.GetAttempts(ctx, lockout.UserKey("tester")); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected no attempts but got %v", err)
+	}
+
+	guard := lockout.NewGuard(lockout.DefaultPolicy())
+	for i := 0; i < lockout.DEFAULT_THRESHOLD; i++ {
+		if err := guard.Failed(ctx, repo, "tester", "10.0.0.1", now); err != nil {
+			t.Fatalf("Expected failure to be recorded but got err - %s", err)
+		}
+	}
+
+	if err := guard.Check(ctx, repo, "tester", "", now); !errors.Is(err, lockout.ErrAccountLocked) {
+		t.Fatalf("Expected account to be locked but got %v", err)
+	}
+
+	if err := repo.DeleteAttempts(ctx, lockout.UserKey("tester")); err != nil {
+		t.Fatalf("Expected attempts to be deleted but got err - %s", err)
+	}
+
+	if err := repo.DeleteAttempts(ctx, lockout.UserKey("tester")); err != nil {
+		t.Fatalf("Expected deleting missing attempts to succeed but got err - %s", err)
+	}
+
+	if count, err := repo.PurgeExpiredAttempts(ctx, now.Add(time.Hour)); err != nil || count != 1 {
+		t.Fatalf("Expected the source attempts to be purged but got %d - %v", count, err)
+	}
+}
+
+func testPasswordHistory(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+
+	if _, err := repo.GetPasswordHistory(ctx, "tester"); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected no history but got %v", err)
+	}
+
+	if _, err := repo.GetPassword(ctx, "tester"); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected no password but got %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	history := &password.History{UserId: "tester"}
+	history.Add("first", 2, now.Add(-time.Hour))
+	history.Add("second", 2, now)
+	history.Add("third", 2, now)
+	if err := repo.PutPasswordHistory(ctx, history); err != nil {
+		t.Fatalf("Expected history to be stored but got err - %s", err)
+	}
+
+	stored, err := repo.GetPasswordHistory(ctx, "tester")
+	if err != nil {
+		t.Fatalf("Expected history but got err - %s", err)
+	}
+	if len(stored.Hashes) != 2 || stored.Hashes[0] != "third" || stored.Hashes[1] != "second" {
+		t.Fatalf("Expected the last 2 hashes newest first but got %v", stored.Hashes)
+	}
+	if !now.Equal(stored.Changed) {
+		t.Fatalf("Expected changed %s but got %s", now, stored.Changed)
+	}
+}
+
+func testResetTokens(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+	now := time.Now()
+
+	first, _, _ := reset.NewToken("tester", now, time.Hour)
+	second, _, _ := reset.NewToken("tester", now, time.Hour)
+	other, _, _ := reset.NewToken("other", now, time.Minute)
+	for _, token := range []*reset.ResetToken{first, second, other} {
+		if err := repo.CreateResetToken(ctx, token); err != nil {
+			t.Fatalf("Expected reset token to be stored but got err - %s", err)
+		}
+	}
+
+	if _, err := repo.GetResetToken(ctx, first.Hash); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected the first token to be replaced but got %v", err)
+	}
+
+	stored, err := repo.GetResetToken(ctx, second.Hash)
+	if err != nil || stored.UserId != "tester" {
+		t.Fatalf("Expected the second token but got %v - %v", stored, err)
+	}
+
+	if err = repo.ConsumeResetToken(ctx, second.Hash); err != nil {
+		t.Fatalf("Expected the token to be used but got err - %s", err)
+	}
+
+	if err = repo.ConsumeResetToken(ctx, second.Hash); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected the token to be used once but got %v", err)
+	}
+
+	if count, err := repo.PurgeExpiredResetTokens(ctx, now.Add(30*time.Minute)); err != nil || count != 1 {
+		t.Fatalf("Expected the expired token to be purged but got %d - %v", count, err)
+	}
+}
+
+func testMFA(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	if _, err := repo.GetMFA(ctx, "tester"); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected no enrollment but got %v", err)
+	}
+
+	e := &mfa.Enrollment{UserId: "tester", Secret: "sealed", RecoveryCodes: []string{"a", "b"}, Updated: now}
+	if err := repo.PutMFA(ctx, e); err != nil {
+		t.Fatalf("Expected enrollment to be stored but got err - %s", err)
+	}
+
+	e.Confirmed = true
+	e.LastStep = 42
+	if err := repo.PutMFA(ctx, e); err != nil {
+		t.Fatalf("Expected enrollment to be replaced but got err - %s", err)
+	}
+
+	stored, err := repo.GetMFA(ctx, "tester")
+	if err != nil {
+		t.Fatalf("Expected enrollment but got err - %s", err)
+	}
+	if !stored.Active() || stored.Secret != "sealed" || stored.LastStep != 42 || len(stored.RecoveryCodes) != 2 {
+		t.Fatalf("Expected the confirmed enrollment but got %v", stored)
+	}
+	if !now.Equal(stored.Updated) {
+		t.Fatalf("Expected updated %s but got %s", now, stored.Updated)
+	}
+}
+
+func testScopedAPIKeys(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+
+	now := time.Now()
+	record, key, err := apikeys.New("tester", now.Add(-2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatalf("Unable to create key - %s", err)
+	}
+	record.Scope = apikeys.Scope{ReadOnly: true, Projects: []string{"proj1"}}
+
+	if err = repo.CreateAPIKeyRecord(ctx, record); err != nil {
+		t.Fatalf("Expected key to be stored but got err - %s", err)
+	}
+
+	stored, err := repo.GetAPIKeyRecord(ctx, record.Key)
+	if err != nil || !stored.Scope.ReadOnly || len(stored.Scope.Projects) != 1 || stored.Scope.Projects[0] != "proj1" {
+		t.Fatalf("Expected key with its scope but got %v - %v", stored, err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, key); err != apikeys.ErrAPIKeyExpired {
+		t.Fatalf("Expected expired key to be rejected but got %v", err)
+	}
+
+	valid, validKey, _ := apikeys.New("tester", now, time.Hour)
+	if err = repo.CreateAPIKeyRecord(ctx, valid); err != nil {
+		t.Fatalf("Expected key to be stored but got err - %s", err)
+	}
+
+	if count, err := repo.DisableExpiredAPIKeys(ctx, now); err != nil || count != 1 {
+		t.Fatalf("Expected 1 key to be disabled but got %d - %v", count, err)
+	}
+
+	if count, err := repo.DisableExpiredAPIKeys(ctx, now); err != nil || count != 0 {
+		t.Fatalf("Expected no keys left to disable but got %d - %v", count, err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, key); err != apikeys.ErrAPIKeyDisabled {
+		t.Fatalf("Expected disabled key to be rejected but got %v", err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, validKey); err != nil {
+		t.Fatalf("Expected unexpired key to keep working but got %v", err)
+	}
+
+	if _, err = repo.GetAPIKeyRecord(ctx, "0123456789abcdef"); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected unknown key to be reported missing but got %v", err)
+	}
+}
+
+func testRotateAPIKeyRecord(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+
+	// backends keep times to the millisecond
+	now := time.Now().Truncate(time.Millisecond)
+	record, key, _ := apikeys.New("tester", now, time.Hour)
+	if err := repo.CreateAPIKeyRecord(ctx, record); err != nil {
+		t.Fatalf("Expected key to be stored but got err - %s", err)
+	}
+
+	if err := repo.StoreAPIKeyPolicy(ctx, entity.NewAPIKeyPolicy(record.Key, true)); err != nil {
+		t.Fatalf("Expected policy to be stored but got err - %s", err)
+	}
+
+	next, nextKey, _ := apikeys.New("tester", now, time.Hour)
+	next.Predecessor = record.Key
+	if err := repo.RotateAPIKeyRecord(ctx, record.Key, next, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Expected key to be rotated but got err - %s", err)
+	}
+
+	stored, err := repo.GetAPIKeyRecord(ctx, record.Key)
+	if err != nil || stored.Successor != next.Key || !stored.Expires.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Expected key to end with the grace period but got %v - %v", stored, err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, key); err != nil {
+		t.Fatalf("Expected rotated key to work during the grace period but got %v", err)
+	}
+
+	if stored, err = repo.GetAPIKeyRecord(ctx, next.Key); err != nil || stored.Predecessor != record.Key {
+		t.Fatalf("Expected successor to name its predecessor but got %v - %v", stored, err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, nextKey); err != nil {
+		t.Fatalf("Expected successor key to work but got %v", err)
+	}
+
+	if policy, err := repo.GetAPIKeyPolicy(ctx, next.Key); err != nil || policy.Key != next.Key {
+		t.Fatalf("Expected successor to inherit the policy but got %v - %v", policy, err)
+	}
+
+	other, _, _ := apikeys.New("tester", now, time.Hour)
+	if err = repo.RotateAPIKeyRecord(ctx, record.Key, other, now.Add(time.Minute)); err != apikeys.ErrAPIKeyRotated {
+		t.Fatalf("Expected a key to be rotated once but got %v", err)
+	}
+
+	if _, err = repo.GetAPIKeyRecord(ctx, other.Key); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected failed rotation to store nothing but got %v", err)
+	}
+}
+
+func testAPIKeyUsage(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+
+	now := time.Now()
+	used, _, _ := apikeys.New("tester", now.Add(-48*time.Hour), 72*time.Hour)
+	unused, _, _ := apikeys.New("tester", now.Add(-48*time.Hour), 72*time.Hour)
+	for _, record := range []*apikeys.Record{used, unused} {
+		if err := repo.CreateAPIKeyRecord(ctx, record); err != nil {
+			t.Fatalf("Expected key to be stored but got err - %s", err)
+		}
+	}
+
+	for _, ip := range []string{"10.1.0.9", "10.1.0.8"} {
+		if err := repo.RecordAPIKeyUse(ctx, used.Key, apikeys.Usage{LastUsed: now, LastIP: ip, Requests: 1}); err != nil {
+			t.Fatalf("Expected use to be recorded but got err - %s", err)
+		}
+	}
+
+	if err := repo.SetAPIKeyNetworks(ctx, used.Key, []string{"10.1.0.0/16"}); err != nil {
+		t.Fatalf("Expected networks to be stored but got err - %s", err)
+	}
+
+	stored, err := repo.GetAPIKeyRecord(ctx, used.Key)
+	if err != nil || stored.Usage.Requests != 2 || stored.Usage.LastIP != "10.1.0.8" || !stored.AllowsIP("10.1.3.4") || stored.AllowsIP("10.2.3.4") {
+		t.Fatalf("Expected usage and networks of the key but got %v - %v", stored, err)
+	}
+
+	stale, err := repo.GetUnusedAPIKeys(ctx, now.Add(-24*time.Hour))
+	if err != nil || len(stale) != 1 || stale[0].Key != unused.Key {
+		t.Fatalf("Expected only the unused key to be stale but got %v - %v", stale, err)
+	}
+
+	if err = repo.RecordAPIKeyUse(ctx, "0123456789abcdef", apikeys.Usage{LastUsed: now, LastIP: "10.1.0.9", Requests: 1}); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected unknown key to be reported missing but got %v", err)
+	}
+}
+
+func testServiceAccounts(t *testing.T, repo Store) {
+
+	ctx := context.Background()
+
+	now := time.Now()
+	sa, user, _ := serviceaccount.New("team1", "ci", "deploys", "owner", now)
+	if err := repo.CreateServiceAccount(ctx, sa, user); err != nil {
+		t.Fatalf("Expected service account to be stored but got err - %s", err)
+	}
+
+	if err := repo.CreateServiceAccount(ctx, sa, user); err != errs.ErrUserAlreadyExists {
+		t.Fatalf("Expected duplicate service account to be rejected but got %v", err)
+	}
+
+	other, otherUser, _ := serviceaccount.New("team2", "ci", "", "owner", now)
+	if err := repo.CreateServiceAccount(ctx, other, otherUser); err != nil {
+		t.Fatalf("Expected service account to be stored but got err - %s", err)
+	}
+
+	stored, err := repo.GetUser(ctx, sa.Id)
+	if err != nil || stored.Email != user.Email || len(stored.Memberships) != 1 || stored.Memberships[0].TeamId != "team1" {
+		t.Fatalf("Expected user of the service account but got %v - %v", stored, err)
+	}
+
+	member, err := repo.GetTeamMembership(ctx, stored.Memberships[0].Key)
+	if err != nil || member.TeamId != "team1" || !serviceaccount.IsJoined(member) {
+		t.Fatalf("Expected joined team member of the service account but got %v - %v", member, err)
+	}
+
+	accounts, err := repo.GetServiceAccounts(ctx, "team1")
+	if err != nil || len(accounts) != 1 || accounts[0].Id != sa.Id || accounts[0].Description != "deploys" {
+		t.Fatalf("Expected the service account of team1 but got %v - %v", accounts, err)
+	}
+
+	if err = repo.DeleteServiceAccount(ctx, sa.Id); err != nil {
+		t.Fatalf("Expected service account to be deleted but got err - %s", err)
+	}
+
+	if _, err = repo.GetServiceAccount(ctx, sa.Id); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected deleted service account to be missing but got %v", err)
+	}
+
+	if _, err = repo.GetUser(ctx, sa.Id); err == nil {
+		t.Fatalf("Expected user of the deleted service account to be removed")
+	}
+
+	if _, err = repo.GetTeamMembership(ctx, sa.Id); err == nil {
+		t.Fatalf("Expected team member of the deleted service account to be removed")
+	}
+
+	if err = repo.DeleteServiceAccount(ctx, sa.Id); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected deleting a missing service account to fail but got %v", err)
+	}
+}
@@ -1,37 +1,92 @@
 package helper
 
 import (
-	"github.com/zbitech/common/pkg/id"
+	"errors"
+	"sync"
 	"time"
 
+	"github.com/zbitech/common/pkg/id"
+
 	"github.com/golang-jwt/jwt"
 	"github.com/zbitech/common/pkg/model/entity"
 	"github.com/zbitech/common/pkg/model/object"
 )
 
+const (
+	TOKEN_ISSUER   = "ZBI"
+	TOKEN_AUDIENCE = "ZBI"
+
+	DEFAULT_ACCESS_TOKEN_LIFETIME  = 15 * time.Minute
+	DEFAULT_REFRESH_TOKEN_LIFETIME = 30 * 24 * time.Hour
+)
+
 var (
-	SECRET_KEY = "KaPdSgVkYp3s6v9y$B&E)H@McQeThWmZ"
+	ErrNoTokenSigner = errors.New("no token signer configured")
+
+	signerMu sync.RWMutex
+	signer   TokenSigner
+
+	accessLifetime  = DEFAULT_ACCESS_TOKEN_LIFETIME
+	refreshLifetime = DEFAULT_REFRESH_TOKEN_LIFETIME
 )
 
+// TokenSigner signs the tokens issued when users authenticate
+type TokenSigner interface {
+	Sign(claims jwt.Claims) (string, error)
+}
+
+// SetTokenSigner sets the signer used by GenerateJwtToken. It is set by the ZBI JWT server.
+func SetTokenSigner(s TokenSigner) {
+	signerMu.Lock()
+	defer signerMu.Unlock()
+	signer = s
+}
+
+// SetTokenLifetimes sets how long access tokens and refresh tokens are valid. Zero keeps the current value.
+func SetTokenLifetimes(access, refresh time.Duration) {
+	signerMu.Lock()
+	defer signerMu.Unlock()
+
+	if access > 0 {
+		accessLifetime = access
+	}
+	if refresh > 0 {
+		refreshLifetime = refresh
+	}
+}
+
+// GetTokenLifetimes returns how long access tokens and refresh tokens are valid
+func GetTokenLifetimes() (time.Duration, time.Duration) {
+	signerMu.RLock()
+	defer signerMu.RUnlock()
+	return accessLifetime, refreshLifetime
+}
+
 func GenerateJwtToken(user entity.User) (*string, error) {
 
+	signerMu.RLock()
+	s, lifetime := signer, accessLifetime
+	signerMu.RUnlock()
+
+	if s == nil {
+		return nil, ErrNoTokenSigner
+	}
+
 	now := time.Now()
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, object.ZBIBasicClaims{
+	signedToken, err := s.Sign(object.ZBIBasicClaims{
 		StandardClaims: jwt.StandardClaims{
-			Audience:  "ZBI",
-			ExpiresAt: now.Add(time.Hour * 24).Unix(),
+			Audience:  TOKEN_AUDIENCE,
+			ExpiresAt: now.Add(lifetime).Unix(),
 			Id:        id.GenerateRequestID(),
 			IssuedAt:  now.Unix(),
-			Issuer:    "ZBI",
+			Issuer:    TOKEN_ISSUER,
 			NotBefore: now.Unix(),
 			Subject:   user.UserId,
 		},
 		Role:  user.Role,
 		Email: user.Email,
 	}) //TODO - add teams to claims?
-
-	signedToken, err := token.SignedString([]byte(SECRET_KEY))
 	if err != nil {
 		return nil, err
 	}
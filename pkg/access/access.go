@@ -0,0 +1,258 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/common/pkg/utils"
+)
+
+// Resources that rules apply to
+const (
+	RESOURCE_PROJECT  = "project"
+	RESOURCE_INSTANCE = "instance"
+	RESOURCE_TEAM     = "team"
+	RESOURCE_METHOD   = "method"
+)
+
+// Roles are derived by the authorizer for each request. A subject usually has several, such as owner and
+// resource-owner.
+const (
+	ROLE_ADMIN           = "admin"           // administrators of the service
+	ROLE_OWNER           = "owner"           // users with the owner role, who hold subscriptions
+	ROLE_RESOURCE_OWNER  = "resource-owner"  // the owner of the project or team
+	ROLE_TEAM_ADMIN      = "team-admin"      // joined admins of the team of the resource
+	ROLE_TEAM_MEMBER     = "team-member"     // joined members of the team of the resource, admins included
+	ROLE_API_KEY         = "apikey"          // requests made with an API key
+	ROLE_SERVICE_ACCOUNT = "service-account" // requests made with a key of a service account
+)
+
+// Attributes describe the request and are matched by conditions
+const (
+	ATTR_SUBSCRIPTION  = "subscription" // subscription level of the owner of the resource
+	ATTR_USER_ROLE     = "userrole"     // role of the current user
+	ATTR_INSTANCE_TYPE = "instancetype" // type of the instance, for existing instances
+	ATTR_METHOD        = "method"       // method called on an instance
+	ATTR_CATEGORY      = "category"     // category of the method
+)
+
+const (
+	EFFECT_ALLOW = "allow"
+	EFFECT_DENY  = "deny"
+
+	// ANY matches every resource, action or role
+	ANY = "*"
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid access policy")
+)
+
+// Condition matches the value of an attribute. A request without the attribute does not match In, and does match
+// NotIn.
+type Condition struct {
+	Attribute string
+	In        []string
+	NotIn     []string
+}
+
+func (c Condition) matches(attributes map[string]string) bool {
+	value, ok := attributes[c.Attribute]
+	if len(c.In) > 0 && (!ok || !contains(c.In, value)) {
+		return false
+	}
+	return !ok || !contains(c.NotIn, value)
+}
+
+// Rule allows or denies the actions on the resources to subjects with any of the roles, when all conditions match
+type Rule struct {
+	Name       string
+	Effect     string
+	Resources  []string
+	Actions    []string
+	Roles      []string
+	Conditions []Condition
+}
+
+func (r Rule) matches(req Request) bool {
+	if !contains(r.Resources, req.Resource) || !contains(r.Actions, req.Action) {
+		return false
+	}
+
+	if !contains(r.Roles, ANY) && !containsAny(r.Roles, req.Roles) {
+		return false
+	}
+
+	for _, condition := range r.Conditions {
+		if !condition.matches(req.Attributes) {
+			return false
+		}
+	}
+	return true
+}
+
+// Document is a list of rules, usually read from a policy file
+type Document struct {
+	Rules []Rule
+}
+
+// Validate checks that every rule names its resources, actions and roles and has a known effect
+func (d Document) Validate() error {
+	if len(d.Rules) == 0 {
+		return fmt.Errorf("%w - no rules", ErrInvalidPolicy)
+	}
+
+	for index, rule := range d.Rules {
+		name := rule.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", index+1)
+		}
+
+		switch rule.Effect {
+		case "", EFFECT_ALLOW, EFFECT_DENY:
+		default:
+			return fmt.Errorf("%w - rule %s has effect %q, expected %s or %s", ErrInvalidPolicy, name, rule.Effect, EFFECT_ALLOW, EFFECT_DENY)
+		}
+
+		if len(rule.Resources) == 0 || len(rule.Actions) == 0 || len(rule.Roles) == 0 {
+			return fmt.Errorf("%w - rule %s needs resources, actions and roles", ErrInvalidPolicy, name)
+		}
+
+		for _, condition := range rule.Conditions {
+			if len(condition.Attribute) == 0 || len(condition.In)+len(condition.NotIn) == 0 {
+				return fmt.Errorf("%w - rule %s has a condition without attribute or values", ErrInvalidPolicy, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Request is what the authorizer asks the engine to decide
+type Request struct {
+	Resource   string
+	Action     string
+	Roles      []string
+	Attributes map[string]string
+}
+
+// Decision names the rule that decided the request. Rule is empty when no rule matched.
+type Decision struct {
+	Allowed bool
+	Rule    string
+}
+
+// Engine evaluates requests against a document. Deny rules take precedence over allow rules, and requests that no
+// rule allows are denied.
+type Engine struct {
+	rules      []Rule
+	attributes map[string]bool
+}
+
+func NewEngine(doc Document) (*Engine, error) {
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+
+	engine := &Engine{rules: append([]Rule{}, doc.Rules...), attributes: map[string]bool{}}
+	for _, rule := range engine.rules {
+		for _, condition := range rule.Conditions {
+			engine.attributes[condition.Attribute] = true
+		}
+	}
+	return engine, nil
+}
+
+// MustNewEngine is like NewEngine but panics when the document is invalid. It is meant for documents built into
+// the program, such as DefaultDocument.
+func MustNewEngine(doc Document) *Engine {
+	engine, err := NewEngine(doc)
+	if err != nil {
+		panic(fmt.Sprintf("access: invalid built-in policy - %s", err))
+	}
+	return engine
+}
+
+// Uses reports whether a condition refers to the attribute, so the authorizer only loads attributes that matter
+func (e *Engine) Uses(attribute string) bool {
+	return e.attributes[attribute]
+}
+
+func (e *Engine) Evaluate(req Request) Decision {
+	decision := Decision{}
+	for index, rule := range e.rules {
+		if !rule.matches(req) {
+			continue
+		}
+
+		name := rule.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", index+1)
+		}
+
+		if rule.Effect == EFFECT_DENY {
+			return Decision{Allowed: false, Rule: name}
+		} else if !decision.Allowed {
+			decision = Decision{Allowed: true, Rule: name}
+		}
+	}
+	return decision
+}
+
+// DefaultDocument reproduces the built-in rules of the authorizer. The subscription limits on creating projects,
+// instances and teams are checked by the authorizer after the policy allows the request.
+func DefaultDocument() Document {
+	actions := func(action ztypes.ZBIAction) []string { return []string{string(action)} }
+	resources := []string{RESOURCE_PROJECT, RESOURCE_INSTANCE, RESOURCE_TEAM}
+
+	return Document{Rules: []Rule{
+		{Name: "create", Resources: resources, Actions: actions(ztypes.ACTION_CREATE), Roles: []string{ROLE_OWNER, ROLE_TEAM_ADMIN}},
+		{Name: "update", Resources: resources, Actions: actions(ztypes.ACTION_UPDATE), Roles: []string{ROLE_RESOURCE_OWNER, ROLE_TEAM_ADMIN}},
+		{Name: "delete", Resources: resources, Actions: actions(ztypes.ACTION_DELETE), Roles: []string{ROLE_ADMIN, ROLE_RESOURCE_OWNER, ROLE_TEAM_ADMIN}},
+		{Name: "access", Resources: resources, Actions: actions(ztypes.ACTION_ACCESS), Roles: []string{ROLE_ADMIN, ROLE_RESOURCE_OWNER, ROLE_TEAM_MEMBER}},
+		// methods are governed by the instance, user and key policies
+		{Name: "methods", Resources: []string{RESOURCE_METHOD}, Actions: actions(ztypes.ACTION_ACCESS), Roles: []string{ANY}},
+	}}
+}
+
+// Config is the access section of iam.yaml. File names a policy document; without one the default policy is used.
+type Config struct {
+	File string
+}
+
+func (c Config) Engine() (*Engine, error) {
+	if len(c.File) == 0 {
+		return NewEngine(DefaultDocument())
+	}
+
+	if _, err := os.Stat(c.File); err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrInvalidPolicy, err)
+	}
+
+	var doc Document
+	if err := utils.ReadConfig(c.File, nil, &doc); err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrInvalidPolicy, err)
+	}
+
+	return NewEngine(doc)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == ANY {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, candidate := range candidates {
+		if contains(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package access
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_DefaultDocument(t *testing.T) {
+	engine, err := NewEngine(DefaultDocument())
+	if err != nil {
+		t.Fatalf("Expected default document to compile but got err - %s", err)
+	}
+
+	tests := []struct {
+		resource string
+		action   string
+		roles    []string
+		allowed  bool
+	}{
+		{RESOURCE_PROJECT, "create", []string{ROLE_OWNER}, true},
+		{RESOURCE_PROJECT, "create", []string{ROLE_TEAM_MEMBER}, false},
+		{RESOURCE_PROJECT, "create", []string{ROLE_ADMIN}, false},
+		{RESOURCE_INSTANCE, "update", []string{ROLE_RESOURCE_OWNER}, true},
+		{RESOURCE_INSTANCE, "update", []string{ROLE_ADMIN}, false},
+		{RESOURCE_INSTANCE, "delete", []string{ROLE_ADMIN}, true},
+		{RESOURCE_INSTANCE, "delete", []string{ROLE_TEAM_MEMBER}, false},
+		{RESOURCE_INSTANCE, "delete", []string{ROLE_TEAM_MEMBER, ROLE_TEAM_ADMIN}, true},
+		{RESOURCE_TEAM, "access", []string{ROLE_TEAM_MEMBER}, true},
+		{RESOURCE_TEAM, "access", nil, false},
+		{RESOURCE_TEAM, "purge", []string{ROLE_ADMIN}, false},
+		{RESOURCE_METHOD, "access", nil, true},
+	}
+
+	for _, test := range tests {
+		decision := engine.Evaluate(Request{Resource: test.resource, Action: test.action, Roles: test.roles})
+		if decision.Allowed != test.allowed {
+			t.Fatalf("Expected %s %s by %v to be allowed %t but got %t", test.action, test.resource, test.roles,
+				test.allowed, decision.Allowed)
+		}
+	}
+}
+
+func Test_EvaluateConditions(t *testing.T) {
+	doc := DefaultDocument()
+	doc.Rules = append(doc.Rules,
+		Rule{Name: "keep-validators", Effect: EFFECT_DENY, Resources: []string{RESOURCE_INSTANCE}, Actions: []string{"delete"},
+			Roles: []string{ANY}, Conditions: []Condition{{Attribute: ATTR_INSTANCE_TYPE, In: []string{"validator"}}}},
+		Rule{Name: "free-read", Effect: EFFECT_DENY, Resources: []string{RESOURCE_METHOD}, Actions: []string{"access"}, Roles: []string{ROLE_API_KEY},
+			Conditions: []Condition{{Attribute: ATTR_SUBSCRIPTION, NotIn: []string{"teamsub"}}, {Attribute: ATTR_CATEGORY, NotIn: []string{"read"}}}},
+	)
+
+	engine, err := NewEngine(doc)
+	if err != nil {
+		t.Fatalf("Expected document to compile but got err - %s", err)
+	}
+	if !engine.Uses(ATTR_INSTANCE_TYPE) || engine.Uses(ATTR_USER_ROLE) {
+		t.Fatalf("Expected engine to use the instance type and not the user role")
+	}
+
+	decision := engine.Evaluate(Request{Resource: RESOURCE_INSTANCE, Action: "delete", Roles: []string{ROLE_ADMIN},
+		Attributes: map[string]string{ATTR_INSTANCE_TYPE: "validator"}})
+	if expected := (Decision{Allowed: false, Rule: "keep-validators"}); decision != expected {
+		t.Fatalf("Expected %v but got %v", expected, decision)
+	}
+
+	decision = engine.Evaluate(Request{Resource: RESOURCE_INSTANCE, Action: "delete", Roles: []string{ROLE_ADMIN},
+		Attributes: map[string]string{ATTR_INSTANCE_TYPE: "node"}})
+	if expected := (Decision{Allowed: true, Rule: "delete"}); decision != expected {
+		t.Fatalf("Expected %v but got %v", expected, decision)
+	}
+
+	decision = engine.Evaluate(Request{Resource: RESOURCE_METHOD, Action: "access", Roles: []string{ROLE_API_KEY},
+		Attributes: map[string]string{ATTR_SUBSCRIPTION: "basic", ATTR_CATEGORY: "write"}})
+	if decision.Allowed {
+		t.Fatalf("Expected writes by api keys of a basic subscription to be denied")
+	}
+
+	decision = engine.Evaluate(Request{Resource: RESOURCE_METHOD, Action: "access", Roles: []string{ROLE_API_KEY},
+		Attributes: map[string]string{ATTR_SUBSCRIPTION: "basic", ATTR_CATEGORY: "read"}})
+	if !decision.Allowed {
+		t.Fatalf("Expected reads by api keys of a basic subscription to be allowed")
+	}
+}
+
+func Test_DocumentValidate(t *testing.T) {
+	invalid := []Document{
+		{},
+		{Rules: []Rule{{Effect: "maybe", Resources: []string{ANY}, Actions: []string{ANY}, Roles: []string{ANY}}}},
+		{Rules: []Rule{{Resources: []string{ANY}, Actions: []string{ANY}}}},
+		{Rules: []Rule{{Resources: []string{ANY}, Actions: []string{ANY}, Roles: []string{ANY}, Conditions: []Condition{{Attribute: ATTR_METHOD}}}}},
+	}
+
+	for _, doc := range invalid {
+		if _, err := NewEngine(doc); !errors.Is(err, ErrInvalidPolicy) {
+			t.Fatalf("Expected invalid policy error for %v but got %v", doc, err)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Expected MustNewEngine to panic for an invalid document")
+			}
+		}()
+		MustNewEngine(invalid[0])
+	}()
+
+	engine, err := Config{}.Engine()
+	if err != nil {
+		t.Fatalf("Expected default engine but got err - %s", err)
+	}
+	if !engine.Evaluate(Request{Resource: RESOURCE_PROJECT, Action: "create", Roles: []string{ROLE_OWNER}}).Allowed {
+		t.Fatalf("Expected owners to create projects with the default engine")
+	}
+}
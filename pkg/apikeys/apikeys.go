@@ -0,0 +1,174 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/model/entity"
+)
+
+// API keys are handed out as zbi_<id>_<secret>. The id is public and names the key in listings and policies; only
+// a hash of the secret is stored, so the full key is shown once when it is created.
+const (
+	PREFIX = "zbi"
+
+	idSize     = 8
+	secretSize = 32
+	separator  = "_"
+)
+
+var (
+	ErrInvalidAPIKey      = errors.New("invalid api key")
+	ErrAPIKeyExpired      = errors.New("api key has expired")
+	ErrAPIKeyDisabled     = errors.New("api key is disabled")
+	ErrAPIKeysUnsupported = errors.New("repository does not store scoped api keys")
+	ErrAPIKeyRotated      = errors.New("api key has already been rotated")
+)
+
+// Record is how an API key is stored. Key holds the id, never the secret. Disabled keys are kept so they can be
+// listed and audited, but are rejected. Predecessor and Successor link the ids of keys that were rotated. Keys with
+// Networks may only be used from addresses in those CIDR ranges.
+type Record struct {
+	Key         string    `json:"key" bson:"key"`
+	Hash        string    `json:"hash" bson:"hash"`
+	UserId      string    `json:"userid" bson:"userid"`
+	Scope       Scope     `json:"scope" bson:"scope"`
+	Disabled    bool      `json:"disabled" bson:"disabled"`
+	Created     time.Time `json:"created" bson:"created"`
+	Expires     time.Time `json:"expires" bson:"expires"`
+	Predecessor string    `json:"predecessor,omitempty" bson:"predecessor,omitempty"`
+	Successor   string    `json:"successor,omitempty" bson:"successor,omitempty"`
+	Networks    []string  `json:"networks,omitempty" bson:"networks,omitempty"`
+	Usage       Usage     `json:"usage" bson:"usage"`
+}
+
+// Expired reports whether the key has expired. Keys without an expiry do not expire.
+func (r *Record) Expired(now time.Time) bool {
+	return !r.Expires.IsZero() && !now.Before(r.Expires)
+}
+
+// Authenticate checks the secret and that the key can still be used. A wrong secret is reported as
+// errs.ErrDBItemNotFound so it cannot be told apart from an unknown key.
+func (r *Record) Authenticate(secret string, now time.Time) error {
+	if !r.Verify(secret) {
+		return errs.ErrDBItemNotFound
+	} else if r.Disabled {
+		return ErrAPIKeyDisabled
+	} else if r.Expired(now) {
+		return ErrAPIKeyExpired
+	}
+	return nil
+}
+
+// Supersede links the record to the key that replaces it and ends it at graceEnds, unless it expires earlier. A
+// key is rotated once, so rotating a record with a successor fails with ErrAPIKeyRotated.
+func (r *Record) Supersede(successor string, graceEnds time.Time) error {
+	if len(r.Successor) > 0 {
+		return ErrAPIKeyRotated
+	}
+
+	r.Successor = successor
+	if r.Expires.IsZero() || graceEnds.Before(r.Expires) {
+		r.Expires = graceEnds
+	}
+	return nil
+}
+
+// APIKey returns the public view of the record, with the id as key
+func (r *Record) APIKey() *entity.APIKey {
+	return &entity.APIKey{Key: r.Key, UserId: r.UserId, Created: r.Created, Expires: r.Expires}
+}
+
+// Verify compares the secret with the stored hash in constant time
+func (r *Record) Verify(secret string) bool {
+	return len(r.Hash) > 0 && subtle.ConstantTimeCompare([]byte(r.Hash), []byte(HashSecret(secret))) == 1
+}
+
+// Hashed reports whether the record was stored or migrated with a hash. Records without one hold a plaintext key
+// from before keys were hashed.
+func (r *Record) Hashed() bool {
+	return len(r.Hash) > 0
+}
+
+// HashSecret returns the hash under which the secret of a key is stored. Secrets are random, so a plain SHA-256
+// is enough.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// New creates a key for the user and returns its record together with the full key to hand to the user
+func New(userId string, now time.Time, lifetime time.Duration) (*Record, string, error) {
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+
+	data := make([]byte, secretSize)
+	if _, err := rand.Read(data); err != nil {
+		return nil, "", err
+	}
+
+	record := &Record{Key: hex.EncodeToString(id), UserId: userId, Created: now, Expires: now.Add(lifetime)}
+	secret := base64.RawURLEncoding.EncodeToString(data)
+	record.Hash = HashSecret(secret)
+
+	return record, strings.Join([]string{PREFIX, record.Key, secret}, separator), nil
+}
+
+// FromPlaintext converts a key from before keys were hashed, such as the keys in admin.yaml. The key keeps working
+// as it is and gets an id derived from it.
+func FromPlaintext(k entity.APIKey) *Record {
+	return &Record{Key: legacyID(k.Key), Hash: HashSecret(k.Key), UserId: k.UserId, Created: k.Created, Expires: k.Expires}
+}
+
+func legacyID(key string) string {
+	sum := sha256.Sum256([]byte("zbi api key id " + key))
+	return hex.EncodeToString(sum[:idSize])
+}
+
+func isID(value string) bool {
+	if len(value) != 2*idSize || strings.ToLower(value) != value {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// Parse splits a key into its id and secret. Keys from before keys were hashed are whole secrets and their id is
+// derived from them.
+func Parse(key string) (string, string, error) {
+	parts := strings.SplitN(key, separator, 3)
+	if len(parts) == 3 && parts[0] == PREFIX && isID(parts[1]) {
+		if len(parts[2]) == 0 {
+			return "", "", ErrInvalidAPIKey
+		}
+		return parts[1], parts[2], nil
+	}
+
+	if len(key) == 0 {
+		return "", "", ErrInvalidAPIKey
+	}
+
+	return legacyID(key), key, nil
+}
+
+// ID returns the id of a key given its id or the full key
+func ID(value string) string {
+	if isID(value) {
+		return value
+	}
+
+	id, _, err := Parse(value)
+	if err != nil {
+		return value
+	}
+	return id
+}
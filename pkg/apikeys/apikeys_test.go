@@ -0,0 +1,111 @@
+package apikeys
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zbitech/common/pkg/model/entity"
+)
+
+func Test_New(t *testing.T) {
+	now := time.Now()
+	record, key, err := New("tester", now, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected api key but got err - %s", err)
+	}
+	if !strings.HasPrefix(key, PREFIX+"_"+record.Key+"_") {
+		t.Fatalf("Expected key %s to start with the prefix and id %s", key, record.Key)
+	}
+	if strings.Contains(record.Hash, key) {
+		t.Fatalf("Expected the hash not to contain the key")
+	}
+	if !record.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expected expiry %s but got %s", now.Add(time.Hour), record.Expires)
+	}
+
+	id, secret, err := Parse(key)
+	if err != nil || id != record.Key {
+		t.Fatalf("Expected id %s but got %s - %v", record.Key, id, err)
+	}
+	if !record.Verify(secret) {
+		t.Fatalf("Expected secret to verify")
+	}
+	if record.Verify(secret + "x") {
+		t.Fatalf("Expected wrong secret not to verify")
+	}
+	if ID(key) != record.Key || ID(record.Key) != record.Key {
+		t.Fatalf("Expected id %s for the key and the id but got %s and %s", record.Key, ID(key), ID(record.Key))
+	}
+
+	public := record.APIKey()
+	if public.Key != record.Key || public.UserId != "tester" {
+		t.Fatalf("Expected public key %s of tester but got %s of %s", record.Key, public.Key, public.UserId)
+	}
+
+	if _, other, _ := New("tester", now, time.Hour); other == key {
+		t.Fatalf("Expected a different key but got %s again", key)
+	}
+}
+
+func Test_FromPlaintext(t *testing.T) {
+	legacy := entity.NewAPIKey("tester", 1)
+	record := FromPlaintext(legacy)
+	if !record.Hashed() {
+		t.Fatalf("Expected record to be hashed")
+	}
+	if record.Key == legacy.Key || record.Key != ID(legacy.Key) {
+		t.Fatalf("Expected id %s but got %s", ID(legacy.Key), record.Key)
+	}
+
+	id, secret, err := Parse(legacy.Key)
+	if err != nil || id != record.Key {
+		t.Fatalf("Expected id %s but got %s - %v", record.Key, id, err)
+	}
+	if !record.Verify(secret) {
+		t.Fatalf("Expected plaintext key to verify")
+	}
+
+	if (&Record{Key: legacy.Key}).Verify(legacy.Key) {
+		t.Fatalf("Expected unhashed records never to verify")
+	}
+}
+
+func Test_Parse(t *testing.T) {
+	for _, key := range []string{"", PREFIX + "_0123456789abcdef_"} {
+		if _, _, err := Parse(key); err != ErrInvalidAPIKey {
+			t.Fatalf("Expected invalid api key error for %q but got %v", key, err)
+		}
+	}
+
+	id, secret, err := Parse(PREFIX + "_0123456789abcdef_se_cr-et")
+	if err != nil {
+		t.Fatalf("Expected key to parse but got err - %s", err)
+	}
+	if id != "0123456789abcdef" || secret != "se_cr-et" {
+		t.Fatalf("Expected id 0123456789abcdef and secret se_cr-et but got %s and %s", id, secret)
+	}
+}
+
+func Test_Supersede(t *testing.T) {
+	now := time.Now()
+	record, _, _ := New("tester", now, time.Hour)
+
+	if err := record.Supersede("next", now.Add(time.Minute)); err != nil {
+		t.Fatalf("Expected key to be superseded but got err - %s", err)
+	}
+	if record.Successor != "next" || !record.Expires.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Expected successor next until %s but got %s until %s", now.Add(time.Minute), record.Successor, record.Expires)
+	}
+	if err := record.Supersede("other", now.Add(time.Minute)); err != ErrAPIKeyRotated {
+		t.Fatalf("Expected rotated error but got %v", err)
+	}
+
+	record, _, _ = New("tester", now, time.Hour)
+	if err := record.Supersede("next", now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("Expected key to be superseded but got err - %s", err)
+	}
+	if !record.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expected the grace period not to extend the key but got expiry %s", record.Expires)
+	}
+}
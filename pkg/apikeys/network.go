@@ -0,0 +1,106 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNetworkDenied  = errors.New("api key may not be used from this address")
+	ErrInvalidNetwork = errors.New("invalid api key network")
+)
+
+type clientIPKey struct{}
+
+// WithClientIP records the address a request comes from so keys bound to networks can be checked
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// Usage tracks how a key is used so keys that are no longer used can be found
+type Usage struct {
+	LastUsed time.Time `json:"lastused,omitempty" bson:"lastused,omitempty"`
+	LastIP   string    `json:"lastip,omitempty" bson:"lastip,omitempty"`
+	Requests int64     `json:"requests" bson:"requests"`
+}
+
+// Record adds a request from ip at now
+func (u *Usage) Record(ip string, now time.Time) {
+	u.LastUsed = now
+	u.LastIP = ip
+	u.Requests++
+}
+
+// Add merges usage collected elsewhere, keeping the address of the latest request
+func (u *Usage) Add(other Usage) {
+	if !other.LastUsed.Before(u.LastUsed) {
+		u.LastUsed = other.LastUsed
+		u.LastIP = other.LastIP
+	}
+	u.Requests += other.Requests
+}
+
+// ParseNetworks checks a list of CIDR ranges and returns it in canonical form. Single addresses are accepted and
+// bound to themselves.
+func ParseNetworks(networks []string) ([]string, error) {
+	parsed := make([]string, 0, len(networks))
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return nil, fmt.Errorf("%w - %q", ErrInvalidNetwork, network)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			network = fmt.Sprintf("%s/%d", ip, bits)
+		}
+
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("%w - %q", ErrInvalidNetwork, network)
+		}
+		parsed = append(parsed, ipNet.String())
+	}
+	return parsed, nil
+}
+
+// AllowsIP reports whether the key may be used from ip. Keys without networks may be used from anywhere; keys with
+// networks are refused when the address is unknown.
+func (r *Record) AllowsIP(ip string) bool {
+	if len(r.Networks) == 0 {
+		return true
+	}
+
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+
+	for _, network := range r.Networks {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil && ipNet.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnusedSince reports whether the key was last used, or created if it was never used, before since
+func (r *Record) UnusedSince(since time.Time) bool {
+	last := r.Usage.LastUsed
+	if last.IsZero() {
+		last = r.Created
+	}
+	return last.Before(since)
+}
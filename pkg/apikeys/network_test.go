@@ -0,0 +1,86 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_ParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.1.2.3/16", " 192.168.1.7 ", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("Expected networks but got err - %s", err)
+	}
+
+	expected := []string{"10.1.0.0/16", "192.168.1.7/32", "2001:db8::1/128"}
+	if !reflect.DeepEqual(networks, expected) {
+		t.Fatalf("Expected %v but got %v", expected, networks)
+	}
+
+	for _, network := range []string{"10.1.2.3/33", "localhost"} {
+		if _, err = ParseNetworks([]string{network}); !errors.Is(err, ErrInvalidNetwork) {
+			t.Fatalf("Expected invalid network error for %s but got %v", network, err)
+		}
+	}
+}
+
+func Test_AllowsIP(t *testing.T) {
+	record := &Record{}
+	if !record.AllowsIP("") {
+		t.Fatalf("Expected keys without networks to be usable from anywhere")
+	}
+
+	record.Networks = []string{"10.1.0.0/16", "2001:db8::/32"}
+	for _, ip := range []string{"10.1.200.4", "2001:db8::5"} {
+		if !record.AllowsIP(ip) {
+			t.Fatalf("Expected %s to be allowed", ip)
+		}
+	}
+	if record.AllowsIP("10.2.0.1") {
+		t.Fatalf("Expected 10.2.0.1 to be refused")
+	}
+	if record.AllowsIP("") {
+		t.Fatalf("Expected unknown addresses to be refused")
+	}
+
+	ctx := WithClientIP(context.Background(), "10.1.0.9")
+	if ip := ClientIPFromContext(ctx); ip != "10.1.0.9" {
+		t.Fatalf("Expected client ip 10.1.0.9 but got %s", ip)
+	}
+	if ip := ClientIPFromContext(context.Background()); ip != "" {
+		t.Fatalf("Expected no client ip but got %s", ip)
+	}
+}
+
+func Test_Usage(t *testing.T) {
+	now := time.Now()
+	record, _, _ := New("tester", now, time.Hour)
+	if !record.UnusedSince(now.Add(time.Minute)) {
+		t.Fatalf("Expected unused keys to count from their creation")
+	}
+	if record.UnusedSince(now) {
+		t.Fatalf("Expected key not to be unused since its creation")
+	}
+
+	record.Usage.Record("10.1.0.9", now.Add(time.Hour))
+	record.Usage.Record("10.1.0.8", now.Add(2*time.Hour))
+	if record.Usage.Requests != 2 || record.Usage.LastIP != "10.1.0.8" {
+		t.Fatalf("Expected 2 requests from 10.1.0.8 but got %d from %s", record.Usage.Requests, record.Usage.LastIP)
+	}
+	if record.UnusedSince(now.Add(time.Hour)) {
+		t.Fatalf("Expected key to be used since %s", now.Add(time.Hour))
+	}
+	if !record.UnusedSince(now.Add(3 * time.Hour)) {
+		t.Fatalf("Expected key to be unused since %s", now.Add(3*time.Hour))
+	}
+
+	record.Usage.Add(Usage{LastUsed: now.Add(time.Hour), LastIP: "10.1.0.7", Requests: 3})
+	if record.Usage.Requests != 5 {
+		t.Fatalf("Expected 5 requests but got %d", record.Usage.Requests)
+	}
+	if record.Usage.LastIP != "10.1.0.8" {
+		t.Fatalf("Expected earlier usage to keep the latest address but got %s", record.Usage.LastIP)
+	}
+}
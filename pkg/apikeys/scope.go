@@ -0,0 +1,219 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zbitech/common/pkg/model/entity"
+)
+
+const (
+	DEFAULT_LIFETIME       = 365 * 24 * time.Hour
+	DEFAULT_SWEEP_INTERVAL = time.Hour
+	DEFAULT_ROTATION_GRACE = 24 * time.Hour
+	DEFAULT_USAGE_FLUSH    = 10 * time.Second
+	DEFAULT_READ_CATEGORY  = "read"
+)
+
+var (
+	ErrScopeDenied   = errors.New("api key scope does not allow the request")
+	ErrInvalidScope  = errors.New("invalid api key scope")
+	ErrInvalidExpiry = errors.New("invalid api key expiry")
+	ErrInvalidConfig = errors.New("invalid api key config")
+)
+
+// Scope limits what a key may be used for. Empty lists do not restrict, so the zero scope allows everything the
+// owner of the key may do. Instances are named project/instance. Read-only keys may only call methods of the read
+// categories of the Policy.
+type Scope struct {
+	ReadOnly   bool     `json:"readonly" bson:"readonly"`
+	Projects   []string `json:"projects" bson:"projects"`
+	Instances  []string `json:"instances" bson:"instances"`
+	Categories []string `json:"categories" bson:"categories"`
+}
+
+func (s Scope) Validate() error {
+	for _, project := range s.Projects {
+		if len(project) == 0 {
+			return fmt.Errorf("%w - empty project", ErrInvalidScope)
+		}
+	}
+
+	for _, instance := range s.Instances {
+		parts := strings.Split(instance, "/")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("%w - instance %q is not project/instance", ErrInvalidScope, instance)
+		}
+	}
+
+	for _, category := range s.Categories {
+		if len(category) == 0 {
+			return fmt.Errorf("%w - empty method category", ErrInvalidScope)
+		}
+	}
+
+	return nil
+}
+
+// Allows reports whether a method of category may be called on the instance of project
+func (s Scope) Allows(project, instance, category string, readCategories map[string]bool) bool {
+	if s.ReadOnly && !readCategories[category] {
+		return false
+	}
+
+	if len(s.Projects) > 0 && !contains(s.Projects, project) {
+		return false
+	}
+
+	if len(s.Instances) > 0 && !contains(s.Instances, project+"/"+instance) {
+		return false
+	}
+
+	return len(s.Categories) == 0 || contains(s.Categories, category)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps the records of API keys. Missing keys are reported with errs.ErrDBItemNotFound.
+type Store interface {
+	CreateAPIKeyRecord(ctx context.Context, r *Record) error
+	GetAPIKeyRecord(ctx context.Context, id string) (*Record, error)
+	// DisableExpiredAPIKeys disables the keys that expired by now and returns how many were disabled
+	DisableExpiredAPIKeys(ctx context.Context, now time.Time) (int64, error)
+	// RotateAPIKeyRecord stores next as the successor of the key id, ends the key at graceEnds and copies its policy
+	// to next. It fails with ErrAPIKeyRotated when the key already has a successor.
+	RotateAPIKeyRecord(ctx context.Context, id string, next *Record, graceEnds time.Time) error
+	SetAPIKeyNetworks(ctx context.Context, id string, networks []string) error
+	// RecordAPIKeyUse adds usage to the key: its request count and, when it is later, its last request
+	RecordAPIKeyUse(ctx context.Context, id string, usage Usage) error
+	// GetUnusedAPIKeys returns the keys that were last used, or created if never used, before since
+	GetUnusedAPIKeys(ctx context.Context, since time.Time) ([]Record, error)
+}
+
+// Service is implemented by IAM services with scoped API keys
+type Service interface {
+	// CreateScopedAPIKey creates a key limited to scope that expires at expires, or after the default lifetime
+	// when expires is zero. The full key is returned once.
+	CreateScopedAPIKey(ctx context.Context, userId string, scope Scope, expires time.Time) (*entity.APIKey, error)
+	GetAPIKeyScope(ctx context.Context, key string) (*Scope, error)
+	// CheckAPIKeyScope fails with ErrScopeDenied unless the key may call a method of category on the instance
+	CheckAPIKeyScope(ctx context.Context, key, project, instance, category string) error
+	DisableExpiredAPIKeys(ctx context.Context) (int64, error)
+	// RotateAPIKey replaces a key with one that has the same scope and policy. Both keys work until the grace
+	// period of the Policy ends. The full successor key is returned once.
+	RotateAPIKey(ctx context.Context, key string) (*entity.APIKey, error)
+	// GetAPIKeyLineage returns the keys a key was rotated from and to, oldest first, without their hashes
+	GetAPIKeyLineage(ctx context.Context, key string) ([]Record, error)
+	// SetAPIKeyNetworks binds a key to CIDR ranges. An empty list lets the key be used from anywhere.
+	SetAPIKeyNetworks(ctx context.Context, key string, networks []string) error
+	// CheckAPIKeyNetwork fails with ErrNetworkDenied unless the key may be used from ip
+	CheckAPIKeyNetwork(ctx context.Context, key, ip string) error
+	RecordAPIKeyUse(ctx context.Context, key, ip string) error
+	GetAPIKeyUsage(ctx context.Context, key string) (*Usage, error)
+	// GetUnusedAPIKeys returns the keys not used since since, without their hashes, so they can be pruned
+	GetUnusedAPIKeys(ctx context.Context, since time.Time) ([]Record, error)
+}
+
+// Policy sets the lifetime of keys and which method categories read-only keys may call. A MaxLifetime of zero
+// does not limit the expiry callers choose. RotationGrace is how long a rotated key keeps working. Usage is
+// buffered and written every UsageFlush, or on each request when it is zero.
+type Policy struct {
+	DefaultLifetime time.Duration
+	MaxLifetime     time.Duration
+	ReadCategories  map[string]bool
+	SweepInterval   time.Duration
+	RotationGrace   time.Duration
+	UsageFlush      time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{DefaultLifetime: DEFAULT_LIFETIME, ReadCategories: map[string]bool{DEFAULT_READ_CATEGORY: true},
+		SweepInterval: DEFAULT_SWEEP_INTERVAL, RotationGrace: DEFAULT_ROTATION_GRACE, UsageFlush: DEFAULT_USAGE_FLUSH}
+}
+
+// Expiry returns when a key created at now expires, given the expiry chosen by the caller
+func (p Policy) Expiry(now, expires time.Time) (time.Time, error) {
+	if expires.IsZero() {
+		expires = now.Add(p.DefaultLifetime)
+		if p.MaxLifetime > 0 && p.MaxLifetime < p.DefaultLifetime {
+			expires = now.Add(p.MaxLifetime)
+		}
+		return expires, nil
+	}
+
+	if !expires.After(now) {
+		return expires, fmt.Errorf("%w - %s is in the past", ErrInvalidExpiry, expires)
+	} else if p.MaxLifetime > 0 && expires.Sub(now) > p.MaxLifetime {
+		return expires, fmt.Errorf("%w - keys may not live longer than %s", ErrInvalidExpiry, p.MaxLifetime)
+	}
+
+	return expires, nil
+}
+
+// Config is the apikeys section of iam.yaml. Durations are strings such as 720h; a sweep interval of 0 turns off
+// the background sweep that disables expired keys, a rotation grace of 0 ends rotated keys at once and a usage flush
+// of 0 writes usage on each request.
+type Config struct {
+	DefaultLifetime string
+	MaxLifetime     string
+	ReadCategories  []string
+	SweepInterval   string
+	RotationGrace   string
+	UsageFlush      string
+}
+
+func (c Config) Policy() (Policy, error) {
+
+	policy := DefaultPolicy()
+
+	parse := func(name, value string, target *time.Duration, allowZero bool) error {
+		if len(value) == 0 {
+			return nil
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 || (duration == 0 && !allowZero) {
+			return fmt.Errorf("%w - %s %q", ErrInvalidConfig, name, value)
+		}
+		*target = duration
+		return nil
+	}
+
+	if err := parse("default lifetime", c.DefaultLifetime, &policy.DefaultLifetime, false); err != nil {
+		return policy, err
+	}
+
+	if err := parse("max lifetime", c.MaxLifetime, &policy.MaxLifetime, false); err != nil {
+		return policy, err
+	}
+
+	if err := parse("sweep interval", c.SweepInterval, &policy.SweepInterval, true); err != nil {
+		return policy, err
+	}
+
+	if err := parse("rotation grace", c.RotationGrace, &policy.RotationGrace, true); err != nil {
+		return policy, err
+	}
+
+	if err := parse("usage flush", c.UsageFlush, &policy.UsageFlush, true); err != nil {
+		return policy, err
+	}
+
+	if len(c.ReadCategories) > 0 {
+		policy.ReadCategories = make(map[string]bool, len(c.ReadCategories))
+		for _, category := range c.ReadCategories {
+			policy.ReadCategories[category] = true
+		}
+	}
+
+	return policy, nil
+}
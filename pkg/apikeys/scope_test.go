@@ -0,0 +1,132 @@
+package apikeys
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+)
+
+func Test_ScopeAllows(t *testing.T) {
+	reads := map[string]bool{"read": true}
+
+	if !(Scope{}.Allows("proj1", "inst1", "write", reads)) {
+		t.Fatalf("Expected an empty scope to allow everything")
+	}
+
+	scope := Scope{ReadOnly: true, Projects: []string{"proj1"}}
+	if !scope.Allows("proj1", "inst1", "read", reads) {
+		t.Fatalf("Expected read only scope to allow reads of proj1")
+	}
+	if scope.Allows("proj1", "inst1", "write", reads) {
+		t.Fatalf("Expected read only scope to refuse writes")
+	}
+	if scope.Allows("proj2", "inst1", "read", reads) {
+		t.Fatalf("Expected scope to refuse proj2")
+	}
+
+	scope = Scope{Instances: []string{"proj1/inst1"}, Categories: []string{"admin"}}
+	if !scope.Allows("proj1", "inst1", "admin", reads) {
+		t.Fatalf("Expected scope to allow admin on proj1/inst1")
+	}
+	if scope.Allows("proj1", "inst2", "admin", reads) {
+		t.Fatalf("Expected scope to refuse proj1/inst2")
+	}
+	if scope.Allows("proj1", "inst1", "read", reads) {
+		t.Fatalf("Expected scope to refuse categories it does not list")
+	}
+}
+
+func Test_ScopeValidate(t *testing.T) {
+	if err := (Scope{Projects: []string{"proj1"}, Instances: []string{"proj1/inst1"}}).Validate(); err != nil {
+		t.Fatalf("Expected valid scope but got err - %s", err)
+	}
+
+	for _, scope := range []Scope{{Instances: []string{"inst1"}}, {Instances: []string{"proj1/"}}, {Projects: []string{""}}} {
+		if err := scope.Validate(); !errors.Is(err, ErrInvalidScope) {
+			t.Fatalf("Expected invalid scope error for %v but got %v", scope, err)
+		}
+	}
+}
+
+func Test_Authenticate(t *testing.T) {
+	now := time.Now()
+	record, key, _ := New("tester", now, time.Hour)
+	_, secret, _ := Parse(key)
+
+	if err := record.Authenticate(secret, now); err != nil {
+		t.Fatalf("Expected key to authenticate but got err - %s", err)
+	}
+	if err := record.Authenticate(secret+"x", now); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected not found error for a wrong secret but got %v", err)
+	}
+	if err := record.Authenticate(secret, now.Add(time.Hour)); err != ErrAPIKeyExpired {
+		t.Fatalf("Expected expired error but got %v", err)
+	}
+
+	record.Disabled = true
+	if err := record.Authenticate(secret, now); err != ErrAPIKeyDisabled {
+		t.Fatalf("Expected disabled error but got %v", err)
+	}
+
+	if (&Record{}).Expired(now) {
+		t.Fatalf("Expected keys without an expiry not to expire")
+	}
+}
+
+func Test_PolicyExpiry(t *testing.T) {
+	now := time.Now()
+	policy := Policy{DefaultLifetime: 2 * time.Hour, MaxLifetime: 24 * time.Hour}
+
+	expires, err := policy.Expiry(now, time.Time{})
+	if err != nil || !expires.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("Expected default lifetime but got %s - %v", expires, err)
+	}
+
+	expires, err = policy.Expiry(now, now.Add(time.Hour))
+	if err != nil || !expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expected requested expiry but got %s - %v", expires, err)
+	}
+
+	if _, err = policy.Expiry(now, now.Add(-time.Minute)); !errors.Is(err, ErrInvalidExpiry) {
+		t.Fatalf("Expected invalid expiry error for a past expiry but got %v", err)
+	}
+
+	if _, err = policy.Expiry(now, now.Add(25*time.Hour)); !errors.Is(err, ErrInvalidExpiry) {
+		t.Fatalf("Expected invalid expiry error beyond the maximum lifetime but got %v", err)
+	}
+
+	policy.MaxLifetime = time.Hour
+	if expires, _ = policy.Expiry(now, time.Time{}); !expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expected the default lifetime to be capped by the maximum but got %s", expires)
+	}
+}
+
+func Test_ConfigPolicy(t *testing.T) {
+	policy, err := Config{}.Policy()
+	if err != nil || !reflect.DeepEqual(policy, DefaultPolicy()) {
+		t.Fatalf("Expected default policy but got %v - %v", policy, err)
+	}
+
+	policy, err = Config{DefaultLifetime: "720h", MaxLifetime: "8760h", ReadCategories: []string{"view"}, SweepInterval: "0",
+		RotationGrace: "1h", UsageFlush: "0"}.Policy()
+	if err != nil {
+		t.Fatalf("Expected policy but got err - %s", err)
+	}
+
+	expected := Policy{DefaultLifetime: 720 * time.Hour, MaxLifetime: 8760 * time.Hour, ReadCategories: map[string]bool{"view": true},
+		RotationGrace: time.Hour}
+	if !reflect.DeepEqual(policy, expected) {
+		t.Fatalf("Expected %v but got %v", expected, policy)
+	}
+
+	if _, err = (Config{DefaultLifetime: "0"}).Policy(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected invalid config error for a zero lifetime but got %v", err)
+	}
+
+	if _, err = (Config{SweepInterval: "soon"}).Policy(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected invalid config error for an unparsable interval but got %v", err)
+	}
+}
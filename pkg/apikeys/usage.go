@@ -0,0 +1,94 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+)
+
+// UsageBuffer collects the usage of keys in memory so requests do not write to the repository. While it is running,
+// Flush adds what was collected to the store with one write per key.
+type UsageBuffer struct {
+	mu      sync.Mutex
+	running bool
+	pending map[string]Usage
+}
+
+func NewUsageBuffer() *UsageBuffer {
+	return &UsageBuffer{pending: make(map[string]Usage)}
+}
+
+// Start makes Record collect usage
+func (b *UsageBuffer) Start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = true
+}
+
+// Stop makes Record refuse usage so callers write it themselves. What was collected stays until the next Flush.
+func (b *UsageBuffer) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = false
+}
+
+// Record collects a request made with the key id from ip at now. It returns false when the buffer is stopped.
+func (b *UsageBuffer) Record(id, ip string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.running {
+		return false
+	}
+
+	usage := b.pending[id]
+	usage.Record(ip, now)
+	b.pending[id] = usage
+	return true
+}
+
+// Pending returns the usage of the key id that was not written yet
+func (b *UsageBuffer) Pending(id string) Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending[id]
+}
+
+// Empty reports whether there is no usage to write
+func (b *UsageBuffer) Empty() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending) == 0
+}
+
+// Flush writes the collected usage to store. Usage of keys that no longer exist is dropped; usage that could not be
+// written is kept for the next Flush and the first error is returned.
+func (b *UsageBuffer) Flush(ctx context.Context, store Store) error {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[string]Usage)
+	b.mu.Unlock()
+
+	var failed error
+	for id, usage := range pending {
+		err := store.RecordAPIKeyUse(ctx, id, usage)
+		if err == nil || errors.Is(err, errs.ErrDBItemNotFound) {
+			continue
+		}
+
+		b.mu.Lock()
+		kept := b.pending[id]
+		kept.Add(usage)
+		b.pending[id] = kept
+		b.mu.Unlock()
+
+		if failed == nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
@@ -0,0 +1,89 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+)
+
+type usageStore struct {
+	Store
+	usage map[string]Usage
+	err   error
+}
+
+func (s *usageStore) RecordAPIKeyUse(ctx context.Context, id string, usage Usage) error {
+	if s.err != nil {
+		return s.err
+	}
+	if _, ok := s.usage[id]; !ok {
+		return errs.ErrDBItemNotFound
+	}
+
+	current := s.usage[id]
+	current.Add(usage)
+	s.usage[id] = current
+	return nil
+}
+
+func Test_UsageBuffer(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := &usageStore{usage: map[string]Usage{"key-1": {LastUsed: now, LastIP: "10.1.0.1", Requests: 5}}}
+
+	buffer := NewUsageBuffer()
+	if buffer.Record("key-1", "10.1.0.9", now) {
+		t.Fatalf("Expected a stopped buffer to leave the write to the caller")
+	}
+
+	buffer.Start()
+	for _, ip := range []string{"10.1.0.9", "10.1.0.8"} {
+		now = now.Add(time.Minute)
+		if !buffer.Record("key-1", ip, now) {
+			t.Fatalf("Expected usage from %s to be buffered", ip)
+		}
+	}
+	if !buffer.Record("deleted", "10.1.0.8", now) {
+		t.Fatalf("Expected usage of the deleted key to be buffered")
+	}
+
+	expected := Usage{LastUsed: now, LastIP: "10.1.0.8", Requests: 2}
+	if pending := buffer.Pending("key-1"); pending != expected {
+		t.Fatalf("Expected pending usage %v but got %v", expected, pending)
+	}
+
+	store.err = errors.New("unavailable")
+	if err := buffer.Flush(ctx, store); !errors.Is(err, store.err) {
+		t.Fatalf("Expected %s but got %v", store.err, err)
+	}
+	if pending := buffer.Pending("key-1"); pending.Requests != 2 {
+		t.Fatalf("Expected usage that was not written to be kept but got %d requests", pending.Requests)
+	}
+
+	store.err = nil
+	if err := buffer.Flush(ctx, store); err != nil {
+		t.Fatalf("Expected usage to be written but got err - %s", err)
+	}
+
+	expected.Requests = 7
+	if usage := store.usage["key-1"]; usage != expected {
+		t.Fatalf("Expected stored usage %v but got %v", expected, usage)
+	}
+	if pending := buffer.Pending("key-1"); pending != (Usage{}) {
+		t.Fatalf("Expected no pending usage but got %v", pending)
+	}
+	if pending := buffer.Pending("deleted"); pending != (Usage{}) {
+		t.Fatalf("Expected usage of deleted keys to be dropped but got %v", pending)
+	}
+	if !buffer.Empty() {
+		t.Fatalf("Expected buffer to be empty")
+	}
+
+	buffer.Stop()
+	if buffer.Record("key-1", "10.1.0.9", now) {
+		t.Fatalf("Expected a stopped buffer to leave the write to the caller")
+	}
+}
@@ -0,0 +1,405 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/id"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/internal/helper"
+	"github.com/zbitech/repo/pkg/apikeys"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type AdminBoltRepository struct {
+	conn *BoltDBConnection
+}
+
+func NewAdminBoltRepository(conn *BoltDBConnection) *AdminBoltRepository {
+	return &AdminBoltRepository{conn: conn}
+}
+
+func (m *AdminBoltRepository) RegisterUser(ctx context.Context, user *entity.User, pass *entity.UserPassword) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RegisterUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if USERS.Exists(tx, user.UserId) {
+			return errs.ErrUserAlreadyExists
+		}
+
+		user.Created = time.Now()
+		user.Active = true
+		user.LastUpdate = time.Now()
+
+		if err := USERS.Insert(tx, user.UserId, user); err != nil {
+			logger.Errorf(ctx, "Error inserting user info - %s", err)
+			return err
+		}
+
+		if pass != nil {
+			if err := PASSWORDS.Put(tx, user.UserId, pass); err != nil {
+				logger.Errorf(ctx, "Error setting user password - %s", err)
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err == errs.ErrUserAlreadyExists {
+		return err
+	} else if err != nil {
+		return errs.ErrDBItemInsertFailed
+	}
+
+	logger.Infof(ctx, "Inserted user with id %s", user.UserId)
+	return nil
+}
+
+func (m *AdminBoltRepository) GetUsers(ctx context.Context) []entity.User {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUsers"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	users := make([]entity.User, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return USERS.ForEach(tx, func(id string, doc bson.Raw) error {
+			var user entity.User
+			if err := bson.Unmarshal(doc, &user); err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Got an error instead of list of users - %s", err)
+		return nil
+	}
+
+	return users
+}
+
+func (m *AdminBoltRepository) GetUser(ctx context.Context, userId string) (*entity.User, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	user := entity.User{}
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return USERS.Decode(tx, userId, &user)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &user, nil
+}
+
+func (m *AdminBoltRepository) UpdateUser(ctx context.Context, user *entity.User) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdateUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if !USERS.Exists(tx, user.UserId) {
+			return errs.ErrDBItemNotFound
+		}
+
+		user.LastUpdate = time.Now()
+		return USERS.Put(tx, user.UserId, user)
+	})
+
+	if err != nil {
+		if err == errs.ErrDBItemNotFound {
+			return err
+		}
+
+		return errs.ErrDBItemUpdateFailed
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) UpdatePassword(ctx context.Context, userid string, password *entity.UserPassword) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdatePassword"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if !USERS.Exists(tx, userid) {
+			return errs.ErrDBItemNotFound
+		}
+
+		return PASSWORDS.Put(tx, userid, password)
+	})
+
+	if err != nil {
+		if err == errs.ErrDBItemNotFound {
+			return err
+		}
+
+		return errs.ErrDBItemUpdateFailed
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) AuthenticateUser(ctx context.Context, userId, password string) (*string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AuthenticateUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	user := entity.User{}
+	pass := entity.UserPassword{}
+
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		if err := USERS.Decode(tx, userId, &user); err != nil {
+			return err
+		}
+
+		return PASSWORDS.Decode(tx, userId, &pass)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	if id.ValidatePassword(pass.Password, []byte(password)) {
+		return helper.GenerateJwtToken(user)
+	}
+
+	return nil, errs.ErrAuthFailed
+}
+
+func (m *AdminBoltRepository) GetAPIKeys(ctx context.Context, userId string) ([]string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	keys := make([]string, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return APIKEYS.Find(tx, "userid", []string{userId}, func(key string, doc bson.Raw) error {
+			keys = append(keys, key)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return keys, nil
+}
+
+func (m *AdminBoltRepository) GetAPIKey(ctx context.Context, apiKey string) (*entity.APIKey, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminBoltRepository.GetAPIKey"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	id, secret, err := apikeys.Parse(apiKey)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	record, err := m.GetAPIKeyRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = record.Authenticate(secret, time.Now()); err != nil {
+		logger.Errorf(ctx, "Rejected api key %s - %s", id, err)
+		return nil, err
+	}
+
+	return record.APIKey(), nil
+}
+
+// CreateAPIKey returns the full key once. Only its id and the hash of its secret are stored.
+func (m *AdminBoltRepository) CreateAPIKey(ctx context.Context, user_id string) (*entity.APIKey, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminBoltRepository.CreateAPIKey"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	record, key, err := apikeys.New(user_id, time.Now(), time.Duration(vars.HOURS_IN_YEAR)*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.CreateAPIKeyRecord(ctx, record); err != nil {
+		return nil, err
+	}
+
+	apikey := record.APIKey()
+	apikey.Key = key
+	return apikey, nil
+}
+
+func (m *AdminBoltRepository) DeleteAPIKey(ctx context.Context, apiKey string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminBoltRepository.DeleteAPIKey"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	id := apikeys.ID(apiKey)
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if err := APIKEYS.Delete(tx, id); err != nil {
+			return err
+		}
+
+		if APIKEY_POLICY.Exists(tx, id) {
+			return APIKEY_POLICY.Delete(tx, id)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) StoreUserPolicy(ctx context.Context, p entity.UserPolicy) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminBoltRepository.StoreUserPolicy"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	p.Updated = time.Now()
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return USER_POLICY.Put(tx, p.UserId, p)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetUserPolicy(ctx context.Context, userId string) (*entity.UserPolicy, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUserPolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := entity.UserPolicy{}
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return USER_POLICY.Decode(tx, userId, &item)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &item, nil
+}
+
+func (m *AdminBoltRepository) StoreInstancePolicy(ctx context.Context, p entity.InstancePolicy) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "StoreInstancePolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.StoreInstancePolicies(ctx, []entity.InstancePolicy{p})
+}
+
+func (m *AdminBoltRepository) StoreInstancePolicies(ctx context.Context, p []entity.InstancePolicy) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "StoreInstancePolicies"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		for _, i := range p {
+			if err := INSTANCE_POLICY.Put(tx, instancePolicyId(i.Project, i.Instance), i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetInstanceMethodPolicy(ctx context.Context, project, instance, methodName string) (*entity.MethodPolicy, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstanceMethodPolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.GetInstancePolicy(ctx, project, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return item.GetMethodByName(methodName), nil
+}
+
+func (m *AdminBoltRepository) GetInstancePolicy(ctx context.Context, project, instance string) (*entity.InstancePolicy, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminBoltRepository.GetInstancePolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := entity.InstancePolicy{}
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return INSTANCE_POLICY.Decode(tx, instancePolicyId(project, instance), &item)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &item, nil
+}
+
+func (m *AdminBoltRepository) GetInstanceMethodPolicies(ctx context.Context, project, instance, methodCategory string) ([]entity.MethodPolicy, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstanceMethodPolicies"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.GetInstancePolicy(ctx, project, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return item.GetMethodsByCategory(methodCategory), nil
+}
+
+func (m *AdminBoltRepository) StoreAPIKeyPolicy(ctx context.Context, p entity.APIKeyPolicy) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "StoreAPIKeyPolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	p.Key = apikeys.ID(p.Key)
+	p.Updated = time.Now()
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return APIKEY_POLICY.Put(tx, p.Key, p)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetAPIKeyPolicy(ctx context.Context, key string) (*entity.APIKeyPolicy, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKeyPolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := entity.APIKeyPolicy{}
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return APIKEY_POLICY.Decode(tx, apikeys.ID(key), &item)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &item, nil
+}
@@ -0,0 +1,252 @@
+package boltdb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/model/config"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/repo/internal/admintest"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"github.com/zbitech/repo/pkg/seed"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestConnection(t *testing.T) *BoltDBConnection {
+
+	ctx := context.Background()
+	conn := NewBoltDBConnection(filepath.Join(t.TempDir(), "zbirepo.db"), time.Second)
+	if err := conn.OpenConnection(ctx); err != nil {
+		t.Fatalf("Expected connection but got err - %s", err)
+	}
+	t.Cleanup(func() { conn.CloseConnection(ctx) })
+
+	if err := CreateBuckets(ctx, conn); err != nil {
+		t.Fatalf("Expected buckets to be created but got err - %s", err)
+	}
+
+	return conn
+}
+
+func Test_AdminStore(t *testing.T) {
+	admintest.Run(t, func(t *testing.T) admintest.Store {
+		return NewAdminBoltRepository(newTestConnection(t))
+	})
+}
+
+func Test_RegisterUser(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminBoltRepository(newTestConnection(t))
+
+	user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+	pass := entity.NewUserPassword(user.UserId, "password")
+	if err := repo.RegisterUser(ctx, &user, &pass); err != nil {
+		t.Fatalf("Expected user to be registered but got err - %s", err)
+	}
+
+	if err := repo.RegisterUser(ctx, &user, &pass); err != errs.ErrUserAlreadyExists {
+		t.Fatalf("Expected duplicate registration to fail but got %v", err)
+	}
+
+	other := entity.User{UserId: "other", Email: user.Email}
+	if err := repo.RegisterUser(ctx, &other, nil); err == nil {
+		t.Fatalf("Expected registration with a duplicate email to fail")
+	}
+
+	stored, err := repo.GetUser(ctx, user.UserId)
+	if err != nil || stored.Email != user.Email {
+		t.Fatalf("Expected registered user but got %v - %s", stored, err)
+	}
+
+	// users without an email do not collide on the unique email index
+	for _, userId := range []string{"noemail1", "noemail2"} {
+		if err := repo.RegisterUser(ctx, &entity.User{UserId: userId}, nil); err != nil {
+			t.Fatalf("Expected user without email to be registered but got err - %s", err)
+		}
+	}
+
+	if users := repo.GetUsers(ctx); len(users) != 3 {
+		t.Fatalf("Expected three users but got %d", len(users))
+	}
+}
+
+func Test_UpdateUser(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminBoltRepository(newTestConnection(t))
+
+	user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+	if err := repo.UpdateUser(ctx, &user); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected update of unknown user to fail but got %v", err)
+	}
+
+	if err := repo.RegisterUser(ctx, &user, nil); err != nil {
+		t.Fatalf("Expected user to be registered but got err - %s", err)
+	}
+
+	user.Email = "changed@zbitech.local"
+	if err := repo.UpdateUser(ctx, &user); err != nil {
+		t.Fatalf("Expected user to be updated but got err - %s", err)
+	}
+
+	// the email index must follow the update so the old address can be reused
+	other := entity.User{UserId: "other", Email: "tester@zbitech.local"}
+	if err := repo.RegisterUser(ctx, &other, nil); err != nil {
+		t.Fatalf("Expected previous email to be available but got err - %s", err)
+	}
+}
+
+func Test_APIKeys(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminBoltRepository(newTestConnection(t))
+
+	apikey, err := repo.CreateAPIKey(ctx, "tester")
+	if err != nil {
+		t.Fatalf("Expected api key but got err - %s", err)
+	}
+
+	keys, err := repo.GetAPIKeys(ctx, "tester")
+	if err != nil || len(keys) != 1 || keys[0] != apikeys.ID(apikey.Key) {
+		t.Fatalf("Expected [%s] but got %v - %s", apikeys.ID(apikey.Key), keys, err)
+	}
+
+	stored, err := repo.GetAPIKey(ctx, apikey.Key)
+	if err != nil || stored.Key != keys[0] || stored.UserId != "tester" {
+		t.Fatalf("Expected the key by its id but got %v - %v", stored, err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, apikey.Key+"x"); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected a wrong secret to be rejected but got %v", err)
+	}
+
+	if err = repo.DeleteAPIKey(ctx, apikey.Key); err != nil {
+		t.Fatalf("Expected api key to be deleted but got err - %s", err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, apikey.Key); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected deleted api key to be missing but got %v", err)
+	}
+}
+
+func Test_TeamMembers(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminBoltRepository(newTestConnection(t))
+
+	member := entity.TeamMember{Key: "key", TeamId: "team", Email: "member@zbitech.local"}
+	if err := repo.AddTeamMember(ctx, "team", member); err != nil {
+		t.Fatalf("Expected member to be added but got err - %s", err)
+	}
+
+	duplicate := entity.TeamMember{Key: "other", TeamId: "team", Email: member.Email}
+	if err := repo.AddTeamMember(ctx, "team", duplicate); err != errs.ErrDBKeyAlreadyExists {
+		t.Fatalf("Expected duplicate member to fail but got %v", err)
+	}
+
+	if err := repo.UpdateTeamMemberStatus(ctx, "team", "key", ztypes.EXPIRED_INVITATION); err != nil {
+		t.Fatalf("Expected member status to be updated but got err - %s", err)
+	}
+
+	count, err := repo.PurgeExpiredInvitations(ctx)
+	if err != nil || count != 1 {
+		t.Fatalf("Expected one expired invitation to be purged but got %d - %s", count, err)
+	}
+
+	if members, _ := repo.GetTeamMembers(ctx, "team"); len(members) != 0 {
+		t.Fatalf("Expected no members but got %d", len(members))
+	}
+}
+
+func Test_SeedDatabase(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminBoltRepository(newTestConnection(t))
+
+	adminConfig := &config.AdminConfig{
+		Users:     []entity.User{{UserId: "admin", Email: "admin@zbitech.local"}, {UserId: "other", Email: "admin@zbitech.local"}},
+		Passwords: map[string]string{"admin": "password"},
+		Keys:      []entity.APIKey{{Key: "key", UserId: "admin"}},
+		Teams:     []entity.Team{{TeamId: "team", Name: "team", Owner: "admin"}},
+		Members:   []entity.TeamMember{{Key: "member", TeamId: "team", Email: "member@zbitech.local"}},
+	}
+
+	report, err := seed.Load(ctx, repo, adminConfig, true)
+	if err != nil || report.Count("", seed.SEED_CREATED) != 5 {
+		t.Fatalf("Expected dry run to report 5 items to create but got %v - %v", report, err)
+	}
+
+	if users := repo.GetUsers(ctx); len(users) != 0 {
+		t.Fatalf("Expected dry run not to write but got %d users", len(users))
+	}
+
+	report, err = seed.Load(ctx, repo, adminConfig, false)
+	if err != nil || report.Count("", seed.SEED_CREATED) != 4 || report.Count(seed.SEED_USER, seed.SEED_CONFLICTED) != 1 {
+		t.Fatalf("Expected 4 items created and a conflicting user but got %v - %v", report, err)
+	}
+
+	if _, err = repo.GetUserPolicy(ctx, "admin"); err != nil {
+		t.Fatalf("Expected seeded user policy but got err - %s", err)
+	}
+
+	adminConfig.Teams[0].Name = "renamed"
+	report, err = seed.Load(ctx, repo, adminConfig, false)
+	if err != nil || report.Count("", seed.SEED_CREATED) != 0 || report.Count("", seed.SEED_UNCHANGED) != 3 ||
+		report.Count(seed.SEED_TEAM, seed.SEED_CONFLICTED) != 1 {
+		t.Fatalf("Expected reseeding to create nothing but got %v - %v", report, err)
+	}
+
+	if team, err := repo.GetTeam(ctx, "team"); err != nil || team.Name != "team" {
+		t.Fatalf("Expected stored team to be kept but got %v - %v", team, err)
+	}
+}
+
+func Test_MigrateAPIKeys(t *testing.T) {
+
+	ctx := context.Background()
+	conn := newTestConnection(t)
+	repo := NewAdminBoltRepository(conn)
+
+	legacy := entity.NewAPIKey("tester", 1)
+	err := conn.Update(func(tx *bolt.Tx) error {
+		if err := APIKEYS.Put(tx, legacy.Key, legacy); err != nil {
+			return err
+		}
+		return APIKEY_POLICY.Put(tx, legacy.Key, entity.NewAPIKeyPolicy(legacy.Key, true))
+	})
+	if err != nil {
+		t.Fatalf("Expected plaintext key to be stored but got err - %s", err)
+	}
+
+	if count, err := MigrateAPIKeys(ctx, conn); err != nil || count != 1 {
+		t.Fatalf("Expected 1 key to be hashed but got %d - %v", count, err)
+	}
+
+	if count, err := MigrateAPIKeys(ctx, conn); err != nil || count != 0 {
+		t.Fatalf("Expected no keys left to hash but got %d - %v", count, err)
+	}
+
+	stored, err := repo.GetAPIKey(ctx, legacy.Key)
+	if err != nil || stored.Key != apikeys.ID(legacy.Key) || stored.Key == legacy.Key {
+		t.Fatalf("Expected the plaintext key to keep working under a new id but got %v - %v", stored, err)
+	}
+
+	if policy, err := repo.GetAPIKeyPolicy(ctx, stored.Key); err != nil || policy.Key != stored.Key {
+		t.Fatalf("Expected the policy to move to the new id but got %v - %v", policy, err)
+	}
+
+	err = conn.View(func(tx *bolt.Tx) error {
+		if APIKEYS.Exists(tx, legacy.Key) || APIKEY_POLICY.Exists(tx, legacy.Key) {
+			t.Fatalf("Expected the plaintext key to be removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to read buckets - %s", err)
+	}
+}
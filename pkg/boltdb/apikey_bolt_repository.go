@@ -0,0 +1,253 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/apikeys"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MigrateAPIKeys hashes the API keys stored in plaintext by earlier versions and moves their policies to the new
+// ids. The keys keep working as they are.
+func MigrateAPIKeys(ctx context.Context, conn *BoltDBConnection) (int, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "MigrateAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	count := 0
+	err := conn.Update(func(tx *bolt.Tx) error {
+		legacy := make([]apikeys.Record, 0)
+		err := APIKEYS.ForEach(tx, func(id string, doc bson.Raw) error {
+			var record apikeys.Record
+			if err := bson.Unmarshal(doc, &record); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			if !record.Hashed() {
+				legacy = append(legacy, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, old := range legacy {
+			record := apikeys.FromPlaintext(entity.APIKey{Key: old.Key, UserId: old.UserId, Created: old.Created, Expires: old.Expires})
+			if err = APIKEYS.Delete(tx, old.Key); err != nil {
+				return err
+			}
+			if err = APIKEYS.Put(tx, record.Key, record); err != nil {
+				return err
+			}
+
+			var policy entity.APIKeyPolicy
+			if err = APIKEY_POLICY.Decode(tx, old.Key, &policy); err == errs.ErrDBItemNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			policy.Key = record.Key
+			if err = APIKEY_POLICY.Delete(tx, old.Key); err != nil {
+				return err
+			}
+			if err = APIKEY_POLICY.Put(tx, record.Key, policy); err != nil {
+				return err
+			}
+		}
+
+		count = len(legacy)
+		return nil
+	})
+
+	if err != nil {
+		return 0, handleBoltError(ctx, err)
+	}
+
+	if count > 0 {
+		logger.Infof(ctx, "Hashed %d plaintext api keys", count)
+	}
+
+	return count, nil
+}
+
+func (m *AdminBoltRepository) CreateAPIKeyRecord(ctx context.Context, r *apikeys.Record) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return APIKEYS.Insert(tx, r.Key, r)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Unable to store API key - %s", err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetAPIKeyRecord(ctx context.Context, id string) (*apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var record apikeys.Record
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return APIKEYS.Decode(tx, id, &record)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &record, nil
+}
+
+func (m *AdminBoltRepository) DisableExpiredAPIKeys(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DisableExpiredAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		expired := make([]apikeys.Record, 0)
+		err := APIKEYS.ForEach(tx, func(id string, doc bson.Raw) error {
+			var record apikeys.Record
+			if err := bson.Unmarshal(doc, &record); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			if !record.Disabled && record.Expired(now) {
+				expired = append(expired, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for index := range expired {
+			expired[index].Disabled = true
+			if err = APIKEYS.Put(tx, expired[index].Key, expired[index]); err != nil {
+				return err
+			}
+		}
+
+		count = int64(len(expired))
+		return nil
+	})
+
+	if err != nil {
+		return 0, handleBoltError(ctx, err)
+	}
+
+	return count, nil
+}
+
+func (m *AdminBoltRepository) RotateAPIKeyRecord(ctx context.Context, id string, next *apikeys.Record, graceEnds time.Time) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		var record apikeys.Record
+		if err := APIKEYS.Decode(tx, id, &record); err != nil {
+			return err
+		}
+
+		if err := record.Supersede(next.Key, graceEnds); err != nil {
+			return err
+		}
+
+		if err := APIKEYS.Put(tx, record.Key, record); err != nil {
+			return err
+		}
+
+		if err := APIKEYS.Insert(tx, next.Key, next); err != nil {
+			return err
+		}
+
+		var policy entity.APIKeyPolicy
+		if err := APIKEY_POLICY.Decode(tx, id, &policy); err == errs.ErrDBItemNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		policy.Key = next.Key
+		policy.Updated = next.Created
+		return APIKEY_POLICY.Put(tx, next.Key, policy)
+	})
+
+	if err == apikeys.ErrAPIKeyRotated {
+		return err
+	} else if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+// updateAPIKeyRecord applies update to the stored key in one transaction
+func (m *AdminBoltRepository) updateAPIKeyRecord(ctx context.Context, id string, update func(r *apikeys.Record)) error {
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		var record apikeys.Record
+		if err := APIKEYS.Decode(tx, id, &record); err != nil {
+			return err
+		}
+
+		update(&record)
+		return APIKEYS.Put(tx, record.Key, record)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) SetAPIKeyNetworks(ctx context.Context, id string, networks []string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetAPIKeyNetworks"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateAPIKeyRecord(ctx, id, func(r *apikeys.Record) {
+		r.Networks = networks
+	})
+}
+
+func (m *AdminBoltRepository) RecordAPIKeyUse(ctx context.Context, id string, usage apikeys.Usage) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RecordAPIKeyUse"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateAPIKeyRecord(ctx, id, func(r *apikeys.Record) {
+		r.Usage.Add(usage)
+	})
+}
+
+func (m *AdminBoltRepository) GetUnusedAPIKeys(ctx context.Context, since time.Time) ([]apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUnusedAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	unused := make([]apikeys.Record, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return APIKEYS.ForEach(tx, func(id string, doc bson.Raw) error {
+			var record apikeys.Record
+			if err := bson.Unmarshal(doc, &record); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			if record.UnusedSince(since) {
+				unused = append(unused, record)
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return unused, nil
+}
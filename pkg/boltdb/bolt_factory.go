@@ -0,0 +1,132 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zbitech/common/interfaces"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo"
+	"github.com/zbitech/repo/pkg/seed"
+)
+
+// BoltConfig locates the database file. It is read from bolt.yaml in the asset directory and defaults to
+// zbirepo.db in the same directory.
+type BoltConfig struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func ReadBoltConfig(ctx context.Context) (BoltConfig, error) {
+
+	cfg := BoltConfig{Path: fmt.Sprintf("%s/zbirepo.db", vars.ASSET_PATH_DIRECTORY), Timeout: 5 * time.Second}
+
+	configPath := fmt.Sprintf("%s/bolt.yaml", vars.ASSET_PATH_DIRECTORY)
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return cfg, nil
+	}
+
+	if err := utils.ReadConfig(configPath, nil, &cfg); err != nil {
+		logger.Errorf(ctx, "Unable to read bolt config: %s", err)
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+type BoltRepositoryFactory struct {
+	project interfaces.ProjectRepositoryIF
+	admin   interfaces.AdminRepositoryIF
+	conn    *BoltDBConnection
+}
+
+func init() {
+	repo.RegisterRepositoryFactory("bolt", NewBoltRepositoryFactory)
+}
+
+func NewBoltRepositoryFactory() interfaces.RepositoryFactoryIF {
+	return &BoltRepositoryFactory{}
+}
+
+func (r *BoltRepositoryFactory) Init(ctx context.Context, create_db, load_db bool) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "BoltRepositoryFactory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	cfg, err := ReadBoltConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.conn = NewBoltDBConnection(cfg.Path, cfg.Timeout)
+	if err = r.conn.OpenConnection(ctx); err != nil {
+		return err
+	}
+
+	if create_db {
+		logger.Infof(ctx, "Creating Bolt DB buckets")
+		err = r.CreateDatabase(ctx, false, load_db)
+	} else {
+		err = CreateBuckets(ctx, r.conn)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = MigrateAPIKeys(ctx, r.conn); err != nil {
+		return err
+	}
+
+	r.project = NewProjectBoltRepository(r.conn)
+	r.admin = NewAdminBoltRepository(r.conn)
+
+	return nil
+}
+
+func (r *BoltRepositoryFactory) OpenConnection(ctx context.Context) error {
+	return r.conn.OpenConnection(ctx)
+}
+
+func (r *BoltRepositoryFactory) CloseConnection(ctx context.Context) error {
+	r.conn.CloseConnection(ctx)
+	return nil
+}
+
+func (r *BoltRepositoryFactory) GetProjectRepository() interfaces.ProjectRepositoryIF {
+	return r.project
+}
+
+func (r *BoltRepositoryFactory) GetAdminRepository() interfaces.AdminRepositoryIF {
+	return r.admin
+}
+
+func (r *BoltRepositoryFactory) CreateDatabase(ctx context.Context, purge, load bool) error {
+
+	if purge {
+		if err := DropBuckets(ctx, r.conn); err != nil {
+			return err
+		}
+	}
+
+	if err := CreateBuckets(ctx, r.conn); err != nil {
+		return err
+	}
+
+	if load {
+		_, err := r.Seed(ctx, false)
+		return err
+	}
+
+	return nil
+}
+
+// Seed adds the missing items of admin.yaml and reports what was created, or would be created in a dry run
+func (r *BoltRepositoryFactory) Seed(ctx context.Context, dryRun bool) (*seed.Report, error) {
+	return LoadDatabase(ctx, r.conn, dryRun)
+}
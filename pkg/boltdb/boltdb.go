@@ -0,0 +1,365 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/seed"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	BOLTDB_BUCKET_PROJECTS        = "projects"
+	BOLTDB_BUCKET_INSTANCES       = "instances"
+	BOLTDB_BUCKET_RESOURCES       = "k8s_resources"
+	BOLTDB_BUCKET_USERS           = "users"
+	BOLTDB_BUCKET_PASSWORD        = "password"
+	BOLTDB_BUCKET_INSTANCE_POLICY = "instance_policy"
+	BOLTDB_BUCKET_USER_POLICY     = "user_policy"
+	BOLTDB_BUCKET_APIKEY          = "apikeys"
+	BOLTDB_BUCKET_APIKEY_POLICY   = "apikey_policy"
+	BOLTDB_BUCKET_TEAMS           = "teams"
+	BOLTDB_BUCKET_TEAM_MEMBERS    = "team_members"
+	BOLTDB_BUCKET_SESSIONS        = "sessions"
+	BOLTDB_BUCKET_REVOKED_TOKENS  = "revoked_tokens"
+	BOLTDB_BUCKET_WATERMARKS      = "token_watermarks"
+	BOLTDB_BUCKET_LOGIN_ATTEMPTS  = "login_attempts"
+	BOLTDB_BUCKET_PASSWORD_HIST   = "password_history"
+	BOLTDB_BUCKET_RESET_TOKENS    = "reset_tokens"
+	BOLTDB_BUCKET_MFA             = "mfa"
+	BOLTDB_BUCKET_SERVICE_ACCOUNT = "service_accounts"
+
+	USERS           = BoltCollection{Name: BOLTDB_BUCKET_USERS, Indexes: []BoltIndex{{Name: "email", Fields: []string{"email"}, Unique: true}}}
+	PASSWORDS       = BoltCollection{Name: BOLTDB_BUCKET_PASSWORD}
+	APIKEYS         = BoltCollection{Name: BOLTDB_BUCKET_APIKEY, Indexes: []BoltIndex{{Name: "userid", Fields: []string{"userid"}}}}
+	INSTANCE_POLICY = BoltCollection{Name: BOLTDB_BUCKET_INSTANCE_POLICY}
+	USER_POLICY     = BoltCollection{Name: BOLTDB_BUCKET_USER_POLICY}
+	APIKEY_POLICY   = BoltCollection{Name: BOLTDB_BUCKET_APIKEY_POLICY}
+	PROJECTS        = BoltCollection{Name: BOLTDB_BUCKET_PROJECTS, Indexes: []BoltIndex{{Name: "owner", Fields: []string{"owner"}}, {Name: "team", Fields: []string{"teamid"}}}}
+	INSTANCES       = BoltCollection{Name: BOLTDB_BUCKET_INSTANCES, Indexes: []BoltIndex{{Name: "project", Fields: []string{"project"}}, {Name: "owner", Fields: []string{"owner"}}}}
+	RESOURCES       = BoltCollection{Name: BOLTDB_BUCKET_RESOURCES, Indexes: []BoltIndex{{Name: "level", Fields: []string{"level", "project", "instance"}}}}
+	TEAMS           = BoltCollection{Name: BOLTDB_BUCKET_TEAMS, Indexes: []BoltIndex{{Name: "owner", Fields: []string{"owner"}}}}
+	TEAM_MEMBERS    = BoltCollection{Name: BOLTDB_BUCKET_TEAM_MEMBERS, Indexes: []BoltIndex{{Name: "team", Fields: []string{"teamid"}}, {Name: "email", Fields: []string{"email"}}, {Name: "team_email", Fields: []string{"teamid", "email"}}}}
+
+	SESSIONS       = BoltCollection{Name: BOLTDB_BUCKET_SESSIONS, Indexes: []BoltIndex{{Name: "user_device", Fields: []string{"userid", "device"}}}}
+	REVOKED_TOKENS = BoltCollection{Name: BOLTDB_BUCKET_REVOKED_TOKENS}
+	WATERMARKS     = BoltCollection{Name: BOLTDB_BUCKET_WATERMARKS}
+	LOGIN_ATTEMPTS = BoltCollection{Name: BOLTDB_BUCKET_LOGIN_ATTEMPTS}
+
+	PASSWORD_HISTORY = BoltCollection{Name: BOLTDB_BUCKET_PASSWORD_HIST}
+	RESET_TOKENS     = BoltCollection{Name: BOLTDB_BUCKET_RESET_TOKENS, Indexes: []BoltIndex{{Name: "userid", Fields: []string{"userid"}}}}
+	MFA              = BoltCollection{Name: BOLTDB_BUCKET_MFA}
+	SERVICE_ACCOUNTS = BoltCollection{Name: BOLTDB_BUCKET_SERVICE_ACCOUNT, Indexes: []BoltIndex{{Name: "teamid", Fields: []string{"teamid"}}}}
+
+	COLLECTIONS = []BoltCollection{USERS, PASSWORDS, APIKEYS, INSTANCE_POLICY, USER_POLICY, APIKEY_POLICY, PROJECTS, INSTANCES, RESOURCES, TEAMS, TEAM_MEMBERS, SESSIONS, REVOKED_TOKENS, WATERMARKS, LOGIN_ATTEMPTS, PASSWORD_HISTORY, RESET_TOKENS, MFA, SERVICE_ACCOUNTS}
+)
+
+// BoltCollection is a bucket of BSON documents keyed by their primary key. Each index is kept in its own bucket
+// whose keys are the indexed field values followed by the primary key of the document.
+type BoltCollection struct {
+	Name    string
+	Indexes []BoltIndex
+}
+
+type BoltIndex struct {
+	Name   string
+	Fields []string
+	Unique bool
+}
+
+// boltResource matches the document stored by the Mongo repository for kubernetes resources
+type boltResource struct {
+	Id       string                    `bson:"_id"`
+	Project  string                    `bson:"project"`
+	Instance string                    `bson:"instance"`
+	Level    string                    `bson:"level"`
+	Resource entity.KubernetesResource `bson:"resource"`
+}
+
+const indexSeparator = "\x00"
+
+func (c BoltCollection) indexBucket(index BoltIndex) []byte {
+	return []byte(fmt.Sprintf("%s.%s", c.Name, index.Name))
+}
+
+func (i BoltIndex) prefix(values ...string) []byte {
+	var buffer bytes.Buffer
+	for _, value := range values {
+		buffer.WriteString(value)
+		buffer.WriteString(indexSeparator)
+	}
+	return buffer.Bytes()
+}
+
+func (i BoltIndex) values(doc bson.Raw) []string {
+	values := make([]string, len(i.Fields))
+	for index, field := range i.Fields {
+		values[index], _ = doc.Lookup(field).StringValueOK()
+	}
+	return values
+}
+
+// empty reports whether a field of the index is missing or empty in doc. Unique indexes do not constrain such
+// documents, like the unique constraints of the SQL and Mongo repositories.
+func (i BoltIndex) empty(doc bson.Raw) bool {
+	for _, value := range i.values(doc) {
+		if len(value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (i BoltIndex) key(doc bson.Raw, id string) []byte {
+	return append(i.prefix(i.values(doc)...), id...)
+}
+
+func (c BoltCollection) getIndex(name string) BoltIndex {
+	for _, index := range c.Indexes {
+		if index.Name == name {
+			return index
+		}
+	}
+	panic(fmt.Errorf("unknown index %s.%s", c.Name, name))
+}
+
+func handleBoltError(ctx context.Context, err error) error {
+	logger.Errorf(ctx, "Database error - %s", err)
+	if err == errs.ErrDBItemNotFound || err == errs.ErrDBKeyAlreadyExists {
+		return err
+	}
+
+	return errs.ErrDBError
+}
+
+// Put stores item under id and refreshes its index entries
+func (c BoltCollection) Put(tx *bolt.Tx, id string, item interface{}) error {
+
+	data, err := bson.Marshal(item)
+	if err != nil {
+		return errs.ErrMarshalFailed
+	}
+
+	bucket := tx.Bucket([]byte(c.Name))
+	if err = c.removeIndexes(tx, id, bucket.Get([]byte(id))); err != nil {
+		return err
+	}
+
+	for _, index := range c.Indexes {
+		idxBucket := tx.Bucket(c.indexBucket(index))
+		if index.Unique && !index.empty(data) {
+			prefix := index.prefix(index.values(data)...)
+			key, _ := idxBucket.Cursor().Seek(prefix)
+			if key != nil && bytes.HasPrefix(key, prefix) {
+				return errs.ErrDBKeyAlreadyExists
+			}
+		}
+
+		if err = idxBucket.Put(index.key(data, id), []byte(id)); err != nil {
+			return err
+		}
+	}
+
+	return bucket.Put([]byte(id), data)
+}
+
+// Insert stores item under id if no document already exists with that id
+func (c BoltCollection) Insert(tx *bolt.Tx, id string, item interface{}) error {
+
+	if c.Exists(tx, id) {
+		return errs.ErrDBKeyAlreadyExists
+	}
+
+	return c.Put(tx, id, item)
+}
+
+func (c BoltCollection) Exists(tx *bolt.Tx, id string) bool {
+	return tx.Bucket([]byte(c.Name)).Get([]byte(id)) != nil
+}
+
+// Get returns the raw document stored under id
+func (c BoltCollection) Get(tx *bolt.Tx, id string) (bson.Raw, error) {
+
+	data := tx.Bucket([]byte(c.Name)).Get([]byte(id))
+	if data == nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	return bson.Raw(data), nil
+}
+
+// Decode unmarshals the document stored under id into item
+func (c BoltCollection) Decode(tx *bolt.Tx, id string, item interface{}) error {
+
+	data, err := c.Get(tx, id)
+	if err != nil {
+		return err
+	}
+
+	if err = bson.Unmarshal(data, item); err != nil {
+		return errs.ErrMarshalFailed
+	}
+
+	return nil
+}
+
+func (c BoltCollection) Delete(tx *bolt.Tx, id string) error {
+
+	bucket := tx.Bucket([]byte(c.Name))
+	data := bucket.Get([]byte(id))
+	if data == nil {
+		return errs.ErrDBItemNotFound
+	}
+
+	if err := c.removeIndexes(tx, id, data); err != nil {
+		return err
+	}
+
+	return bucket.Delete([]byte(id))
+}
+
+func (c BoltCollection) removeIndexes(tx *bolt.Tx, id string, data []byte) error {
+
+	if data == nil {
+		return nil
+	}
+
+	for _, index := range c.Indexes {
+		if err := tx.Bucket(c.indexBucket(index)).Delete(index.key(data, id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ForEach calls fn with every document in the collection
+func (c BoltCollection) ForEach(tx *bolt.Tx, fn func(id string, doc bson.Raw) error) error {
+	return tx.Bucket([]byte(c.Name)).ForEach(func(k, v []byte) error {
+		return fn(string(k), bson.Raw(v))
+	})
+}
+
+// Find calls fn with every document whose indexed fields match values
+func (c BoltCollection) Find(tx *bolt.Tx, indexName string, values []string, fn func(id string, doc bson.Raw) error) error {
+
+	index := c.getIndex(indexName)
+	bucket := tx.Bucket([]byte(c.Name))
+	prefix := index.prefix(values...)
+
+	cursor := tx.Bucket(c.indexBucket(index)).Cursor()
+	for key, id := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, id = cursor.Next() {
+		data := bucket.Get(id)
+		if data == nil {
+			continue
+		}
+
+		if err := fn(string(id), bson.Raw(data)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Count returns the number of documents whose indexed fields match values
+func (c BoltCollection) Count(tx *bolt.Tx, indexName string, values ...string) int {
+
+	index := c.getIndex(indexName)
+	prefix := index.prefix(values...)
+
+	count := 0
+	cursor := tx.Bucket(c.indexBucket(index)).Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		count++
+	}
+
+	return count
+}
+
+func CreateBuckets(ctx context.Context, conn *BoltDBConnection) error {
+
+	return conn.Update(func(tx *bolt.Tx) error {
+		for _, coll := range COLLECTIONS {
+			if _, err := tx.CreateBucketIfNotExists([]byte(coll.Name)); err != nil {
+				logger.Errorf(ctx, "Unable to create bucket %s - %s", coll.Name, err)
+				return err
+			}
+
+			for _, index := range coll.Indexes {
+				// an index added after documents were stored is built from them
+				if tx.Bucket(coll.indexBucket(index)) != nil {
+					continue
+				}
+
+				idxBucket, err := tx.CreateBucket(coll.indexBucket(index))
+				if err != nil {
+					logger.Errorf(ctx, "Unable to create index %s.%s - %s", coll.Name, index.Name, err)
+					return err
+				}
+
+				err = coll.ForEach(tx, func(id string, doc bson.Raw) error {
+					return idxBucket.Put(index.key(doc, id), []byte(id))
+				})
+				if err != nil {
+					logger.Errorf(ctx, "Unable to build index %s.%s - %s", coll.Name, index.Name, err)
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func DropBuckets(ctx context.Context, conn *BoltDBConnection) error {
+
+	return conn.Update(func(tx *bolt.Tx) error {
+		for _, coll := range COLLECTIONS {
+			if err := tx.DeleteBucket([]byte(coll.Name)); err != nil && err != bolt.ErrBucketNotFound {
+				logger.Errorf(ctx, "Unable to drop bucket %s - %s", coll.Name, err)
+				return err
+			}
+
+			for _, index := range coll.Indexes {
+				if err := tx.DeleteBucket(coll.indexBucket(index)); err != nil && err != bolt.ErrBucketNotFound {
+					logger.Errorf(ctx, "Unable to drop index %s.%s - %s", coll.Name, index.Name, err)
+					return err
+				}
+			}
+
+			logger.Infof(ctx, "Dropped bucket %s", coll.Name)
+		}
+
+		return nil
+	})
+}
+
+// LoadDatabase adds the users, keys, teams and members defined in admin.yaml that are not stored yet
+func LoadDatabase(ctx context.Context, conn *BoltDBConnection, dryRun bool) (*seed.Report, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "LoadDatabase"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	adminConfig, err := seed.ReadAdminConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return seed.Load(ctx, NewAdminBoltRepository(conn), adminConfig, dryRun)
+}
+
+func instancePolicyId(project, instance string) string {
+	return fmt.Sprintf("%s-%s", project, instance)
+}
+
+func instanceId(project, name string) string {
+	return fmt.Sprintf("%s-%s", project, name)
+}
@@ -0,0 +1,76 @@
+package boltdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	bolt "go.etcd.io/bbolt"
+)
+
+type BoltDBConnection struct {
+	db      *bolt.DB
+	path    string
+	timeout time.Duration
+}
+
+func NewBoltDBConnection(path string, timeout time.Duration) *BoltDBConnection {
+	return &BoltDBConnection{
+		db:      nil,
+		path:    path,
+		timeout: timeout,
+	}
+}
+
+func (b *BoltDBConnection) OpenConnection(ctx context.Context) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "OpenConnection"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if b.db != nil {
+		return nil
+	}
+
+	logger.Infof(ctx, "Opening bolt database @ %s", b.path)
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(b.path, 0600, &bolt.Options{Timeout: b.timeout})
+	if err != nil {
+		logger.Errorf(ctx, "Unable to open bolt database - %s", err)
+		return err
+	}
+
+	b.db = db
+	return nil
+}
+
+func (b *BoltDBConnection) CloseConnection(ctx context.Context) {
+	logger.Infof(ctx, "Closing bolt database")
+
+	if b.db != nil {
+		if err := b.db.Close(); err != nil {
+			logger.Errorf(ctx, "Error while closing bolt database - %s", err)
+		}
+	}
+	b.db = nil
+}
+
+func (b *BoltDBConnection) View(fn func(tx *bolt.Tx) error) error {
+	if b.db == nil {
+		return errs.ErrDBError
+	}
+	return b.db.View(fn)
+}
+
+func (b *BoltDBConnection) Update(fn func(tx *bolt.Tx) error) error {
+	if b.db == nil {
+		return errs.ErrDBError
+	}
+	return b.db.Update(fn)
+}
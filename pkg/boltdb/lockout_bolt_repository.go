@@ -0,0 +1,77 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/lockout"
+	bolt "go.etcd.io/bbolt"
+)
+
+func (m *AdminBoltRepository) GetAttempts(ctx context.Context, key string) (*lockout.Attempts, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var attempts lockout.Attempts
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return LOGIN_ATTEMPTS.Decode(tx, key, &attempts)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &attempts, nil
+}
+
+func (m *AdminBoltRepository) PutAttempts(ctx context.Context, attempts *lockout.Attempts) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return LOGIN_ATTEMPTS.Put(tx, attempts.Key, attempts)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) DeleteAttempts(ctx context.Context, key string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if !LOGIN_ATTEMPTS.Exists(tx, key) {
+			return nil
+		}
+		return LOGIN_ATTEMPTS.Delete(tx, key)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) PurgeExpiredAttempts(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(func(tx *bolt.Tx) (err error) {
+		count, err = purgeExpired(tx, LOGIN_ATTEMPTS, now)
+		return err
+	})
+
+	if err != nil {
+		return 0, handleBoltError(ctx, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,42 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/mfa"
+	bolt "go.etcd.io/bbolt"
+)
+
+func (m *AdminBoltRepository) GetMFA(ctx context.Context, userId string) (*mfa.Enrollment, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var e mfa.Enrollment
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return MFA.Decode(tx, userId, &e)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &e, nil
+}
+
+func (m *AdminBoltRepository) PutMFA(ctx context.Context, e *mfa.Enrollment) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return MFA.Put(tx, e.UserId, e)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/password"
+	bolt "go.etcd.io/bbolt"
+)
+
+func (m *AdminBoltRepository) GetPassword(ctx context.Context, userId string) (*entity.UserPassword, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetPassword"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var pass entity.UserPassword
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return PASSWORDS.Decode(tx, userId, &pass)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &pass, nil
+}
+
+func (m *AdminBoltRepository) GetPasswordHistory(ctx context.Context, userId string) (*password.History, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetPasswordHistory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var history password.History
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return PASSWORD_HISTORY.Decode(tx, userId, &history)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &history, nil
+}
+
+func (m *AdminBoltRepository) PutPasswordHistory(ctx context.Context, history *password.History) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutPasswordHistory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return PASSWORD_HISTORY.Put(tx, history.UserId, history)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,459 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	RESOURCE_LEVEL_PROJECT  = "project"
+	RESOURCE_LEVEL_INSTANCE = "instance"
+)
+
+type ProjectBoltRepository struct {
+	conn *BoltDBConnection
+}
+
+func NewProjectBoltRepository(conn *BoltDBConnection) *ProjectBoltRepository {
+	return &ProjectBoltRepository{conn: conn}
+}
+
+func (m *ProjectBoltRepository) GetProjects(ctx context.Context) ([]entity.Project, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjects"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findProjects(ctx, func(project *entity.Project) bool { return true })
+}
+
+func (m *ProjectBoltRepository) findProjects(ctx context.Context, match func(project *entity.Project) bool) ([]entity.Project, error) {
+
+	projects := make([]entity.Project, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return PROJECTS.ForEach(tx, func(name string, doc bson.Raw) error {
+			var project entity.Project
+			if err := bson.Unmarshal(doc, &project); err != nil {
+				return errs.ErrMarshalFailed
+			}
+
+			if match(&project) {
+				projects = append(projects, project)
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return projects, nil
+}
+
+func (m *ProjectBoltRepository) CreateProject(ctx context.Context, project *entity.Project) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateProject"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	logger.Infof(ctx, "Saving project %s - %s", project.GetName(), utils.MarshalObject(project))
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return PROJECTS.Insert(tx, project.Name, project)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Project insert failed - %s", err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *ProjectBoltRepository) UpdateProject(ctx context.Context, project *entity.Project) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdateProject"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	logger.Infof(ctx, "Updating project %s - %s", project.GetName(), utils.MarshalObject(project))
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if !PROJECTS.Exists(tx, project.Name) {
+			return errs.ErrDBItemNotFound
+		}
+
+		return PROJECTS.Put(tx, project.Name, project)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Project update failed - %s", err)
+		return errs.ErrDBItemUpdateFailed
+	}
+
+	return nil
+}
+
+func (m *ProjectBoltRepository) GetProject(ctx context.Context, name string) (*entity.Project, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectByName"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var project entity.Project
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return PROJECTS.Decode(tx, name, &project)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &project, nil
+}
+
+func (m *ProjectBoltRepository) GetProjectsByOwner(ctx context.Context, owner string) ([]entity.Project, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectsByOwner"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	projects := make([]entity.Project, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return PROJECTS.Find(tx, "owner", []string{owner}, func(name string, doc bson.Raw) error {
+			var project entity.Project
+			if err := bson.Unmarshal(doc, &project); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			projects = append(projects, project)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return projects, nil
+}
+
+func (m *ProjectBoltRepository) GetProjectsByTeam(ctx context.Context, team string) ([]entity.Project, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectsByTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	projects := make([]entity.Project, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return PROJECTS.Find(tx, "team", []string{team}, func(name string, doc bson.Raw) error {
+			var project entity.Project
+			if err := bson.Unmarshal(doc, &project); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			projects = append(projects, project)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return projects, nil
+}
+
+func (m *ProjectBoltRepository) UpdateProjectStatus(ctx context.Context, project string, status string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ProjectBoltRepository.UpdateProjectStatus"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		var item entity.Project
+		if err := PROJECTS.Decode(tx, project, &item); err != nil {
+			return err
+		}
+
+		item.Status = status
+		item.Timestamp = time.Now()
+		return PROJECTS.Put(tx, project, item)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *ProjectBoltRepository) decodeInstance(ctx context.Context, doc bson.Raw) (*entity.Instance, error) {
+	return vars.ManagerFactory.GetProjectDataManager(ctx).UnmarshalBSONInstance(ctx, doc)
+}
+
+// findInstances decodes the instances returned by find. Documents are copied out of the transaction before they
+// are handed to the project data manager since bolt only guarantees their memory while the transaction is open.
+func (m *ProjectBoltRepository) findInstances(ctx context.Context, find func(tx *bolt.Tx, fn func(id string, doc bson.Raw) error) error) ([]entity.Instance, error) {
+
+	docs := make([]bson.Raw, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return find(tx, func(id string, doc bson.Raw) error {
+			docs = append(docs, append(bson.Raw{}, doc...))
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	instances := make([]entity.Instance, 0, len(docs))
+	for _, doc := range docs {
+		instance, err := m.decodeInstance(ctx, doc)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, *instance)
+	}
+
+	return instances, nil
+}
+
+func (m *ProjectBoltRepository) GetInstances(ctx context.Context) ([]entity.Instance, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstances"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findInstances(ctx, INSTANCES.ForEach)
+}
+
+func (m *ProjectBoltRepository) CreateInstance(ctx context.Context, instance *entity.Instance) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateInstance"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return INSTANCES.Insert(tx, instanceId(instance.Project, instance.Name), instance)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	logger.Infof(ctx, "Saved instance %s - %s", instance.Name, utils.MarshalObject(instance))
+
+	return nil
+}
+
+func (m *ProjectBoltRepository) GetInstance(ctx context.Context, project, name string) (*entity.Instance, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstanceByName"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var doc bson.Raw
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		data, err := INSTANCES.Get(tx, instanceId(project, name))
+		doc = append(bson.Raw{}, data...)
+		return err
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Unable to find instance %s in project %s - %s", name, project, err)
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	return m.decodeInstance(ctx, doc)
+}
+
+func (m *ProjectBoltRepository) GetInstancesByProject(ctx context.Context, project string) ([]entity.Instance, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstancesByProject"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findInstances(ctx, func(tx *bolt.Tx, fn func(id string, doc bson.Raw) error) error {
+		return INSTANCES.Find(tx, "project", []string{project}, fn)
+	})
+}
+
+func (m *ProjectBoltRepository) GetInstancesByOwner(ctx context.Context, owner string) ([]entity.Instance, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstancesByOwner"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findInstances(ctx, func(tx *bolt.Tx, fn func(id string, doc bson.Raw) error) error {
+		return INSTANCES.Find(tx, "owner", []string{owner}, fn)
+	})
+}
+
+func (m *ProjectBoltRepository) UpdateInstanceStatus(ctx context.Context, project, name, status string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdateInstanceStatus"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	// the instance document may carry fields specific to its type, so the status is changed in place rather than
+	// round tripping through entity.Instance
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		key := instanceId(project, name)
+		data, err := INSTANCES.Get(tx, key)
+		if err != nil {
+			return err
+		}
+
+		var doc bson.D
+		if err = bson.Unmarshal(data, &doc); err != nil {
+			return errs.ErrMarshalFailed
+		}
+
+		doc = setField(doc, "status", status)
+		doc = setField(doc, "timestamp", time.Now())
+
+		return INSTANCES.Put(tx, key, doc)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func setField(doc bson.D, key string, value interface{}) bson.D {
+	for index := range doc {
+		if doc[index].Key == key {
+			doc[index].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+func (m *ProjectBoltRepository) findResources(ctx context.Context, level, project, instance string) ([]entity.KubernetesResource, error) {
+
+	resources := make([]entity.KubernetesResource, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return RESOURCES.Find(tx, "level", []string{level, project, instance}, func(id string, doc bson.Raw) error {
+			var resource boltResource
+			if err := bson.Unmarshal(doc, &resource); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			resources = append(resources, resource.Resource)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return resources, nil
+}
+
+// saveResource stores resource or, if it already exists, updates its state and timestamp
+func (m *ProjectBoltRepository) saveResource(ctx context.Context, parent *BoltCollection, parentId string, item boltResource) error {
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if !parent.Exists(tx, parentId) {
+			return errs.ErrDBItemNotFound
+		}
+
+		var current boltResource
+		err := RESOURCES.Decode(tx, item.Id, &current)
+		if err == nil {
+			current.Resource.State = item.Resource.State
+			current.Resource.Timestamp = item.Resource.Timestamp
+			item = current
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		return RESOURCES.Put(tx, item.Id, item)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Save of kubernetes resource %s failed - %s", utils.MarshalObject(item.Resource), err)
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *ProjectBoltRepository) GetProjectResources(ctx context.Context, project string) ([]entity.KubernetesResource, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectResources"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findResources(ctx, RESOURCE_LEVEL_PROJECT, project, "")
+}
+
+func (m *ProjectBoltRepository) SaveProjectResource(ctx context.Context, project string, resource *entity.KubernetesResource) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveProjectResource"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := boltResource{Id: resource.Id, Project: project, Level: RESOURCE_LEVEL_PROJECT, Resource: *resource}
+	return m.saveResource(ctx, &PROJECTS, project, item)
+}
+
+func (m *ProjectBoltRepository) SaveProjectResources(ctx context.Context, project string, resources []entity.KubernetesResource) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveProjectResources"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	for index := range resources {
+		if err := m.SaveProjectResource(ctx, project, &resources[index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *ProjectBoltRepository) GetInstanceResources(ctx context.Context, project, instance string) ([]entity.KubernetesResource, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstanceResources"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findResources(ctx, RESOURCE_LEVEL_INSTANCE, project, instance)
+}
+
+func (m *ProjectBoltRepository) SaveInstanceResource(ctx context.Context, project, instance string, resource *entity.KubernetesResource) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveInstanceResource"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := boltResource{Id: resource.Id, Project: project, Instance: instance, Level: RESOURCE_LEVEL_INSTANCE, Resource: *resource}
+	return m.saveResource(ctx, &INSTANCES, instanceId(project, instance), item)
+}
+
+func (m *ProjectBoltRepository) SaveInstanceResources(ctx context.Context, project, instance string, resources []entity.KubernetesResource) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveInstanceResources"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	for index := range resources {
+		if err := m.SaveInstanceResource(ctx, project, instance, &resources[index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *ProjectBoltRepository) GetUserSummary(ctx context.Context, userId string) (*entity.ResourceSummary, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUserSummary"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	summary := entity.ResourceSummary{}
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		summary.TotalAPIKeys = APIKEYS.Count(tx, "userid", userId)
+		summary.TotalProjects = PROJECTS.Count(tx, "owner", userId)
+		summary.TotalInstances = INSTANCES.Count(tx, "owner", userId)
+		summary.TotalTeams = TEAMS.Count(tx, "owner", userId)
+		return nil
+	})
+
+	if err != nil {
+		return nil, errs.ErrDBError
+	}
+
+	return &summary, nil
+}
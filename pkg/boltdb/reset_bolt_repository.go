@@ -0,0 +1,94 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/reset"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (m *AdminBoltRepository) CreateResetToken(ctx context.Context, t *reset.ResetToken) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		ids := make([]string, 0)
+		err := RESET_TOKENS.Find(tx, "userid", []string{t.UserId}, func(id string, doc bson.Raw) error {
+			ids = append(ids, id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err = RESET_TOKENS.Delete(tx, id); err != nil {
+				return err
+			}
+		}
+
+		return RESET_TOKENS.Insert(tx, t.Hash, t)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetResetToken(ctx context.Context, hash string) (*reset.ResetToken, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var t reset.ResetToken
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return RESET_TOKENS.Decode(tx, hash, &t)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &t, nil
+}
+
+func (m *AdminBoltRepository) ConsumeResetToken(ctx context.Context, hash string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ConsumeResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if !RESET_TOKENS.Exists(tx, hash) {
+			return errs.ErrDBItemNotFound
+		}
+		return RESET_TOKENS.Delete(tx, hash)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) PurgeExpiredResetTokens(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredResetTokens"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(func(tx *bolt.Tx) (err error) {
+		count, err = purgeExpired(tx, RESET_TOKENS, now)
+		return err
+	})
+
+	if err != nil {
+		return 0, handleBoltError(ctx, err)
+	}
+
+	return count, nil
+}
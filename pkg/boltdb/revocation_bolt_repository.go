@@ -0,0 +1,126 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/revocation"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// purgeExpired removes the documents of coll whose expires field is not after now
+func purgeExpired(tx *bolt.Tx, coll BoltCollection, now time.Time) (int64, error) {
+
+	ids := make([]string, 0)
+	err := coll.ForEach(tx, func(id string, doc bson.Raw) error {
+		expires, ok := doc.Lookup("expires").TimeOK()
+		if !ok {
+			return errs.ErrMarshalFailed
+		}
+		if !now.Before(expires) {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err = coll.Delete(tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(ids)), nil
+}
+
+func (m *AdminBoltRepository) RevokeToken(ctx context.Context, token *revocation.RevokedToken) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RevokeToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return REVOKED_TOKENS.Put(tx, token.Id, token)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "IsTokenRevoked"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var revoked bool
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		revoked = REVOKED_TOKENS.Exists(tx, tokenId)
+		return nil
+	})
+
+	if err != nil {
+		return false, handleBoltError(ctx, err)
+	}
+
+	return revoked, nil
+}
+
+func (m *AdminBoltRepository) SetWatermark(ctx context.Context, w *revocation.Watermark) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetWatermark"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return WATERMARKS.Put(tx, w.UserId, w)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetWatermark(ctx context.Context, userId string) (*revocation.Watermark, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetWatermark"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var w revocation.Watermark
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return WATERMARKS.Decode(tx, userId, &w)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &w, nil
+}
+
+func (m *AdminBoltRepository) PurgeExpiredRevocations(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredRevocations"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		for _, coll := range []BoltCollection{REVOKED_TOKENS, WATERMARKS} {
+			purged, err := purgeExpired(tx, coll, now)
+			if err != nil {
+				return err
+			}
+			count += purged
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, handleBoltError(ctx, err)
+	}
+
+	return count, nil
+}
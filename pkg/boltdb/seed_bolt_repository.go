@@ -0,0 +1,148 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/apikeys"
+	bolt "go.etcd.io/bbolt"
+)
+
+// run returns the transaction used to seed: a read-only one for a dry run
+func (m *AdminBoltRepository) run(dryRun bool) func(fn func(tx *bolt.Tx) error) error {
+	if dryRun {
+		return m.conn.View
+	}
+	return m.conn.Update
+}
+
+// insertMissing stores a dependent item unless one is already stored under id
+func insertMissing(tx *bolt.Tx, coll BoltCollection, id string, item interface{}) error {
+	if coll.Exists(tx, id) {
+		return nil
+	}
+	return coll.Insert(tx, id, item)
+}
+
+func (m *AdminBoltRepository) SeedUser(ctx context.Context, user *entity.User, pass *entity.UserPassword, policy *entity.UserPolicy, dryRun bool) (*entity.User, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var existing *entity.User
+	err := m.run(dryRun)(func(tx *bolt.Tx) error {
+		var stored entity.User
+		if err := USERS.Decode(tx, user.UserId, &stored); err == nil {
+			existing = &stored
+			return nil
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		if err := USERS.Insert(tx, user.UserId, user); err != nil {
+			return err
+		}
+		if err := insertMissing(tx, PASSWORDS, user.UserId, pass); err != nil {
+			return err
+		}
+		return insertMissing(tx, USER_POLICY, user.UserId, policy)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return existing, nil
+}
+
+func (m *AdminBoltRepository) SeedAPIKey(ctx context.Context, apikey *apikeys.Record, policy *entity.APIKeyPolicy, dryRun bool) (*apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var existing *apikeys.Record
+	err := m.run(dryRun)(func(tx *bolt.Tx) error {
+		var stored apikeys.Record
+		if err := APIKEYS.Decode(tx, apikey.Key, &stored); err == nil {
+			existing = &stored
+			return nil
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		if err := APIKEYS.Insert(tx, apikey.Key, apikey); err != nil {
+			return err
+		}
+		return insertMissing(tx, APIKEY_POLICY, apikey.Key, policy)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return existing, nil
+}
+
+func (m *AdminBoltRepository) SeedTeam(ctx context.Context, team *entity.Team, dryRun bool) (*entity.Team, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var existing *entity.Team
+	err := m.run(dryRun)(func(tx *bolt.Tx) error {
+		var stored entity.Team
+		if err := TEAMS.Decode(tx, team.TeamId, &stored); err == nil {
+			existing = &stored
+			return nil
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+		return TEAMS.Insert(tx, team.TeamId, team)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return existing, nil
+}
+
+func (m *AdminBoltRepository) SeedTeamMember(ctx context.Context, member *entity.TeamMember, dryRun bool) (*entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedTeamMember"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var existing *entity.TeamMember
+	err := m.run(dryRun)(func(tx *bolt.Tx) error {
+		var stored entity.TeamMember
+		if err := TEAM_MEMBERS.Decode(tx, member.Key, &stored); err == nil {
+			existing = &stored
+			return nil
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+		return TEAM_MEMBERS.Insert(tx, member.Key, member)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return existing, nil
+}
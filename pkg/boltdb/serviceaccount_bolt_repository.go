@@ -0,0 +1,110 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (m *AdminBoltRepository) CreateServiceAccount(ctx context.Context, sa *serviceaccount.ServiceAccount, user *entity.User) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if USERS.Exists(tx, user.UserId) || SERVICE_ACCOUNTS.Exists(tx, sa.Id) {
+			return errs.ErrUserAlreadyExists
+		}
+
+		if err := USERS.Insert(tx, user.UserId, user); err != nil {
+			return err
+		}
+
+		if err := SERVICE_ACCOUNTS.Insert(tx, sa.Id, sa); err != nil {
+			return err
+		}
+
+		return TEAM_MEMBERS.Insert(tx, sa.Id, sa.TeamMember())
+	})
+
+	if err == errs.ErrUserAlreadyExists {
+		return err
+	} else if err != nil {
+		logger.Errorf(ctx, "Unable to store service account %s - %s", sa.Id, err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetServiceAccount(ctx context.Context, id string) (*serviceaccount.ServiceAccount, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var sa serviceaccount.ServiceAccount
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return SERVICE_ACCOUNTS.Decode(tx, id, &sa)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &sa, nil
+}
+
+func (m *AdminBoltRepository) GetServiceAccounts(ctx context.Context, teamId string) ([]serviceaccount.ServiceAccount, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetServiceAccounts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	accounts := make([]serviceaccount.ServiceAccount, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return SERVICE_ACCOUNTS.Find(tx, "teamid", []string{teamId}, func(id string, doc bson.Raw) error {
+			var sa serviceaccount.ServiceAccount
+			if err := bson.Unmarshal(doc, &sa); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			accounts = append(accounts, sa)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return accounts, nil
+}
+
+func (m *AdminBoltRepository) DeleteServiceAccount(ctx context.Context, id string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if err := SERVICE_ACCOUNTS.Delete(tx, id); err != nil {
+			return err
+		}
+
+		for _, collection := range []BoltCollection{USERS, USER_POLICY, TEAM_MEMBERS} {
+			if collection.Exists(tx, id) {
+				if err := collection.Delete(tx, id); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/session"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// deleteSessions removes the sessions found by the index values, or all sessions matching match when no values
+// are given. The ids are collected first since bolt cursors must not see their bucket change.
+func deleteSessions(tx *bolt.Tx, values []string, match func(s *session.Session) bool) (int64, error) {
+
+	ids := make([]string, 0)
+	collect := func(id string, doc bson.Raw) error {
+		var s session.Session
+		if err := bson.Unmarshal(doc, &s); err != nil {
+			return errs.ErrMarshalFailed
+		}
+		if match(&s) {
+			ids = append(ids, id)
+		}
+		return nil
+	}
+
+	var err error
+	if len(values) > 0 {
+		err = SESSIONS.Find(tx, "user_device", values, collect)
+	} else {
+		err = SESSIONS.ForEach(tx, collect)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err = SESSIONS.Delete(tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(ids)), nil
+}
+
+func (m *AdminBoltRepository) CreateSession(ctx context.Context, s *session.Session) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		all := func(s *session.Session) bool { return true }
+		if _, err := deleteSessions(tx, []string{s.UserId, s.Device}, all); err != nil {
+			return err
+		}
+		return SESSIONS.Insert(tx, s.Id, s)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetSession(ctx context.Context, sessionId string) (*session.Session, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var s session.Session
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return SESSIONS.Decode(tx, sessionId, &s)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &s, nil
+}
+
+func (m *AdminBoltRepository) RotateSession(ctx context.Context, tokenHash string, next *session.Session) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		var s session.Session
+		if err := SESSIONS.Decode(tx, next.Id, &s); err != nil {
+			return err
+		}
+
+		if s.TokenHash != tokenHash {
+			return session.ErrRefreshTokenReused
+		}
+
+		return SESSIONS.Put(tx, next.Id, next)
+	})
+
+	if err == session.ErrRefreshTokenReused {
+		return err
+	} else if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetSessions(ctx context.Context, userId string) ([]session.Session, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	sessions := make([]session.Session, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return SESSIONS.Find(tx, "user_device", []string{userId}, func(id string, doc bson.Raw) error {
+			var s session.Session
+			if err := bson.Unmarshal(doc, &s); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			sessions = append(sessions, s)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return sessions, nil
+}
+
+func (m *AdminBoltRepository) DeleteSession(ctx context.Context, sessionId string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return SESSIONS.Delete(tx, sessionId)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) DeleteSessions(ctx context.Context, userId string) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(func(tx *bolt.Tx) (err error) {
+		count, err = deleteSessions(tx, []string{userId}, func(s *session.Session) bool { return true })
+		return err
+	})
+
+	if err != nil {
+		return 0, handleBoltError(ctx, err)
+	}
+
+	return count, nil
+}
+
+func (m *AdminBoltRepository) PurgeExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(func(tx *bolt.Tx) (err error) {
+		count, err = deleteSessions(tx, nil, func(s *session.Session) bool { return s.Expired(now) })
+		return err
+	})
+
+	if err != nil {
+		return 0, handleBoltError(ctx, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,423 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/utils"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (m *AdminBoltRepository) GetExpiringInvitations(ctx context.Context, date time.Time) ([]entity.TeamMember, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetExpiringInvitations"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	invites := make([]entity.TeamMember, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return TEAM_MEMBERS.ForEach(tx, func(key string, doc bson.Raw) error {
+			var member entity.TeamMember
+			if err := bson.Unmarshal(doc, &member); err != nil {
+				return errs.ErrMarshalFailed
+			}
+
+			if member.ExpiresOn.Equal(date) {
+				invites = append(invites, member)
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return invites, nil
+}
+
+func (m *AdminBoltRepository) PurgeExpiredInvitations(ctx context.Context) (int64, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredInvitations"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		expired := make([]string, 0)
+		err := TEAM_MEMBERS.ForEach(tx, func(key string, doc bson.Raw) error {
+			var member entity.TeamMember
+			if err := bson.Unmarshal(doc, &member); err != nil {
+				return errs.ErrMarshalFailed
+			}
+
+			if member.Status == ztypes.EXPIRED_INVITATION {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// bolt does not allow a bucket to be modified while it is being iterated
+		for _, key := range expired {
+			if err = TEAM_MEMBERS.Delete(tx, key); err != nil {
+				return err
+			}
+			count++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, handleBoltError(ctx, err)
+	}
+
+	return count, nil
+}
+
+func (m *AdminBoltRepository) CreateTeam(ctx context.Context, team entity.Team) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if TEAMS.Exists(tx, team.TeamId) {
+			return nil
+		}
+
+		return TEAMS.Insert(tx, team.TeamId, team)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Unable to insert team %s - %s", utils.MarshalObject(team), err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetTeams(ctx context.Context) ([]entity.Team, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeams"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	teams := make([]entity.Team, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return TEAMS.ForEach(tx, func(id string, doc bson.Raw) error {
+			var team entity.Team
+			if err := bson.Unmarshal(doc, &team); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			teams = append(teams, team)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return teams, nil
+}
+
+func (m *AdminBoltRepository) GetTeam(ctx context.Context, teamId string) (*entity.Team, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	team := entity.Team{}
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return TEAMS.Decode(tx, teamId, &team)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &team, nil
+}
+
+func (m *AdminBoltRepository) GetTeamByOwner(ctx context.Context, userid string) (*entity.Team, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetTeamByOwner"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var team *entity.Team
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return TEAMS.Find(tx, "owner", []string{userid}, func(id string, doc bson.Raw) error {
+			if team != nil {
+				return nil
+			}
+
+			team = &entity.Team{}
+			if err := bson.Unmarshal(doc, team); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	if team == nil {
+		return nil, handleBoltError(ctx, errs.ErrDBItemNotFound)
+	}
+
+	return team, nil
+}
+
+func (m *AdminBoltRepository) findMembers(ctx context.Context, indexName string, values ...string) ([]entity.TeamMember, error) {
+
+	members := make([]entity.TeamMember, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return TEAM_MEMBERS.Find(tx, indexName, values, func(key string, doc bson.Raw) error {
+			var member entity.TeamMember
+			if err := bson.Unmarshal(doc, &member); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			members = append(members, member)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return members, nil
+}
+
+func (m *AdminBoltRepository) GetTeamMembers(ctx context.Context, teamId string) ([]entity.TeamMember, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMembers"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findMembers(ctx, "team", teamId)
+}
+
+func (m *AdminBoltRepository) AddTeamMember(ctx context.Context, teamId string, member entity.TeamMember) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "setTeamMembers"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if TEAM_MEMBERS.Count(tx, "team_email", teamId, member.Email) > 0 {
+			return errs.ErrDBKeyAlreadyExists
+		}
+
+		return TEAM_MEMBERS.Insert(tx, member.Key, member)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	logger.Infof(ctx, "Inserted new member: %s, key: %s, team: %s", member.Email, member.Key, member.TeamId)
+	return nil
+}
+
+func (m *AdminBoltRepository) RemoveTeamMembers(ctx context.Context, teamId string, keys []string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "removeTeamMembers"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	deleted := 0
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			if !TEAM_MEMBERS.Exists(tx, key) {
+				continue
+			}
+
+			if err := TEAM_MEMBERS.Delete(tx, key); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	logger.Infof(ctx, "Deleted %d members from team %s", deleted, teamId)
+
+	if deleted != len(keys) {
+		return errs.ErrDBItemDeleteFailed
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) RemoveTeamMember(ctx context.Context, teamId string, key string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "removeTeamMember"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.RemoveTeamMembers(ctx, teamId, []string{key})
+}
+
+// updateTeamMember applies change to the member with key in teamId and stores the result
+func (m *AdminBoltRepository) updateTeamMember(ctx context.Context, teamId, key string, change func(member *entity.TeamMember)) error {
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		member := entity.TeamMember{}
+		if err := TEAM_MEMBERS.Decode(tx, key, &member); err != nil {
+			return err
+		}
+
+		if member.TeamId != teamId {
+			return errs.ErrDBItemNotFound
+		}
+
+		change(&member)
+		member.LastUpdate = time.Now()
+
+		return TEAM_MEMBERS.Put(tx, key, member)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) UpdateTeamMemberEmail(ctx context.Context, teamId, key, email string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "updateTeamMemberEmail"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateTeamMember(ctx, teamId, key, func(member *entity.TeamMember) {
+		member.Email = email
+	})
+}
+
+func (m *AdminBoltRepository) UpdateTeamMemberRole(ctx context.Context, teamId, key string, role ztypes.Role) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "updateTeamMemberRole"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateTeamMember(ctx, teamId, key, func(member *entity.TeamMember) {
+		member.Role = role
+	})
+}
+
+func (m *AdminBoltRepository) UpdateTeamMemberStatus(ctx context.Context, teamId, key string, status ztypes.InvitationStatus) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "updateTeamMemberStatus"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateTeamMember(ctx, teamId, key, func(member *entity.TeamMember) {
+		member.Status = status
+	})
+}
+
+func (m *AdminBoltRepository) UpdateTeam(ctx context.Context, team entity.Team) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "updateTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		if !TEAMS.Exists(tx, team.TeamId) {
+			return errs.ErrDBItemNotFound
+		}
+
+		return TEAMS.Put(tx, team.TeamId, team)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) DeleteTeam(ctx context.Context, teamId string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "deleteTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(func(tx *bolt.Tx) error {
+		return TEAMS.Delete(tx, teamId)
+	})
+
+	if err != nil {
+		return handleBoltError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminBoltRepository) GetAllMemberships(ctx context.Context) ([]entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMemberships"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	members := make([]entity.TeamMember, 0)
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return TEAM_MEMBERS.ForEach(tx, func(key string, doc bson.Raw) error {
+			var member entity.TeamMember
+			if err := bson.Unmarshal(doc, &member); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			members = append(members, member)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return members, nil
+}
+
+func (m *AdminBoltRepository) GetTeamMemberships(ctx context.Context, email string) ([]entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMemberships"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findMembers(ctx, "email", email)
+}
+
+func (m *AdminBoltRepository) GetTeamMembership(ctx context.Context, key string) (*entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMembership"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	member := entity.TeamMember{}
+	err := m.conn.View(func(tx *bolt.Tx) error {
+		return TEAM_MEMBERS.Decode(tx, key, &member)
+	})
+
+	if err != nil {
+		return nil, handleBoltError(ctx, err)
+	}
+
+	return &member, nil
+}
+
+func (m *AdminBoltRepository) GetTeamMembershipByEmail(ctx context.Context, teamId, email string) (*entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMembershipByEmail"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	members, err := m.findMembers(ctx, "team_email", teamId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) == 0 {
+		return nil, handleBoltError(ctx, errs.ErrDBItemNotFound)
+	}
+
+	return &members[0], nil
+}
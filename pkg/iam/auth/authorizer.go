@@ -12,14 +12,28 @@ import (
 	"github.com/zbitech/common/pkg/model/ztypes"
 	"github.com/zbitech/common/pkg/rctx"
 	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/access"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"github.com/zbitech/repo/pkg/serviceaccount"
 )
 
+// AccessAuthorizer decides requests with a policy engine. The engine says who may perform an action; the
+// authorizer derives the roles and attributes of each request and enforces the subscription limits.
 type AccessAuthorizer struct {
 	iamService interfaces.IAMServiceIF
+	engine     *access.Engine
 }
 
+// defaultEngine evaluates the default policy. Engines are not changed after they are built, so it is shared.
+var defaultEngine = access.MustNewEngine(access.DefaultDocument())
+
+// NewAccessAuthorizer returns an authorizer with the default policy
 func NewAccessAuthorizer(iamService interfaces.IAMServiceIF) interfaces.AccessAuthorizerIF {
-	return &AccessAuthorizer{iamService: iamService}
+	return &AccessAuthorizer{iamService: iamService, engine: defaultEngine}
+}
+
+func NewAccessAuthorizerWithPolicy(iamService interfaces.IAMServiceIF, engine *access.Engine) interfaces.AccessAuthorizerIF {
+	return &AccessAuthorizer{iamService: iamService, engine: engine}
 }
 
 func (a *AccessAuthorizer) getTeam(ctx context.Context, teamName, userId string) (*entity.Team, *entity.TeamMember, error) {
@@ -72,21 +86,105 @@ func (a *AccessAuthorizer) getOwnerInfo(ctx context.Context, ownerId string) (*e
 	return owner, ownerSummary, sPolicy, nil
 }
 
+var (
+	projectDenied = map[ztypes.ZBIAction]error{ztypes.ACTION_CREATE: errs.ErrProjectCreateNotAllowed, ztypes.ACTION_UPDATE: errs.ErrProjectUpdateNotAllowed,
+		ztypes.ACTION_DELETE: errs.ErrProjectDeleteNotAllowed, ztypes.ACTION_ACCESS: errs.ErrProjectAccessNotAllowed}
+	instanceDenied = map[ztypes.ZBIAction]error{ztypes.ACTION_CREATE: errs.ErrInstanceCreateNotAllowed, ztypes.ACTION_UPDATE: errs.ErrInstanceUpdateNotAllowed,
+		ztypes.ACTION_DELETE: errs.ErrInstanceDeleteNotAllowed, ztypes.ACTION_ACCESS: errs.ErrInstanceAccessNotAllowed}
+	teamDenied = map[ztypes.ZBIAction]error{ztypes.ACTION_CREATE: errs.ErrTeamCreateNotAllowed, ztypes.ACTION_UPDATE: errs.ErrTeamUpdateNotAllowed,
+		ztypes.ACTION_DELETE: errs.ErrProjectDeleteNotAllowed, ztypes.ACTION_ACCESS: errs.ErrTeamAccessNotAllowed}
+)
+
+// subject is what the authorizer knows of the current user for a request
+type subject struct {
+	admin         bool // administrator of the service
+	owner         bool // holds a subscription
+	resourceOwner bool // owns the project or team
+	member        bool // joined member of the team of the resource
+	teamAdmin     bool // joined admin of that team
+}
+
+func newSubject(currUser *rctx.CurrentUser) subject {
+	return subject{admin: currUser.IsAdmin(), owner: currUser.IsOwner()}
+}
+
+// join records the membership of the team of the resource, if it has been joined
+func (s *subject) join(mbr *entity.TeamMember) {
+	if mbr != nil && mbr.IsJoined() {
+		s.member = true
+		s.teamAdmin = mbr.IsAdmin()
+	}
+}
+
+// roles returns the roles of the policy that the subject has
+func (s subject) roles() []string {
+	roles := make([]string, 0, 5)
+	for _, role := range []struct {
+		has  bool
+		name string
+	}{{s.admin, access.ROLE_ADMIN}, {s.owner, access.ROLE_OWNER}, {s.resourceOwner, access.ROLE_RESOURCE_OWNER},
+		{s.member, access.ROLE_TEAM_MEMBER}, {s.teamAdmin, access.ROLE_TEAM_ADMIN}} {
+		if role.has {
+			roles = append(roles, role.name)
+		}
+	}
+	return roles
+}
+
+// attributes returns the attributes of the owner of the resource and the current user
+func attributes(owner *entity.User, currUser *rctx.CurrentUser) map[string]string {
+	attrs := map[string]string{}
+	if owner != nil {
+		attrs[access.ATTR_SUBSCRIPTION] = string(owner.Level)
+	}
+	if currUser != nil && currUser.User != nil {
+		attrs[access.ATTR_USER_ROLE] = string(currUser.User.Role)
+	}
+	return attrs
+}
+
+// addInstanceType adds the type of an existing instance when the policy has conditions on it
+func (a *AccessAuthorizer) addInstanceType(ctx context.Context, attrs map[string]string, project, instance string) {
+	if !a.engine.Uses(access.ATTR_INSTANCE_TYPE) {
+		return
+	}
+
+	projRepo := vars.RepositoryFactory.GetProjectRepository()
+	inst, err := projRepo.GetInstance(ctx, project, instance)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to get instance %s of project %s - %s", instance, project, err)
+		return
+	}
+	attrs[access.ATTR_INSTANCE_TYPE] = string(inst.InstanceType)
+}
+
+// allowed asks the policy engine whether the subject with roles may perform the action on the resource
+func (a *AccessAuthorizer) allowed(ctx context.Context, resource string, action ztypes.ZBIAction, roles []string, attrs map[string]string) bool {
+
+	decision := a.engine.Evaluate(access.Request{Resource: resource, Action: string(action), Roles: roles, Attributes: attrs})
+	if !decision.Allowed {
+		logger.Infof(ctx, "Denied %s of %s to roles %v with %v - rule %q", action, resource, roles, attrs, decision.Rule)
+	}
+	return decision.Allowed
+}
+
 func (a *AccessAuthorizer) ValidateProjectAction(ctx context.Context, project string, action ztypes.ZBIAction) error {
 
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ValidateProjectAction"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	denied, ok := projectDenied[action]
+	if !ok {
+		return errs.ErrProjectAccessError
+	}
+
 	currUser := rctx.GetCurrentUser(ctx)
 
-	var ownerId string
 	var proj *entity.Project
 	var err error
-	var mbr *entity.TeamMember
 
-	if action == ztypes.ACTION_CREATE {
-		ownerId = currUser.UserId
-	} else {
+	ownerId := currUser.UserId
+	if action != ztypes.ACTION_CREATE {
 		projRepo := vars.RepositoryFactory.GetProjectRepository()
 		proj, err = projRepo.GetProject(ctx, project)
 		if err != nil {
@@ -95,57 +193,35 @@ func (a *AccessAuthorizer) ValidateProjectAction(ctx context.Context, project st
 		ownerId = proj.Owner
 	}
 
-	_, ownerSummary, sPolicy, err := a.getOwnerInfo(ctx, ownerId)
+	owner, ownerSummary, sPolicy, err := a.getOwnerInfo(ctx, ownerId)
 	if err != nil {
 		logger.Errorf(ctx, "Failed to get owner information - %s", err)
 		return errs.ErrProjectCreateNotAllowed
 	}
 
+	sub := newSubject(currUser)
 	if proj != nil {
-		_, mbr, err = a.getTeam(ctx, proj.TeamId, currUser.UserId)
+		sub.resourceOwner = proj.Owner == currUser.UserId
+
+		_, mbr, err := a.getTeam(ctx, proj.TeamId, currUser.UserId)
 		if err != nil {
 			logger.Errorf(ctx, "Unable to get team information - %s", err)
 		}
+		sub.join(mbr)
 	}
 
-	switch action {
-	case ztypes.ACTION_CREATE:
-		if currUser.IsOwner() || (mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			logger.Infof(ctx, "Evaluating if owner or team admin can create project. Summary (%v), Policy (%v)", ownerSummary, sPolicy)
-			if ownerSummary.TotalProjects < sPolicy.MaxProjects {
-				return nil
-			} else {
-				return errs.ErrMaxProjectsCreated
-			}
-		}
-
-		return errs.ErrProjectCreateNotAllowed
-
-	case ztypes.ACTION_UPDATE:
-		if proj.Owner == currUser.UserId ||
-			(mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			return nil
-		}
-
-		return errs.ErrProjectUpdateNotAllowed
-
-	case ztypes.ACTION_DELETE:
-		if currUser.IsAdmin() || proj.Owner == currUser.UserId || (mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			return nil
-		}
-
-		return errs.ErrProjectDeleteNotAllowed
+	if !a.allowed(ctx, access.RESOURCE_PROJECT, action, sub.roles(), attributes(owner, currUser)) {
+		return denied
+	}
 
-	case ztypes.ACTION_ACCESS:
-		if currUser.IsAdmin() || proj.Owner == currUser.UserId || (mbr != nil && mbr.IsJoined()) {
-			return nil
+	if action == ztypes.ACTION_CREATE {
+		logger.Infof(ctx, "Evaluating if owner or team admin can create project. Summary (%v), Policy (%v)", ownerSummary, sPolicy)
+		if ownerSummary.TotalProjects >= sPolicy.MaxProjects {
+			return errs.ErrMaxProjectsCreated
 		}
-
-		return errs.ErrProjectAccessNotAllowed
-
 	}
 
-	return errs.ErrProjectAccessError
+	return nil
 }
 
 func (a *AccessAuthorizer) ValidateInstanceAction(ctx context.Context, project, instance string, action ztypes.ZBIAction) error {
@@ -153,16 +229,18 @@ func (a *AccessAuthorizer) ValidateInstanceAction(ctx context.Context, project,
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ValidateInstanceAction"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	denied, ok := instanceDenied[action]
+	if !ok {
+		return errs.ErrInstanceAccessError
+	}
+
 	currUser := rctx.GetCurrentUser(ctx)
 
-	var ownerId string
 	var proj *entity.Project
 	var err error
-	var mbr *entity.TeamMember
 
-	if action == ztypes.ACTION_CREATE {
-		ownerId = currUser.UserId
-	} else {
+	ownerId := currUser.UserId
+	if action != ztypes.ACTION_CREATE {
 		projRepo := vars.RepositoryFactory.GetProjectRepository()
 		proj, err = projRepo.GetProject(ctx, project)
 		if err != nil {
@@ -171,56 +249,34 @@ func (a *AccessAuthorizer) ValidateInstanceAction(ctx context.Context, project,
 		ownerId = proj.Owner
 	}
 
-	_, ownerSummary, sPolicy, err := a.getOwnerInfo(ctx, ownerId)
+	owner, ownerSummary, sPolicy, err := a.getOwnerInfo(ctx, ownerId)
 	if err != nil {
 		logger.Errorf(ctx, "Failed to get owner information - %s", err)
 		return errs.ErrProjectCreateNotAllowed
 	}
 
+	sub := newSubject(currUser)
+	attrs := attributes(owner, currUser)
 	if proj != nil {
-		_, mbr, err = a.getTeam(ctx, proj.TeamId, currUser.UserId)
+		sub.resourceOwner = proj.Owner == currUser.UserId
+
+		_, mbr, err := a.getTeam(ctx, proj.TeamId, currUser.UserId)
 		if err != nil {
 			logger.Errorf(ctx, "Unable to get team information - %s", err)
 		}
+		sub.join(mbr)
+		a.addInstanceType(ctx, attrs, project, instance)
 	}
 
-	switch action {
-	case ztypes.ACTION_CREATE:
-		if currUser.IsOwner() || (mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			if ownerSummary.TotalInstances < sPolicy.MaxInstances {
-				return nil
-			} else {
-				return errs.ErrMaxInstancesCreated
-			}
-		}
-
-		return errs.ErrInstanceCreateNotAllowed
-
-	case ztypes.ACTION_UPDATE:
-		if proj.Owner == currUser.UserId ||
-			(mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			return nil
-		}
-
-		return errs.ErrInstanceUpdateNotAllowed
-
-	case ztypes.ACTION_DELETE:
-		if currUser.IsAdmin() || proj.Owner == currUser.UserId || (mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			return nil
-		}
-
-		return errs.ErrInstanceDeleteNotAllowed
-
-	case ztypes.ACTION_ACCESS:
-		if currUser.IsAdmin() || proj.Owner == currUser.UserId || (mbr != nil && mbr.IsJoined()) {
-			return nil
-		}
-
-		return errs.ErrInstanceAccessNotAllowed
+	if !a.allowed(ctx, access.RESOURCE_INSTANCE, action, sub.roles(), attrs) {
+		return denied
+	}
 
+	if action == ztypes.ACTION_CREATE && ownerSummary.TotalInstances >= sPolicy.MaxInstances {
+		return errs.ErrMaxInstancesCreated
 	}
 
-	return errs.ErrInstanceAccessError
+	return nil
 }
 
 func (a *AccessAuthorizer) ValidateTeamAction(ctx context.Context, teamId string, action ztypes.ZBIAction) error {
@@ -228,17 +284,20 @@ func (a *AccessAuthorizer) ValidateTeamAction(ctx context.Context, teamId string
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ValidateTeamAction"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	denied, ok := teamDenied[action]
+	if !ok {
+		return errs.ErrTeamAccessError
+	}
+
 	currUser := rctx.GetCurrentUser(ctx)
 
+	// the owner is only known when creating a team, which counts against the subscription of the current user
 	var ownerId string
-	var err error
-	var mbr *entity.TeamMember
-
 	if action == ztypes.ACTION_CREATE {
 		ownerId = currUser.UserId
 	}
 
-	_, ownerSummary, sPolicy, err := a.getOwnerInfo(ctx, ownerId)
+	owner, ownerSummary, sPolicy, err := a.getOwnerInfo(ctx, ownerId)
 	if err != nil {
 		logger.Errorf(ctx, "Failed to get owner information - %s", err)
 		return errs.ErrTeamAccessError
@@ -250,51 +309,26 @@ func (a *AccessAuthorizer) ValidateTeamAction(ctx context.Context, teamId string
 		return errs.ErrTeamAccessError
 	}
 
-	ut := currUser.User.GetTeam(team.TeamId)
-	if ut != nil {
-		mbr, err = a.iamService.GetTeamMembership(ctx, ut.Key)
+	sub := newSubject(currUser)
+	sub.resourceOwner = team.Owner == currUser.UserId
+
+	if ut := currUser.User.GetTeam(team.TeamId); ut != nil {
+		mbr, err := a.iamService.GetTeamMembership(ctx, ut.Key)
 		if err != nil {
 			logger.Errorf(ctx, "Failed to get team membership info - %s", err)
 		}
+		sub.join(mbr)
 	}
 
-	switch action {
-	case ztypes.ACTION_CREATE:
-		if currUser.IsOwner() || (mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			if ownerSummary.TotalTeams < sPolicy.MaxTeams {
-				return nil
-			} else {
-				return errs.ErrMaxTeamsCreated
-			}
-		}
-
-		return errs.ErrTeamCreateNotAllowed
-
-	case ztypes.ACTION_UPDATE:
-		if team.Owner == currUser.UserId ||
-			(mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			return nil
-		}
-
-		return errs.ErrTeamUpdateNotAllowed
-
-	case ztypes.ACTION_DELETE:
-		if currUser.IsAdmin() || team.Owner == currUser.UserId || (mbr != nil && mbr.IsJoined() && mbr.IsAdmin()) {
-			return nil
-		}
-
-		return errs.ErrProjectDeleteNotAllowed
-
-	case ztypes.ACTION_ACCESS:
-		if currUser.IsAdmin() || team.Owner == currUser.UserId || (mbr != nil && mbr.IsJoined()) {
-			return nil
-		}
-
-		return errs.ErrTeamAccessNotAllowed
+	if !a.allowed(ctx, access.RESOURCE_TEAM, action, sub.roles(), attributes(owner, currUser)) {
+		return denied
+	}
 
+	if action == ztypes.ACTION_CREATE && ownerSummary.TotalTeams >= sPolicy.MaxTeams {
+		return errs.ErrMaxTeamsCreated
 	}
 
-	return errs.ErrTeamAccessError
+	return nil
 }
 
 func (a *AccessAuthorizer) ValidateUserInstanceMethodAccess(ctx context.Context, project, instance, method string) (ztypes.SubscriptionLevel, error) {
@@ -342,9 +376,25 @@ func (a *AccessAuthorizer) ValidateUserInstanceMethodAccess(ctx context.Context,
 		return ztypes.NO_SUB_LEVEL, err
 	}
 
+	sub := newSubject(currUser)
+	sub.resourceOwner = proj.Owner == currUser.UserId
+	sub.join(mbr)
+
+	if !a.allowedMethod(ctx, project, instance, instancePolicy, sub.roles(), attributes(owner, currUser)) {
+		return ztypes.NO_SUB_LEVEL, errs.ErrInstanceAccessNotAllowed
+	}
+
 	return owner.Level, nil
 }
 
+// allowedMethod asks the policy engine whether the subject with roles may call the method of the instance
+func (a *AccessAuthorizer) allowedMethod(ctx context.Context, project, instance string, method *entity.MethodPolicy, roles []string, attrs map[string]string) bool {
+	attrs[access.ATTR_METHOD] = method.MethodName
+	attrs[access.ATTR_CATEGORY] = method.Category
+	a.addInstanceType(ctx, attrs, project, instance)
+	return a.allowed(ctx, access.RESOURCE_METHOD, ztypes.ACTION_ACCESS, roles, attrs)
+}
+
 func (a *AccessAuthorizer) ValidateAPIKeyInstanceMethodAccess(ctx context.Context, project, instance, method, apikey string) (ztypes.SubscriptionLevel, error) {
 
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ValidateAPIKeyInstanceMethodAccess"), rctx.Context(rctx.StartTime, time.Now()))
@@ -371,12 +421,23 @@ func (a *AccessAuthorizer) ValidateAPIKeyInstanceMethodAccess(ctx context.Contex
 		return ztypes.NO_SUB_LEVEL, err
 	}
 
+	scoped, ok := a.iamService.(apikeys.Service)
+	clientIP := apikeys.ClientIPFromContext(ctx)
+	if ok {
+		if err = scoped.CheckAPIKeyNetwork(ctx, apiKey.Key, clientIP); err != nil {
+			return ztypes.NO_SUB_LEVEL, err
+		}
+		if err = scoped.CheckAPIKeyScope(ctx, apiKey.Key, project, instance, instancePolicy.Category); err != nil {
+			return ztypes.NO_SUB_LEVEL, err
+		}
+	}
+
 	team, mbr, err := a.getTeam(ctx, proj.TeamId, apiKey.UserId)
-	if err != nil || !team.IsOwner(proj.Owner) || !mbr.IsJoined() {
+	if err != nil || !team.IsOwner(proj.Owner) || !serviceaccount.IsJoined(mbr) {
 		return ztypes.NO_SUB_LEVEL, err
 	}
 
-	keyPolicy, err := a.iamService.GetAPIKeyPolicy(ctx, apikey)
+	keyPolicy, err := a.iamService.GetAPIKeyPolicy(ctx, apiKey.Key)
 	if err != nil {
 		return ztypes.NO_SUB_LEVEL, nil
 	}
@@ -393,5 +454,20 @@ func (a *AccessAuthorizer) ValidateAPIKeyInstanceMethodAccess(ctx context.Contex
 		return ztypes.NO_SUB_LEVEL, err
 	}
 
+	roles := []string{access.ROLE_API_KEY}
+	if serviceaccount.IsServiceAccount(apiKey.UserId) {
+		roles = append(roles, access.ROLE_SERVICE_ACCOUNT)
+	}
+
+	if !a.allowedMethod(ctx, project, instance, instancePolicy, roles, attributes(owner, nil)) {
+		return ztypes.NO_SUB_LEVEL, errs.ErrInstanceAccessNotAllowed
+	}
+
+	if ok {
+		if err = scoped.RecordAPIKeyUse(ctx, apiKey.Key, clientIP); err != nil {
+			logger.Errorf(ctx, "Unable to record use of api key %s - %s", apiKey.Key, err)
+		}
+	}
+
 	return owner.Level, nil
 }
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/repo/pkg/access"
+)
+
+// baselineAllowed is the decision the authorizer made before the access policy, for an existing resource
+func baselineAllowed(action ztypes.ZBIAction, sub subject) bool {
+	switch action {
+	case ztypes.ACTION_CREATE:
+		return sub.owner || sub.teamAdmin
+	case ztypes.ACTION_UPDATE:
+		return sub.resourceOwner || sub.teamAdmin
+	case ztypes.ACTION_DELETE:
+		return sub.admin || sub.resourceOwner || sub.teamAdmin
+	case ztypes.ACTION_ACCESS:
+		return sub.admin || sub.resourceOwner || sub.member
+	}
+	return false
+}
+
+// subjects returns every combination of what the authorizer knows of a user. Team admins are always members.
+func subjects() []subject {
+	all := make([]subject, 0, 24)
+	for bits := 0; bits < 32; bits++ {
+		sub := subject{admin: bits&1 != 0, owner: bits&2 != 0, resourceOwner: bits&4 != 0, member: bits&8 != 0, teamAdmin: bits&16 != 0}
+		if sub.teamAdmin && !sub.member {
+			continue
+		}
+		all = append(all, sub)
+	}
+	return all
+}
+
+func Test_DefaultPolicyMatchesBaseline(t *testing.T) {
+	engine, err := access.NewEngine(access.DefaultDocument())
+	if err != nil {
+		t.Fatalf("Expected default document to compile but got err - %s", err)
+	}
+	a := &AccessAuthorizer{engine: engine}
+	ctx := context.Background()
+
+	tests := []struct {
+		resource string
+		denied   map[ztypes.ZBIAction]error
+		// project and instance are created without a resource to own or a team to belong to
+		createsResource bool
+	}{
+		{access.RESOURCE_PROJECT, projectDenied, true},
+		{access.RESOURCE_INSTANCE, instanceDenied, true},
+		{access.RESOURCE_TEAM, teamDenied, false},
+	}
+
+	for _, test := range tests {
+		for _, action := range []ztypes.ZBIAction{ztypes.ACTION_CREATE, ztypes.ACTION_UPDATE, ztypes.ACTION_DELETE, ztypes.ACTION_ACCESS} {
+			for _, sub := range subjects() {
+				if test.createsResource && action == ztypes.ACTION_CREATE && (sub.resourceOwner || sub.member) {
+					continue
+				}
+
+				expected := baselineAllowed(action, sub)
+				if allowed := a.allowed(ctx, test.resource, action, sub.roles(), nil); allowed != expected {
+					t.Fatalf("Expected %s %s by %+v to be allowed %t but got %t", action, test.resource, sub, expected, allowed)
+				}
+			}
+		}
+	}
+
+	if err := teamDenied[ztypes.ACTION_DELETE]; err != errs.ErrProjectDeleteNotAllowed {
+		t.Fatalf("Expected team deletes to be denied with the project error but got %v", err)
+	}
+	if err := instanceDenied[ztypes.ACTION_ACCESS]; err != errs.ErrInstanceAccessNotAllowed {
+		t.Fatalf("Expected instance access error but got %v", err)
+	}
+	if err := projectDenied[ztypes.ACTION_UPDATE]; err != errs.ErrProjectUpdateNotAllowed {
+		t.Fatalf("Expected project update error but got %v", err)
+	}
+}
+
+func Test_SubjectRoles(t *testing.T) {
+	if roles := (subject{}).roles(); len(roles) != 0 {
+		t.Fatalf("Expected no roles but got %v", roles)
+	}
+
+	expected := []string{access.ROLE_OWNER, access.ROLE_RESOURCE_OWNER, access.ROLE_TEAM_MEMBER, access.ROLE_TEAM_ADMIN}
+	if roles := (subject{owner: true, resourceOwner: true, member: true, teamAdmin: true}).roles(); !reflect.DeepEqual(roles, expected) {
+		t.Fatalf("Expected %v but got %v", expected, roles)
+	}
+
+	sub := subject{}
+	sub.join(nil)
+	if sub.member {
+		t.Fatalf("Expected subject without a membership not to be a member")
+	}
+}
@@ -0,0 +1,397 @@
+package basic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/apikeys"
+)
+
+// apikeyStore returns the admin repository if it stores scoped API keys
+func apikeyStore() (apikeys.Store, error) {
+	store, ok := vars.RepositoryFactory.GetAdminRepository().(apikeys.Store)
+	if !ok {
+		return nil, apikeys.ErrAPIKeysUnsupported
+	}
+	return store, nil
+}
+
+// CreateScopedAPIKey creates a key for the user that is limited to scope. A zero expires uses the default lifetime
+// of the policy. The full key is returned once.
+func (b *BasicIAMService) CreateScopedAPIKey(ctx context.Context, userId string, scope apikeys.Scope, expires time.Time) (*entity.APIKey, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateScopedAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = b.GetUser(ctx, userId); err != nil {
+		return nil, err
+	}
+
+	if err = scope.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expires, err = b.apikeyPolicy.Expiry(now, expires)
+	if err != nil {
+		return nil, err
+	}
+
+	record, key, err := apikeys.New(userId, now, expires.Sub(now))
+	if err != nil {
+		return nil, err
+	}
+	record.Scope = scope
+
+	if err = store.CreateAPIKeyRecord(ctx, record); err != nil {
+		return nil, err
+	}
+
+	logger.Infof(ctx, "Created api key %s for %s", record.Key, userId)
+
+	apikey := record.APIKey()
+	apikey.Key = key
+	return apikey, nil
+}
+
+// GetAPIKeyScope returns the scope of a key given its id or the full key
+func (b *BasicIAMService) GetAPIKeyScope(ctx context.Context, key string) (*apikeys.Scope, error) {
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := store.GetAPIKeyRecord(ctx, apikeys.ID(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &record.Scope, nil
+}
+
+// CheckAPIKeyScope fails with apikeys.ErrScopeDenied unless the scope of the key allows calling a method of
+// category on the instance. Repositories without scoped keys have no scopes to enforce.
+func (b *BasicIAMService) CheckAPIKeyScope(ctx context.Context, key, project, instance, category string) error {
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil
+	}
+
+	record, err := store.GetAPIKeyRecord(ctx, apikeys.ID(key))
+	if err != nil {
+		return err
+	}
+
+	if !record.Scope.Allows(project, instance, category, b.apikeyPolicy.ReadCategories) {
+		logger.Errorf(ctx, "Api key %s may not call %s on %s/%s", record.Key, category, project, instance)
+		return apikeys.ErrScopeDenied
+	}
+
+	return nil
+}
+
+// DisableExpiredAPIKeys disables the keys that have expired and returns how many were disabled
+func (b *BasicIAMService) DisableExpiredAPIKeys(ctx context.Context) (int64, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DisableExpiredAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := apikeyStore()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := store.DisableExpiredAPIKeys(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	if count > 0 {
+		logger.Infof(ctx, "Disabled %d expired api keys", count)
+	}
+
+	return count, nil
+}
+
+// StartAPIKeySweep disables expired keys every interval until ctx is done
+func (b *BasicIAMService) StartAPIKeySweep(ctx context.Context, interval time.Duration) {
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := b.DisableExpiredAPIKeys(ctx); err != nil {
+					logger.Errorf(ctx, "Api key sweep failed - %s", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// RotateAPIKey replaces a key with a successor that has the same owner, scope, networks and policy. The key keeps
+// working for the rotation grace of the policy so clients can switch over. The full successor key is returned once.
+func (b *BasicIAMService) RotateAPIKey(ctx context.Context, key string) (*entity.APIKey, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := store.GetAPIKeyRecord(ctx, apikeys.ID(key))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.Disabled {
+		return nil, apikeys.ErrAPIKeyDisabled
+	} else if record.Expired(now) {
+		return nil, apikeys.ErrAPIKeyExpired
+	} else if len(record.Successor) > 0 {
+		return nil, apikeys.ErrAPIKeyRotated
+	}
+
+	expires, err := b.apikeyPolicy.Expiry(now, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	next, nextKey, err := apikeys.New(record.UserId, now, expires.Sub(now))
+	if err != nil {
+		return nil, err
+	}
+	next.Scope = record.Scope
+	next.Networks = record.Networks
+	next.Predecessor = record.Key
+
+	if err = store.RotateAPIKeyRecord(ctx, record.Key, next, now.Add(b.apikeyPolicy.RotationGrace)); err != nil {
+		return nil, err
+	}
+
+	logger.Infof(ctx, "Rotated api key %s of %s to %s", record.Key, record.UserId, next.Key)
+
+	apikey := next.APIKey()
+	apikey.Key = nextKey
+	return apikey, nil
+}
+
+// GetAPIKeyLineage follows the rotations of a key back to the first key and forward to the current one. A deleted
+// key ends the lineage.
+func (b *BasicIAMService) GetAPIKeyLineage(ctx context.Context, key string) ([]apikeys.Record, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKeyLineage"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := store.GetAPIKeyRecord(ctx, apikeys.ID(key))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{record.Key: true}
+	lineage := []apikeys.Record{*record}
+	for id := record.Predecessor; len(id) > 0 && !seen[id]; {
+		previous, err := store.GetAPIKeyRecord(ctx, id)
+		if errors.Is(err, errs.ErrDBItemNotFound) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		seen[id] = true
+		lineage = append([]apikeys.Record{*previous}, lineage...)
+		id = previous.Predecessor
+	}
+
+	for id := record.Successor; len(id) > 0 && !seen[id]; {
+		following, err := store.GetAPIKeyRecord(ctx, id)
+		if errors.Is(err, errs.ErrDBItemNotFound) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		seen[id] = true
+		lineage = append(lineage, *following)
+		id = following.Successor
+	}
+
+	for index := range lineage {
+		lineage[index].Hash = ""
+	}
+
+	return lineage, nil
+}
+
+// SetAPIKeyNetworks binds a key to CIDR ranges or single addresses. An empty list lets the key be used from anywhere.
+func (b *BasicIAMService) SetAPIKeyNetworks(ctx context.Context, key string, networks []string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetAPIKeyNetworks"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := apikeyStore()
+	if err != nil {
+		return err
+	}
+
+	parsed, err := apikeys.ParseNetworks(networks)
+	if err != nil {
+		return err
+	}
+
+	return store.SetAPIKeyNetworks(ctx, apikeys.ID(key), parsed)
+}
+
+// CheckAPIKeyNetwork fails with apikeys.ErrNetworkDenied unless the key may be used from ip. Repositories without
+// scoped keys have no networks to enforce.
+func (b *BasicIAMService) CheckAPIKeyNetwork(ctx context.Context, key, ip string) error {
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil
+	}
+
+	record, err := store.GetAPIKeyRecord(ctx, apikeys.ID(key))
+	if err != nil {
+		return err
+	}
+
+	if !record.AllowsIP(ip) {
+		logger.Errorf(ctx, "Api key %s may not be used from %q", record.Key, ip)
+		return apikeys.ErrNetworkDenied
+	}
+
+	return nil
+}
+
+// RecordAPIKeyUse counts a request made with the key from ip. While the usage flush runs the request is buffered
+// and written later; otherwise it is written at once. Repositories without scoped keys do not track usage.
+func (b *BasicIAMService) RecordAPIKeyUse(ctx context.Context, key, ip string) error {
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil
+	}
+
+	id, now := apikeys.ID(key), time.Now()
+	if b.usage.Record(id, ip, now) {
+		return nil
+	}
+
+	return store.RecordAPIKeyUse(ctx, id, apikeys.Usage{LastUsed: now, LastIP: ip, Requests: 1})
+}
+
+// StartAPIKeyUsageFlush buffers the usage of keys and writes it every interval instead of on each request. The
+// returned function stops buffering and writes the usage that is still pending.
+func (b *BasicIAMService) StartAPIKeyUsageFlush(interval time.Duration) func() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	b.usage.Start()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				b.flushAPIKeyUsage(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+		b.usage.Stop()
+		b.flushAPIKeyUsage(context.Background())
+	}
+}
+
+func (b *BasicIAMService) flushAPIKeyUsage(ctx context.Context) {
+
+	if b.usage.Empty() {
+		return
+	}
+
+	store, err := apikeyStore()
+	if err != nil {
+		return
+	}
+
+	if err = b.usage.Flush(ctx, store); err != nil {
+		logger.Errorf(ctx, "Unable to write api key usage - %s", err)
+	}
+}
+
+// GetAPIKeyUsage returns the usage of a key, including requests that are buffered and not written yet
+func (b *BasicIAMService) GetAPIKeyUsage(ctx context.Context, key string) (*apikeys.Usage, error) {
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	id := apikeys.ID(key)
+	record, err := store.GetAPIKeyRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Usage.Add(b.usage.Pending(id))
+	return &record.Usage, nil
+}
+
+// GetUnusedAPIKeys returns the keys that were not used since since, without their hashes. Keys that were never used
+// count from when they were created.
+func (b *BasicIAMService) GetUnusedAPIKeys(ctx context.Context, since time.Time) ([]apikeys.Record, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUnusedAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := apikeyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	// buffered requests may make a key used
+	b.flushAPIKeyUsage(ctx)
+
+	unused, err := store.GetUnusedAPIKeys(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+
+	for index := range unused {
+		unused[index].Hash = ""
+	}
+
+	return unused, nil
+}
@@ -6,24 +6,58 @@ import (
 	"github.com/zbitech/common/interfaces"
 	"github.com/zbitech/common/pkg/errs"
 	"github.com/zbitech/common/pkg/model/entity"
-	"github.com/zbitech/common/pkg/model/object"
 	"github.com/zbitech/common/pkg/model/ztypes"
 	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"github.com/zbitech/repo/pkg/iam/jwtsvr"
+	"github.com/zbitech/repo/pkg/lockout"
+	"github.com/zbitech/repo/pkg/mfa"
+	"github.com/zbitech/repo/pkg/password"
+	"github.com/zbitech/repo/pkg/reset"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+	"sync"
 	"time"
 )
 
 type BasicIAMService struct {
 	jwtServer interfaces.JwtServerIF
+	guard     *lockout.Guard
+	passwords password.Policy
+
+	resetLifetime time.Duration
+	notifier      reset.Notifier
+
+	mfaPolicy mfa.Policy
+	// mfaMu serializes code checks so a code is accepted once
+	mfaMu sync.Mutex
+
+	apikeyPolicy apikeys.Policy
+	usage        *apikeys.UsageBuffer
 }
 
-func NewBasicIAMService(jwtServer interfaces.JwtServerIF) interfaces.IAMServiceIF {
-	return &BasicIAMService{jwtServer: jwtServer}
+// Options configure the basic IAM service
+type Options struct {
+	Lockout       lockout.Policy
+	Password      password.Policy
+	ResetLifetime time.Duration
+	Notifier      reset.Notifier
+	MFA           mfa.Policy
+	APIKeys       apikeys.Policy
+}
+
+func DefaultOptions() Options {
+	return Options{Lockout: lockout.DefaultPolicy(), Password: password.DefaultPolicy(), ResetLifetime: reset.DEFAULT_LIFETIME,
+		MFA: mfa.DefaultPolicy(), APIKeys: apikeys.DefaultPolicy()}
 }
 
-func (b *BasicIAMService) RegisterUser(ctx context.Context, user *entity.User, pass *entity.UserPassword) error {
+func NewBasicIAMService(jwtServer interfaces.JwtServerIF) interfaces.IAMServiceIF {
+	return NewBasicIAMServiceWithOptions(jwtServer, DefaultOptions())
+}
 
-	adminRepo := vars.RepositoryFactory.GetAdminRepository()
-	return adminRepo.RegisterUser(ctx, user, pass)
+func NewBasicIAMServiceWithOptions(jwtServer interfaces.JwtServerIF, opts Options) *BasicIAMService {
+	return &BasicIAMService{jwtServer: jwtServer, guard: lockout.NewGuard(opts.Lockout), passwords: opts.Password,
+		resetLifetime: opts.ResetLifetime, notifier: opts.Notifier, mfaPolicy: opts.MFA,
+		apikeyPolicy: opts.APIKeys, usage: apikeys.NewUsageBuffer()}
 }
 
 func (b *BasicIAMService) DeactivateUser(ctx context.Context, userid string) error {
@@ -35,7 +69,11 @@ func (b *BasicIAMService) DeactivateUser(ctx context.Context, userid string) err
 	user.Active = false
 	user.LastUpdate = time.Now()
 
-	return b.UpdateUser(ctx, user)
+	if err = b.UpdateUser(ctx, user); err != nil {
+		return err
+	}
+
+	return b.revokeUserTokens(ctx, userid)
 }
 
 func (b *BasicIAMService) ReactivateUser(ctx context.Context, userid string) error {
@@ -50,10 +88,18 @@ func (b *BasicIAMService) ReactivateUser(ctx context.Context, userid string) err
 	return b.UpdateUser(ctx, user)
 }
 
+// GetUsers returns the people registered with the service. Service accounts are listed by team with
+// GetServiceAccounts.
 func (b *BasicIAMService) GetUsers(ctx context.Context) []entity.User {
 
 	adminRepo := vars.RepositoryFactory.GetAdminRepository()
-	return adminRepo.GetUsers(ctx)
+	users := make([]entity.User, 0)
+	for _, user := range adminRepo.GetUsers(ctx) {
+		if !serviceaccount.IsServiceAccount(user.UserId) {
+			users = append(users, user)
+		}
+	}
+	return users
 }
 
 func (b *BasicIAMService) GetUser(ctx context.Context, userId string) (*entity.User, error) {
@@ -68,18 +114,6 @@ func (b *BasicIAMService) UpdateUser(ctx context.Context, user *entity.User) err
 	return adminRepo.UpdateUser(ctx, user)
 }
 
-func (b *BasicIAMService) ChangePassword(ctx context.Context, userid string, pass *entity.UserPassword) error {
-
-	adminRepo := vars.RepositoryFactory.GetAdminRepository()
-	return adminRepo.UpdatePassword(ctx, userid, pass)
-}
-
-func (b *BasicIAMService) AuthenticateUser(ctx context.Context, userId, password string) (*string, error) {
-
-	adminRepo := vars.RepositoryFactory.GetAdminRepository()
-	return adminRepo.AuthenticateUser(ctx, userId, password)
-}
-
 func (b *BasicIAMService) ValidateAuthToken(ctx context.Context, tokenString string) (jwt.Claims, *entity.User, error) {
 
 	token, err := jwt.ParseWithClaims(tokenString, b.jwtServer.GetPayload(), func(token *jwt.Token) (interface{}, error) {
@@ -88,6 +122,9 @@ func (b *BasicIAMService) ValidateAuthToken(ctx context.Context, tokenString str
 			return nil, err
 		}
 
+		if resolver, ok := b.jwtServer.(jwtsvr.KeyResolver); ok {
+			return resolver.GetTokenKey(ctx, token)
+		}
 		return b.jwtServer.GetKey()
 	})
 
@@ -99,15 +136,44 @@ func (b *BasicIAMService) ValidateAuthToken(ctx context.Context, tokenString str
 		return nil, nil, errs.ErrInvalidToken
 	}
 
-	claims := token.Claims.(*object.ZBIBasicClaims)
-	user, err := b.GetUser(ctx, claims.Subject)
+	claims := token.Claims
+	user, err := b.GetUser(ctx, b.jwtServer.GetUserId(claims))
 	if err != nil {
 		return nil, nil, errs.ErrUnregisteredUser
 	}
 
+	if err = b.checkRevocation(ctx, claims, user); err != nil {
+		return nil, nil, err
+	}
+
+	if mapper, ok := b.jwtServer.(jwtsvr.IdentityMapper); ok {
+		user = mapIdentity(mapper, claims, user)
+	}
+
 	return claims, user, nil
 }
 
+// mapIdentity returns a copy of the stored user with the role and team memberships of the token, so the authorizer
+// sees what the identity provider granted. Keys of memberships that are also stored are kept.
+func mapIdentity(mapper jwtsvr.IdentityMapper, claims jwt.Claims, user *entity.User) *entity.User {
+
+	mapped := *user
+	mapped.Role = mapper.GetRole(claims)
+
+	keys := make(map[string]string, len(user.Memberships))
+	for _, membership := range user.Memberships {
+		keys[membership.TeamId] = membership.Key
+	}
+
+	teams := mapper.GetTeams(claims)
+	mapped.Memberships = make([]entity.UserTeam, 0, len(teams))
+	for _, team := range teams {
+		mapped.Memberships = append(mapped.Memberships, entity.UserTeam{TeamId: team, Key: keys[team]})
+	}
+
+	return &mapped
+}
+
 func (b *BasicIAMService) GetAPIKeys(ctx context.Context, userId string) ([]string, error) {
 
 	adminRepo := vars.RepositoryFactory.GetAdminRepository()
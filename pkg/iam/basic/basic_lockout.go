@@ -0,0 +1,127 @@
+package basic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/lockout"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+)
+
+// lockoutStore returns the admin repository if it stores login attempts
+func lockoutStore() (lockout.Store, error) {
+	store, ok := vars.RepositoryFactory.GetAdminRepository().(lockout.Store)
+	if !ok {
+		return nil, lockout.ErrLockoutUnsupported
+	}
+	return store, nil
+}
+
+// AuthenticateUser checks the password of the user and returns an access token. Failed attempts are counted for
+// the user and for the source set with lockout.WithSource. Each failure delays the next attempt and too many lock
+// the account for a while; both are reported with a lockout.LockedError before the password is checked. A
+// password stored with an outdated cost is rehashed, and an expired password is rejected with
+// password.ErrPasswordExpired. Users with a second factor get an mfa.ChallengeError instead of a token.
+func (b *BasicIAMService) AuthenticateUser(ctx context.Context, userId, password string) (*string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AuthenticateUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	token, err := b.authenticate(ctx, userId, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = b.checkPassword(ctx, userId, password); err != nil {
+		return nil, err
+	}
+
+	if err = b.checkMFA(ctx, userId); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// authenticate checks the password of the user under the lockout policy. Service accounts have no password.
+func (b *BasicIAMService) authenticate(ctx context.Context, userId, password string) (*string, error) {
+
+	if serviceaccount.IsServiceAccount(userId) {
+		return nil, serviceaccount.ErrPasswordLogin
+	}
+
+	adminRepo := vars.RepositoryFactory.GetAdminRepository()
+	store, err := lockoutStore()
+	if err != nil {
+		return adminRepo.AuthenticateUser(ctx, userId, password)
+	}
+
+	source := lockout.SourceFromContext(ctx)
+	if err = b.guard.Check(ctx, store, userId, source, time.Now()); err != nil {
+		logger.Errorf(ctx, "Rejected login of %s from %s - %s", userId, source, err)
+		return nil, err
+	}
+
+	token, err := adminRepo.AuthenticateUser(ctx, userId, password)
+	if errors.Is(err, errs.ErrAuthFailed) || errors.Is(err, errs.ErrDBItemNotFound) {
+		if lockErr := b.guard.Failed(ctx, store, userId, source, time.Now()); lockErr != nil {
+			logger.Errorf(ctx, "Unable to record failed login of %s - %s", userId, lockErr)
+		}
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	if lockErr := b.guard.Succeeded(ctx, store, userId); lockErr != nil {
+		logger.Errorf(ctx, "Unable to clear failed logins of %s - %s", userId, lockErr)
+	}
+
+	return token, nil
+}
+
+// GetLoginAttempts returns the recent failed logins of the user
+func (b *BasicIAMService) GetLoginAttempts(ctx context.Context, userId string) (*lockout.Attempts, error) {
+
+	store, err := lockoutStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetAttempts(ctx, lockout.UserKey(userId))
+}
+
+// UnlockUser clears the failed logins and second factors of the user so the user can log in again immediately
+func (b *BasicIAMService) UnlockUser(ctx context.Context, userId string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UnlockUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := lockoutStore()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range []string{lockout.UserKey(userId), lockout.MFAKey(userId)} {
+		if err = store.DeleteAttempts(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof(ctx, "Unlocked %s", userId)
+	return nil
+}
+
+func (b *BasicIAMService) PurgeExpiredAttempts(ctx context.Context) (int64, error) {
+
+	store, err := lockoutStore()
+	if err != nil {
+		return 0, err
+	}
+
+	return store.PurgeExpiredAttempts(ctx, time.Now())
+}
@@ -0,0 +1,426 @@
+package basic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/internal/helper"
+	"github.com/zbitech/repo/pkg/lockout"
+	"github.com/zbitech/repo/pkg/mfa"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+	"github.com/zbitech/repo/pkg/session"
+)
+
+// mfaStore returns the admin repository if it stores MFA enrollments
+func mfaStore() (mfa.Store, error) {
+	store, ok := vars.RepositoryFactory.GetAdminRepository().(mfa.Store)
+	if !ok {
+		return nil, mfa.ErrMFAUnsupported
+	}
+	return store, nil
+}
+
+// getEnrollment returns the enrollment of the user, or nil when the user has none
+func getEnrollment(ctx context.Context, store mfa.Store, userId string) (*mfa.Enrollment, error) {
+	e, err := store.GetMFA(ctx, userId)
+	if errors.Is(err, errs.ErrDBItemNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// checkMFA runs after the password of the user was accepted. Users with an active factor, or who must enroll,
+// get a ChallengeError instead of a token. Without a repository or key the check fails closed for those users.
+func (b *BasicIAMService) checkMFA(ctx context.Context, userId string) error {
+
+	user, err := b.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	var e *mfa.Enrollment
+	store, err := mfaStore()
+	if err == nil {
+		if e, err = getEnrollment(ctx, store, userId); err != nil {
+			return err
+		}
+	} else if !b.mfaPolicy.Required(user.Role, nil) {
+		return nil
+	}
+
+	cause := mfa.ErrMFARequired
+	if e == nil || !e.Active() {
+		if !b.mfaPolicy.Required(user.Role, e) {
+			return nil
+		}
+		cause = mfa.ErrMFAEnrollmentRequired
+	}
+
+	if store == nil || b.mfaPolicy.Keys == nil {
+		logger.Errorf(ctx, "Rejected login of %s - %s and mfa is not available", userId, cause)
+		return mfa.ErrMFAUnavailable
+	}
+
+	challenge, expires, err := b.mfaPolicy.Keys.NewChallenge(userId, time.Now(), b.mfaPolicy.ChallengeLifetime)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf(ctx, "Login of %s needs a second factor - %s", userId, cause)
+	return &mfa.ChallengeError{Err: cause, Challenge: challenge, Expires: expires}
+}
+
+// verifyCode checks a TOTP or recovery code of the user. A TOTP code confirms a pending enrollment when confirm is
+// set; recovery codes only work once the factor is confirmed.
+func (b *BasicIAMService) verifyCode(ctx context.Context, store mfa.Store, userId, code string, confirm bool) error {
+
+	b.mfaMu.Lock()
+	defer b.mfaMu.Unlock()
+
+	e, err := getEnrollment(ctx, store, userId)
+	if err != nil {
+		return err
+	} else if e == nil || len(e.Secret) == 0 {
+		return mfa.ErrMFANotEnrolled
+	} else if !e.Confirmed && !confirm {
+		return mfa.ErrMFANotEnrolled
+	}
+
+	now := time.Now()
+	if mfa.IsRecoveryCode(code) {
+		if !e.Confirmed || !e.UseRecoveryCode(code) {
+			return mfa.ErrInvalidCode
+		}
+		logger.Infof(ctx, "Used a recovery code of %s - %d left", userId, len(e.RecoveryCodes))
+	} else {
+		secret, err := b.mfaPolicy.Keys.Decrypt(userId, e.Secret)
+		if err != nil {
+			logger.Errorf(ctx, "Unable to decrypt totp secret of %s - %s", userId, err)
+			return err
+		}
+
+		step, ok := mfa.Validate(secret, code, now, e.LastStep)
+		if !ok {
+			return mfa.ErrInvalidCode
+		}
+		e.LastStep = step
+		e.Confirmed = true
+	}
+
+	e.Updated = now
+	return store.PutMFA(ctx, e)
+}
+
+// ChallengeUser returns the user of a login challenge
+func (b *BasicIAMService) ChallengeUser(ctx context.Context, challenge string) (string, error) {
+
+	if b.mfaPolicy.Keys == nil {
+		return "", mfa.ErrMFAUnavailable
+	}
+
+	return b.mfaPolicy.Keys.ParseChallenge(challenge, time.Now())
+}
+
+// VerifyMFA completes a login with the challenge from AuthenticateUser and a TOTP or recovery code, and returns an
+// access token. Wrong codes are counted apart from wrong passwords, so a correct password does not reset them. A
+// user who enrolled during the login confirms the factor here.
+func (b *BasicIAMService) VerifyMFA(ctx context.Context, challenge, code string) (*string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "VerifyMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	userId, err := b.ChallengeUser(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := mfaStore()
+	if err != nil {
+		return nil, err
+	}
+
+	source := lockout.SourceFromContext(ctx)
+	attempts, _ := lockoutStore()
+	if attempts != nil {
+		if err = b.guard.CheckMFA(ctx, attempts, userId, source, time.Now()); err != nil {
+			logger.Errorf(ctx, "Rejected second factor of %s from %s - %s", userId, source, err)
+			return nil, err
+		}
+	}
+
+	if err = b.verifyCode(ctx, store, userId, code, true); err != nil {
+		if errors.Is(err, mfa.ErrInvalidCode) && attempts != nil {
+			if lockErr := b.guard.FailedMFA(ctx, attempts, userId, source, time.Now()); lockErr != nil {
+				logger.Errorf(ctx, "Unable to record failed second factor of %s - %s", userId, lockErr)
+			}
+		}
+		return nil, err
+	}
+
+	if attempts != nil {
+		if lockErr := b.guard.SucceededMFA(ctx, attempts, userId); lockErr != nil {
+			logger.Errorf(ctx, "Unable to clear failed second factors of %s - %s", userId, lockErr)
+		}
+	}
+
+	user, err := b.GetUser(ctx, userId)
+	if err != nil || !user.Active {
+		return nil, errs.ErrAuthFailed
+	}
+
+	return helper.GenerateJwtToken(*user)
+}
+
+// LoginMFA completes a Login that returned an mfa.ChallengeError and starts the session on device
+func (b *BasicIAMService) LoginMFA(ctx context.Context, challenge, code, device string) (*session.Tokens, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "LoginMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := sessionStore()
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := b.ChallengeUser(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := b.VerifyMFA(ctx, challenge, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return startSession(ctx, store, userId, device, *accessToken)
+}
+
+// EnrollMFA creates a new TOTP secret and recovery codes for the user. They are returned once and take effect
+// when a code confirms them. A confirmed factor must be disabled before enrolling again.
+func (b *BasicIAMService) EnrollMFA(ctx context.Context, userId string) (*mfa.Setup, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "EnrollMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if b.mfaPolicy.Keys == nil {
+		return nil, mfa.ErrMFAUnavailable
+	}
+
+	if serviceaccount.IsServiceAccount(userId) {
+		return nil, serviceaccount.ErrPasswordLogin
+	}
+
+	store, err := mfaStore()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = b.GetUser(ctx, userId); err != nil {
+		return nil, err
+	}
+
+	b.mfaMu.Lock()
+	defer b.mfaMu.Unlock()
+
+	e, err := getEnrollment(ctx, store, userId)
+	if err != nil {
+		return nil, err
+	} else if e == nil {
+		e = &mfa.Enrollment{UserId: userId}
+	} else if e.Active() {
+		return nil, mfa.ErrMFAAlreadyEnrolled
+	}
+
+	secret, err := mfa.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := mfa.NewRecoveryCodes(b.mfaPolicy.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Secret, err = b.mfaPolicy.Keys.Encrypt(userId, secret); err != nil {
+		return nil, err
+	}
+	e.Confirmed = false
+	e.RecoveryCodes = hashes
+	e.Updated = time.Now()
+
+	if err = store.PutMFA(ctx, e); err != nil {
+		return nil, err
+	}
+
+	logger.Infof(ctx, "Started mfa enrollment of %s", userId)
+	return &mfa.Setup{Secret: secret, URI: mfa.ProvisioningURI(b.mfaPolicy.Issuer, userId, secret), RecoveryCodes: codes}, nil
+}
+
+// ConfirmMFA activates a pending enrollment with a code from the authenticator
+func (b *BasicIAMService) ConfirmMFA(ctx context.Context, userId, code string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ConfirmMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if b.mfaPolicy.Keys == nil {
+		return mfa.ErrMFAUnavailable
+	}
+
+	store, err := mfaStore()
+	if err != nil {
+		return err
+	}
+
+	if status, err := b.GetMFAStatus(ctx, userId); err != nil {
+		return err
+	} else if status.Enrolled {
+		return mfa.ErrMFAAlreadyEnrolled
+	}
+
+	if mfa.IsRecoveryCode(code) {
+		return mfa.ErrInvalidCode
+	}
+
+	if err = b.verifyCode(ctx, store, userId, code, true); err != nil {
+		return err
+	}
+
+	logger.Infof(ctx, "Confirmed mfa enrollment of %s", userId)
+	return nil
+}
+
+// DisableMFA removes the factor of the user. A user who is required to use MFA must enroll again at the next login.
+func (b *BasicIAMService) DisableMFA(ctx context.Context, userId string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DisableMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := mfaStore()
+	if err != nil {
+		return err
+	}
+
+	b.mfaMu.Lock()
+	defer b.mfaMu.Unlock()
+
+	e, err := getEnrollment(ctx, store, userId)
+	if err != nil {
+		return err
+	} else if e == nil || len(e.Secret) == 0 {
+		return mfa.ErrMFANotEnrolled
+	}
+
+	e.Secret = ""
+	e.Confirmed = false
+	e.RecoveryCodes = nil
+	e.Updated = time.Now()
+	if err = store.PutMFA(ctx, e); err != nil {
+		return err
+	}
+
+	logger.Infof(ctx, "Disabled mfa of %s", userId)
+	return nil
+}
+
+// SetMFARequired sets whether the user must use MFA in addition to the roles that require it
+func (b *BasicIAMService) SetMFARequired(ctx context.Context, userId string, required bool) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetMFARequired"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if required && b.mfaPolicy.Keys == nil {
+		return mfa.ErrMFAUnavailable
+	}
+
+	store, err := mfaStore()
+	if err != nil {
+		return err
+	}
+
+	if _, err = b.GetUser(ctx, userId); err != nil {
+		return err
+	}
+
+	b.mfaMu.Lock()
+	defer b.mfaMu.Unlock()
+
+	e, err := getEnrollment(ctx, store, userId)
+	if err != nil {
+		return err
+	} else if e == nil {
+		e = &mfa.Enrollment{UserId: userId}
+	}
+
+	e.Required = required
+	e.Updated = time.Now()
+	return store.PutMFA(ctx, e)
+}
+
+func (b *BasicIAMService) GetMFAStatus(ctx context.Context, userId string) (*mfa.Status, error) {
+
+	user, err := b.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := mfaStore()
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := getEnrollment(ctx, store, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &mfa.Status{Required: b.mfaPolicy.Required(user.Role, e)}
+	if e != nil && e.Active() {
+		status.Enrolled = true
+		status.RecoveryCodes = len(e.RecoveryCodes)
+	}
+
+	return status, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of an enrolled user and returns the new codes once
+func (b *BasicIAMService) RegenerateRecoveryCodes(ctx context.Context, userId string) ([]string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RegenerateRecoveryCodes"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := mfaStore()
+	if err != nil {
+		return nil, err
+	}
+
+	b.mfaMu.Lock()
+	defer b.mfaMu.Unlock()
+
+	e, err := getEnrollment(ctx, store, userId)
+	if err != nil {
+		return nil, err
+	} else if e == nil || !e.Active() {
+		return nil, mfa.ErrMFANotEnrolled
+	}
+
+	codes, hashes, err := mfa.NewRecoveryCodes(b.mfaPolicy.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	e.RecoveryCodes = hashes
+	e.Updated = time.Now()
+	if err = store.PutMFA(ctx, e); err != nil {
+		return nil, err
+	}
+
+	logger.Infof(ctx, "Regenerated recovery codes of %s", userId)
+	return codes, nil
+}
@@ -0,0 +1,239 @@
+package basic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/password"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+)
+
+// passwordStore returns the admin repository if it stores password history
+func passwordStore() (password.Store, error) {
+	store, ok := vars.RepositoryFactory.GetAdminRepository().(password.Store)
+	if !ok {
+		return nil, password.ErrPasswordUnsupported
+	}
+	return store, nil
+}
+
+// passwordHistory returns the password history of the user, or nil when none is stored
+func passwordHistory(ctx context.Context, store password.Store, userId string) (*password.History, error) {
+	if store == nil {
+		return nil, nil
+	}
+
+	history, err := store.GetPasswordHistory(ctx, userId)
+	if errors.Is(err, errs.ErrDBItemNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// hashPassword checks a plaintext password against the policy and the history and hashes it. Hashes cannot be
+// checked and are rejected; the hashed passwords of admin.yaml are stored by seeding, which does not go through
+// the service.
+func (b *BasicIAMService) hashPassword(ctx context.Context, pass *entity.UserPassword, history *password.History) (*entity.UserPassword, error) {
+
+	if password.IsHash(pass.Password) {
+		logger.Errorf(ctx, "Password of %s was given as a hash", pass.UserId)
+		return nil, password.ErrPasswordHashed
+	}
+
+	if err := b.passwords.Validate(pass.Password, history); err != nil {
+		return nil, err
+	}
+
+	hash, err := b.passwords.Hash(pass.Password)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to hash password of %s - %s", pass.UserId, err)
+		return nil, errs.ErrMarshalFailed
+	}
+
+	hashed := entity.NewUserPassword(pass.UserId, hash)
+	return &hashed, nil
+}
+
+// validatePassword checks a new plaintext password of the user against the policy and the user's history
+func (b *BasicIAMService) validatePassword(ctx context.Context, userId, plaintext string) error {
+
+	store, _ := passwordStore()
+	history, err := passwordHistory(ctx, store, userId)
+	if err != nil {
+		return err
+	}
+
+	return b.passwords.Validate(plaintext, history)
+}
+
+// recordPassword adds the hash of a new password to the history of the user. The password has already changed,
+// so failures are only logged.
+func (b *BasicIAMService) recordPassword(ctx context.Context, store password.Store, userId, hash string, history *password.History) {
+
+	if store == nil {
+		return
+	}
+
+	if history == nil {
+		history = &password.History{UserId: userId}
+	}
+
+	size := b.passwords.History
+	if size < 1 {
+		size = 1
+	}
+	history.Add(hash, size, time.Now())
+
+	if err := store.PutPasswordHistory(ctx, history); err != nil {
+		logger.Errorf(ctx, "Unable to record password history of %s - %s", userId, err)
+	}
+}
+
+// RegisterUser registers the user with a plaintext password, which must meet the password policy and is hashed
+// with the configured cost
+func (b *BasicIAMService) RegisterUser(ctx context.Context, user *entity.User, pass *entity.UserPassword) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RegisterUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if serviceaccount.IsServiceAccount(user.UserId) {
+		return serviceaccount.ErrReservedUserId
+	}
+
+	adminRepo := vars.RepositoryFactory.GetAdminRepository()
+	if pass == nil {
+		return adminRepo.RegisterUser(ctx, user, pass)
+	}
+
+	hashed, err := b.hashPassword(ctx, pass, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = adminRepo.RegisterUser(ctx, user, hashed); err != nil {
+		return err
+	}
+
+	store, _ := passwordStore()
+	b.recordPassword(ctx, store, user.UserId, hashed.Password, nil)
+	return nil
+}
+
+// ChangePassword replaces the password of the user and revokes the user's tokens. The plaintext password must
+// meet the password policy and differ from the recent passwords of the user.
+func (b *BasicIAMService) ChangePassword(ctx context.Context, userid string, pass *entity.UserPassword) error {
+	return b.changePassword(ctx, userid, pass)
+}
+
+// SetPassword replaces the password of the user with a plaintext password that must meet the password policy
+func (b *BasicIAMService) SetPassword(ctx context.Context, userId, plaintext string) error {
+	pass := entity.NewUserPassword(userId, plaintext)
+	return b.changePassword(ctx, userId, &pass)
+}
+
+// UpdatePassword replaces the password of the user after checking the current one. It is how users change an
+// expired password. Wrong passwords count as failed logins.
+func (b *BasicIAMService) UpdatePassword(ctx context.Context, userId, current, next string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdatePassword"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if _, err := b.authenticate(ctx, userId, current); err != nil {
+		return err
+	}
+
+	return b.SetPassword(ctx, userId, next)
+}
+
+func (b *BasicIAMService) changePassword(ctx context.Context, userId string, pass *entity.UserPassword) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ChangePassword"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if serviceaccount.IsServiceAccount(userId) {
+		return serviceaccount.ErrPasswordLogin
+	}
+
+	store, _ := passwordStore()
+	history, err := passwordHistory(ctx, store, userId)
+	if err != nil {
+		return err
+	}
+
+	hashed, err := b.hashPassword(ctx, pass, history)
+	if err != nil {
+		return err
+	}
+
+	adminRepo := vars.RepositoryFactory.GetAdminRepository()
+	if err = adminRepo.UpdatePassword(ctx, userId, hashed); err != nil {
+		return err
+	}
+
+	b.recordPassword(ctx, store, userId, hashed.Password, history)
+	return b.revokeUserTokens(ctx, userId)
+}
+
+// checkPassword runs after a successful login. It rehashes a password stored with a lower cost than the policy
+// requires and rejects passwords older than the maximum age. Users without history start aging from this login.
+func (b *BasicIAMService) checkPassword(ctx context.Context, userId, plaintext string) error {
+
+	store, err := passwordStore()
+	if err != nil {
+		return nil
+	}
+
+	if pass, err := store.GetPassword(ctx, userId); err != nil {
+		logger.Errorf(ctx, "Unable to read password of %s - %s", userId, err)
+	} else if b.passwords.NeedsRehash(pass.Password) {
+		b.rehashPassword(ctx, userId, plaintext)
+	}
+
+	history, err := passwordHistory(ctx, store, userId)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to read password history of %s - %s", userId, err)
+		return nil
+	}
+
+	if history == nil {
+		if pass, err := store.GetPassword(ctx, userId); err == nil {
+			b.recordPassword(ctx, store, userId, pass.Password, nil)
+		}
+		return nil
+	}
+
+	if b.passwords.Expired(history, time.Now()) {
+		logger.Infof(ctx, "Password of %s expired - last changed %s", userId, history.Changed)
+		return password.ErrPasswordExpired
+	}
+
+	return nil
+}
+
+// rehashPassword stores the password again with the current cost. The history keeps the earlier hash, which
+// still matches the same password. Failures are logged and the old hash is kept.
+func (b *BasicIAMService) rehashPassword(ctx context.Context, userId, plaintext string) {
+
+	hash, err := b.passwords.Hash(plaintext)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to rehash password of %s - %s", userId, err)
+		return
+	}
+
+	pass := entity.NewUserPassword(userId, hash)
+	adminRepo := vars.RepositoryFactory.GetAdminRepository()
+	if err = adminRepo.UpdatePassword(ctx, userId, &pass); err != nil {
+		logger.Errorf(ctx, "Unable to store rehashed password of %s - %s", userId, err)
+		return
+	}
+
+	logger.Infof(ctx, "Rehashed password of %s with cost %d", userId, b.passwords.Cost)
+}
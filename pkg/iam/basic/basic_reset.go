@@ -0,0 +1,131 @@
+package basic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/reset"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+)
+
+// resetStore returns the admin repository if it stores password reset tokens
+func resetStore() (reset.Store, error) {
+	store, ok := vars.RepositoryFactory.GetAdminRepository().(reset.Store)
+	if !ok {
+		return nil, reset.ErrResetUnsupported
+	}
+	return store, nil
+}
+
+// SetResetNotifier sets how reset tokens are delivered. It is meant to be called before the service handles
+// requests, typically by the API with a notifier that sends email.
+func (b *BasicIAMService) SetResetNotifier(notifier reset.Notifier) {
+	b.notifier = notifier
+}
+
+// RequestPasswordReset sends the user a token that can be used once to set a new password. A new request
+// replaces the earlier token of the user. Unknown and inactive users are ignored so the result does not reveal
+// which users exist.
+func (b *BasicIAMService) RequestPasswordReset(ctx context.Context, userId string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RequestPasswordReset"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if b.notifier == nil {
+		return reset.ErrNoNotifier
+	}
+
+	store, err := resetStore()
+	if err != nil {
+		return err
+	}
+
+	user, err := b.GetUser(ctx, userId)
+	if errors.Is(err, errs.ErrDBItemNotFound) {
+		logger.Infof(ctx, "Ignored password reset of unknown user %s", userId)
+		return nil
+	} else if err != nil {
+		return err
+	} else if !user.Active {
+		logger.Infof(ctx, "Ignored password reset of inactive user %s", userId)
+		return nil
+	} else if serviceaccount.IsServiceAccount(userId) {
+		logger.Infof(ctx, "Ignored password reset of service account %s", userId)
+		return nil
+	}
+
+	t, token, err := reset.NewToken(userId, time.Now(), b.resetLifetime)
+	if err != nil {
+		return err
+	}
+
+	if err = store.CreateResetToken(ctx, t); err != nil {
+		return err
+	}
+
+	if err = b.notifier.SendPasswordReset(ctx, user, token, t.Expires); err != nil {
+		logger.Errorf(ctx, "Unable to send password reset to %s - %s", userId, err)
+		return err
+	}
+
+	logger.Infof(ctx, "Sent password reset to %s", userId)
+	return nil
+}
+
+// CompletePasswordReset sets the password of the token's user and revokes the user's tokens. The password is
+// checked against the policy before the token is used, so a rejected password does not spend the token.
+func (b *BasicIAMService) CompletePasswordReset(ctx context.Context, token, password string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CompletePasswordReset"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := resetStore()
+	if err != nil {
+		return err
+	}
+
+	hash := reset.HashToken(token)
+	t, err := store.GetResetToken(ctx, hash)
+	if errors.Is(err, errs.ErrDBItemNotFound) {
+		return reset.ErrInvalidResetToken
+	} else if err != nil {
+		return err
+	}
+
+	if t.Expired(time.Now()) {
+		_ = store.ConsumeResetToken(ctx, hash)
+		return reset.ErrResetTokenExpired
+	}
+
+	if err = b.validatePassword(ctx, t.UserId, password); err != nil {
+		return err
+	}
+
+	if err = store.ConsumeResetToken(ctx, hash); errors.Is(err, errs.ErrDBItemNotFound) {
+		return reset.ErrInvalidResetToken
+	} else if err != nil {
+		return err
+	}
+
+	if err = b.SetPassword(ctx, t.UserId, password); err != nil {
+		return err
+	}
+
+	logger.Infof(ctx, "Reset password of %s", t.UserId)
+	return nil
+}
+
+func (b *BasicIAMService) PurgeExpiredResetTokens(ctx context.Context) (int64, error) {
+
+	store, err := resetStore()
+	if err != nil {
+		return 0, err
+	}
+
+	return store.PurgeExpiredResetTokens(ctx, time.Now())
+}
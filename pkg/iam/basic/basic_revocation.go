@@ -0,0 +1,119 @@
+package basic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/internal/helper"
+	"github.com/zbitech/repo/pkg/iam/jwtsvr"
+	"github.com/zbitech/repo/pkg/revocation"
+	"github.com/zbitech/repo/pkg/session"
+)
+
+// revocationStore returns the admin repository if it stores revoked tokens
+func revocationStore() (revocation.Store, error) {
+	store, ok := vars.RepositoryFactory.GetAdminRepository().(revocation.Store)
+	if !ok {
+		return nil, revocation.ErrRevocationUnsupported
+	}
+	return store, nil
+}
+
+// checkRevocation rejects tokens of inactive users and tokens revoked by id or by the user's watermark. Tokens
+// are only checked against the store when the repository keeps revocations and the server can identify tokens.
+func (b *BasicIAMService) checkRevocation(ctx context.Context, claims jwt.Claims, user *entity.User) error {
+
+	if !user.Active {
+		return revocation.ErrUserInactive
+	}
+
+	identifier, ok := b.jwtServer.(jwtsvr.TokenIdentifier)
+	if !ok {
+		return nil
+	}
+
+	store, err := revocationStore()
+	if err != nil {
+		return nil
+	}
+
+	return revocation.Check(ctx, store, user.UserId, identifier.GetTokenId(claims), identifier.GetIssuedAt(claims))
+}
+
+// RevokeToken rejects the token until it expires, as when a user logs out
+func (b *BasicIAMService) RevokeToken(ctx context.Context, claims jwt.Claims) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RevokeToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	identifier, ok := b.jwtServer.(jwtsvr.TokenIdentifier)
+	if !ok || len(identifier.GetTokenId(claims)) == 0 {
+		return revocation.ErrTokenNotRevocable
+	}
+
+	store, err := revocationStore()
+	if err != nil {
+		return err
+	}
+
+	token := &revocation.RevokedToken{Id: identifier.GetTokenId(claims), UserId: b.jwtServer.GetUserId(claims),
+		Revoked: time.Now(), Expires: identifier.GetExpiresAt(claims)}
+	if err = store.RevokeToken(ctx, token); err != nil {
+		return err
+	}
+
+	logger.Infof(ctx, "Revoked token %s of %s", token.Id, token.UserId)
+	return nil
+}
+
+// RevokeUserTokens rejects every access token issued to the user so far and ends the user's sessions. The
+// watermark is kept for the access token lifetime, after which the tokens it covers have expired.
+func (b *BasicIAMService) RevokeUserTokens(ctx context.Context, userId string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RevokeUserTokens"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := revocationStore()
+	if err != nil {
+		return err
+	}
+
+	accessLifetime, _ := helper.GetTokenLifetimes()
+	if err = store.SetWatermark(ctx, revocation.NewWatermark(userId, time.Now(), accessLifetime)); err != nil {
+		return err
+	}
+
+	if _, err = b.RevokeSessions(ctx, userId); err != nil && !errors.Is(err, session.ErrSessionsUnsupported) {
+		return err
+	}
+
+	logger.Infof(ctx, "Revoked tokens and sessions of %s", userId)
+	return nil
+}
+
+func (b *BasicIAMService) PurgeExpiredRevocations(ctx context.Context) (int64, error) {
+
+	store, err := revocationStore()
+	if err != nil {
+		return 0, err
+	}
+
+	return store.PurgeExpiredRevocations(ctx, time.Now())
+}
+
+// revokeUserTokens revokes the user's tokens after a change to the account. Repositories without revocations
+// are tolerated since inactive users are still rejected.
+func (b *BasicIAMService) revokeUserTokens(ctx context.Context, userId string) error {
+	err := b.RevokeUserTokens(ctx, userId)
+	if errors.Is(err, revocation.ErrRevocationUnsupported) {
+		logger.Infof(ctx, "Tokens of %s were not revoked - %s", userId, err)
+		return nil
+	}
+	return err
+}
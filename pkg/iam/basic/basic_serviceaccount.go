@@ -0,0 +1,129 @@
+package basic
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+)
+
+// serviceAccountStore returns the admin repository if it stores service accounts
+func serviceAccountStore() (serviceaccount.Store, error) {
+	store, ok := vars.RepositoryFactory.GetAdminRepository().(serviceaccount.Store)
+	if !ok {
+		return nil, serviceaccount.ErrServiceAccountsUnsupported
+	}
+	return store, nil
+}
+
+// CreateServiceAccount creates an account called name for the team. Its keys are created with the API key methods
+// using the id of the account as the user id.
+func (b *BasicIAMService) CreateServiceAccount(ctx context.Context, teamId, name, description string) (*serviceaccount.ServiceAccount, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := serviceAccountStore()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = b.GetTeam(ctx, teamId); err != nil {
+		return nil, err
+	}
+
+	var createdBy string
+	if currUser := rctx.GetCurrentUser(ctx); currUser != nil {
+		createdBy = currUser.UserId
+	}
+
+	sa, user, err := serviceaccount.New(teamId, name, description, createdBy, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = store.CreateServiceAccount(ctx, sa, user); err != nil {
+		return nil, err
+	}
+
+	logger.Infof(ctx, "Created service account %s for team %s", sa.Id, teamId)
+	return sa, nil
+}
+
+func (b *BasicIAMService) GetServiceAccount(ctx context.Context, id string) (*serviceaccount.ServiceAccount, error) {
+
+	store, err := serviceAccountStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetServiceAccount(ctx, id)
+}
+
+func (b *BasicIAMService) GetServiceAccounts(ctx context.Context, teamId string) ([]serviceaccount.ServiceAccount, error) {
+
+	store, err := serviceAccountStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetServiceAccounts(ctx, teamId)
+}
+
+// DeleteServiceAccount deletes the keys of the account before the account, so no key outlives it
+func (b *BasicIAMService) DeleteServiceAccount(ctx context.Context, id string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := serviceAccountStore()
+	if err != nil {
+		return err
+	}
+
+	if _, err = store.GetServiceAccount(ctx, id); err != nil {
+		return err
+	}
+
+	keys, err := b.GetAPIKeys(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err = b.DeleteAPIKey(ctx, key); err != nil {
+			logger.Errorf(ctx, "Unable to delete api key %s of service account %s - %s", key, id, err)
+			return err
+		}
+	}
+
+	if err = store.DeleteServiceAccount(ctx, id); err != nil {
+		return err
+	}
+
+	logger.Infof(ctx, "Deleted service account %s with %d api keys", id, len(keys))
+	return nil
+}
+
+// GetServiceAccountSummary counts the service accounts of the team and their keys
+func (b *BasicIAMService) GetServiceAccountSummary(ctx context.Context, teamId string) (*serviceaccount.Summary, error) {
+
+	accounts, err := b.GetServiceAccounts(ctx, teamId)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &serviceaccount.Summary{TeamId: teamId, TotalServiceAccounts: len(accounts)}
+	for _, sa := range accounts {
+		keys, err := b.GetAPIKeys(ctx, sa.Id)
+		if err != nil {
+			return nil, err
+		}
+		summary.TotalAPIKeys += len(keys)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,145 @@
+package basic
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/internal/helper"
+	"github.com/zbitech/repo/pkg/session"
+)
+
+// sessionStore returns the admin repository if it stores sessions
+func sessionStore() (session.Store, error) {
+	store, ok := vars.RepositoryFactory.GetAdminRepository().(session.Store)
+	if !ok {
+		return nil, session.ErrSessionsUnsupported
+	}
+	return store, nil
+}
+
+// Login authenticates the user and starts a session on device. It returns a short-lived access token and the
+// refresh token that renews it. Users with a second factor get an mfa.ChallengeError and complete the login with
+// LoginMFA.
+func (b *BasicIAMService) Login(ctx context.Context, userId, password, device string) (*session.Tokens, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "Login"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := sessionStore()
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := b.AuthenticateUser(ctx, userId, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return startSession(ctx, store, userId, device, *accessToken)
+}
+
+// startSession starts a session for a user who authenticated and returns it with the user's access token
+func startSession(ctx context.Context, store session.Store, userId, device, accessToken string) (*session.Tokens, error) {
+
+	accessLifetime, refreshLifetime := helper.GetTokenLifetimes()
+	s, refreshToken, err := session.Start(ctx, store, userId, device, refreshLifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session.Tokens{AccessToken: accessToken, AccessExpires: time.Now().Add(accessLifetime),
+		RefreshToken: refreshToken, RefreshExpires: s.Expires, SessionId: s.Id}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token. The user must still be active.
+func (b *BasicIAMService) Refresh(ctx context.Context, refreshToken string) (*session.Tokens, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "Refresh"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	store, err := sessionStore()
+	if err != nil {
+		return nil, err
+	}
+
+	accessLifetime, refreshLifetime := helper.GetTokenLifetimes()
+	s, nextToken, err := session.Refresh(ctx, store, refreshToken, refreshLifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := b.GetUser(ctx, s.UserId)
+	if err != nil || !user.Active {
+		logger.Errorf(ctx, "Unable to refresh session %s - user %s is not active", s.Id, s.UserId)
+		_ = store.DeleteSession(ctx, s.Id)
+		return nil, errs.ErrAuthFailed
+	}
+
+	accessToken, err := helper.GenerateJwtToken(*user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session.Tokens{AccessToken: *accessToken, AccessExpires: time.Now().Add(accessLifetime),
+		RefreshToken: nextToken, RefreshExpires: s.Expires, SessionId: s.Id}, nil
+}
+
+// GetSessions returns the active sessions of the user
+func (b *BasicIAMService) GetSessions(ctx context.Context, userId string) ([]session.Session, error) {
+
+	store, err := sessionStore()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := store.GetSessions(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := make([]session.Session, 0, len(sessions))
+	for _, s := range sessions {
+		if !s.Expired(now) {
+			active = append(active, s)
+		}
+	}
+
+	return active, nil
+}
+
+// RevokeSession ends a session of the user. Its refresh token can no longer be used; access tokens already issued
+// stay valid until they expire.
+func (b *BasicIAMService) RevokeSession(ctx context.Context, userId, sessionId string) error {
+
+	store, err := sessionStore()
+	if err != nil {
+		return err
+	}
+
+	s, err := store.GetSession(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	if s.UserId != userId {
+		return errs.ErrDBItemNotFound
+	}
+
+	return store.DeleteSession(ctx, sessionId)
+}
+
+// RevokeSessions ends every session of the user and returns how many were ended
+func (b *BasicIAMService) RevokeSessions(ctx context.Context, userId string) (int64, error) {
+
+	store, err := sessionStore()
+	if err != nil {
+		return 0, err
+	}
+
+	return store.DeleteSessions(ctx, userId)
+}
@@ -2,34 +2,287 @@ package iam
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zbitech/repo/internal/helper"
+	"github.com/zbitech/repo/pkg/access"
+	"github.com/zbitech/repo/pkg/apikeys"
 	"github.com/zbitech/repo/pkg/iam/auth"
 	"github.com/zbitech/repo/pkg/iam/basic"
 	"github.com/zbitech/repo/pkg/iam/jwtsvr"
+	"github.com/zbitech/repo/pkg/iam/oidc"
+	"github.com/zbitech/repo/pkg/lockout"
+	"github.com/zbitech/repo/pkg/mfa"
+	"github.com/zbitech/repo/pkg/password"
+	"github.com/zbitech/repo/pkg/reset"
 
 	"github.com/zbitech/common/interfaces"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
+)
+
+const (
+	IAM_PROVIDER_BASIC = "basic"
+	IAM_PROVIDER_OIDC  = "oidc"
+	IAM_PROVIDER_LDAP  = "ldap"
+
+	JWT_SERVER_ZBI  = "zbi"
+	JWT_SERVER_OIDC = "oidc"
+)
+
+var (
+	ErrUnknownIAMProvider     = errors.New("unknown iam provider")
+	ErrUnsupportedIAMProvider = errors.New("iam provider is not available")
+	ErrUnknownJwtServer       = errors.New("unknown jwt server")
+	ErrIncompatibleJwtServer  = errors.New("jwt server cannot be used with the iam provider")
 )
 
+type IAMProviderConfig struct {
+	Provider string
+}
+
+type JwtServerConfig struct {
+	Server string
+}
+
+// AuthConfig selects the IAM service and the JWT server. It is read from iam.yaml in the asset directory and
+// defaults to the basic service with ZBI tokens:
+//
+//	iam:
+//	  provider: basic   # basic, oidc or ldap
+//	jwt:
+//	  server: zbi       # zbi or oidc
+//	lockout:
+//	  threshold: 5      # failed logins before a user is locked
+//	  duration: 15m
+//	password:
+//	  minlength: 8
+//	  maxage: 2160h     # unset never expires passwords
+//	reset:
+//	  lifetime: 1h
+//	  notifier: log     # log or file, for local use
+//	mfa:
+//	  keyfile: /etc/zbi/mfa.key   # 32 bytes in base64, needed to enroll users
+//	  requiredroles: [owner]
+//	apikeys:
+//	  defaultlifetime: 720h
+//	  maxlifetime: 8760h   # unset lets callers choose any expiry
+//	  readcategories: [read]
+//	  sweepinterval: 1h    # 0 turns off disabling expired keys
+//	  rotationgrace: 24h   # how long a rotated key keeps working
+//	  usageflush: 10s      # 0 writes usage on each request
+//	access:
+//	  file: /etc/zbi/access.yaml   # policy document, unset uses the default policy
+type AuthConfig struct {
+	Iam      IAMProviderConfig
+	Jwt      JwtServerConfig
+	Lockout  lockout.Config
+	Password password.Config
+	Reset    reset.Config
+	Mfa      mfa.Config
+	Apikeys  apikeys.Config
+	Access   access.Config
+}
+
+func ReadAuthConfig(ctx context.Context) (AuthConfig, error) {
+
+	cfg := AuthConfig{Iam: IAMProviderConfig{Provider: IAM_PROVIDER_BASIC}, Jwt: JwtServerConfig{Server: JWT_SERVER_ZBI}}
+
+	configPath := fmt.Sprintf("%s/iam.yaml", vars.ASSET_PATH_DIRECTORY)
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return cfg, nil
+	}
+
+	if err := utils.ReadConfig(configPath, nil, &cfg); err != nil {
+		logger.Errorf(ctx, "Unable to read iam config: %s", err)
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the provider and server are known and can be used together. The oidc provider keeps no
+// passwords, so it needs tokens from the oidc server; the basic provider accepts either.
+func (c AuthConfig) Validate() error {
+
+	switch c.Jwt.Server {
+	case JWT_SERVER_ZBI, JWT_SERVER_OIDC:
+	default:
+		return fmt.Errorf("%w %q - expected %s or %s", ErrUnknownJwtServer, c.Jwt.Server, JWT_SERVER_ZBI, JWT_SERVER_OIDC)
+	}
+
+	switch c.Iam.Provider {
+	case IAM_PROVIDER_BASIC:
+	case IAM_PROVIDER_OIDC:
+		if c.Jwt.Server != JWT_SERVER_OIDC {
+			return fmt.Errorf("%w - %s tokens with the %s provider", ErrIncompatibleJwtServer, c.Jwt.Server, c.Iam.Provider)
+		}
+	case IAM_PROVIDER_LDAP:
+		return fmt.Errorf("%w - %s", ErrUnsupportedIAMProvider, c.Iam.Provider)
+	default:
+		return fmt.Errorf("%w %q - expected %s, %s or %s", ErrUnknownIAMProvider, c.Iam.Provider, IAM_PROVIDER_BASIC, IAM_PROVIDER_OIDC, IAM_PROVIDER_LDAP)
+	}
+
+	if _, err := c.Lockout.Policy(); err != nil {
+		return err
+	}
+
+	if _, err := c.Password.Policy(); err != nil {
+		return err
+	}
+
+	if _, err := c.Reset.TokenLifetime(); err != nil {
+		return err
+	}
+
+	if _, err := c.Reset.NewNotifier(); err != nil {
+		return err
+	}
+
+	if _, err := c.Mfa.Policy(); err != nil {
+		return err
+	}
+
+	if _, err := c.Apikeys.Policy(); err != nil {
+		return err
+	}
+
+	if _, err := c.Access.Engine(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 type BasicAuthorizationFactory struct {
 	jwtServer        interfaces.JwtServerIF
 	accessAuthorizer interfaces.AccessAuthorizerIF
 	iamService       interfaces.IAMServiceIF
+	stopSweep        context.CancelFunc
+	stopUsageFlush   func()
 }
 
 func NewBasicAuthorizationFactory() interfaces.AuthorizationFactoryIF {
 	return &BasicAuthorizationFactory{}
 }
 
+// Init creates the JWT server and IAM service selected by iam.yaml
 func (j *BasicAuthorizationFactory) Init(ctx context.Context) error {
-	//TODO - introduce logic to determine which JWT Server to use
-	j.jwtServer = jwtsvr.NewZBIJwtServer()
 
-	//TODO - introduce logic to determine which IAM service to use
-	j.iamService = basic.NewBasicIAMService(j.jwtServer)
-	j.accessAuthorizer = auth.NewAccessAuthorizer(j.iamService)
+	cfg, err := ReadAuthConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	return j.InitWithConfig(ctx, cfg)
+}
+
+func (j *BasicAuthorizationFactory) InitWithConfig(ctx context.Context, cfg AuthConfig) error {
+
+	if err := cfg.Validate(); err != nil {
+		logger.Errorf(ctx, "Invalid iam config: %s", err)
+		return err
+	}
+
+	logger.Infof(ctx, "Creating %s iam service with %s jwt server", cfg.Iam.Provider, cfg.Jwt.Server)
+	j.Close()
+
+	switch cfg.Jwt.Server {
+	case JWT_SERVER_OIDC:
+		oidcConfig, err := jwtsvr.ReadOIDCConfig(ctx)
+		if err != nil {
+			return err
+		}
+
+		if j.jwtServer, err = jwtsvr.NewKeyCloakJwt(ctx, oidcConfig); err != nil {
+			return err
+		}
+	default:
+		zbiConfig, err := jwtsvr.ReadZBIJwtConfig(ctx)
+		if err != nil {
+			return err
+		}
+
+		access, refresh, err := zbiConfig.Lifetimes()
+		if err != nil {
+			logger.Errorf(ctx, "Invalid jwt token lifetime - %s", err)
+			return err
+		}
+
+		keys, err := jwtsvr.NewKeyRingFromConfig(ctx, zbiConfig)
+		if err != nil {
+			return err
+		}
+		j.jwtServer = jwtsvr.NewZBIJwtServer(keys)
+		helper.SetTokenLifetimes(access, refresh)
+	}
+
+	switch cfg.Iam.Provider {
+	case IAM_PROVIDER_OIDC:
+		j.iamService = oidc.NewOIDCIAMService(j.jwtServer)
+	default:
+		lockoutPolicy, err := cfg.Lockout.Policy()
+		if err != nil {
+			return err
+		}
+		passwordPolicy, err := cfg.Password.Policy()
+		if err != nil {
+			return err
+		}
+		resetLifetime, err := cfg.Reset.TokenLifetime()
+		if err != nil {
+			return err
+		}
+		notifier, err := cfg.Reset.NewNotifier()
+		if err != nil {
+			return err
+		}
+		mfaPolicy, err := cfg.Mfa.Policy()
+		if err != nil {
+			return err
+		}
+		apikeyPolicy, err := cfg.Apikeys.Policy()
+		if err != nil {
+			return err
+		}
+		iamService := basic.NewBasicIAMServiceWithOptions(j.jwtServer, basic.Options{Lockout: lockoutPolicy, Password: passwordPolicy,
+			ResetLifetime: resetLifetime, Notifier: notifier, MFA: mfaPolicy, APIKeys: apikeyPolicy})
+		if apikeyPolicy.SweepInterval > 0 {
+			var sweepCtx context.Context
+			sweepCtx, j.stopSweep = context.WithCancel(context.Background())
+			iamService.StartAPIKeySweep(sweepCtx, apikeyPolicy.SweepInterval)
+		}
+		if apikeyPolicy.UsageFlush > 0 {
+			j.stopUsageFlush = iamService.StartAPIKeyUsageFlush(apikeyPolicy.UsageFlush)
+		}
+		j.iamService = iamService
+	}
+
+	engine, err := cfg.Access.Engine()
+	if err != nil {
+		return err
+	}
+	j.accessAuthorizer = auth.NewAccessAuthorizerWithPolicy(j.iamService, engine)
 
 	return nil
 }
 
+// Close stops the api key sweep started by Init and writes the buffered api key usage. Initializing the factory
+// again also stops the earlier sweep and flush.
+func (j *BasicAuthorizationFactory) Close() {
+	if j.stopSweep != nil {
+		j.stopSweep()
+		j.stopSweep = nil
+	}
+	if j.stopUsageFlush != nil {
+		j.stopUsageFlush()
+		j.stopUsageFlush = nil
+	}
+}
+
 func (j *BasicAuthorizationFactory) GetJwtServer() interfaces.JwtServerIF {
 	return j.jwtServer
 }
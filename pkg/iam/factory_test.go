@@ -0,0 +1,112 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zbitech/repo/pkg/access"
+	"github.com/zbitech/repo/pkg/iam/basic"
+	"github.com/zbitech/repo/pkg/iam/jwtsvr"
+)
+
+func Test_AuthConfigValidate(t *testing.T) {
+	tests := []struct {
+		provider string
+		server   string
+		err      error
+	}{
+		{IAM_PROVIDER_BASIC, JWT_SERVER_ZBI, nil},
+		{IAM_PROVIDER_BASIC, JWT_SERVER_OIDC, nil},
+		{IAM_PROVIDER_OIDC, JWT_SERVER_OIDC, nil},
+		{IAM_PROVIDER_OIDC, JWT_SERVER_ZBI, ErrIncompatibleJwtServer},
+		{IAM_PROVIDER_LDAP, JWT_SERVER_ZBI, ErrUnsupportedIAMProvider},
+		{"saml", JWT_SERVER_ZBI, ErrUnknownIAMProvider},
+		{"", JWT_SERVER_ZBI, ErrUnknownIAMProvider},
+		{IAM_PROVIDER_BASIC, "hmac", ErrUnknownJwtServer},
+	}
+
+	for _, test := range tests {
+		cfg := AuthConfig{Iam: IAMProviderConfig{Provider: test.provider}, Jwt: JwtServerConfig{Server: test.server}}
+		if err := cfg.Validate(); !errors.Is(err, test.err) {
+			t.Fatalf("Expected %v for %s/%s but got %v", test.err, test.provider, test.server, err)
+		}
+	}
+}
+
+func Test_InitWithConfig(t *testing.T) {
+	ctx := context.Background()
+
+	factory := &BasicAuthorizationFactory{}
+	err := factory.InitWithConfig(ctx, AuthConfig{Iam: IAMProviderConfig{Provider: IAM_PROVIDER_BASIC}, Jwt: JwtServerConfig{Server: JWT_SERVER_ZBI}})
+	if err != nil {
+		t.Fatalf("Expected factory to be initialized but got err - %s", err)
+	}
+	if _, ok := factory.GetJwtServer().(*jwtsvr.ZBIJwtServer); !ok {
+		t.Fatalf("Expected zbi jwt server but got %T", factory.GetJwtServer())
+	}
+	if _, ok := factory.GetIAMService().(*basic.BasicIAMService); !ok {
+		t.Fatalf("Expected basic iam service but got %T", factory.GetIAMService())
+	}
+	if factory.GetAccessAuthorizer() == nil {
+		t.Fatalf("Expected access authorizer")
+	}
+
+	factory = &BasicAuthorizationFactory{}
+	err = factory.InitWithConfig(ctx, AuthConfig{Iam: IAMProviderConfig{Provider: IAM_PROVIDER_LDAP}, Jwt: JwtServerConfig{Server: JWT_SERVER_ZBI}})
+	if !errors.Is(err, ErrUnsupportedIAMProvider) {
+		t.Fatalf("Expected unsupported provider error but got %v", err)
+	}
+	if factory.GetJwtServer() != nil || factory.GetIAMService() != nil {
+		t.Fatalf("Expected no jwt server or iam service after a failed initialization")
+	}
+}
+
+func Test_InitWithConfigSweep(t *testing.T) {
+	ctx := context.Background()
+	cfg := AuthConfig{Iam: IAMProviderConfig{Provider: IAM_PROVIDER_BASIC}, Jwt: JwtServerConfig{Server: JWT_SERVER_ZBI}}
+
+	factory := &BasicAuthorizationFactory{}
+	if err := factory.InitWithConfig(ctx, cfg); err != nil {
+		t.Fatalf("Expected factory to be initialized but got err - %s", err)
+	}
+	if factory.stopSweep == nil || factory.stopUsageFlush == nil {
+		t.Fatalf("Expected the api key sweep and usage flush to be started")
+	}
+
+	if err := factory.InitWithConfig(ctx, cfg); err != nil {
+		t.Fatalf("Expected factory to be initialized again but got err - %s", err)
+	}
+	if factory.stopSweep == nil {
+		t.Fatalf("Expected the api key sweep to be started again")
+	}
+
+	cfg.Apikeys.SweepInterval = "0"
+	cfg.Apikeys.UsageFlush = "0"
+	if err := factory.InitWithConfig(ctx, cfg); err != nil {
+		t.Fatalf("Expected factory to be initialized without a sweep but got err - %s", err)
+	}
+	if factory.stopSweep != nil || factory.stopUsageFlush != nil {
+		t.Fatalf("Expected initializing without a sweep and flush to stop the earlier ones")
+	}
+
+	if err := factory.InitWithConfig(ctx, AuthConfig{Iam: IAMProviderConfig{Provider: IAM_PROVIDER_BASIC}, Jwt: JwtServerConfig{Server: JWT_SERVER_ZBI}}); err != nil {
+		t.Fatalf("Expected factory to be initialized but got err - %s", err)
+	}
+	factory.Close()
+	if factory.stopSweep != nil || factory.stopUsageFlush != nil {
+		t.Fatalf("Expected close to stop the api key sweep and usage flush")
+	}
+}
+
+func Test_AuthConfigAccessPolicy(t *testing.T) {
+	cfg := AuthConfig{Iam: IAMProviderConfig{Provider: IAM_PROVIDER_BASIC}, Jwt: JwtServerConfig{Server: JWT_SERVER_ZBI}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected config to be valid but got err - %s", err)
+	}
+
+	cfg.Access.File = t.TempDir() + "/missing.yaml"
+	if err := cfg.Validate(); !errors.Is(err, access.ErrInvalidPolicy) {
+		t.Fatalf("Expected invalid policy error for a missing policy file but got %v", err)
+	}
+}
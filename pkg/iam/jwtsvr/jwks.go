@@ -0,0 +1,285 @@
+package jwtsvr
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/ztypes"
+)
+
+var (
+	ErrUnknownKey     = errors.New("no key matches the token key id")
+	ErrUnsupportedKey = errors.New("unsupported json web key")
+
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// KeyResolver is implemented by JWT servers that choose the verification key from the token, usually by its kid
+// header, rather than using a single key. ctx is the context of the request being authenticated.
+type KeyResolver interface {
+	GetTokenKey(ctx context.Context, token *jwt.Token) (interface{}, error)
+}
+
+// TokenIdentifier is implemented by JWT servers that can read the id (jti), issue time and expiry of their tokens,
+// which are needed to revoke them
+type TokenIdentifier interface {
+	GetTokenId(claim jwt.Claims) string
+	GetIssuedAt(claim jwt.Claims) time.Time
+	GetExpiresAt(claim jwt.Claims) time.Time
+}
+
+// IdentityMapper is implemented by JWT servers whose tokens carry the role and teams of the user, such as the
+// access tokens of an OpenID Connect provider. The token is then authoritative over the stored user.
+type IdentityMapper interface {
+	GetRole(claim jwt.Claims) ztypes.Role
+	GetTeams(claim jwt.Claims) []string
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func decodeSegment(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(value)
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := decodeSegment(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// PublicKey returns the RSA, ECDSA or Ed25519 public key described by the JWK
+func (k JWK) PublicKey() (interface{}, error) {
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("%w - modulus of %s: %s", ErrUnsupportedKey, k.Kid, err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("%w - exponent of %s", ErrUnsupportedKey, k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w - curve %s of %s", ErrUnsupportedKey, k.Crv, k.Kid)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("%w - x of %s: %s", ErrUnsupportedKey, k.Kid, err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("%w - y of %s: %s", ErrUnsupportedKey, k.Kid, err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("%w - point of %s is not on %s", ErrUnsupportedKey, k.Kid, k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w - curve %s of %s", ErrUnsupportedKey, k.Crv, k.Kid)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w - x of %s", ErrUnsupportedKey, k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("%w - key type %s of %s", ErrUnsupportedKey, k.Kty, k.Kid)
+}
+
+// readJSON decodes the document at location, which is an http(s) url or a local file
+func readJSON(ctx context.Context, location string, item interface{}) error {
+
+	var data []byte
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return err
+		}
+
+		response, err := httpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("unable to read %s - %s", location, response.Status)
+		}
+
+		if data, err = io.ReadAll(response.Body); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(location); err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, item)
+}
+
+// keySet caches the keys of a JWKS by kid. The set is reloaded when it is older than refresh, or when a token
+// names an unknown kid, which is how a key rotated in by the issuer is picked up. A reload is attempted at most once
+// every minRefresh, whether it succeeds or not, and requests that need a reload while one is running wait for it,
+// so tokens with unknown kids cannot make every request fetch the key set while the issuer is down.
+type keySet struct {
+	location   string
+	refresh    time.Duration
+	minRefresh time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	algs      map[string]string
+	fetched   time.Time
+	attempted time.Time
+	loading   chan struct{}
+	err       error
+}
+
+func newKeySet(location string, refresh time.Duration) *keySet {
+	return &keySet{location: location, refresh: refresh, minRefresh: time.Minute, keys: make(map[string]interface{})}
+}
+
+func (s *keySet) read(ctx context.Context) (map[string]interface{}, map[string]string, error) {
+
+	var jwks JWKS
+	if err := readJSON(ctx, s.location, &jwks); err != nil {
+		logger.Errorf(ctx, "Unable to read key set %s - %s", s.location, err)
+		return nil, nil, err
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	algs := make(map[string]string, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.PublicKey()
+		if err != nil {
+			logger.Errorf(ctx, "Skipping key %s - %s", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+		algs[jwk.Kid] = jwk.Alg
+	}
+
+	logger.Infof(ctx, "Loaded %d keys from %s", len(keys), s.location)
+	return keys, algs, nil
+}
+
+// load reads the key set, or waits for the load already in progress. A load abandoned because ctx is done is not
+// counted as an attempt, so one cancelled request does not hold back the next reload.
+func (s *keySet) load(ctx context.Context) error {
+
+	s.mu.Lock()
+	if done := s.loading; done != nil {
+		s.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.err
+	}
+
+	done := make(chan struct{})
+	attempted := s.attempted
+	s.loading, s.attempted = done, time.Now()
+	s.mu.Unlock()
+
+	keys, algs, err := s.read(ctx)
+
+	s.mu.Lock()
+	if err == nil {
+		s.keys, s.algs, s.fetched = keys, algs, time.Now()
+	} else if ctx.Err() != nil {
+		s.attempted = attempted
+	}
+	s.loading, s.err = nil, err
+	s.mu.Unlock()
+	close(done)
+
+	return err
+}
+
+// lookup returns the key named kid and whether the key set is due to be reloaded before it is used
+func (s *keySet) lookup(kid string) (interface{}, string, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[kid]
+	stale := !ok || (s.refresh > 0 && time.Since(s.fetched) > s.refresh)
+	return key, s.algs[kid], ok, stale && time.Since(s.attempted) > s.minRefresh
+}
+
+// Key returns the key named kid and the algorithm the key set declares for it. A failed reload keeps the keys that
+// were loaded before it.
+func (s *keySet) Key(ctx context.Context, kid string) (interface{}, string, error) {
+
+	key, alg, ok, reload := s.lookup(kid)
+	if reload {
+		if err := s.load(ctx); err != nil && !ok {
+			return nil, "", err
+		}
+		key, alg, ok, _ = s.lookup(kid)
+	}
+
+	if !ok {
+		return nil, "", fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+
+	return key, alg, nil
+}
@@ -1,39 +1,294 @@
 package jwtsvr
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/golang-jwt/jwt"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
 )
 
-type KeyCloakJwt struct {
-	publicKey string
+var (
+	ErrInvalidIssuer         = errors.New("token issuer is not trusted")
+	ErrInvalidAudience       = errors.New("token audience does not include the client")
+	ErrInvalidSigningMethod  = errors.New("token signing method is not allowed")
+	ErrIssuerMetadataInvalid = errors.New("issuer metadata does not match the configured issuer")
+)
+
+// OIDCConfig configures token validation against an OpenID Connect provider such as Keycloak. It is read from
+// oidc.yaml in the asset directory. The keys are read from JWKSFile when it is set, which needs no network, then
+// from JWKSUrl, and otherwise from the jwks_uri published in the issuer metadata.
+type OIDCConfig struct {
+	Issuer          string
+	Audience        string
+	JWKSUrl         string
+	JWKSFile        string
+	RefreshInterval time.Duration
+	UserClaim       string
+	Roles           map[string]ztypes.Role
+	TeamGroupPrefix string
+}
+
+func defaultOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		Issuer:          "http://localhost:8181/auth/realms/zbi-realm",
+		Audience:        "zbi",
+		RefreshInterval: time.Hour,
+		UserClaim:       "preferred_username",
+		Roles: map[string]ztypes.Role{
+			"zbi-admin": ztypes.ROLE_ADMIN,
+			"zbi-owner": ztypes.ROLE_OWNER,
+			"zbi-user":  ztypes.ROLE_USER,
+		},
+		TeamGroupPrefix: "/teams/",
+	}
+}
+
+func ReadOIDCConfig(ctx context.Context) (OIDCConfig, error) {
+
+	cfg := defaultOIDCConfig()
+
+	configPath := fmt.Sprintf("%s/oidc.yaml", vars.ASSET_PATH_DIRECTORY)
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return cfg, nil
+	}
+
+	if err := utils.ReadConfig(configPath, nil, &cfg); err != nil {
+		logger.Errorf(ctx, "Unable to read oidc config: %s", err)
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// oidcMetadata is the part of the issuer's openid-configuration document used to find its keys
+type oidcMetadata struct {
+	Issuer  string `json:"issuer"`
+	JWKSUri string `json:"jwks_uri"`
+}
+
+// Audience holds the aud claim, which is a single string or an array of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
 }
 
+type RealmAccess struct {
+	Roles []string `json:"roles"`
+}
+
+// KeyCloakClaims are the claims of a Keycloak access token. Groups needs the group membership mapper on the client.
 type KeyCloakClaims struct {
-	Roles []string
-	Orgs  []string
-	jwt.StandardClaims
+	Issuer            string      `json:"iss,omitempty"`
+	Subject           string      `json:"sub,omitempty"`
+	Audience          Audience    `json:"aud,omitempty"`
+	ExpiresAt         int64       `json:"exp,omitempty"`
+	IssuedAt          int64       `json:"iat,omitempty"`
+	NotBefore         int64       `json:"nbf,omitempty"`
+	Id                string      `json:"jti,omitempty"`
+	Email             string      `json:"email,omitempty"`
+	PreferredUsername string      `json:"preferred_username,omitempty"`
+	RealmAccess       RealmAccess `json:"realm_access,omitempty"`
+	Groups            []string    `json:"groups,omitempty"`
 }
 
-func NewKeyCloakJwt(publicKey string) KeyCloakJwt {
-	return KeyCloakJwt{
-		publicKey: publicKey,
+// Valid checks the time based claims. The expiry is required.
+func (c *KeyCloakClaims) Valid() error {
+	if c.ExpiresAt == 0 {
+		return jwt.NewValidationError("token has no expiry", jwt.ValidationErrorExpired)
 	}
+
+	standard := jwt.StandardClaims{ExpiresAt: c.ExpiresAt, IssuedAt: c.IssuedAt, NotBefore: c.NotBefore}
+	return standard.Valid()
 }
 
+// KeyCloakJwt validates access tokens issued by an OpenID Connect provider. Tokens must be signed with RSA or ECDSA
+// by a key of the provider's JWKS, be issued by the configured issuer for the configured audience, and not be
+// expired.
+type KeyCloakJwt struct {
+	cfg  OIDCConfig
+	keys *keySet
+}
+
+// NewKeyCloakJwt reads the issuer metadata when needed and loads the provider's keys
+func NewKeyCloakJwt(ctx context.Context, cfg OIDCConfig) (*KeyCloakJwt, error) {
+
+	if len(cfg.Issuer) == 0 {
+		return nil, fmt.Errorf("%w - no issuer configured", ErrInvalidIssuer)
+	}
+
+	location := cfg.JWKSFile
+	if len(location) == 0 {
+		location = cfg.JWKSUrl
+	}
+
+	if len(location) == 0 {
+		var metadata oidcMetadata
+		discovery := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := readJSON(ctx, discovery, &metadata); err != nil {
+			logger.Errorf(ctx, "Unable to read issuer metadata %s - %s", discovery, err)
+			return nil, err
+		}
+
+		if metadata.Issuer != cfg.Issuer || len(metadata.JWKSUri) == 0 {
+			return nil, fmt.Errorf("%w - %s publishes issuer %q", ErrIssuerMetadataInvalid, discovery, metadata.Issuer)
+		}
+		location = metadata.JWKSUri
+	}
+
+	keys := newKeySet(location, cfg.RefreshInterval)
+	if err := keys.load(ctx); err != nil {
+		return nil, err
+	}
+
+	return &KeyCloakJwt{cfg: cfg, keys: keys}, nil
+}
+
+// GetKey is not used because the key depends on the token. See GetTokenKey.
 func (s *KeyCloakJwt) GetKey() (interface{}, error) {
-	return jwt.ParseRSAPublicKeyFromPEM([]byte(s.publicKey))
+	return nil, ErrUnknownKey
+}
+
+// GetTokenKey returns the provider key named by the kid header of the token
+func (s *KeyCloakJwt) GetTokenKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+
+	kid, _ := token.Header["kid"].(string)
+	key, alg, err := s.keys.Key(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(alg) > 0 && alg != token.Method.Alg() {
+		return nil, fmt.Errorf("%w - key %s is for %s", ErrInvalidSigningMethod, kid, alg)
+	}
+
+	return key, nil
 }
 
 func (s *KeyCloakJwt) ValidateToken(token *jwt.Token) error {
 
-	_, ok := token.Method.(*jwt.SigningMethodHMAC)
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return fmt.Errorf("%w - %s", ErrInvalidSigningMethod, token.Method.Alg())
+	}
+
+	claims, ok := token.Claims.(*KeyCloakClaims)
 	if !ok {
 		return jwt.ErrInvalidKey
 	}
 
+	if claims.Issuer != s.cfg.Issuer {
+		return fmt.Errorf("%w - %s", ErrInvalidIssuer, claims.Issuer)
+	}
+
+	if len(s.cfg.Audience) > 0 && !claims.Audience.Contains(s.cfg.Audience) {
+		return fmt.Errorf("%w %s", ErrInvalidAudience, s.cfg.Audience)
+	}
+
 	return nil
 }
 
 func (s *KeyCloakJwt) GetPayload() jwt.Claims {
-	return KeyCloakClaims{}
+	return &KeyCloakClaims{}
+}
+
+// GetUserId returns the claim named by UserClaim, falling back to the subject
+func (s *KeyCloakJwt) GetUserId(claim jwt.Claims) string {
+	claims := claim.(*KeyCloakClaims)
+	if s.cfg.UserClaim == "preferred_username" && len(claims.PreferredUsername) > 0 {
+		return claims.PreferredUsername
+	}
+	if s.cfg.UserClaim == "email" && len(claims.Email) > 0 {
+		return claims.Email
+	}
+	return claims.Subject
+}
+
+func (s *KeyCloakJwt) GetTokenId(claim jwt.Claims) string {
+	claims := claim.(*KeyCloakClaims)
+	return claims.Id
+}
+
+func (s *KeyCloakJwt) GetIssuedAt(claim jwt.Claims) time.Time {
+	claims := claim.(*KeyCloakClaims)
+	return time.Unix(claims.IssuedAt, 0)
+}
+
+func (s *KeyCloakJwt) GetExpiresAt(claim jwt.Claims) time.Time {
+	claims := claim.(*KeyCloakClaims)
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+func (s *KeyCloakJwt) GetEmail(claim jwt.Claims) string {
+	claims := claim.(*KeyCloakClaims)
+	return claims.Email
+}
+
+// GetRole maps the realm roles of the token to the highest ZBI role: admin, then owner, then user
+func (s *KeyCloakJwt) GetRole(claim jwt.Claims) ztypes.Role {
+	claims := claim.(*KeyCloakClaims)
+
+	roles := make(map[ztypes.Role]bool)
+	for _, realmRole := range claims.RealmAccess.Roles {
+		if role, ok := s.cfg.Roles[realmRole]; ok {
+			roles[role] = true
+		}
+	}
+
+	for _, role := range []ztypes.Role{ztypes.ROLE_ADMIN, ztypes.ROLE_OWNER} {
+		if roles[role] {
+			return role
+		}
+	}
+
+	return ztypes.ROLE_USER
+}
+
+// GetTeams returns the ids of the teams the token's groups map to. A group /teams/<id> is membership of team <id>.
+func (s *KeyCloakJwt) GetTeams(claim jwt.Claims) []string {
+	claims := claim.(*KeyCloakClaims)
+
+	teams := make([]string, 0)
+	for _, group := range claims.Groups {
+		if !strings.HasPrefix(group, s.cfg.TeamGroupPrefix) {
+			continue
+		}
+
+		team := strings.TrimPrefix(group, s.cfg.TeamGroupPrefix)
+		if len(team) > 0 && !strings.Contains(team, "/") {
+			teams = append(teams, team)
+		}
+	}
+
+	return teams
 }
@@ -0,0 +1,321 @@
+package jwtsvr
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/zbitech/common/pkg/model/ztypes"
+)
+
+const testIssuer = "https://keycloak.test/auth/realms/zbi-realm"
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	jwks JWKS
+}
+
+func encodeInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Expected rsa key but got err - %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Expected ecdsa key but got err - %s", err)
+	}
+
+	return &testKeys{rsa: rsaKey, ec: ecKey, jwks: JWKS{Keys: []JWK{
+		{Kty: "RSA", Kid: "rsa-1", Use: "sig", Alg: "RS256", N: encodeInt(rsaKey.N), E: encodeInt(big.NewInt(int64(rsaKey.E)))},
+		{Kty: "EC", Kid: "ec-1", Use: "sig", Alg: "ES256", Crv: "P-256", X: encodeInt(ecKey.X), Y: encodeInt(ecKey.Y)},
+	}}}
+}
+
+func (k *testKeys) writeFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	k.write(t, path)
+	return path
+}
+
+func (k *testKeys) write(t *testing.T, path string) {
+	data, err := json.Marshal(k.jwks)
+	if err != nil {
+		t.Fatalf("Expected jwks but got err - %s", err)
+	}
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Expected jwks file but got err - %s", err)
+	}
+}
+
+func testClaims() *KeyCloakClaims {
+	now := time.Now()
+	return &KeyCloakClaims{
+		Issuer:            testIssuer,
+		Subject:           "8d3c3bd0-6a0f-4b33-9a4e-5d2c1f6b8d11",
+		Audience:          Audience{"account", "zbi"},
+		ExpiresAt:         now.Add(time.Minute).Unix(),
+		IssuedAt:          now.Unix(),
+		Email:             "owner@zbitech.local",
+		PreferredUsername: "owner",
+		RealmAccess:       RealmAccess{Roles: []string{"offline_access", "zbi-user", "zbi-owner"}},
+		Groups:            []string{"/teams/team-1", "/staff", "/teams/team-2/admins"},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Expected signed token but got err - %s", err)
+	}
+	return signed
+}
+
+func parse(server *KeyCloakJwt, tokenString string) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, server.GetPayload(), func(token *jwt.Token) (interface{}, error) {
+		if err := server.ValidateToken(token); err != nil {
+			return nil, err
+		}
+		return server.GetTokenKey(context.Background(), token)
+	})
+}
+
+// cause returns the error of the key function, which jwt v3 keeps in ValidationError.Inner without unwrapping it
+func cause(err error) error {
+	var validation *jwt.ValidationError
+	if errors.As(err, &validation) && validation.Inner != nil {
+		return validation.Inner
+	}
+	return err
+}
+
+func testServer(t *testing.T, keys *testKeys) *KeyCloakJwt {
+	cfg := defaultOIDCConfig()
+	cfg.Issuer = testIssuer
+	cfg.JWKSFile = keys.writeFile(t)
+
+	server, err := NewKeyCloakJwt(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Expected keycloak server but got err - %s", err)
+	}
+	return server
+}
+
+func Test_KeyCloakValidateToken(t *testing.T) {
+	keys := newTestKeys(t)
+	server := testServer(t, keys)
+
+	hmacClaims := testClaims()
+	wrongIssuer := testClaims()
+	wrongIssuer.Issuer = "https://other.test/auth/realms/zbi-realm"
+	wrongAudience := testClaims()
+	wrongAudience.Audience = Audience{"account"}
+	expired := testClaims()
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"RS256", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims()), nil},
+		{"ES256", sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, testClaims()), nil},
+		{"HS256", sign(t, jwt.SigningMethodHS256, "rsa-1", []byte("secret"), hmacClaims), ErrInvalidSigningMethod},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, wrongIssuer), ErrInvalidIssuer},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, wrongAudience), ErrInvalidAudience},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "rsa-2", keys.rsa, testClaims()), ErrUnknownKey},
+		{"alg mismatch", sign(t, jwt.SigningMethodRS512, "rsa-1", keys.rsa, testClaims()), ErrInvalidSigningMethod},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := parse(server, test.token)
+			if test.err == nil && (err != nil || !token.Valid) {
+				t.Fatalf("Expected token to be valid but got err - %v", err)
+			}
+			if test.err != nil && !errors.Is(cause(err), test.err) {
+				t.Fatalf("Expected %s but got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		_, err := parse(server, sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, expired))
+		var validation *jwt.ValidationError
+		if !errors.As(err, &validation) || validation.Errors&jwt.ValidationErrorExpired == 0 {
+			t.Fatalf("Expected expired token error but got %v", err)
+		}
+	})
+}
+
+func Test_KeyCloakRotation(t *testing.T) {
+	keys := newTestKeys(t)
+	server := testServer(t, keys)
+	server.keys.minRefresh = 0
+
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Expected rsa key but got err - %s", err)
+	}
+	token := sign(t, jwt.SigningMethodRS256, "rsa-2", rotated, testClaims())
+
+	if _, err = parse(server, token); !errors.Is(cause(err), ErrUnknownKey) {
+		t.Fatalf("Expected unknown key error before the rotation but got %v", err)
+	}
+
+	keys.jwks.Keys = append(keys.jwks.Keys, JWK{Kty: "RSA", Kid: "rsa-2", Use: "sig", Alg: "RS256", N: encodeInt(rotated.N), E: encodeInt(big.NewInt(int64(rotated.E)))})
+	keys.write(t, server.cfg.JWKSFile)
+
+	if _, err = parse(server, token); err != nil {
+		t.Fatalf("Expected rotated key to be picked up but got err - %s", err)
+	}
+}
+
+func Test_KeyCloakKeySetReload(t *testing.T) {
+	ctx := context.Background()
+	keys := newTestKeys(t)
+
+	var fetches, failing int32
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(keys.jwks)
+	}))
+	defer provider.Close()
+
+	set := newKeySet(provider.URL, time.Hour)
+	if err := set.load(ctx); err != nil {
+		t.Fatalf("Expected key set but got err - %s", err)
+	}
+
+	if _, _, err := set.Key(ctx, "rsa-2"); !errors.Is(err, ErrUnknownKey) || atomic.LoadInt32(&fetches) != 1 {
+		t.Fatalf("Expected unknown key without a fetch inside the backoff but got %v after %d fetches", err, fetches)
+	}
+
+	// the issuer is down: concurrent requests share one reload, and the failed reload starts the backoff
+	atomic.StoreInt32(&failing, 1)
+	set.attempted = time.Now().Add(-2 * set.minRefresh)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := set.Key(ctx, "rsa-2"); err == nil {
+				t.Errorf("Expected unknown key to be refused")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, _, err := set.Key(ctx, "rsa-2"); err == nil || atomic.LoadInt32(&fetches) != 2 {
+		t.Fatalf("Expected a single reload for unknown keys but got %d fetches", fetches)
+	}
+	if _, _, err := set.Key(ctx, "rsa-1"); err != nil {
+		t.Fatalf("Expected known key to be kept after a failed reload but got err - %s", err)
+	}
+
+	// a reload abandoned by its request does not start the backoff
+	set.attempted = time.Now().Add(-2 * set.minRefresh)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := set.Key(cancelled, "rsa-2"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancelled reload but got %v", err)
+	}
+	if _, _, _, reload := set.lookup("rsa-2"); !reload {
+		t.Fatalf("Expected reload to be due after a cancelled reload")
+	}
+}
+
+func Test_KeyCloakClaims(t *testing.T) {
+	keys := newTestKeys(t)
+	server := testServer(t, keys)
+
+	token, err := parse(server, sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, testClaims()))
+	if err != nil {
+		t.Fatalf("Expected token to be valid but got err - %s", err)
+	}
+
+	if userId, email := server.GetUserId(token.Claims), server.GetEmail(token.Claims); userId != "owner" || email != "owner@zbitech.local" {
+		t.Fatalf("Expected owner with owner@zbitech.local but got %s with %s", userId, email)
+	}
+	if role := server.GetRole(token.Claims); role != ztypes.ROLE_OWNER {
+		t.Fatalf("Expected owner role but got %s", role)
+	}
+	if teams := server.GetTeams(token.Claims); !reflect.DeepEqual(teams, []string{"team-1"}) {
+		t.Fatalf("Expected team-1 but got %v", teams)
+	}
+
+	claims := testClaims()
+	roles := map[ztypes.Role][]string{ztypes.ROLE_ADMIN: {"zbi-admin", "zbi-user"}, ztypes.ROLE_USER: {"offline_access"}}
+	for expected, realmRoles := range roles {
+		claims.RealmAccess.Roles = realmRoles
+		if role := server.GetRole(claims); role != expected {
+			t.Fatalf("Expected %s for %v but got %s", expected, realmRoles, role)
+		}
+	}
+
+	server.cfg.UserClaim = "sub"
+	if userId := server.GetUserId(claims); userId != claims.Subject {
+		t.Fatalf("Expected subject %s but got %s", claims.Subject, userId)
+	}
+}
+
+func Test_KeyCloakDiscovery(t *testing.T) {
+	keys := newTestKeys(t)
+
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/zbi-realm/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcMetadata{Issuer: issuer, JWKSUri: issuer + "/protocol/openid-connect/certs"})
+	})
+	mux.HandleFunc("/realms/zbi-realm/protocol/openid-connect/certs", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(keys.jwks)
+	})
+	provider := httptest.NewServer(mux)
+	defer provider.Close()
+	issuer = provider.URL + "/realms/zbi-realm"
+
+	cfg := defaultOIDCConfig()
+	cfg.Issuer = issuer
+	server, err := NewKeyCloakJwt(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Expected keycloak server from the issuer metadata but got err - %s", err)
+	}
+
+	claims := testClaims()
+	claims.Issuer = issuer
+	if _, err = parse(server, sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, claims)); err != nil {
+		t.Fatalf("Expected token to be valid but got err - %s", err)
+	}
+
+	cfg.Issuer = provider.URL + "/realms/other"
+	if _, err = NewKeyCloakJwt(context.Background(), cfg); err == nil {
+		t.Fatalf("Expected an issuer without metadata to be refused")
+	}
+}
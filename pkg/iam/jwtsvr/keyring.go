@@ -0,0 +1,321 @@
+package jwtsvr
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrNoSigningKey   = errors.New("no signing key")
+	ErrKeyAlgorithm   = errors.New("key does not match the signing algorithm")
+	ErrDuplicateKeyId = errors.New("key id is already in the key ring")
+)
+
+// SigningKey is a key of a KeyRing. Private is nil for keys kept only to verify tokens signed before a rotation.
+type SigningKey struct {
+	Kid     string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// signingMethod returns the method for alg if it is an RSA, ECDSA or EdDSA algorithm
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	method := jwt.GetSigningMethod(alg)
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		return method, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrInvalidSigningMethod, alg)
+}
+
+// matches reports whether public is the kind of key method verifies with
+func matches(method jwt.SigningMethod, public crypto.PublicKey) bool {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA:
+		_, ok := public.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		key, ok := public.(*ecdsa.PublicKey)
+		return ok && key.Curve.Params().BitSize == m.CurveBits
+	case *jwt.SigningMethodEd25519:
+		_, ok := public.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// NewSigningKey creates a key for alg from a private key, or from a public key for verification only. The kid
+// defaults to the RFC 7638 thumbprint of the public key.
+func NewSigningKey(kid, alg string, key crypto.PublicKey) (*SigningKey, error) {
+
+	method, err := signingMethod(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey := &SigningKey{Kid: kid, Method: method, Public: key}
+	if signer, ok := key.(crypto.Signer); ok {
+		signingKey.Private = signer
+		signingKey.Public = signer.Public()
+	}
+
+	if !matches(method, signingKey.Public) {
+		return nil, fmt.Errorf("%w %s", ErrKeyAlgorithm, alg)
+	}
+
+	if len(signingKey.Kid) == 0 {
+		if signingKey.Kid, err = thumbprint(signingKey.JWK()); err != nil {
+			return nil, err
+		}
+	}
+
+	return signingKey, nil
+}
+
+// GenerateSigningKey creates a new private key for alg
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+
+	method, err := signingMethod(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.Signer
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case *jwt.SigningMethodECDSA:
+		curves := map[int]elliptic.Curve{256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}
+		key, err = ecdsa.GenerateKey(curves[m.CurveBits], rand.Reader)
+	default:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey("", alg, key)
+}
+
+// ParseSigningKey reads a PEM encoded private key, or a public key for verification only, for alg
+func ParseSigningKey(kid, alg string, data []byte) (*SigningKey, error) {
+
+	method, err := signingMethod(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.PublicKey
+	switch method.(type) {
+	case *jwt.SigningMethodRSA:
+		key, err = parsePEM(data, func(data []byte) (crypto.PublicKey, error) { return jwt.ParseRSAPrivateKeyFromPEM(data) },
+			func(data []byte) (crypto.PublicKey, error) { return jwt.ParseRSAPublicKeyFromPEM(data) })
+	case *jwt.SigningMethodECDSA:
+		key, err = parsePEM(data, func(data []byte) (crypto.PublicKey, error) { return jwt.ParseECPrivateKeyFromPEM(data) },
+			func(data []byte) (crypto.PublicKey, error) { return jwt.ParseECPublicKeyFromPEM(data) })
+	default:
+		key, err = parsePEM(data, func(data []byte) (crypto.PublicKey, error) { return jwt.ParseEdPrivateKeyFromPEM(data) },
+			jwt.ParseEdPublicKeyFromPEM)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s key %s: %w", alg, kid, err)
+	}
+
+	return NewSigningKey(kid, alg, key)
+}
+
+// parsePEM parses data as a private key, or as a public key when it is not one
+func parsePEM(data []byte, private, public func([]byte) (crypto.PublicKey, error)) (crypto.PublicKey, error) {
+	if key, err := private(data); err == nil {
+		return key, nil
+	}
+	return public(data)
+}
+
+func encodeBytes(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// encodeCoordinate encodes an EC coordinate padded to the size of the curve, as RFC 7518 requires
+func encodeCoordinate(value *big.Int, curve elliptic.Curve) string {
+	data := make([]byte, (curve.Params().BitSize+7)/8)
+	return encodeBytes(value.FillBytes(data))
+}
+
+// JWK returns the public key in JSON Web Key format
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{Kid: k.Kid, Use: "sig", Alg: k.Method.Alg()}
+
+	switch key := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBytes(key.N.Bytes())
+		jwk.E = encodeBytes(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = encodeCoordinate(key.X, key.Curve)
+		jwk.Y = encodeCoordinate(key.Y, key.Curve)
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeBytes(key)
+	}
+
+	return jwk
+}
+
+// thumbprint returns the RFC 7638 thumbprint of jwk: the hash of its required members in lexical order
+func thumbprint(jwk JWK) (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", fmt.Errorf("%w - key type %s", ErrUnsupportedKey, jwk.Kty)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return encodeBytes(sum[:]), nil
+}
+
+// KeyRing signs tokens with its current key and verifies tokens signed with the current key or any previous key.
+// Rotating makes a new key current and keeps the old one for verification until it is retired, so tokens issued
+// before the rotation stay valid until they expire.
+type KeyRing struct {
+	mu       sync.RWMutex
+	current  *SigningKey
+	previous []*SigningKey
+}
+
+func NewKeyRing(current *SigningKey, previous ...*SigningKey) (*KeyRing, error) {
+	if current == nil || current.Private == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	kids := map[string]bool{current.Kid: true}
+	for _, key := range previous {
+		if kids[key.Kid] {
+			return nil, fmt.Errorf("%w - %s", ErrDuplicateKeyId, key.Kid)
+		}
+		kids[key.Kid] = true
+	}
+
+	return &KeyRing{current: current, previous: previous}, nil
+}
+
+// Rotate makes next the signing key. The current key is kept for verification.
+func (r *KeyRing) Rotate(next *SigningKey) error {
+	if next == nil || next.Private == nil {
+		return ErrNoSigningKey
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, _, err := r.key(next.Kid); err == nil {
+		return fmt.Errorf("%w - %s", ErrDuplicateKeyId, next.Kid)
+	}
+
+	if r.current != nil {
+		r.previous = append([]*SigningKey{r.current}, r.previous...)
+	}
+	r.current = next
+	return nil
+}
+
+// Retire removes a previous key. Tokens signed with it are no longer accepted.
+func (r *KeyRing) Retire(kid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]*SigningKey, 0, len(r.previous))
+	for _, key := range r.previous {
+		if key.Kid != kid {
+			keys = append(keys, key)
+		}
+	}
+	r.previous = keys
+}
+
+func (r *KeyRing) Current() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Sign signs claims with the current key and names the key in the kid header
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	key := r.Current()
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Private)
+}
+
+func (r *KeyRing) key(kid string) (crypto.PublicKey, jwt.SigningMethod, error) {
+	for _, key := range append([]*SigningKey{r.current}, r.previous...) {
+		if key != nil && key.Kid == kid {
+			return key.Public, key.Method, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+}
+
+// Key returns the public key named kid and the method it verifies
+func (r *KeyRing) Key(kid string) (crypto.PublicKey, jwt.SigningMethod, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.key(kid)
+}
+
+// JWKS returns the public keys of the ring, current key first
+func (r *KeyRing) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(r.previous)+1)}
+	for _, key := range append([]*SigningKey{r.current}, r.previous...) {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
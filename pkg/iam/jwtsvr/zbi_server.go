@@ -1,39 +1,203 @@
 package jwtsvr
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
 	"github.com/golang-jwt/jwt"
-	"github.com/zbitech/common/interfaces"
+	"github.com/zbitech/common/pkg/logger"
 	"github.com/zbitech/common/pkg/model/object"
 	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
 	"github.com/zbitech/repo/internal/helper"
 )
 
-type ZBIJwtServer struct {
-	secretKey string
+const DEFAULT_SIGNING_ALGORITHM = "ES256"
+
+var ErrInvalidLifetime = errors.New("token lifetime must be positive")
+
+// SigningKeyConfig locates a PEM encoded key, inline in Key or in File. The kid defaults to the key thumbprint.
+type SigningKeyConfig struct {
+	Kid       string
+	Algorithm string
+	Key       string
+	File      string
+}
+
+// ZBIJwtConfig lists the keys of the ZBI JWT server. It is read from jwt.yaml in the asset directory. The first key
+// signs new tokens and must be a private key; the others, which may be public keys, only verify tokens issued before
+// a rotation. Without keys a key is generated at startup, and tokens do not survive a restart.
+//
+// The lifetimes are durations such as 15m or 720h and default to 15 minutes for access tokens and 30 days for
+// refresh tokens.
+type ZBIJwtConfig struct {
+	Keys                 []SigningKeyConfig
+	AccessTokenLifetime  string
+	RefreshTokenLifetime string
+}
+
+// Lifetimes returns the configured access and refresh token lifetimes, or zero for those not configured
+func (c ZBIJwtConfig) Lifetimes() (time.Duration, time.Duration, error) {
+
+	lifetimes := make([]time.Duration, 2)
+	for index, value := range []string{c.AccessTokenLifetime, c.RefreshTokenLifetime} {
+		if len(value) == 0 {
+			continue
+		}
+
+		lifetime, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, 0, err
+		} else if lifetime <= 0 {
+			return 0, 0, fmt.Errorf("%w %s", ErrInvalidLifetime, value)
+		}
+		lifetimes[index] = lifetime
+	}
+
+	return lifetimes[0], lifetimes[1], nil
+}
+
+func ReadZBIJwtConfig(ctx context.Context) (ZBIJwtConfig, error) {
+
+	cfg := ZBIJwtConfig{Keys: make([]SigningKeyConfig, 0)}
+
+	configPath := fmt.Sprintf("%s/jwt.yaml", vars.ASSET_PATH_DIRECTORY)
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return cfg, nil
+	}
+
+	if err := utils.ReadConfig(configPath, nil, &cfg); err != nil {
+		logger.Errorf(ctx, "Unable to read jwt config: %s", err)
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func loadSigningKey(cfg SigningKeyConfig) (*SigningKey, error) {
+
+	alg := cfg.Algorithm
+	if len(alg) == 0 {
+		alg = DEFAULT_SIGNING_ALGORITHM
+	}
+
+	data := []byte(cfg.Key)
+	if len(cfg.File) > 0 {
+		var err error
+		if data, err = os.ReadFile(cfg.File); err != nil {
+			return nil, err
+		}
+	}
+
+	return ParseSigningKey(cfg.Kid, alg, data)
 }
 
-func NewZBIJwtServer() interfaces.JwtServerIF {
-	return &ZBIJwtServer{
-		secretKey: helper.SECRET_KEY,
+// NewKeyRingFromConfig loads the configured keys, or generates a signing key when none are configured
+func NewKeyRingFromConfig(ctx context.Context, cfg ZBIJwtConfig) (*KeyRing, error) {
+
+	if len(cfg.Keys) == 0 {
+		key, err := GenerateSigningKey(DEFAULT_SIGNING_ALGORITHM)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Infof(ctx, "No jwt signing keys configured - generated %s key %s, tokens will not survive a restart", DEFAULT_SIGNING_ALGORITHM, key.Kid)
+		return NewKeyRing(key)
+	}
+
+	keys := make([]*SigningKey, 0, len(cfg.Keys))
+	for _, keyConfig := range cfg.Keys {
+		key, err := loadSigningKey(keyConfig)
+		if err != nil {
+			logger.Errorf(ctx, "Unable to load jwt signing key %s - %s", keyConfig.Kid, err)
+			return nil, err
+		}
+		keys = append(keys, key)
 	}
+
+	return NewKeyRing(keys[0], keys[1:]...)
 }
 
+// ZBIJwtServer issues and validates the tokens of users that authenticate with the data store. Tokens are signed
+// by the current key of a KeyRing and name it in their kid header.
+type ZBIJwtServer struct {
+	keys *KeyRing
+}
+
+// NewZBIJwtServer creates the server and makes its key ring the signer of new tokens
+func NewZBIJwtServer(keys *KeyRing) *ZBIJwtServer {
+	helper.SetTokenSigner(keys)
+	return &ZBIJwtServer{keys: keys}
+}
+
+func (s *ZBIJwtServer) KeyRing() *KeyRing {
+	return s.keys
+}
+
+// GetKey returns the public key of the current signing key
 func (s *ZBIJwtServer) GetKey() (interface{}, error) {
-	return []byte(s.secretKey), nil
+	key := s.keys.Current()
+	if key == nil {
+		return nil, ErrNoSigningKey
+	}
+	return key.Public, nil
+}
+
+// GetTokenKey returns the public key named by the kid header of the token
+func (s *ZBIJwtServer) GetTokenKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+
+	kid, _ := token.Header["kid"].(string)
+	key, method, err := s.keys.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("%w - key %s is for %s", ErrInvalidSigningMethod, kid, method.Alg())
+	}
+
+	return key, nil
 }
 
 func (s *ZBIJwtServer) ValidateToken(token *jwt.Token) error {
 
-	_, ok := token.Method.(*jwt.SigningMethodHMAC)
+	if _, err := signingMethod(token.Method.Alg()); err != nil {
+		return err
+	}
 
+	claims, ok := token.Claims.(*object.ZBIBasicClaims)
 	if !ok {
-		//		log.Printf("Method %s is not valid", method)
 		return jwt.ErrInvalidKey
 	}
 
+	if !claims.VerifyIssuer(helper.TOKEN_ISSUER, true) {
+		return fmt.Errorf("%w - %s", ErrInvalidIssuer, claims.Issuer)
+	}
+
+	if !claims.VerifyAudience(helper.TOKEN_AUDIENCE, true) {
+		return fmt.Errorf("%w %s", ErrInvalidAudience, helper.TOKEN_AUDIENCE)
+	}
+
 	return nil
 }
 
+// JWKSHandler serves the public keys as a JWKS document so other services can verify ZBI tokens
+func (s *ZBIJwtServer) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "max-age=300")
+		if err := json.NewEncoder(w).Encode(s.keys.JWKS()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
 func (s *ZBIJwtServer) GetPayload() jwt.Claims {
 	return &object.ZBIBasicClaims{}
 }
@@ -43,6 +207,21 @@ func (s *ZBIJwtServer) GetUserId(claim jwt.Claims) string {
 	return zbiClaims.Subject
 }
 
+func (s *ZBIJwtServer) GetTokenId(claim jwt.Claims) string {
+	zbiClaims := claim.(*object.ZBIBasicClaims)
+	return zbiClaims.Id
+}
+
+func (s *ZBIJwtServer) GetIssuedAt(claim jwt.Claims) time.Time {
+	zbiClaims := claim.(*object.ZBIBasicClaims)
+	return time.Unix(zbiClaims.IssuedAt, 0)
+}
+
+func (s *ZBIJwtServer) GetExpiresAt(claim jwt.Claims) time.Time {
+	zbiClaims := claim.(*object.ZBIBasicClaims)
+	return time.Unix(zbiClaims.ExpiresAt, 0)
+}
+
 func (s *ZBIJwtServer) GetEmail(claim jwt.Claims) string {
 	zbiClaims := claim.(*object.ZBIBasicClaims)
 	return zbiClaims.Email
@@ -0,0 +1,267 @@
+package jwtsvr
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/model/object"
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/repo/internal/helper"
+)
+
+func parseZBI(server *ZBIJwtServer, tokenString string) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, server.GetPayload(), func(token *jwt.Token) (interface{}, error) {
+		if err := server.ValidateToken(token); err != nil {
+			return nil, err
+		}
+		return server.GetTokenKey(context.Background(), token)
+	})
+}
+
+// testKeyRing returns a key ring holding a new key of alg
+func testKeyRing(t *testing.T, alg string) (*KeyRing, *SigningKey) {
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		t.Fatalf("Expected %s signing key but got err - %s", alg, err)
+	}
+
+	ring, err := NewKeyRing(key)
+	if err != nil {
+		t.Fatalf("Expected key ring but got err - %s", err)
+	}
+	return ring, key
+}
+
+func Test_Thumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs" +
+			"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n9" +
+			"1CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+
+	kid, err := thumbprint(jwk)
+	if err != nil || kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("Expected the RFC 7638 thumbprint but got %s - %v", kid, err)
+	}
+}
+
+func Test_KeyRingAlgorithms(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "ES384", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			ring, key := testKeyRing(t, alg)
+			if len(key.Kid) == 0 {
+				t.Fatalf("Expected key id")
+			}
+			server := NewZBIJwtServer(ring)
+
+			tokenString, err := helper.GenerateJwtToken(entity.User{UserId: "owner", Email: "owner@zbitech.local", Role: ztypes.ROLE_OWNER})
+			if err != nil {
+				t.Fatalf("Expected token but got err - %s", err)
+			}
+
+			token, err := parseZBI(server, *tokenString)
+			if err != nil {
+				t.Fatalf("Expected token to be valid but got err - %s", err)
+			}
+			if token.Header["kid"] != key.Kid || token.Header["alg"] != alg {
+				t.Fatalf("Expected token signed by %s with %s but got %v", key.Kid, alg, token.Header)
+			}
+			if server.GetUserId(token.Claims) != "owner" || server.GetRole(token.Claims) != ztypes.ROLE_OWNER {
+				t.Fatalf("Expected owner with the owner role but got %s with %s", server.GetUserId(token.Claims), server.GetRole(token.Claims))
+			}
+			if len(server.GetTokenId(token.Claims)) == 0 {
+				t.Fatalf("Expected token id")
+			}
+			if !server.GetExpiresAt(token.Claims).After(server.GetIssuedAt(token.Claims)) {
+				t.Fatalf("Expected token to expire after it was issued")
+			}
+
+			public, err := ring.JWKS().Keys[0].PublicKey()
+			if err != nil || !reflect.DeepEqual(public, key.Public) {
+				t.Fatalf("Expected published key to be the public key but got %v - %v", public, err)
+			}
+		})
+	}
+
+	if _, err := GenerateSigningKey("HS256"); !errors.Is(err, ErrInvalidSigningMethod) {
+		t.Fatalf("Expected invalid signing method error for HS256 but got %v", err)
+	}
+}
+
+func Test_KeyRingRotation(t *testing.T) {
+	ring, first := testKeyRing(t, "ES256")
+	server := NewZBIJwtServer(ring)
+
+	claims := &object.ZBIBasicClaims{StandardClaims: jwt.StandardClaims{
+		Audience: helper.TOKEN_AUDIENCE, Issuer: helper.TOKEN_ISSUER, Subject: "owner", ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}}
+	before, err := ring.Sign(claims)
+	if err != nil {
+		t.Fatalf("Expected signed token but got err - %s", err)
+	}
+
+	second, err := GenerateSigningKey("RS256")
+	if err != nil {
+		t.Fatalf("Expected signing key but got err - %s", err)
+	}
+	if err = ring.Rotate(second); err != nil {
+		t.Fatalf("Expected key to be rotated in but got err - %s", err)
+	}
+	if err = ring.Rotate(second); !errors.Is(err, ErrDuplicateKeyId) {
+		t.Fatalf("Expected duplicate key id error but got %v", err)
+	}
+
+	after, err := ring.Sign(claims)
+	if err != nil {
+		t.Fatalf("Expected signed token but got err - %s", err)
+	}
+
+	for _, token := range []string{before, after} {
+		if _, err = parseZBI(server, token); err != nil {
+			t.Fatalf("Expected tokens of both keys to be valid but got err - %s", err)
+		}
+	}
+	if keys := ring.JWKS().Keys; len(keys) != 2 || keys[0].Kid != second.Kid {
+		t.Fatalf("Expected the new key first of 2 keys but got %v", keys)
+	}
+
+	ring.Retire(first.Kid)
+	if _, err = parseZBI(server, before); !errors.Is(cause(err), ErrUnknownKey) {
+		t.Fatalf("Expected unknown key error for a retired key but got %v", err)
+	}
+	if _, err = parseZBI(server, after); err != nil {
+		t.Fatalf("Expected token of the current key to be valid but got err - %s", err)
+	}
+}
+
+func Test_ZBIValidateToken(t *testing.T) {
+	ring, key := testKeyRing(t, "ES256")
+	server := NewZBIJwtServer(ring)
+
+	claims := func(issuer, audience string) *object.ZBIBasicClaims {
+		return &object.ZBIBasicClaims{StandardClaims: jwt.StandardClaims{
+			Audience: audience, Issuer: issuer, Subject: "owner", ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		}}
+	}
+
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(helper.TOKEN_ISSUER, helper.TOKEN_AUDIENCE))
+	hmac.Header["kid"] = key.Kid
+	hmacToken, err := hmac.SignedString([]byte(key.Kid))
+	if err != nil {
+		t.Fatalf("Expected hmac token but got err - %s", err)
+	}
+
+	wrongIssuer, err := ring.Sign(claims("other", helper.TOKEN_AUDIENCE))
+	if err != nil {
+		t.Fatalf("Expected signed token but got err - %s", err)
+	}
+	wrongAudience, err := ring.Sign(claims(helper.TOKEN_ISSUER, "other"))
+	if err != nil {
+		t.Fatalf("Expected signed token but got err - %s", err)
+	}
+
+	tests := map[string]error{hmacToken: ErrInvalidSigningMethod, wrongIssuer: ErrInvalidIssuer, wrongAudience: ErrInvalidAudience}
+	for token, expected := range tests {
+		if _, err = parseZBI(server, token); !errors.Is(cause(err), expected) {
+			t.Fatalf("Expected %s but got %v", expected, err)
+		}
+	}
+}
+
+func Test_KeyRingFromConfig(t *testing.T) {
+	ctx := context.Background()
+
+	current, err := GenerateSigningKey("EdDSA")
+	if err != nil {
+		t.Fatalf("Expected signing key but got err - %s", err)
+	}
+	previous, err := GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatalf("Expected signing key but got err - %s", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(current.Private)
+	if err != nil {
+		t.Fatalf("Expected private key der but got err - %s", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(previous.Public)
+	if err != nil {
+		t.Fatalf("Expected public key der but got err - %s", err)
+	}
+
+	cfg := ZBIJwtConfig{Keys: []SigningKeyConfig{
+		{Kid: "2022-06", Algorithm: "EdDSA", Key: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))},
+		{Algorithm: "ES256", Key: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))},
+	}}
+
+	ring, err := NewKeyRingFromConfig(ctx, cfg)
+	if err != nil || ring.Current().Kid != "2022-06" {
+		t.Fatalf("Expected key ring signing with 2022-06 but got err - %v", err)
+	}
+
+	_, method, err := ring.Key(previous.Kid)
+	if err != nil || method.Alg() != "ES256" {
+		t.Fatalf("Expected previous ES256 key to verify but got err - %v", err)
+	}
+
+	cfg.Keys[0], cfg.Keys[1] = cfg.Keys[1], cfg.Keys[0]
+	if _, err = NewKeyRingFromConfig(ctx, cfg); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("Expected no signing key error when the first key is public but got %v", err)
+	}
+
+	cfg.Keys = []SigningKeyConfig{{Algorithm: "RS256", Key: cfg.Keys[1].Key}}
+	if _, err = NewKeyRingFromConfig(ctx, cfg); err == nil {
+		t.Fatalf("Expected an EdDSA key configured as RS256 to be refused")
+	}
+
+	ring, err = NewKeyRingFromConfig(ctx, ZBIJwtConfig{})
+	if err != nil || ring.Current().Method.Alg() != DEFAULT_SIGNING_ALGORITHM {
+		t.Fatalf("Expected generated %s key but got err - %v", DEFAULT_SIGNING_ALGORITHM, err)
+	}
+}
+
+func Test_JWKSHandler(t *testing.T) {
+	ring, key := testKeyRing(t, "ES256")
+	server := NewZBIJwtServer(ring)
+
+	recorder := httptest.NewRecorder()
+	server.JWKSHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected json but got %s", contentType)
+	}
+
+	var jwks JWKS
+	if err := json.Unmarshal(recorder.Body.Bytes(), &jwks); err != nil {
+		t.Fatalf("Expected jwks but got err - %s", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != key.Kid || jwks.Keys[0].Alg != "ES256" {
+		t.Fatalf("Expected ES256 key %s but got %v", key.Kid, jwks.Keys)
+	}
+}
+
+func Test_ZBIJwtConfigLifetimes(t *testing.T) {
+	access, refresh, err := ZBIJwtConfig{AccessTokenLifetime: "5m"}.Lifetimes()
+	if err != nil || access != 5*time.Minute || refresh != 0 {
+		t.Fatalf("Expected 5m access and default refresh lifetimes but got %s and %s - %v", access, refresh, err)
+	}
+
+	if _, _, err = (ZBIJwtConfig{RefreshTokenLifetime: "-1h"}).Lifetimes(); !errors.Is(err, ErrInvalidLifetime) {
+		t.Fatalf("Expected invalid lifetime error but got %v", err)
+	}
+
+	if _, _, err = (ZBIJwtConfig{AccessTokenLifetime: "soon"}).Lifetimes(); err == nil {
+		t.Fatalf("Expected unparsable lifetime to be refused")
+	}
+}
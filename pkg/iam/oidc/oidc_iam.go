@@ -0,0 +1,84 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zbitech/common/interfaces"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/repo/pkg/iam/basic"
+	"github.com/zbitech/repo/pkg/mfa"
+	"github.com/zbitech/repo/pkg/session"
+)
+
+var ErrExternalCredentials = errors.New("credentials are managed by the identity provider")
+
+// OIDCIAMService is used when users sign in with an OpenID Connect provider. Users, keys and policies are kept in
+// the data store as with the basic service, but passwords are not: users authenticate with the provider and present
+// the access tokens it issues.
+type OIDCIAMService struct {
+	*basic.BasicIAMService
+}
+
+func NewOIDCIAMService(jwtServer interfaces.JwtServerIF) interfaces.IAMServiceIF {
+	return &OIDCIAMService{BasicIAMService: basic.NewBasicIAMService(jwtServer).(*basic.BasicIAMService)}
+}
+
+// RegisterUser adds the user to the data store. Users cannot be registered with a password.
+func (o *OIDCIAMService) RegisterUser(ctx context.Context, user *entity.User, pass *entity.UserPassword) error {
+	if pass != nil {
+		return ErrExternalCredentials
+	}
+	return o.BasicIAMService.RegisterUser(ctx, user, nil)
+}
+
+func (o *OIDCIAMService) ChangePassword(ctx context.Context, userid string, pass *entity.UserPassword) error {
+	return ErrExternalCredentials
+}
+
+func (o *OIDCIAMService) SetPassword(ctx context.Context, userId, plaintext string) error {
+	return ErrExternalCredentials
+}
+
+func (o *OIDCIAMService) UpdatePassword(ctx context.Context, userId, current, next string) error {
+	return ErrExternalCredentials
+}
+
+func (o *OIDCIAMService) AuthenticateUser(ctx context.Context, userId, password string) (*string, error) {
+	return nil, ErrExternalCredentials
+}
+
+// Login is not available; the identity provider issues refresh tokens for its own access tokens
+func (o *OIDCIAMService) Login(ctx context.Context, userId, password, device string) (*session.Tokens, error) {
+	return nil, ErrExternalCredentials
+}
+
+func (o *OIDCIAMService) Refresh(ctx context.Context, refreshToken string) (*session.Tokens, error) {
+	return nil, ErrExternalCredentials
+}
+
+// RequestPasswordReset is not available; passwords are reset with the identity provider
+func (o *OIDCIAMService) RequestPasswordReset(ctx context.Context, userId string) error {
+	return ErrExternalCredentials
+}
+
+func (o *OIDCIAMService) CompletePasswordReset(ctx context.Context, token, password string) error {
+	return ErrExternalCredentials
+}
+
+// VerifyMFA is not available; second factors are checked by the identity provider
+func (o *OIDCIAMService) VerifyMFA(ctx context.Context, challenge, code string) (*string, error) {
+	return nil, ErrExternalCredentials
+}
+
+func (o *OIDCIAMService) LoginMFA(ctx context.Context, challenge, code, device string) (*session.Tokens, error) {
+	return nil, ErrExternalCredentials
+}
+
+func (o *OIDCIAMService) EnrollMFA(ctx context.Context, userId string) (*mfa.Setup, error) {
+	return nil, ErrExternalCredentials
+}
+
+func (o *OIDCIAMService) ConfirmMFA(ctx context.Context, userId, code string) error {
+	return ErrExternalCredentials
+}
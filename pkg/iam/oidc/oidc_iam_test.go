@@ -0,0 +1,150 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/iam/jwtsvr"
+	"github.com/zbitech/repo/pkg/iam/oidc"
+	"github.com/zbitech/repo/pkg/memory"
+)
+
+const testIssuer = "https://keycloak.test/auth/realms/zbi-realm"
+
+func testKeyCloakServer(t *testing.T) (*jwtsvr.KeyCloakJwt, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Expected rsa key but got err - %s", err)
+	}
+
+	encode := func(value *big.Int) string { return base64.RawURLEncoding.EncodeToString(value.Bytes()) }
+	jwks := jwtsvr.JWKS{Keys: []jwtsvr.JWK{{Kty: "RSA", Kid: "rsa-1", Use: "sig", Alg: "RS256", N: encode(key.N), E: encode(big.NewInt(int64(key.E)))}}}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("Expected jwks but got err - %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Expected jwks file but got err - %s", err)
+	}
+
+	server, err := jwtsvr.NewKeyCloakJwt(context.Background(), jwtsvr.OIDCConfig{
+		Issuer: testIssuer, Audience: "zbi", JWKSFile: path, RefreshInterval: time.Hour, UserClaim: "preferred_username",
+		Roles: map[string]ztypes.Role{"zbi-admin": ztypes.ROLE_ADMIN, "zbi-owner": ztypes.ROLE_OWNER}, TeamGroupPrefix: "/teams/",
+	})
+	if err != nil {
+		t.Fatalf("Expected keycloak server but got err - %s", err)
+	}
+	return server, key
+}
+
+func Test_ValidateAuthTokenMapsIdentity(t *testing.T) {
+	ctx := context.Background()
+
+	factory := vars.RepositoryFactory
+	defer func() { vars.RepositoryFactory = factory }()
+
+	vars.RepositoryFactory = memory.NewMemoryRepositoryFactory()
+	if err := vars.RepositoryFactory.Init(ctx, false, false); err != nil {
+		t.Fatalf("Expected memory repository but got err - %s", err)
+	}
+
+	stored := entity.User{UserId: "owner", Email: "owner@zbitech.local", Role: ztypes.ROLE_USER, Active: true,
+		Memberships: []entity.UserTeam{{TeamId: "team-1", Key: "member-1"}, {TeamId: "team-9", Key: "member-9"}}}
+	pass := entity.NewUserPassword(stored.UserId, "")
+	if err := vars.RepositoryFactory.GetAdminRepository().RegisterUser(ctx, &stored, &pass); err != nil {
+		t.Fatalf("Expected user to be registered but got err - %s", err)
+	}
+
+	server, key := testKeyCloakServer(t)
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwtsvr.KeyCloakClaims{
+		Issuer: testIssuer, Subject: "8d3c3bd0", Audience: jwtsvr.Audience{"zbi"}, Id: "token-1",
+		ExpiresAt: now.Add(time.Minute).Unix(), IssuedAt: now.Unix(), PreferredUsername: "owner",
+		RealmAccess: jwtsvr.RealmAccess{Roles: []string{"offline_access", "zbi-owner"}},
+		Groups:      []string{"/teams/team-1", "/teams/team-2", "/staff"},
+	})
+	token.Header["kid"] = "rsa-1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Expected signed token but got err - %s", err)
+	}
+
+	service := oidc.NewOIDCIAMService(server)
+	_, user, err := service.ValidateAuthToken(ctx, signed)
+	if err != nil {
+		t.Fatalf("Expected token to be valid but got err - %s", err)
+	}
+	if user.Role != ztypes.ROLE_OWNER {
+		t.Fatalf("Expected the role of the token but got %s", user.Role)
+	}
+
+	memberships := []entity.UserTeam{{TeamId: "team-1", Key: "member-1"}, {TeamId: "team-2"}}
+	if !reflect.DeepEqual(user.Memberships, memberships) {
+		t.Fatalf("Expected the teams of the token %v but got %v", memberships, user.Memberships)
+	}
+
+	// the stored user is left as it is
+	saved, err := vars.RepositoryFactory.GetAdminRepository().GetUser(ctx, "owner")
+	if err != nil {
+		t.Fatalf("Expected stored user but got err - %s", err)
+	}
+	if saved.Role != ztypes.ROLE_USER || len(saved.Memberships) != 2 {
+		t.Fatalf("Expected stored user to keep its role and 2 teams but got %s and %v", saved.Role, saved.Memberships)
+	}
+}
+
+func Test_ExternalCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	factory := vars.RepositoryFactory
+	defer func() { vars.RepositoryFactory = factory }()
+
+	vars.RepositoryFactory = memory.NewMemoryRepositoryFactory()
+	if err := vars.RepositoryFactory.Init(ctx, false, false); err != nil {
+		t.Fatalf("Expected memory repository but got err - %s", err)
+	}
+
+	server, _ := testKeyCloakServer(t)
+	service := oidc.NewOIDCIAMService(server).(*oidc.OIDCIAMService)
+
+	pass := entity.NewUserPassword("tester", "Secret-password-1")
+	if err := service.RegisterUser(ctx, &entity.User{UserId: "tester"}, nil); err != nil {
+		t.Fatalf("Expected user without a password to be registered but got err - %s", err)
+	}
+
+	_, verifyErr := service.VerifyMFA(ctx, "challenge", "123456")
+	_, loginErr := service.LoginMFA(ctx, "challenge", "123456", "device")
+	_, enrollErr := service.EnrollMFA(ctx, "tester")
+
+	calls := map[string]error{
+		"RegisterUser":   service.RegisterUser(ctx, &entity.User{UserId: "tester"}, &pass),
+		"ChangePassword": service.ChangePassword(ctx, "tester", &pass),
+		"SetPassword":    service.SetPassword(ctx, "tester", pass.Password),
+		"UpdatePassword": service.UpdatePassword(ctx, "tester", "", pass.Password),
+		"VerifyMFA":      verifyErr,
+		"LoginMFA":       loginErr,
+		"EnrollMFA":      enrollErr,
+		"ConfirmMFA":     service.ConfirmMFA(ctx, "tester", "123456"),
+	}
+	for call, err := range calls {
+		if !errors.Is(err, oidc.ErrExternalCredentials) {
+			t.Fatalf("Expected %s to be refused with external credentials error but got %v", call, err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+)
+
+const (
+	DEFAULT_THRESHOLD        = 5
+	DEFAULT_SOURCE_THRESHOLD = 20
+	DEFAULT_WINDOW           = 15 * time.Minute
+	DEFAULT_DURATION         = 15 * time.Minute
+	DEFAULT_BASE_DELAY       = time.Second
+	DEFAULT_MAX_DELAY        = time.Minute
+
+	userPrefix   = "user:"
+	mfaPrefix    = "mfa:"
+	sourcePrefix = "source:"
+)
+
+var (
+	ErrAccountLocked      = errors.New("account is temporarily locked")
+	ErrTooManyAttempts    = errors.New("too many failed attempts")
+	ErrLockoutUnsupported = errors.New("repository does not store login attempts")
+	ErrInvalidPolicy      = errors.New("invalid lockout policy")
+)
+
+// LockedError is returned while a user or source may not authenticate. It wraps ErrAccountLocked or
+// ErrTooManyAttempts and tells when to retry.
+type LockedError struct {
+	Err   error
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s - retry after %s", e.Err, e.Until.Format(time.RFC3339))
+}
+
+func (e *LockedError) Unwrap() error {
+	return e.Err
+}
+
+// Attempts counts the recent failed logins of a user or a source address. Each failure delays the next attempt
+// until Blocked, doubling the delay each time, and reaching the threshold locks the key for the lockout duration.
+type Attempts struct {
+	Key         string    `json:"key" bson:"_id"`
+	Failures    int       `json:"failures" bson:"failures"`
+	LastFailure time.Time `json:"lastfailure" bson:"lastfailure"`
+	Blocked     time.Time `json:"blocked" bson:"blocked"`
+	Locked      bool      `json:"locked" bson:"locked"`
+	Expires     time.Time `json:"expires" bson:"expires"`
+}
+
+// Store keeps login attempts. Missing attempts are reported with errs.ErrDBItemNotFound.
+type Store interface {
+	GetAttempts(ctx context.Context, key string) (*Attempts, error)
+	PutAttempts(ctx context.Context, attempts *Attempts) error
+	// DeleteAttempts removes the attempts of key if there are any
+	DeleteAttempts(ctx context.Context, key string) error
+	PurgeExpiredAttempts(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Service is implemented by IAM services that lock out users
+type Service interface {
+	GetLoginAttempts(ctx context.Context, userId string) (*Attempts, error)
+	UnlockUser(ctx context.Context, userId string) error
+	PurgeExpiredAttempts(ctx context.Context) (int64, error)
+}
+
+func UserKey(userId string) string {
+	return userPrefix + userId
+}
+
+// MFAKey counts the wrong second factor codes of the user. A correct password does not clear them.
+func MFAKey(userId string) string {
+	return mfaPrefix + userId
+}
+
+func SourceKey(source string) string {
+	return sourcePrefix + source
+}
+
+type sourceKey struct{}
+
+// WithSource records the address a login comes from so failures are also counted per source
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func SourceFromContext(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+// Policy sets when failed logins delay and lock out further attempts. Failures older than Window are forgotten.
+type Policy struct {
+	Disabled        bool
+	Threshold       int
+	SourceThreshold int
+	Window          time.Duration
+	Duration        time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{Threshold: DEFAULT_THRESHOLD, SourceThreshold: DEFAULT_SOURCE_THRESHOLD, Window: DEFAULT_WINDOW,
+		Duration: DEFAULT_DURATION, BaseDelay: DEFAULT_BASE_DELAY, MaxDelay: DEFAULT_MAX_DELAY}
+}
+
+// Config is the lockout section of iam.yaml. Durations are written as 15m or 1h, and unset values keep their
+// defaults.
+type Config struct {
+	Disabled        bool
+	Threshold       int
+	SourceThreshold int
+	Window          string
+	Duration        string
+	BaseDelay       string
+	MaxDelay        string
+}
+
+func (c Config) Policy() (Policy, error) {
+
+	policy := DefaultPolicy()
+	policy.Disabled = c.Disabled
+
+	if c.Threshold < 0 || c.SourceThreshold < 0 {
+		return policy, fmt.Errorf("%w - thresholds must be positive", ErrInvalidPolicy)
+	}
+	if c.Threshold > 0 {
+		policy.Threshold = c.Threshold
+	}
+	if c.SourceThreshold > 0 {
+		policy.SourceThreshold = c.SourceThreshold
+	}
+
+	for _, field := range []struct {
+		value  string
+		target *time.Duration
+	}{{c.Window, &policy.Window}, {c.Duration, &policy.Duration}, {c.BaseDelay, &policy.BaseDelay}, {c.MaxDelay, &policy.MaxDelay}} {
+		if len(field.value) == 0 {
+			continue
+		}
+
+		duration, err := time.ParseDuration(field.value)
+		if err != nil {
+			return policy, fmt.Errorf("%w - %s", ErrInvalidPolicy, err)
+		} else if duration <= 0 {
+			return policy, fmt.Errorf("%w - duration %s must be positive", ErrInvalidPolicy, field.value)
+		}
+		*field.target = duration
+	}
+
+	return policy, nil
+}
+
+// delay returns how long to wait after the given number of consecutive failures
+func (p Policy) delay(failures int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Guard applies a Policy to the attempts kept in a Store. Updates are serialized so concurrent failures are all
+// counted.
+type Guard struct {
+	policy Policy
+	mu     sync.Mutex
+}
+
+func NewGuard(policy Policy) *Guard {
+	return &Guard{policy: policy}
+}
+
+func (g *Guard) Policy() Policy {
+	return g.policy
+}
+
+func keys(subject, source string) []string {
+	if len(source) == 0 {
+		return []string{subject}
+	}
+	return []string{subject, SourceKey(source)}
+}
+
+// Check returns a LockedError if the user or the source must wait before trying again
+func (g *Guard) Check(ctx context.Context, store Store, userId, source string, now time.Time) error {
+	return g.check(ctx, store, UserKey(userId), source, now)
+}
+
+// CheckMFA returns a LockedError if the user must wait before trying another second factor code, or the source
+// before trying again
+func (g *Guard) CheckMFA(ctx context.Context, store Store, userId, source string, now time.Time) error {
+	return g.check(ctx, store, MFAKey(userId), source, now)
+}
+
+func (g *Guard) check(ctx context.Context, store Store, subject, source string, now time.Time) error {
+
+	if g.policy.Disabled {
+		return nil
+	}
+
+	for _, key := range keys(subject, source) {
+		attempts, err := store.GetAttempts(ctx, key)
+		if errors.Is(err, errs.ErrDBItemNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if now.Before(attempts.Blocked) {
+			if attempts.Locked && key == subject {
+				return &LockedError{Err: ErrAccountLocked, Until: attempts.Blocked}
+			}
+			return &LockedError{Err: ErrTooManyAttempts, Until: attempts.Blocked}
+		}
+	}
+
+	return nil
+}
+
+// Failed records a failed login of the user from source
+func (g *Guard) Failed(ctx context.Context, store Store, userId, source string, now time.Time) error {
+	return g.failed(ctx, store, UserKey(userId), source, now)
+}
+
+// FailedMFA records a wrong second factor code of the user from source
+func (g *Guard) FailedMFA(ctx context.Context, store Store, userId, source string, now time.Time) error {
+	return g.failed(ctx, store, MFAKey(userId), source, now)
+}
+
+func (g *Guard) failed(ctx context.Context, store Store, subject, source string, now time.Time) error {
+
+	if g.policy.Disabled {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, key := range keys(subject, source) {
+		attempts, err := store.GetAttempts(ctx, key)
+		if errors.Is(err, errs.ErrDBItemNotFound) || (err == nil && now.Sub(attempts.LastFailure) > g.policy.Window) {
+			attempts, err = &Attempts{Key: key}, nil
+		} else if err != nil {
+			return err
+		}
+
+		threshold := g.policy.Threshold
+		if key != subject {
+			threshold = g.policy.SourceThreshold
+		}
+
+		attempts.Failures++
+		attempts.LastFailure = now
+		attempts.Locked = attempts.Failures >= threshold
+		if attempts.Locked {
+			attempts.Blocked = now.Add(g.policy.Duration)
+			logger.Infof(ctx, "Locked %s after %d failed logins until %s", key, attempts.Failures, attempts.Blocked)
+		} else {
+			attempts.Blocked = now.Add(g.policy.delay(attempts.Failures))
+		}
+
+		attempts.Expires = now.Add(g.policy.Window)
+		if attempts.Blocked.After(attempts.Expires) {
+			attempts.Expires = attempts.Blocked
+		}
+
+		if err = store.PutAttempts(ctx, attempts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Succeeded forgets the failed passwords of the user. Wrong second factor codes and failures counted against the
+// source are kept.
+func (g *Guard) Succeeded(ctx context.Context, store Store, userId string) error {
+	return g.succeeded(ctx, store, UserKey(userId))
+}
+
+// SucceededMFA forgets the wrong second factor codes of the user
+func (g *Guard) SucceededMFA(ctx context.Context, store Store, userId string) error {
+	return g.succeeded(ctx, store, MFAKey(userId))
+}
+
+func (g *Guard) succeeded(ctx context.Context, store Store, subject string) error {
+
+	if g.policy.Disabled {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return store.DeleteAttempts(ctx, subject)
+}
@@ -0,0 +1,177 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+)
+
+type mapStore map[string]Attempts
+
+func (s mapStore) GetAttempts(ctx context.Context, key string) (*Attempts, error) {
+	attempts, ok := s[key]
+	if !ok {
+		return nil, errs.ErrDBItemNotFound
+	}
+	return &attempts, nil
+}
+
+func (s mapStore) PutAttempts(ctx context.Context, attempts *Attempts) error {
+	s[attempts.Key] = *attempts
+	return nil
+}
+
+func (s mapStore) DeleteAttempts(ctx context.Context, key string) error {
+	delete(s, key)
+	return nil
+}
+
+func (s mapStore) PurgeExpiredAttempts(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func Test_GuardBackoffAndLockout(t *testing.T) {
+	ctx := context.Background()
+	store := mapStore{}
+	guard := NewGuard(Policy{Threshold: 3, SourceThreshold: 10, Window: time.Hour, Duration: 10 * time.Minute,
+		BaseDelay: time.Second, MaxDelay: 3 * time.Second})
+	now := time.Now()
+
+	if err := guard.Check(ctx, store, "tester", "10.0.0.1", now); err != nil {
+		t.Fatalf("Expected first attempt to be allowed but got err - %s", err)
+	}
+
+	if err := guard.Failed(ctx, store, "tester", "10.0.0.1", now); err != nil {
+		t.Fatalf("Expected failure to be recorded but got err - %s", err)
+	}
+
+	var locked *LockedError
+	err := guard.Check(ctx, store, "tester", "10.0.0.1", now)
+	if !errors.As(err, &locked) || !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Expected too many attempts error but got %v", err)
+	}
+	if !locked.Until.Equal(now.Add(time.Second)) {
+		t.Fatalf("Expected backoff until %s but got %s", now.Add(time.Second), locked.Until)
+	}
+
+	now = now.Add(time.Second)
+	if err = guard.Check(ctx, store, "tester", "10.0.0.1", now); err != nil {
+		t.Fatalf("Expected attempt after the backoff to be allowed but got err - %s", err)
+	}
+	if err = guard.Failed(ctx, store, "tester", "10.0.0.1", now); err != nil {
+		t.Fatalf("Expected failure to be recorded but got err - %s", err)
+	}
+	if err = guard.Check(ctx, store, "tester", "10.0.0.2", now.Add(time.Second)); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Expected backoff to double to 2s but got %v", err)
+	}
+
+	now = now.Add(2 * time.Second)
+	if err = guard.Failed(ctx, store, "tester", "10.0.0.1", now); err != nil {
+		t.Fatalf("Expected failure to be recorded but got err - %s", err)
+	}
+	if err = guard.Check(ctx, store, "tester", "10.0.0.2", now.Add(5*time.Minute)); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected account locked error but got %v", err)
+	}
+	if err = guard.Check(ctx, store, "tester", "10.0.0.2", now.Add(10*time.Minute)); err != nil {
+		t.Fatalf("Expected lockout to end after its duration but got err - %s", err)
+	}
+
+	if failures := store[SourceKey("10.0.0.1")].Failures; failures != 3 {
+		t.Fatalf("Expected 3 failures from the source but got %d", failures)
+	}
+	if err = guard.Succeeded(ctx, store, "tester"); err != nil {
+		t.Fatalf("Expected success to be recorded but got err - %s", err)
+	}
+	if _, found := store[UserKey("tester")]; found {
+		t.Fatalf("Expected success to clear the failures of the user")
+	}
+	if _, found := store[SourceKey("10.0.0.1")]; !found {
+		t.Fatalf("Expected success to keep the failures of the source")
+	}
+}
+
+func Test_GuardSourceAndWindow(t *testing.T) {
+	ctx := context.Background()
+	store := mapStore{}
+	guard := NewGuard(Policy{Threshold: 2, SourceThreshold: 2, Window: time.Minute, Duration: time.Hour,
+		BaseDelay: time.Second, MaxDelay: time.Second})
+	now := time.Now()
+
+	for _, userId := range []string{"first", "second"} {
+		if err := guard.Failed(ctx, store, userId, "10.0.0.1", now); err != nil {
+			t.Fatalf("Expected failure to be recorded but got err - %s", err)
+		}
+	}
+	if err := guard.Check(ctx, store, "third", "10.0.0.1", now.Add(time.Minute)); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Expected source to be locked without locking the account but got %v", err)
+	}
+
+	if err := guard.Failed(ctx, store, "first", "", now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("Expected failure to be recorded but got err - %s", err)
+	}
+	if failures := store[UserKey("first")].Failures; failures != 1 {
+		t.Fatalf("Expected failures outside the window to be forgotten but got %d", failures)
+	}
+
+	disabled := NewGuard(Policy{Disabled: true})
+	if err := disabled.Check(ctx, store, "third", "10.0.0.1", now); err != nil {
+		t.Fatalf("Expected disabled guard to allow every attempt but got err - %s", err)
+	}
+}
+
+func Test_GuardMFA(t *testing.T) {
+	ctx := context.Background()
+	store := mapStore{}
+	guard := NewGuard(Policy{Threshold: 2, SourceThreshold: 10, Window: time.Hour, Duration: time.Hour,
+		BaseDelay: time.Second, MaxDelay: time.Second})
+	now := time.Now()
+
+	// a correct password between wrong codes does not reset the count of wrong codes
+	if err := guard.FailedMFA(ctx, store, "tester", "", now); err != nil {
+		t.Fatalf("Expected failure to be recorded but got err - %s", err)
+	}
+	if err := guard.Succeeded(ctx, store, "tester"); err != nil {
+		t.Fatalf("Expected success to be recorded but got err - %s", err)
+	}
+	if err := guard.FailedMFA(ctx, store, "tester", "", now.Add(time.Second)); err != nil {
+		t.Fatalf("Expected failure to be recorded but got err - %s", err)
+	}
+
+	if err := guard.CheckMFA(ctx, store, "tester", "", now.Add(time.Minute)); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected account locked error but got %v", err)
+	}
+	if err := guard.Check(ctx, store, "tester", "", now.Add(time.Minute)); err != nil {
+		t.Fatalf("Expected passwords to be counted apart but got err - %s", err)
+	}
+
+	if err := guard.SucceededMFA(ctx, store, "tester"); err != nil {
+		t.Fatalf("Expected success to be recorded but got err - %s", err)
+	}
+	if _, found := store[MFAKey("tester")]; found {
+		t.Fatalf("Expected success to clear the wrong codes")
+	}
+}
+
+func Test_ConfigPolicy(t *testing.T) {
+	policy, err := Config{}.Policy()
+	if err != nil || policy != DefaultPolicy() {
+		t.Fatalf("Expected default policy but got %v - %v", policy, err)
+	}
+
+	policy, err = Config{Threshold: 10, Duration: "1h", MaxDelay: "30s"}.Policy()
+	if err != nil {
+		t.Fatalf("Expected policy but got err - %s", err)
+	}
+	if policy.Threshold != 10 || policy.Duration != time.Hour || policy.MaxDelay != 30*time.Second || policy.Window != DEFAULT_WINDOW {
+		t.Fatalf("Expected configured policy with the default window but got %v", policy)
+	}
+
+	for _, cfg := range []Config{{Window: "-1m"}, {Threshold: -1}} {
+		if _, err = cfg.Policy(); !errors.Is(err, ErrInvalidPolicy) {
+			t.Fatalf("Expected invalid policy error for %v but got %v", cfg, err)
+		}
+	}
+}
@@ -5,101 +5,130 @@ import (
 	"fmt"
 	"github.com/zbitech/common/pkg/errs"
 	"github.com/zbitech/common/pkg/id"
-	"github.com/zbitech/common/pkg/model/config"
 	"github.com/zbitech/common/pkg/model/entity"
 	"github.com/zbitech/common/pkg/rctx"
-	"github.com/zbitech/common/pkg/utils"
 	"github.com/zbitech/common/pkg/vars"
+	"sync"
 	"time"
 
 	"github.com/zbitech/common/pkg/logger"
-	mem "github.com/zbitech/common/pkg/memory"
 	"github.com/zbitech/repo/internal/helper"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"github.com/zbitech/repo/pkg/seed"
 )
 
 type AdminMemoryRepository struct {
-	users            *mem.MemoryStore
-	passwords        map[string]string
-	apikeys          *mem.MemoryStore
-	userPolicies     *mem.MemoryStore
-	instancePolicies *mem.MemoryStore
-	apikeyPolicies   *mem.MemoryStore
-	teams            *mem.MemoryStore
-	members          *mem.MemoryStore
+	users            *journalStore
+	passwords        *journalStore
+	apikeys          *journalStore
+	userPolicies     *journalStore
+	instancePolicies *journalStore
+	apikeyPolicies   *journalStore
+	teams            *journalStore
+	members          *journalStore
+	sessions         *journalStore
+	revokedTokens    *journalStore
+	watermarks       *journalStore
+	loginAttempts    *journalStore
+	passwordHistory  *journalStore
+	resetTokens      *journalStore
+	mfa              *journalStore
+	serviceAccounts  *journalStore
+	summaries        *resourceSummaries
+
+	// sessionMu makes reading and replacing a session atomic
+	sessionMu sync.Mutex
+	// resetMu makes using a reset token atomic
+	resetMu sync.Mutex
+	// apikeyMu makes updating an api key atomic
+	apikeyMu sync.Mutex
+	// serviceAccountMu makes creating a service account with its user atomic
+	serviceAccountMu sync.Mutex
+	// userMu makes registering a user with its password atomic
+	userMu sync.Mutex
+	// teamMu makes changing a team and the summary of its owner atomic
+	teamMu sync.Mutex
+}
+
+func newAdminMemoryRepository(summaries *resourceSummaries) *AdminMemoryRepository {
+	return &AdminMemoryRepository{
+		users:            newJournalStore("users", decodeUser),
+		passwords:        newJournalStore("passwords", decodeUserPassword),
+		apikeys:          newJournalStore("apikeys", decodeAPIKey),
+		userPolicies:     newJournalStore("user_policy", decodeUserPolicy),
+		instancePolicies: newJournalStore("instance_policy", decodeInstancePolicy),
+		apikeyPolicies:   newJournalStore("apikey_policy", decodeAPIKeyPolicy),
+		teams:            newJournalStore("teams", decodeTeam),
+		members:          newJournalStore("team_members", decodeTeamMember),
+		sessions:         newJournalStore("sessions", decodeSession),
+		revokedTokens:    newJournalStore("revoked_tokens", decodeRevokedToken),
+		watermarks:       newJournalStore("token_watermarks", decodeWatermark),
+		loginAttempts:    newJournalStore("login_attempts", decodeAttempts),
+		passwordHistory:  newJournalStore("password_history", decodePasswordHistory),
+		resetTokens:      newJournalStore("reset_tokens", decodeResetToken),
+		mfa:              newJournalStore("mfa", decodeEnrollment),
+		serviceAccounts:  newJournalStore("service_accounts", decodeServiceAccount),
+		summaries:        summaries,
+	}
 }
 
 func NewAdminMemoryRepository(ctx context.Context, path string) (*AdminMemoryRepository, error) {
 
-	var store *AdminMemoryRepository = &AdminMemoryRepository{
-		users:            mem.NewMemoryStore(),
-		passwords:        make(map[string]string, 0),
-		apikeys:          mem.NewMemoryStore(),
-		userPolicies:     mem.NewMemoryStore(),
-		instancePolicies: mem.NewMemoryStore(),
-		apikeyPolicies:   mem.NewMemoryStore(),
-		teams:            mem.NewMemoryStore(),
-		members:          mem.NewMemoryStore(),
+	var store = newAdminMemoryRepository(newResourceSummaries())
+	if _, err := store.LoadDatabase(ctx, false); err != nil {
+		return nil, err
 	}
 
-	var adminConfig config.AdminConfig
-	configPath := fmt.Sprintf("%s/admin.yaml", vars.ASSET_PATH_DIRECTORY)
-	err := utils.ReadConfig(configPath, nil, &adminConfig)
-	if err != nil {
-		logger.Errorf(ctx, "Unable to load default admin data: %s", err)
-		return nil, errs.ErrDBError
-	} else {
-
-		for _, user := range adminConfig.Users {
-			if user.Memberships == nil {
-				user.Memberships = make([]entity.UserTeam, 0)
-			}
-			user.Created = time.Now()
-			user.LastUpdate = time.Now()
-			user.Active = true
-
-			userid := user.UserId
-			password := adminConfig.Passwords[userid]
-			userPolicy := entity.NewUserPolicy(user.UserId)
-
-			store.users.StoreItem(user.UserId, user)
-			store.users.StoreItem(user.UserId, userPolicy)
-			store.passwords[user.UserId] = password
-		}
+	return store, nil
+}
 
-		for _, apikey := range adminConfig.Keys {
-			apikey.Created = time.Now()
-			apikey.Expires = apikey.Created.Add(time.Hour * 8760)
-			keyPolicy := entity.NewAPIKeyPolicy(apikey.Key, true)
+// LoadDatabase adds the users, keys, teams and members defined in admin.yaml that are not stored yet
+func (m *AdminMemoryRepository) LoadDatabase(ctx context.Context, dryRun bool) (*seed.Report, error) {
 
-			store.apikeys.StoreItem(apikey.Key, apikey)
-			store.apikeyPolicies.StoreItem(apikey.Key, keyPolicy)
-		}
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "LoadDatabase"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
 
-		for _, team := range adminConfig.Teams {
-			team.Created = time.Now()
-			team.LastUpdate = time.Now()
-			store.teams.StoreItem(team.TeamId, team)
-		}
+	adminConfig, err := seed.ReadAdminConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, mbr := range adminConfig.Members {
-			mbr.CreatedOn = time.Now()
-			mbr.LastUpdate = time.Now()
-			store.members.StoreItem(mbr.Key, mbr)
-		}
+	return seed.Load(ctx, m, adminConfig, dryRun)
+}
+
+// PurgeDatabase removes all items from the repository
+func (m *AdminMemoryRepository) PurgeDatabase(ctx context.Context) {
 
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeDatabase"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	for _, store := range m.stores() {
+		store.clear()
 	}
+	m.summaries.purge()
+}
 
-	return store, nil
+func (m *AdminMemoryRepository) stores() []*journalStore {
+	return []*journalStore{m.users, m.passwords, m.apikeys, m.userPolicies, m.instancePolicies, m.apikeyPolicies, m.teams, m.members, m.sessions, m.revokedTokens, m.watermarks, m.loginAttempts, m.passwordHistory, m.resetTokens, m.mfa, m.serviceAccounts}
 }
 
 func (m *AdminMemoryRepository) RegisterUser(ctx context.Context, user *entity.User, pass *entity.UserPassword) error {
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RegisterUser"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	m.userMu.Lock()
+	defer m.userMu.Unlock()
+
 	_, err := m.users.GetItem(user.UserId)
 	if err != nil {
+		user.Created = time.Now()
+		user.Active = true
+		user.LastUpdate = time.Now()
+
 		m.users.StoreItem(user.UserId, user)
-		m.passwords[user.UserId] = pass.Password
+		if pass != nil {
+			m.passwords.StoreItem(user.UserId, pass)
+		}
 
 		return nil
 	}
@@ -158,10 +187,10 @@ func (m *AdminMemoryRepository) UpdatePassword(ctx context.Context, userid strin
 
 	_, err := m.users.GetItem(userid)
 	if err != nil {
-		return nil
+		return errs.ErrDBItemNotFound
 	}
 
-	m.passwords[userid] = password.Password
+	m.passwords.StoreItem(userid, password)
 	return nil
 }
 
@@ -170,12 +199,13 @@ func (m *AdminMemoryRepository) AuthenticateUser(ctx context.Context, userId, pa
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AuthenticateUser"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	userPass, ok := m.passwords[userId]
-	if !ok {
+	item, err := m.passwords.GetItem(userId)
+	if err != nil {
 		return nil, errs.ErrAuthFailed
 	}
 
-	if id.ValidatePassword(userPass, []byte(password)) {
+	userPass := item.(*entity.UserPassword)
+	if id.ValidatePassword(userPass.Password, []byte(password)) {
 		user, err := m.GetUser(ctx, userId)
 		if err != nil {
 			return nil, err
@@ -195,7 +225,7 @@ func (m *AdminMemoryRepository) GetAPIKeys(ctx context.Context, userId string) (
 	keys := make([]string, 0)
 	items := m.apikeys.GetItems()
 	for _, item := range items {
-		apiKey := item.(*entity.APIKey)
+		apiKey := item.(*apikeys.Record)
 		if apiKey.UserId == userId {
 			keys = append(keys, apiKey.Key)
 		}
@@ -208,36 +238,56 @@ func (m *AdminMemoryRepository) GetAPIKey(ctx context.Context, apiKey string) (*
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	item, err := m.apikeys.GetItem(apiKey)
+	id, secret, err := apikeys.Parse(apiKey)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	record, err := m.GetAPIKeyRecord(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	apikey := item.(*entity.APIKey)
-	return apikey, nil
+	if err = record.Authenticate(secret, time.Now()); err != nil {
+		logger.Errorf(ctx, "Rejected api key %s - %s", id, err)
+		return nil, err
+	}
+
+	return record.APIKey(), nil
 }
 
+// CreateAPIKey returns the full key once. Only its id and the hash of its secret are stored.
 func (m *AdminMemoryRepository) CreateAPIKey(ctx context.Context, user_id string) (*entity.APIKey, error) {
 
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	apikey := entity.NewAPIKey(user_id, vars.HOURS_IN_YEAR)
-	m.apikeys.StoreItem(apikey.Key, &apikey)
+	record, key, err := apikeys.New(user_id, time.Now(), time.Duration(vars.HOURS_IN_YEAR)*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.CreateAPIKeyRecord(ctx, record); err != nil {
+		return nil, err
+	}
 
-	return &apikey, nil
+	apikey := record.APIKey()
+	apikey.Key = key
+	return apikey, nil
 }
 
 func (m *AdminMemoryRepository) DeleteAPIKey(ctx context.Context, apiKey string) error {
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	_, err := m.apikeys.GetItem(apiKey)
+	id := apikeys.ID(apiKey)
+	item, err := m.apikeys.GetItem(id)
 	if err != nil {
 		return err
 	}
 
-	m.apikeys.RemoveItem(apiKey)
+	m.apikeys.RemoveItem(id)
+	m.summaries.update(item.(*apikeys.Record).UserId, addAPIKeys(-1))
 	return nil
 }
 
@@ -329,6 +379,7 @@ func (m *AdminMemoryRepository) StoreAPIKeyPolicy(ctx context.Context, p entity.
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "StoreAPIKeyPolicy"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	p.Key = apikeys.ID(p.Key)
 	m.apikeyPolicies.StoreItem(p.Key, &p)
 	return nil
 }
@@ -337,7 +388,7 @@ func (m *AdminMemoryRepository) GetAPIKeyPolicy(ctx context.Context, key string)
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKeyPolicy"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	item, err := m.apikeyPolicies.GetItem(key)
+	item, err := m.apikeyPolicies.GetItem(apikeys.ID(key))
 	if err != nil {
 		return nil, err
 	}
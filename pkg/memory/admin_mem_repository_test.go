@@ -1,25 +1,184 @@
 package memory
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/internal/admintest"
 )
 
 func Test_NewAdminMemoryRepository(t *testing.T) {
 
+	ctx := context.Background()
+	repo, err := NewAdminMemoryRepository(ctx, vars.ASSET_PATH_DIRECTORY)
+	if err != nil {
+		t.Fatalf("Expected repository but got err - %s", err)
+	}
+
+	users := repo.GetUsers(ctx)
+	if len(users) == 0 {
+		t.Fatalf("Expected users to be loaded but got none")
+	}
+}
+
+func Test_AdminStore(t *testing.T) {
+	admintest.Run(t, func(t *testing.T) admintest.Store {
+		return newAdminMemoryRepository(newResourceSummaries())
+	})
 }
 
 func Test_RegisterUser(t *testing.T) {
 
+	ctx := context.Background()
+	repo := newAdminMemoryRepository(newResourceSummaries())
+
+	user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+	pass := entity.NewUserPassword(user.UserId, "password")
+	if err := repo.RegisterUser(ctx, &user, &pass); err != nil {
+		t.Fatalf("Expected user to be registered but got err - %s", err)
+	}
+
+	if err := repo.RegisterUser(ctx, &user, &pass); err == nil {
+		t.Fatalf("Expected duplicate registration to fail")
+	}
+}
+
+func Test_RegisterUserConcurrently(t *testing.T) {
+
+	ctx := context.Background()
+	repo := newAdminMemoryRepository(newResourceSummaries())
+
+	var wg sync.WaitGroup
+	var registered int32
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+			pass := entity.NewUserPassword(user.UserId, fmt.Sprintf("password-%d", i))
+			if repo.RegisterUser(ctx, &user, &pass) == nil {
+				atomic.AddInt32(&registered, 1)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if registered != 1 {
+		t.Fatalf("Expected the user to be registered once but got %d", registered)
+	}
 }
 
 func Test_GetUsers(t *testing.T) {
 
+	ctx := context.Background()
+	repo, err := NewAdminMemoryRepository(ctx, vars.ASSET_PATH_DIRECTORY)
+	if err != nil {
+		t.Fatalf("Expected repository but got err - %s", err)
+	}
+
+	users := repo.GetUsers(ctx)
+	if users == nil || len(users) == 0 {
+		t.Fatalf("Expected users but got nil")
+	}
+
+	t.Logf("Users - %s", utils.MarshalObject(users))
 }
 
 func Test_GetUser(t *testing.T) {
 
+	ctx := context.Background()
+	repo, err := NewAdminMemoryRepository(ctx, vars.ASSET_PATH_DIRECTORY)
+	if err != nil {
+		t.Fatalf("Expected repository but got err - %s", err)
+	}
+
+	user, err := repo.GetUser(ctx, "admin")
+	if err != nil {
+		t.Fatalf("Expected admin user but got err - %s", err)
+	}
+
+	t.Logf("Users - %s", utils.MarshalObject(user))
 }
 
 func Test_UpdateUser(t *testing.T) {
-	
+
+	ctx := context.Background()
+	repo := newAdminMemoryRepository(newResourceSummaries())
+
+	user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+	if err := repo.UpdateUser(ctx, &user); err == nil {
+		t.Fatalf("Expected update of unknown user to fail")
+	}
+
+	pass := entity.NewUserPassword(user.UserId, "password")
+	if err := repo.RegisterUser(ctx, &user, &pass); err != nil {
+		t.Fatalf("Expected user to be registered but got err - %s", err)
+	}
+
+	user.Email = "updated@zbitech.local"
+	if err := repo.UpdateUser(ctx, &user); err != nil {
+		t.Fatalf("Expected user to be updated but got err - %s", err)
+	}
+
+	stored, err := repo.GetUser(ctx, user.UserId)
+	if err != nil || stored.Email != user.Email {
+		t.Fatalf("Expected updated user but got %s - %s", utils.MarshalObject(stored), err)
+	}
+}
+
+func Test_GetAPIKeys(t *testing.T) {
+
+	ctx := context.Background()
+	repo, err := NewAdminMemoryRepository(ctx, vars.ASSET_PATH_DIRECTORY)
+	if err != nil {
+		t.Fatalf("Expected repository but got err - %s", err)
+	}
+
+	keys, err := repo.GetAPIKeys(ctx, "jakinyele")
+	if err != nil {
+		t.Fatalf("Expected apikeys but got err - %s", err)
+	}
+
+	t.Logf("API Keys - %s", keys)
+}
+
+func Test_GetAPIKey(t *testing.T) {
+
+	ctx := context.Background()
+	repo, err := NewAdminMemoryRepository(ctx, vars.ASSET_PATH_DIRECTORY)
+	if err != nil {
+		t.Fatalf("Expected repository but got err - %s", err)
+	}
+
+	key, err := repo.GetAPIKey(ctx, "9664a36c-58bd-4968-82d1-a8fb4c3502bf")
+	if err != nil {
+		t.Fatalf("Expected apikeys but got err - %s", err)
+	}
+
+	t.Logf("API Keys - %s", utils.MarshalObject(key))
+}
+
+func Test_GetUserPolicy(t *testing.T) {
+
+	ctx := context.Background()
+	repo, err := NewAdminMemoryRepository(ctx, vars.ASSET_PATH_DIRECTORY)
+	if err != nil {
+		t.Fatalf("Expected repository but got err - %s", err)
+	}
+
+	u_policy, err := repo.GetUserPolicy(ctx, "jakinyele")
+	if err != nil {
+		t.Fatalf("Expected user policy but got error - %s", err)
+	}
+
+	t.Logf("User Policy - %s", utils.MarshalObject(u_policy))
 }
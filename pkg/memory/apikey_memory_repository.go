@@ -0,0 +1,186 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/apikeys"
+)
+
+// migrateAPIKeys hashes the API keys restored in plaintext from a journal of an earlier version and moves their
+// policies to the new ids. The keys keep working as they are.
+func (m *AdminMemoryRepository) migrateAPIKeys(ctx context.Context) int {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "migrateAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	count := 0
+	for _, item := range m.apikeys.GetItems() {
+		old := item.(*apikeys.Record)
+		if old.Hashed() {
+			continue
+		}
+
+		record := apikeys.FromPlaintext(entity.APIKey{Key: old.Key, UserId: old.UserId, Created: old.Created, Expires: old.Expires})
+		m.apikeys.RemoveItem(old.Key)
+		m.apikeys.StoreItem(record.Key, record)
+
+		if item, err := m.apikeyPolicies.GetItem(old.Key); err == nil {
+			policy := *item.(*entity.APIKeyPolicy)
+			policy.Key = record.Key
+			m.apikeyPolicies.RemoveItem(old.Key)
+			m.apikeyPolicies.StoreItem(record.Key, &policy)
+		}
+		count++
+	}
+
+	if count > 0 {
+		logger.Infof(ctx, "Hashed %d plaintext api keys", count)
+	}
+
+	return count
+}
+
+func copyRecord(r *apikeys.Record) *apikeys.Record {
+	record := *r
+	record.Scope.Projects = append([]string{}, r.Scope.Projects...)
+	record.Scope.Instances = append([]string{}, r.Scope.Instances...)
+	record.Scope.Categories = append([]string{}, r.Scope.Categories...)
+	record.Networks = append([]string{}, r.Networks...)
+	return &record
+}
+
+func (m *AdminMemoryRepository) CreateAPIKeyRecord(ctx context.Context, r *apikeys.Record) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.apikeyMu.Lock()
+	defer m.apikeyMu.Unlock()
+
+	if _, err := m.apikeys.GetItem(r.Key); err == nil {
+		return errs.ErrDBItemInsertFailed
+	}
+
+	m.apikeys.StoreItem(r.Key, copyRecord(r))
+	m.summaries.update(r.UserId, addAPIKeys(1))
+	return nil
+}
+
+func (m *AdminMemoryRepository) GetAPIKeyRecord(ctx context.Context, id string) (*apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.apikeys.GetItem(id)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	return copyRecord(item.(*apikeys.Record)), nil
+}
+
+func (m *AdminMemoryRepository) DisableExpiredAPIKeys(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DisableExpiredAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.apikeyMu.Lock()
+	defer m.apikeyMu.Unlock()
+
+	var count int64
+	for _, item := range m.apikeys.GetItems() {
+		if stored := item.(*apikeys.Record); !stored.Disabled && stored.Expired(now) {
+			record := copyRecord(stored)
+			record.Disabled = true
+			m.apikeys.StoreItem(record.Key, record)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *AdminMemoryRepository) RotateAPIKeyRecord(ctx context.Context, id string, next *apikeys.Record, graceEnds time.Time) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.apikeyMu.Lock()
+	defer m.apikeyMu.Unlock()
+
+	item, err := m.apikeys.GetItem(id)
+	if err != nil {
+		return errs.ErrDBItemNotFound
+	}
+
+	if _, err = m.apikeys.GetItem(next.Key); err == nil {
+		return errs.ErrDBItemInsertFailed
+	}
+
+	record := copyRecord(item.(*apikeys.Record))
+	if err = record.Supersede(next.Key, graceEnds); err != nil {
+		return err
+	}
+
+	m.apikeys.StoreItem(record.Key, record)
+	m.apikeys.StoreItem(next.Key, copyRecord(next))
+	m.summaries.update(next.UserId, addAPIKeys(1))
+
+	if item, err := m.apikeyPolicies.GetItem(id); err == nil {
+		policy := *item.(*entity.APIKeyPolicy)
+		policy.Key = next.Key
+		policy.Updated = next.Created
+		m.apikeyPolicies.StoreItem(next.Key, &policy)
+	}
+
+	return nil
+}
+
+// updateAPIKeyRecord applies update to a copy of the stored key and stores the copy
+func (m *AdminMemoryRepository) updateAPIKeyRecord(id string, update func(r *apikeys.Record)) error {
+
+	m.apikeyMu.Lock()
+	defer m.apikeyMu.Unlock()
+
+	item, err := m.apikeys.GetItem(id)
+	if err != nil {
+		return errs.ErrDBItemNotFound
+	}
+
+	record := copyRecord(item.(*apikeys.Record))
+	update(record)
+	m.apikeys.StoreItem(record.Key, record)
+	return nil
+}
+
+func (m *AdminMemoryRepository) SetAPIKeyNetworks(ctx context.Context, id string, networks []string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetAPIKeyNetworks"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateAPIKeyRecord(id, func(r *apikeys.Record) {
+		r.Networks = append([]string{}, networks...)
+	})
+}
+
+func (m *AdminMemoryRepository) RecordAPIKeyUse(ctx context.Context, id string, usage apikeys.Usage) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RecordAPIKeyUse"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateAPIKeyRecord(id, func(r *apikeys.Record) {
+		r.Usage.Add(usage)
+	})
+}
+
+func (m *AdminMemoryRepository) GetUnusedAPIKeys(ctx context.Context, since time.Time) ([]apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUnusedAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	unused := make([]apikeys.Record, 0)
+	for _, item := range m.apikeys.GetItems() {
+		if record := item.(*apikeys.Record); record.UnusedSince(since) {
+			unused = append(unused, *copyRecord(record))
+		}
+	}
+
+	return unused, nil
+}
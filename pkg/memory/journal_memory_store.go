@@ -0,0 +1,266 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/zbitech/common/pkg/logger"
+	mem "github.com/zbitech/common/pkg/memory"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"github.com/zbitech/repo/pkg/lockout"
+	"github.com/zbitech/repo/pkg/mfa"
+	"github.com/zbitech/repo/pkg/password"
+	"github.com/zbitech/repo/pkg/reset"
+	"github.com/zbitech/repo/pkg/revocation"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+	"github.com/zbitech/repo/pkg/session"
+)
+
+// journalStore wraps a MemoryStore and records changes to an attached Journal. Without a journal it behaves like
+// the MemoryStore it wraps.
+type journalStore struct {
+	*mem.MemoryStore
+	name    string
+	decode  func(data []byte) (interface{}, error)
+	journal *Journal
+	keys    map[string]struct{}
+	mutex   sync.Mutex
+}
+
+func newJournalStore(name string, decode func(data []byte) (interface{}, error)) *journalStore {
+	return &journalStore{
+		MemoryStore: mem.NewMemoryStore(),
+		name:        name,
+		decode:      decode,
+		keys:        make(map[string]struct{}),
+	}
+}
+
+func (s *journalStore) StoreItem(key string, item interface{}) {
+
+	apply := func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.MemoryStore.StoreItem(key, item)
+		s.keys[key] = struct{}{}
+	}
+
+	if s.journal == nil {
+		apply()
+		return
+	}
+
+	s.record(journalEntry{Store: s.name, Op: journalOpStore, Key: key}, item, apply)
+}
+
+func (s *journalStore) RemoveItem(key string) {
+
+	apply := func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.MemoryStore.RemoveItem(key)
+		delete(s.keys, key)
+	}
+
+	if s.journal == nil {
+		apply()
+		return
+	}
+
+	s.record(journalEntry{Store: s.name, Op: journalOpRemove, Key: key}, nil, apply)
+}
+
+func (s *journalStore) clear() {
+
+	apply := func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.removeAll()
+	}
+
+	if s.journal == nil {
+		apply()
+		return
+	}
+
+	s.record(journalEntry{Store: s.name, Op: journalOpClear}, nil, apply)
+}
+
+// record journals a change before applying it. The stores do not return errors, so a change refused by the journal
+// is logged here and the failure is reported by the repository factory.
+func (s *journalStore) record(entry journalEntry, item interface{}, apply func()) {
+	if err := s.journal.record(entry, item, apply); err != nil {
+		logger.Errorf(context.Background(), "Unable to journal %s of %s.%s - %s", entry.Op, entry.Store, entry.Key, err)
+	}
+}
+
+func (s *journalStore) removeAll() {
+	for key := range s.keys {
+		s.MemoryStore.RemoveItem(key)
+	}
+	s.keys = make(map[string]struct{})
+}
+
+func (s *journalStore) items() map[string]interface{} {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	items := make(map[string]interface{}, len(s.keys))
+	for key := range s.keys {
+		if item, err := s.MemoryStore.GetItem(key); err == nil {
+			items[key] = item
+		}
+	}
+
+	return items
+}
+
+func (s *journalStore) restoreItem(key string, data []byte) error {
+
+	item, err := s.decode(data)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.MemoryStore.StoreItem(key, item)
+	s.keys[key] = struct{}{}
+	return nil
+}
+
+func (s *journalStore) replay(entry journalEntry) error {
+
+	switch entry.Op {
+	case journalOpStore:
+		return s.restoreItem(entry.Key, entry.Item)
+
+	case journalOpRemove:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.MemoryStore.RemoveItem(entry.Key)
+		delete(s.keys, entry.Key)
+		return nil
+
+	case journalOpClear:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.removeAll()
+		return nil
+	}
+
+	return fmt.Errorf("unknown journal operation %s", entry.Op)
+}
+
+func decodeUser(data []byte) (interface{}, error) {
+	var item entity.User
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeUserPassword(data []byte) (interface{}, error) {
+	var item entity.UserPassword
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeAPIKey(data []byte) (interface{}, error) {
+	var item apikeys.Record
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeUserPolicy(data []byte) (interface{}, error) {
+	var item entity.UserPolicy
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeInstancePolicy(data []byte) (interface{}, error) {
+	var item entity.InstancePolicy
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeAPIKeyPolicy(data []byte) (interface{}, error) {
+	var item entity.APIKeyPolicy
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeTeam(data []byte) (interface{}, error) {
+	var item entity.Team
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeTeamMember(data []byte) (interface{}, error) {
+	var item entity.TeamMember
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeSession(data []byte) (interface{}, error) {
+	var item session.Session
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeRevokedToken(data []byte) (interface{}, error) {
+	var item revocation.RevokedToken
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeWatermark(data []byte) (interface{}, error) {
+	var item revocation.Watermark
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeAttempts(data []byte) (interface{}, error) {
+	var item lockout.Attempts
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodePasswordHistory(data []byte) (interface{}, error) {
+	var item password.History
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeResetToken(data []byte) (interface{}, error) {
+	var item reset.ResetToken
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeEnrollment(data []byte) (interface{}, error) {
+	var item mfa.Enrollment
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeServiceAccount(data []byte) (interface{}, error) {
+	var item serviceaccount.ServiceAccount
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeProject(data []byte) (interface{}, error) {
+	var item entity.Project
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeInstance(data []byte) (interface{}, error) {
+	var item entity.Instance
+	return &item, json.Unmarshal(data, &item)
+}
+
+func decodeResources(data []byte) (interface{}, error) {
+	items := make([]*entity.KubernetesResource, 0)
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func decodeResourceSummary(data []byte) (interface{}, error) {
+	var item entity.ResourceSummary
+	return &item, json.Unmarshal(data, &item)
+}
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/lockout"
+)
+
+func (m *AdminMemoryRepository) GetAttempts(ctx context.Context, key string) (*lockout.Attempts, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.loginAttempts.GetItem(key)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	attempts := *item.(*lockout.Attempts)
+	return &attempts, nil
+}
+
+func (m *AdminMemoryRepository) PutAttempts(ctx context.Context, attempts *lockout.Attempts) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	stored := *attempts
+	m.loginAttempts.StoreItem(attempts.Key, &stored)
+	return nil
+}
+
+func (m *AdminMemoryRepository) DeleteAttempts(ctx context.Context, key string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if _, err := m.loginAttempts.GetItem(key); err == nil {
+		m.loginAttempts.RemoveItem(key)
+	}
+	return nil
+}
+
+func (m *AdminMemoryRepository) PurgeExpiredAttempts(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	for _, item := range m.loginAttempts.GetItems() {
+		if attempts := item.(*lockout.Attempts); !now.Before(attempts.Expires) {
+			m.loginAttempts.RemoveItem(attempts.Key)
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -2,14 +2,19 @@ package memory
 
 import (
 	"context"
+	"time"
 
 	"github.com/zbitech/common/interfaces"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/seed"
 )
 
 type MemoryRepositoryFactory struct {
-	project interfaces.ProjectRepositoryIF
+	project *ProjectMemoryRepository
 	//	team    interfaces.TeamRepositoryIF
-	admin interfaces.AdminRepositoryIF
+	admin   *AdminMemoryRepository
+	journal *Journal
 }
 
 func NewMemoryRepositoryFactory() interfaces.RepositoryFactoryIF {
@@ -18,6 +23,49 @@ func NewMemoryRepositoryFactory() interfaces.RepositoryFactoryIF {
 
 func (r *MemoryRepositoryFactory) Init(ctx context.Context, create_db, load_db bool) error {
 
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "MemoryRepositoryFactory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	// a journal opened by an earlier Init writes its final snapshot before the stores are restored from it. A failed
+	// journal is closed as well and the stores are restored from what it wrote.
+	if err := r.CloseConnection(ctx); err != nil {
+		logger.Errorf(ctx, "Unable to close memory journal - %s", err)
+	}
+
+	r.project = NewProjectMemoryRepository()
+	r.admin = newAdminMemoryRepository(r.project.summaries)
+
+	persistence, err := ReadPersistenceConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if persistence.Enabled() {
+		journal, err := OpenJournal(ctx, persistence)
+		if err != nil {
+			logger.Errorf(ctx, "Unable to open memory journal - %s", err)
+			return err
+		}
+
+		journal.Attach(r.project.stores()...)
+		journal.Attach(r.admin.stores()...)
+		if err = journal.Restore(ctx); err != nil {
+			logger.Errorf(ctx, "Unable to restore memory repositories - %s", err)
+			journal.file.Close()
+			return err
+		}
+		r.journal = journal
+		r.admin.migrateAPIKeys(ctx)
+		r.journal.Start(ctx)
+	}
+
+	if create_db {
+		logger.Infof(ctx, "Creating memory repositories")
+		if err := r.CreateDatabase(ctx, false, load_db); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -26,22 +74,51 @@ func (r *MemoryRepositoryFactory) OpenConnection(ctx context.Context) error {
 }
 
 func (r *MemoryRepositoryFactory) CloseConnection(ctx context.Context) error {
+	if r.journal != nil {
+		journal := r.journal
+		r.journal = nil
+		return journal.Close(ctx)
+	}
 	return nil
 }
 
-func (r *MemoryRepositoryFactory) GetProjectRepository() interfaces.ProjectRepositoryIF {
+// Err returns ErrJournalFailed once the journal could not record a change. The repositories refuse every change
+// from then on, so the factory should be closed and the service restarted from disk.
+func (r *MemoryRepositoryFactory) Err() error {
+	if r.journal != nil {
+		return r.journal.Err()
+	}
 	return nil
 }
 
+func (r *MemoryRepositoryFactory) GetProjectRepository() interfaces.ProjectRepositoryIF {
+	return r.project
+}
+
 //func (r *MemoryRepositoryFactory) GetTeamRepository() interfaces.TeamRepositoryIF {
 //	return nil
 //}
 
 func (r *MemoryRepositoryFactory) GetAdminRepository() interfaces.AdminRepositoryIF {
-	return nil
+	return r.admin
 }
 
 func (r *MemoryRepositoryFactory) CreateDatabase(ctx context.Context, purge, load bool) error {
 
+	if purge {
+		r.project.PurgeDatabase(ctx)
+		r.admin.PurgeDatabase(ctx)
+	}
+
+	if load {
+		_, err := r.Seed(ctx, false)
+		return err
+	}
+
 	return nil
 }
+
+// Seed adds the missing items of admin.yaml and reports what was created, or would be created in a dry run
+func (r *MemoryRepositoryFactory) Seed(ctx context.Context, dryRun bool) (*seed.Report, error) {
+	return r.admin.LoadDatabase(ctx, dryRun)
+}
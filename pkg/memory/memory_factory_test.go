@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zbitech/common/pkg/model/entity"
+)
+
+func Test_MemoryRepositoryFactory_Init(t *testing.T) {
+
+	ctx := context.Background()
+	factory := NewMemoryRepositoryFactory()
+	if err := factory.Init(ctx, true, true); err != nil {
+		t.Fatalf("Expected factory to initialize but got err - %s", err)
+	}
+
+	if factory.GetProjectRepository() == nil || factory.GetAdminRepository() == nil {
+		t.Fatalf("Expected repositories but got nil")
+	}
+
+	if _, err := factory.GetAdminRepository().GetUser(ctx, "admin"); err != nil {
+		t.Fatalf("Expected admin user but got err - %s", err)
+	}
+}
+
+func Test_MemoryRepositoryFactory_CreateDatabase(t *testing.T) {
+
+	ctx := context.Background()
+	factory := NewMemoryRepositoryFactory()
+	if err := factory.Init(ctx, false, false); err != nil {
+		t.Fatalf("Expected factory to initialize but got err - %s", err)
+	}
+
+	if users := factory.GetAdminRepository().GetUsers(ctx); len(users) != 0 {
+		t.Fatalf("Expected empty repository but got %d users", len(users))
+	}
+
+	if err := factory.CreateDatabase(ctx, false, true); err != nil {
+		t.Fatalf("Expected database to load but got err - %s", err)
+	}
+
+	projRepo := factory.GetProjectRepository()
+	if err := projRepo.CreateProject(ctx, &entity.Project{Name: "project", Owner: "admin"}); err != nil {
+		t.Fatalf("Expected project to be created but got err - %s", err)
+	}
+
+	if err := factory.CreateDatabase(ctx, true, false); err != nil {
+		t.Fatalf("Expected database to purge but got err - %s", err)
+	}
+
+	if users := factory.GetAdminRepository().GetUsers(ctx); len(users) != 0 {
+		t.Fatalf("Expected purged repository but got %d users", len(users))
+	}
+
+	if _, err := projRepo.GetProject(ctx, "project"); err == nil {
+		t.Fatalf("Expected purged project repository")
+	}
+}
@@ -0,0 +1,452 @@
+package memory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
+)
+
+const (
+	JOURNAL_FILE  = "journal.log"
+	SNAPSHOT_FILE = "snapshot.json"
+
+	FSYNC_ALWAYS   = "always"
+	FSYNC_INTERVAL = "interval"
+	FSYNC_NEVER    = "never"
+
+	journalOpStore  = "store"
+	journalOpRemove = "remove"
+	journalOpClear  = "clear"
+)
+
+// PersistenceConfig controls whether the memory repositories are backed by an append-only journal and periodic
+// snapshots in Path. It is read from memory.yaml in the asset directory and persistence is disabled when the file
+// does not exist.
+type PersistenceConfig struct {
+	Path             string
+	Fsync            string
+	SyncInterval     time.Duration
+	SnapshotInterval time.Duration
+}
+
+func (c PersistenceConfig) Enabled() bool {
+	return len(c.Path) > 0
+}
+
+func (c PersistenceConfig) validate() error {
+	switch c.Fsync {
+	case FSYNC_ALWAYS, FSYNC_INTERVAL, FSYNC_NEVER:
+	default:
+		return fmt.Errorf("unknown fsync policy %s", c.Fsync)
+	}
+
+	if c.Fsync == FSYNC_INTERVAL && c.SyncInterval <= 0 {
+		return fmt.Errorf("sync interval is required for fsync policy %s", c.Fsync)
+	}
+
+	return nil
+}
+
+func ReadPersistenceConfig(ctx context.Context) (PersistenceConfig, error) {
+
+	cfg := PersistenceConfig{Fsync: FSYNC_INTERVAL, SyncInterval: time.Second, SnapshotInterval: 5 * time.Minute}
+
+	configPath := fmt.Sprintf("%s/memory.yaml", vars.ASSET_PATH_DIRECTORY)
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		logger.Infof(ctx, "No memory persistence configured at %s", configPath)
+		return PersistenceConfig{}, nil
+	}
+
+	if err := utils.ReadConfig(configPath, nil, &cfg); err != nil {
+		logger.Errorf(ctx, "Unable to read memory persistence config: %s", err)
+		return cfg, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+// ErrJournalFailed is returned once a write to the journal has failed. The stores are no longer changed from then
+// on so they do not diverge from what would be restored from disk.
+var ErrJournalFailed = errors.New("memory journal failed")
+
+type journalEntry struct {
+	Store string          `json:"store"`
+	Op    string          `json:"op"`
+	Key   string          `json:"key,omitempty"`
+	Item  json.RawMessage `json:"item,omitempty"`
+}
+
+// Journal records every change made to the memory stores attached to it so they can be restored on startup from
+// the last snapshot plus the entries written after it.
+type Journal struct {
+	config PersistenceConfig
+	stores map[string]*journalStore
+	file   *os.File
+	writer *bufio.Writer
+	mutex  sync.Mutex
+	done   chan struct{}
+	wg     sync.WaitGroup
+	err    error
+}
+
+func OpenJournal(ctx context.Context, config PersistenceConfig) (*Journal, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "OpenJournal"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(config.Path, 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(config.Path, JOURNAL_FILE), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof(ctx, "Opened memory journal in %s with fsync policy %s", config.Path, config.Fsync)
+
+	return &Journal{
+		config: config,
+		stores: make(map[string]*journalStore),
+		file:   file,
+		writer: bufio.NewWriter(file),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Attach registers stores with the journal. Changes made to them are recorded from then on.
+func (j *Journal) Attach(stores ...*journalStore) {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, store := range stores {
+		store.journal = j
+		j.stores[store.name] = store
+	}
+}
+
+// Restore loads the last snapshot into the attached stores and replays the journal written after it
+func (j *Journal) Restore(ctx context.Context) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "Journal.Restore"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	snapshot := make(map[string]map[string]json.RawMessage)
+	data, err := os.ReadFile(filepath.Join(j.config.Path, SNAPSHOT_FILE))
+	if err == nil {
+		if err = json.Unmarshal(data, &snapshot); err != nil {
+			logger.Errorf(ctx, "Unable to read memory snapshot - %s", err)
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for name, items := range snapshot {
+		store, ok := j.stores[name]
+		if !ok {
+			logger.Errorf(ctx, "Ignoring snapshot of unknown store %s", name)
+			continue
+		}
+
+		for key, data := range items {
+			if err = store.restoreItem(key, data); err != nil {
+				logger.Errorf(ctx, "Unable to restore %s item %s - %s", name, key, err)
+				return err
+			}
+		}
+	}
+
+	if _, err = j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	count := 0
+	scanner := bufio.NewScanner(j.file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a partially written trailing entry is expected after a crash
+			logger.Errorf(ctx, "Discarding unreadable journal entry - %s", err)
+			break
+		}
+
+		store, ok := j.stores[entry.Store]
+		if !ok {
+			logger.Errorf(ctx, "Ignoring journal entry for unknown store %s", entry.Store)
+			continue
+		}
+
+		if err = store.replay(entry); err != nil {
+			logger.Errorf(ctx, "Unable to replay %s entry for %s - %s", entry.Op, entry.Key, err)
+			return err
+		}
+		count++
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	logger.Infof(ctx, "Restored %d stores from snapshot and replayed %d journal entries", len(snapshot), count)
+
+	// fold the replayed entries into a fresh snapshot so a torn trailing entry is not appended to
+	return j.snapshot()
+}
+
+// Start runs the background fsync and snapshot tasks until the journal is closed
+func (j *Journal) Start(ctx context.Context) {
+
+	if j.config.Fsync == FSYNC_INTERVAL {
+		j.run(ctx, j.config.SyncInterval, j.sync)
+	}
+
+	if j.config.SnapshotInterval > 0 {
+		j.run(ctx, j.config.SnapshotInterval, j.Snapshot)
+	}
+}
+
+func (j *Journal) run(ctx context.Context, interval time.Duration, task func() error) {
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := task(); err != nil {
+					logger.Errorf(ctx, "Memory journal task failed - %s", err)
+				}
+			case <-j.done:
+				return
+			}
+		}
+	}()
+}
+
+// Snapshot writes the content of every attached store to disk and truncates the journal
+func (j *Journal) Snapshot() error {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.err != nil {
+		return j.err
+	}
+
+	return j.snapshot()
+}
+
+// Err returns the error that stopped the journal, or nil while writes are still recorded
+func (j *Journal) Err() error {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.err
+}
+
+// fail stops the journal after a failed write. It is called with the mutex held.
+func (j *Journal) fail(err error) error {
+	if j.err == nil {
+		j.err = fmt.Errorf("%w - %s", ErrJournalFailed, err)
+	}
+	return j.err
+}
+
+func (j *Journal) snapshot() error {
+
+	snapshot := make(map[string]map[string]interface{}, len(j.stores))
+	for name, store := range j.stores {
+		snapshot[name] = store.items()
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(j.config.Path, SNAPSHOT_FILE)
+	tmp, err := os.CreateTemp(j.config.Path, SNAPSHOT_FILE+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// the rename is only durable once the directory is synced, and the journal must not be truncated before
+	if err = syncDir(j.config.Path); err != nil {
+		return err
+	}
+
+	j.writer.Reset(j.file)
+	if err = j.file.Truncate(0); err != nil {
+		return err
+	}
+
+	return j.file.Sync()
+}
+
+func syncDir(path string) error {
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	if err = dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+
+	return dir.Close()
+}
+
+func (j *Journal) sync() error {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.err != nil {
+		return j.err
+	}
+
+	if err := j.writer.Flush(); err != nil {
+		return j.fail(err)
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return j.fail(err)
+	}
+
+	return nil
+}
+
+// record appends a change to the journal and then applies it to a store as a single step so snapshots never
+// observe a change that has not been journaled. A change that cannot be journaled is not applied, and after a
+// failed write no further changes are applied.
+func (j *Journal) record(entry journalEntry, item interface{}, apply func()) error {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.err != nil {
+		return j.err
+	}
+
+	data, err := j.encode(entry, item)
+	if err != nil {
+		return err
+	}
+
+	if err = j.append(data); err != nil {
+		return j.fail(err)
+	}
+
+	apply()
+	return nil
+}
+
+func (j *Journal) encode(entry journalEntry, item interface{}) ([]byte, error) {
+
+	if item != nil {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		entry.Item = data
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+func (j *Journal) append(data []byte) error {
+
+	if _, err := j.writer.Write(data); err != nil {
+		return err
+	}
+
+	switch j.config.Fsync {
+	case FSYNC_ALWAYS:
+		if err := j.writer.Flush(); err != nil {
+			return err
+		}
+		return j.file.Sync()
+
+	case FSYNC_NEVER:
+		return j.writer.Flush()
+	}
+
+	return nil
+}
+
+// Close stops the background tasks, writes a final snapshot and closes the journal
+func (j *Journal) Close(ctx context.Context) error {
+
+	close(j.done)
+	j.wg.Wait()
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.err != nil {
+		logger.Errorf(ctx, "Closing failed memory journal without a final snapshot - %s", j.err)
+		j.file.Close()
+		return j.err
+	}
+
+	if err := j.snapshot(); err != nil {
+		logger.Errorf(ctx, "Unable to write final memory snapshot - %s", err)
+		j.file.Close()
+		return err
+	}
+
+	return j.file.Close()
+}
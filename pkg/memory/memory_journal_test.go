@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zbitech/common/pkg/model/entity"
+)
+
+func openTestRepositories(t *testing.T, config PersistenceConfig) (*ProjectMemoryRepository, *AdminMemoryRepository, *Journal) {
+
+	ctx := context.Background()
+	projRepo := NewProjectMemoryRepository()
+	adminRepo := newAdminMemoryRepository(projRepo.summaries)
+
+	journal, err := OpenJournal(ctx, config)
+	if err != nil {
+		t.Fatalf("Expected journal but got err - %s", err)
+	}
+
+	journal.Attach(projRepo.stores()...)
+	journal.Attach(adminRepo.stores()...)
+	if err = journal.Restore(ctx); err != nil {
+		t.Fatalf("Expected journal to be restored but got err - %s", err)
+	}
+
+	return projRepo, adminRepo, journal
+}
+
+func Test_Journal_Restore(t *testing.T) {
+
+	ctx := context.Background()
+	config := PersistenceConfig{Path: t.TempDir(), Fsync: FSYNC_ALWAYS}
+
+	projRepo, adminRepo, journal := openTestRepositories(t, config)
+
+	user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+	pass := entity.NewUserPassword(user.UserId, "password")
+	adminRepo.RegisterUser(ctx, &user, &pass)
+	adminRepo.CreateTeam(ctx, entity.Team{TeamId: "team", Owner: "tester"})
+	projRepo.CreateProject(ctx, &entity.Project{Name: "project", Owner: "tester", TeamId: "team"})
+	projRepo.SaveProjectResource(ctx, "project", &entity.KubernetesResource{Id: "rsc", State: "created"})
+
+	if err := journal.Snapshot(); err != nil {
+		t.Fatalf("Expected snapshot but got err - %s", err)
+	}
+
+	// changes after the snapshot are only in the journal
+	projRepo.CreateInstance(ctx, &entity.Instance{Project: "project", Name: "instance", Owner: "tester"})
+	projRepo.SaveProjectResource(ctx, "project", &entity.KubernetesResource{Id: "rsc", State: "running"})
+	adminRepo.DeleteTeam(ctx, "team")
+	journal.file.Close()
+
+	projRepo, adminRepo, journal = openTestRepositories(t, config)
+	defer journal.Close(ctx)
+
+	if _, err := adminRepo.GetUser(ctx, "tester"); err != nil {
+		t.Fatalf("Expected user to be restored but got err - %s", err)
+	}
+
+	if _, err := adminRepo.GetTeam(ctx, "team"); err == nil {
+		t.Fatalf("Expected deleted team to stay deleted")
+	}
+
+	if _, err := projRepo.GetInstance(ctx, "project", "instance"); err != nil {
+		t.Fatalf("Expected instance to be replayed but got err - %s", err)
+	}
+
+	resources, _ := projRepo.GetProjectResources(ctx, "project")
+	if len(resources) != 1 || resources[0].State != "running" {
+		t.Fatalf("Expected replayed resource state but got %v", resources)
+	}
+
+	summary, _ := projRepo.GetUserSummary(ctx, "tester")
+	if summary.TotalProjects != 1 || summary.TotalInstances != 1 || summary.TotalTeams != 0 {
+		t.Fatalf("Expected restored summary but got %v", summary)
+	}
+}
+
+func Test_Journal_InvalidConfig(t *testing.T) {
+
+	if _, err := OpenJournal(context.Background(), PersistenceConfig{Path: t.TempDir(), Fsync: "sometimes"}); err == nil {
+		t.Fatalf("Expected unknown fsync policy to be rejected")
+	}
+}
+
+func Test_Journal_Failed(t *testing.T) {
+
+	ctx := context.Background()
+	config := PersistenceConfig{Path: t.TempDir(), Fsync: FSYNC_ALWAYS}
+
+	projRepo, adminRepo, journal := openTestRepositories(t, config)
+
+	user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+	pass := entity.NewUserPassword(user.UserId, "password")
+	adminRepo.RegisterUser(ctx, &user, &pass)
+
+	// writes to a closed file fail like writes to a full or failed disk
+	journal.file.Close()
+	projRepo.CreateProject(ctx, &entity.Project{Name: "project", Owner: "tester"})
+
+	if err := journal.Err(); !errors.Is(err, ErrJournalFailed) {
+		t.Fatalf("Expected journal failed error but got %v", err)
+	}
+
+	if _, err := projRepo.GetProject(ctx, "project"); err == nil {
+		t.Fatalf("Expected project that was not journaled to be refused")
+	}
+
+	adminRepo.CreateTeam(ctx, entity.Team{TeamId: "team", Owner: "tester"})
+	if _, err := adminRepo.GetTeam(ctx, "team"); err == nil {
+		t.Fatalf("Expected writes after a failed write to be refused")
+	}
+
+	if err := journal.Snapshot(); !errors.Is(err, ErrJournalFailed) {
+		t.Fatalf("Expected snapshot to report the journal failure but got %v", err)
+	}
+
+	if err := journal.Close(ctx); !errors.Is(err, ErrJournalFailed) {
+		t.Fatalf("Expected close to report the journal failure but got %v", err)
+	}
+
+	_, adminRepo, journal = openTestRepositories(t, config)
+	defer journal.Close(ctx)
+
+	if _, err := adminRepo.GetUser(ctx, "tester"); err != nil {
+		t.Fatalf("Expected user journaled before the failure to be restored but got err - %s", err)
+	}
+}
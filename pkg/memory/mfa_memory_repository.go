@@ -0,0 +1,35 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/mfa"
+)
+
+func (m *AdminMemoryRepository) GetMFA(ctx context.Context, userId string) (*mfa.Enrollment, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.mfa.GetItem(userId)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	e := *item.(*mfa.Enrollment)
+	e.RecoveryCodes = append([]string{}, e.RecoveryCodes...)
+	return &e, nil
+}
+
+func (m *AdminMemoryRepository) PutMFA(ctx context.Context, e *mfa.Enrollment) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	stored := *e
+	stored.RecoveryCodes = append([]string{}, e.RecoveryCodes...)
+	m.mfa.StoreItem(e.UserId, &stored)
+	return nil
+}
@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/password"
+)
+
+func (m *AdminMemoryRepository) GetPassword(ctx context.Context, userId string) (*entity.UserPassword, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetPassword"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.passwords.GetItem(userId)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	pass := *item.(*entity.UserPassword)
+	return &pass, nil
+}
+
+func (m *AdminMemoryRepository) GetPasswordHistory(ctx context.Context, userId string) (*password.History, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetPasswordHistory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.passwordHistory.GetItem(userId)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	history := *item.(*password.History)
+	history.Hashes = append([]string{}, history.Hashes...)
+	return &history, nil
+}
+
+func (m *AdminMemoryRepository) PutPasswordHistory(ctx context.Context, history *password.History) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutPasswordHistory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	stored := *history
+	stored.Hashes = append([]string{}, history.Hashes...)
+	m.passwordHistory.StoreItem(history.UserId, &stored)
+	return nil
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/zbitech/common/pkg/errs"
-	mem "github.com/zbitech/common/pkg/memory"
+	"sync"
 	"time"
 
 	"github.com/zbitech/common/pkg/logger"
@@ -13,18 +13,19 @@ import (
 )
 
 type ProjectMemoryRepository struct {
-	projects  *mem.MemoryStore
-	instances *mem.MemoryStore
-	resources *mem.MemoryStore
-	summaries *mem.MemoryStore
+	projects  *journalStore
+	instances *journalStore
+	resources *journalStore
+	summaries *resourceSummaries
+	mutex     sync.Mutex
 }
 
 func NewProjectMemoryRepository() *ProjectMemoryRepository {
 	return &ProjectMemoryRepository{
-		projects:  mem.NewMemoryStore(),
-		instances: mem.NewMemoryStore(),
-		resources: mem.NewMemoryStore(),
-		summaries: mem.NewMemoryStore(),
+		projects:  newJournalStore("projects", decodeProject),
+		instances: newJournalStore("instances", decodeInstance),
+		resources: newJournalStore("k8s_resources", decodeResources),
+		summaries: newResourceSummaries(),
 	}
 }
 
@@ -48,10 +49,14 @@ func (m *ProjectMemoryRepository) CreateProject(ctx context.Context, project *en
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateProject"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	_, err := m.projects.GetItem(project.Name)
 	if err != nil {
 		m.projects.StoreItem(project.Name, project)
 		m.resources.StoreItem(project.Name, make([]*entity.KubernetesResource, 0))
+		m.summaries.update(project.Owner, addProjects(1))
 		return nil
 	}
 
@@ -63,6 +68,20 @@ func (m *ProjectMemoryRepository) UpdateProject(ctx context.Context, project *en
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdateProject"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	item, err := m.projects.GetItem(project.Name)
+	if err != nil {
+		logger.Errorf(ctx, "Project update failed - %s", err)
+		return errs.ErrDBItemUpdateFailed
+	}
+
+	if current := item.(*entity.Project); current.Owner != project.Owner {
+		m.summaries.update(current.Owner, addProjects(-1))
+		m.summaries.update(project.Owner, addProjects(1))
+	}
+
 	m.projects.StoreItem(project.Name, project)
 	return nil
 }
@@ -151,11 +170,15 @@ func (m *ProjectMemoryRepository) CreateInstance(ctx context.Context, instance *
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateInstance"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	key := fmt.Sprintf("%s-%s", instance.Project, instance.Name)
 	_, err := m.instances.GetItem(key)
 	if err != nil {
 		m.instances.StoreItem(key, instance)
 		m.resources.StoreItem(key, make([]*entity.KubernetesResource, 0))
+		m.summaries.update(instance.Owner, addInstances(1))
 		return nil
 	}
 
@@ -224,7 +247,7 @@ func (m *ProjectMemoryRepository) UpdateInstanceStatus(ctx context.Context, proj
 	instance.Status = status
 	instance.Timestamp = time.Now()
 
-	m.projects.StoreItem(key, project)
+	m.instances.StoreItem(key, instance)
 
 	return nil
 }
@@ -234,20 +257,7 @@ func (m *ProjectMemoryRepository) GetProjectResources(ctx context.Context, proje
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectResources"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	items, err := m.resources.GetItem(project)
-	if err != nil {
-		logger.Errorf(ctx, "No resources found - %s", err)
-		return []entity.KubernetesResource{}, nil
-	}
-
-	array := items.([]interface{})
-	projRsc := make([]entity.KubernetesResource, 0)
-	for _, item := range array {
-		rsc := item.(*entity.KubernetesResource)
-		projRsc = append(projRsc, *rsc)
-	}
-
-	return projRsc, nil
+	return m.getResources(ctx, project), nil
 }
 
 func (m *ProjectMemoryRepository) SaveProjectResource(ctx context.Context, project string, resource *entity.KubernetesResource) error {
@@ -255,23 +265,12 @@ func (m *ProjectMemoryRepository) SaveProjectResource(ctx context.Context, proje
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveProjectResource"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	items, err := m.resources.GetItem(project)
-	if err != nil {
-		logger.Errorf(ctx, "No resources found - %s", err)
-		return nil
-	}
-
-	array := items.([]interface{})
-	for _, item := range array {
-		rsc := item.(*entity.KubernetesResource)
-		if rsc.Id == resource.Id {
-			rsc.State = resource.State
-			rsc.Timestamp = resource.Timestamp
-			return nil
-		}
+	if _, err := m.projects.GetItem(project); err != nil {
+		logger.Errorf(ctx, "No project found - %s", err)
+		return errs.ErrDBItemNotFound
 	}
 
-	m.resources.AddItem(project, resource)
+	m.saveResource(project, resource)
 	return nil
 }
 
@@ -295,20 +294,7 @@ func (m *ProjectMemoryRepository) GetInstanceResources(ctx context.Context, proj
 	defer logger.LogComponentTime(ctx)
 
 	key := fmt.Sprintf("%s-%s", project, instance)
-	items, err := m.resources.GetItem(key)
-	if err != nil {
-		logger.Errorf(ctx, "No resources found - %s", err)
-		return []entity.KubernetesResource{}, nil
-	}
-
-	array := items.([]interface{})
-	resources := make([]entity.KubernetesResource, 0)
-	for _, item := range array {
-		rsc := item.(*entity.KubernetesResource)
-		resources = append(resources, *rsc)
-	}
-
-	return resources, nil
+	return m.getResources(ctx, key), nil
 }
 
 func (m *ProjectMemoryRepository) SaveInstanceResource(ctx context.Context, project, instance string, resource *entity.KubernetesResource) error {
@@ -317,23 +303,12 @@ func (m *ProjectMemoryRepository) SaveInstanceResource(ctx context.Context, proj
 	defer logger.LogComponentTime(ctx)
 
 	key := fmt.Sprintf("%s-%s", project, instance)
-	items, err := m.resources.GetItem(key)
-	if err != nil {
-		logger.Errorf(ctx, "No resources found - %s", err)
-		return nil
-	}
-
-	array := items.([]interface{})
-	for _, item := range array {
-		rsc := item.(*entity.KubernetesResource)
-		if rsc.Id == resource.Id {
-			rsc.State = resource.State
-			rsc.Timestamp = resource.Timestamp
-			return nil
-		}
+	if _, err := m.instances.GetItem(key); err != nil {
+		logger.Errorf(ctx, "No instance found - %s", err)
+		return errs.ErrDBItemNotFound
 	}
 
-	m.resources.AddItem(project, key)
+	m.saveResource(key, resource)
 	return nil
 }
 
@@ -356,5 +331,68 @@ func (m *ProjectMemoryRepository) GetUserSummary(ctx context.Context, userId str
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUserSummary"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	return nil, nil
+	summary := m.summaries.get(userId)
+	return &summary, nil
+}
+
+// PurgeDatabase removes all items from the repository
+func (m *ProjectMemoryRepository) PurgeDatabase(ctx context.Context) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeDatabase"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.projects.clear()
+	m.instances.clear()
+	m.resources.clear()
+	m.summaries.purge()
+}
+
+func (m *ProjectMemoryRepository) stores() []*journalStore {
+	return []*journalStore{m.projects, m.instances, m.resources, m.summaries.store}
+}
+
+func (m *ProjectMemoryRepository) getResources(ctx context.Context, key string) []entity.KubernetesResource {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	resources := make([]entity.KubernetesResource, 0)
+	item, err := m.resources.GetItem(key)
+	if err != nil {
+		logger.Errorf(ctx, "No resources found - %s", err)
+		return resources
+	}
+
+	for _, rsc := range item.([]*entity.KubernetesResource) {
+		resources = append(resources, *rsc)
+	}
+
+	return resources
+}
+
+// saveResource updates the state of a known resource or appends a copy of a new one to the list stored under key
+func (m *ProjectMemoryRepository) saveResource(key string, resource *entity.KubernetesResource) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	resources := make([]*entity.KubernetesResource, 0)
+	if item, err := m.resources.GetItem(key); err == nil {
+		resources = item.([]*entity.KubernetesResource)
+	}
+
+	for _, rsc := range resources {
+		if rsc.Id == resource.Id {
+			rsc.State = resource.State
+			rsc.Timestamp = resource.Timestamp
+			m.resources.StoreItem(key, resources)
+			return
+		}
+	}
+
+	rsc := *resource
+	m.resources.StoreItem(key, append(resources, &rsc))
 }
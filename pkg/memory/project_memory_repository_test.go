@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/utils"
+)
+
+func Test_GetUserSummary(t *testing.T) {
+
+	ctx := context.Background()
+	factory := NewMemoryRepositoryFactory()
+	if err := factory.Init(ctx, false, false); err != nil {
+		t.Fatalf("Expected factory to initialize but got err - %s", err)
+	}
+
+	projRepo := factory.GetProjectRepository()
+	adminRepo := factory.GetAdminRepository()
+
+	summary, err := projRepo.GetUserSummary(ctx, "owner")
+	if err != nil || summary == nil {
+		t.Fatalf("Expected empty summary but got %v - %s", summary, err)
+	}
+
+	projRepo.CreateProject(ctx, &entity.Project{Name: "project", Owner: "owner"})
+	projRepo.CreateInstance(ctx, &entity.Instance{Project: "project", Name: "instance", Owner: "owner"})
+	adminRepo.CreateTeam(ctx, entity.Team{TeamId: "team", Owner: "owner"})
+	apikey, _ := adminRepo.CreateAPIKey(ctx, "owner")
+
+	summary, _ = projRepo.GetUserSummary(ctx, "owner")
+	if summary.TotalProjects != 1 || summary.TotalInstances != 1 || summary.TotalTeams != 1 || summary.TotalAPIKeys != 1 {
+		t.Fatalf("Expected one of each resource but got %s", utils.MarshalObject(summary))
+	}
+
+	adminRepo.DeleteTeam(ctx, "team")
+	adminRepo.DeleteAPIKey(ctx, apikey.Key)
+
+	summary, _ = projRepo.GetUserSummary(ctx, "owner")
+	if summary.TotalTeams != 0 || summary.TotalAPIKeys != 0 {
+		t.Fatalf("Expected deleted resources to be removed from summary but got %s", utils.MarshalObject(summary))
+	}
+}
+
+func Test_CreateConcurrently(t *testing.T) {
+
+	ctx := context.Background()
+	projRepo := NewProjectMemoryRepository()
+	adminRepo := newAdminMemoryRepository(projRepo.summaries)
+
+	var wg sync.WaitGroup
+	var created int32
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if projRepo.CreateProject(ctx, &entity.Project{Name: "project", Owner: "tester"}) == nil {
+				atomic.AddInt32(&created, 1)
+			}
+			projRepo.CreateInstance(ctx, &entity.Instance{Project: "project", Name: "instance", Owner: "tester"})
+			adminRepo.CreateTeam(ctx, entity.Team{TeamId: "team", Owner: "tester"})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatalf("Expected the project to be created once but got %d", created)
+	}
+
+	summary, _ := projRepo.GetUserSummary(ctx, "tester")
+	if summary.TotalProjects != 1 || summary.TotalInstances != 1 || summary.TotalTeams != 1 {
+		t.Fatalf("Expected one project, instance and team to be counted but got %v", summary)
+	}
+}
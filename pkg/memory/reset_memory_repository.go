@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/reset"
+)
+
+func (m *AdminMemoryRepository) CreateResetToken(ctx context.Context, t *reset.ResetToken) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.resetMu.Lock()
+	defer m.resetMu.Unlock()
+
+	for _, item := range m.resetTokens.GetItems() {
+		if stored := item.(*reset.ResetToken); stored.UserId == t.UserId {
+			m.resetTokens.RemoveItem(stored.Hash)
+		}
+	}
+
+	stored := *t
+	m.resetTokens.StoreItem(t.Hash, &stored)
+	return nil
+}
+
+func (m *AdminMemoryRepository) GetResetToken(ctx context.Context, hash string) (*reset.ResetToken, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.resetTokens.GetItem(hash)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	t := *item.(*reset.ResetToken)
+	return &t, nil
+}
+
+func (m *AdminMemoryRepository) ConsumeResetToken(ctx context.Context, hash string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ConsumeResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.resetMu.Lock()
+	defer m.resetMu.Unlock()
+
+	if _, err := m.resetTokens.GetItem(hash); err != nil {
+		return errs.ErrDBItemNotFound
+	}
+
+	m.resetTokens.RemoveItem(hash)
+	return nil
+}
+
+func (m *AdminMemoryRepository) PurgeExpiredResetTokens(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredResetTokens"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.resetMu.Lock()
+	defer m.resetMu.Unlock()
+
+	var count int64
+	for _, item := range m.resetTokens.GetItems() {
+		if t := item.(*reset.ResetToken); t.Expired(now) {
+			m.resetTokens.RemoveItem(t.Hash)
+			count++
+		}
+	}
+
+	return count, nil
+}
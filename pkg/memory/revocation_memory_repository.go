@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/revocation"
+)
+
+func (m *AdminMemoryRepository) RevokeToken(ctx context.Context, token *revocation.RevokedToken) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RevokeToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	stored := *token
+	m.revokedTokens.StoreItem(token.Id, &stored)
+	return nil
+}
+
+func (m *AdminMemoryRepository) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "IsTokenRevoked"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	_, err := m.revokedTokens.GetItem(tokenId)
+	return err == nil, nil
+}
+
+func (m *AdminMemoryRepository) SetWatermark(ctx context.Context, w *revocation.Watermark) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetWatermark"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	stored := *w
+	m.watermarks.StoreItem(w.UserId, &stored)
+	return nil
+}
+
+func (m *AdminMemoryRepository) GetWatermark(ctx context.Context, userId string) (*revocation.Watermark, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetWatermark"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.watermarks.GetItem(userId)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	w := *item.(*revocation.Watermark)
+	return &w, nil
+}
+
+func (m *AdminMemoryRepository) PurgeExpiredRevocations(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredRevocations"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	for _, item := range m.revokedTokens.GetItems() {
+		if token := item.(*revocation.RevokedToken); !now.Before(token.Expires) {
+			m.revokedTokens.RemoveItem(token.Id)
+			count++
+		}
+	}
+
+	for _, item := range m.watermarks.GetItems() {
+		if w := item.(*revocation.Watermark); !now.Before(w.Expires) {
+			m.watermarks.RemoveItem(w.UserId)
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/apikeys"
+)
+
+func (m *AdminMemoryRepository) SeedUser(ctx context.Context, user *entity.User, pass *entity.UserPassword, policy *entity.UserPolicy, dryRun bool) (*entity.User, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.userMu.Lock()
+	defer m.userMu.Unlock()
+
+	if item, err := m.users.GetItem(user.UserId); err == nil {
+		return item.(*entity.User), nil
+	}
+
+	if !dryRun {
+		m.users.StoreItem(user.UserId, user)
+		if _, err := m.passwords.GetItem(user.UserId); err != nil {
+			m.passwords.StoreItem(user.UserId, pass)
+		}
+		if _, err := m.userPolicies.GetItem(user.UserId); err != nil {
+			m.userPolicies.StoreItem(user.UserId, policy)
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *AdminMemoryRepository) SeedAPIKey(ctx context.Context, apikey *apikeys.Record, policy *entity.APIKeyPolicy, dryRun bool) (*apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if item, err := m.apikeys.GetItem(apikey.Key); err == nil {
+		return item.(*apikeys.Record), nil
+	}
+
+	if !dryRun {
+		m.apikeys.StoreItem(apikey.Key, apikey)
+		m.summaries.update(apikey.UserId, addAPIKeys(1))
+		if _, err := m.apikeyPolicies.GetItem(apikey.Key); err != nil {
+			m.apikeyPolicies.StoreItem(apikey.Key, policy)
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *AdminMemoryRepository) SeedTeam(ctx context.Context, team *entity.Team, dryRun bool) (*entity.Team, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.teamMu.Lock()
+	defer m.teamMu.Unlock()
+
+	if item, err := m.teams.GetItem(team.TeamId); err == nil {
+		return item.(*entity.Team), nil
+	}
+
+	if !dryRun {
+		m.teams.StoreItem(team.TeamId, team)
+		m.summaries.update(team.Owner, addTeams(1))
+	}
+
+	return nil, nil
+}
+
+func (m *AdminMemoryRepository) SeedTeamMember(ctx context.Context, member *entity.TeamMember, dryRun bool) (*entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedTeamMember"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if item, err := m.members.GetItem(member.Key); err == nil {
+		return item.(*entity.TeamMember), nil
+	}
+
+	if !dryRun {
+		m.members.StoreItem(member.Key, member)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+)
+
+func (m *AdminMemoryRepository) CreateServiceAccount(ctx context.Context, sa *serviceaccount.ServiceAccount, user *entity.User) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.serviceAccountMu.Lock()
+	defer m.serviceAccountMu.Unlock()
+	m.userMu.Lock()
+	defer m.userMu.Unlock()
+
+	if _, err := m.users.GetItem(user.UserId); err == nil {
+		return errs.ErrUserAlreadyExists
+	}
+
+	if _, err := m.serviceAccounts.GetItem(sa.Id); err == nil {
+		return errs.ErrUserAlreadyExists
+	}
+
+	stored := *user
+	stored.Memberships = append([]entity.UserTeam{}, user.Memberships...)
+	m.users.StoreItem(user.UserId, &stored)
+
+	account := *sa
+	m.serviceAccounts.StoreItem(sa.Id, &account)
+	m.members.StoreItem(sa.Id, sa.TeamMember())
+	return nil
+}
+
+func (m *AdminMemoryRepository) GetServiceAccount(ctx context.Context, id string) (*serviceaccount.ServiceAccount, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.serviceAccounts.GetItem(id)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	sa := *item.(*serviceaccount.ServiceAccount)
+	return &sa, nil
+}
+
+func (m *AdminMemoryRepository) GetServiceAccounts(ctx context.Context, teamId string) ([]serviceaccount.ServiceAccount, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetServiceAccounts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	accounts := make([]serviceaccount.ServiceAccount, 0)
+	for _, item := range m.serviceAccounts.GetItems() {
+		if sa := item.(*serviceaccount.ServiceAccount); sa.TeamId == teamId {
+			accounts = append(accounts, *sa)
+		}
+	}
+
+	return accounts, nil
+}
+
+func (m *AdminMemoryRepository) DeleteServiceAccount(ctx context.Context, id string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.serviceAccountMu.Lock()
+	defer m.serviceAccountMu.Unlock()
+
+	if _, err := m.serviceAccounts.GetItem(id); err != nil {
+		return errs.ErrDBItemNotFound
+	}
+
+	m.serviceAccounts.RemoveItem(id)
+	m.users.RemoveItem(id)
+	m.userPolicies.RemoveItem(id)
+	m.members.RemoveItem(id)
+	return nil
+}
@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/session"
+)
+
+func (m *AdminMemoryRepository) getSession(sessionId string) (*session.Session, error) {
+	item, err := m.sessions.GetItem(sessionId)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	s := *item.(*session.Session)
+	return &s, nil
+}
+
+func (m *AdminMemoryRepository) CreateSession(ctx context.Context, s *session.Session) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.sessionMu.Lock()
+	defer m.sessionMu.Unlock()
+
+	for _, item := range m.sessions.GetItems() {
+		stored := item.(*session.Session)
+		if stored.UserId == s.UserId && stored.Device == s.Device {
+			m.sessions.RemoveItem(stored.Id)
+		}
+	}
+
+	stored := *s
+	m.sessions.StoreItem(s.Id, &stored)
+	return nil
+}
+
+func (m *AdminMemoryRepository) GetSession(ctx context.Context, sessionId string) (*session.Session, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.getSession(sessionId)
+}
+
+func (m *AdminMemoryRepository) RotateSession(ctx context.Context, tokenHash string, next *session.Session) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.sessionMu.Lock()
+	defer m.sessionMu.Unlock()
+
+	s, err := m.getSession(next.Id)
+	if err != nil {
+		return err
+	}
+
+	if s.TokenHash != tokenHash {
+		return session.ErrRefreshTokenReused
+	}
+
+	stored := *next
+	m.sessions.StoreItem(next.Id, &stored)
+	return nil
+}
+
+func (m *AdminMemoryRepository) GetSessions(ctx context.Context, userId string) ([]session.Session, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	sessions := make([]session.Session, 0)
+	for _, item := range m.sessions.GetItems() {
+		s := item.(*session.Session)
+		if s.UserId == userId {
+			sessions = append(sessions, *s)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (m *AdminMemoryRepository) DeleteSession(ctx context.Context, sessionId string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	m.sessionMu.Lock()
+	defer m.sessionMu.Unlock()
+
+	if _, err := m.sessions.GetItem(sessionId); err != nil {
+		return errs.ErrDBItemNotFound
+	}
+
+	m.sessions.RemoveItem(sessionId)
+	return nil
+}
+
+func (m *AdminMemoryRepository) deleteSessions(match func(s *session.Session) bool) int64 {
+	m.sessionMu.Lock()
+	defer m.sessionMu.Unlock()
+
+	var count int64
+	for _, item := range m.sessions.GetItems() {
+		s := item.(*session.Session)
+		if match(s) {
+			m.sessions.RemoveItem(s.Id)
+			count++
+		}
+	}
+
+	return count
+}
+
+func (m *AdminMemoryRepository) DeleteSessions(ctx context.Context, userId string) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.deleteSessions(func(s *session.Session) bool { return s.UserId == userId }), nil
+}
+
+func (m *AdminMemoryRepository) PurgeExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.deleteSessions(func(s *session.Session) bool { return s.Expired(now) }), nil
+}
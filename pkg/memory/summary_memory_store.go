@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/zbitech/common/pkg/model/entity"
+)
+
+// resourceSummaries keeps a running count of the resources owned by each user. It is shared by the admin and
+// project repositories so the counters can be maintained as items are created and deleted.
+type resourceSummaries struct {
+	store *journalStore
+	mutex sync.Mutex
+}
+
+func newResourceSummaries() *resourceSummaries {
+	return &resourceSummaries{store: newJournalStore("summaries", decodeResourceSummary)}
+}
+
+func (s *resourceSummaries) get(owner string) entity.ResourceSummary {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	item, err := s.store.GetItem(owner)
+	if err != nil {
+		return entity.ResourceSummary{}
+	}
+
+	return *item.(*entity.ResourceSummary)
+}
+
+func (s *resourceSummaries) update(owner string, change func(summary *entity.ResourceSummary)) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// the stored summary may be marshalled by a journal snapshot, so it is replaced rather than changed
+	summary := entity.ResourceSummary{}
+	if item, err := s.store.GetItem(owner); err == nil {
+		summary = *item.(*entity.ResourceSummary)
+	}
+
+	change(&summary)
+	s.store.StoreItem(owner, &summary)
+}
+
+func (s *resourceSummaries) purge() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.store.clear()
+}
+
+func addAPIKeys(count int) func(summary *entity.ResourceSummary) {
+	return func(summary *entity.ResourceSummary) { summary.TotalAPIKeys += count }
+}
+
+func addProjects(count int) func(summary *entity.ResourceSummary) {
+	return func(summary *entity.ResourceSummary) { summary.TotalProjects += count }
+}
+
+func addInstances(count int) func(summary *entity.ResourceSummary) {
+	return func(summary *entity.ResourceSummary) { summary.TotalInstances += count }
+}
+
+func addTeams(count int) func(summary *entity.ResourceSummary) {
+	return func(summary *entity.ResourceSummary) { summary.TotalTeams += count }
+}
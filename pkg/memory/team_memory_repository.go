@@ -52,12 +52,16 @@ func (m *AdminMemoryRepository) CreateTeam(ctx context.Context, team entity.Team
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateTeam"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	m.teamMu.Lock()
+	defer m.teamMu.Unlock()
+
 	_, err := m.teams.GetItem(team.TeamId)
-	if err != nil {
+	if err == nil {
 		return nil
 	}
 
 	m.teams.StoreItem(team.TeamId, &team)
+	m.summaries.update(team.Owner, addTeams(1))
 	return nil
 }
 
@@ -132,7 +136,7 @@ func (m *AdminMemoryRepository) AddTeamMember(ctx context.Context, teamId string
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AddTeamMembers"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	m.members.StoreItem(member.Key, member)
+	m.members.StoreItem(member.Key, &member)
 	return nil
 }
 
@@ -171,7 +175,7 @@ func (m *AdminMemoryRepository) UpdateTeamMemberEmail(ctx context.Context, teamI
 	member := item.(*entity.TeamMember)
 	member.Email = email
 
-	m.members.StoreItem(key, m)
+	m.members.StoreItem(key, member)
 	return nil
 }
 
@@ -188,7 +192,7 @@ func (m *AdminMemoryRepository) UpdateTeamMemberRole(ctx context.Context, teamId
 	member := item.(*entity.TeamMember)
 	member.Role = role
 
-	m.members.StoreItem(key, m)
+	m.members.StoreItem(key, member)
 	return nil
 }
 
@@ -205,7 +209,7 @@ func (m *AdminMemoryRepository) UpdateTeamMemberStatus(ctx context.Context, team
 	member := item.(*entity.TeamMember)
 	member.Status = status
 
-	m.members.StoreItem(key, m)
+	m.members.StoreItem(key, member)
 	return nil
 }
 
@@ -214,7 +218,19 @@ func (m *AdminMemoryRepository) UpdateTeam(ctx context.Context, team entity.Team
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdateTeam"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	m.teams.StoreItem(team.TeamId, team)
+	m.teamMu.Lock()
+	defer m.teamMu.Unlock()
+
+	if item, err := m.teams.GetItem(team.TeamId); err == nil {
+		if current := item.(*entity.Team); current.Owner != team.Owner {
+			m.summaries.update(current.Owner, addTeams(-1))
+			m.summaries.update(team.Owner, addTeams(1))
+		}
+	} else {
+		m.summaries.update(team.Owner, addTeams(1))
+	}
+
+	m.teams.StoreItem(team.TeamId, &team)
 	return nil
 }
 
@@ -223,7 +239,16 @@ func (m *AdminMemoryRepository) DeleteTeam(ctx context.Context, teamId string) e
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteTeam"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
+	m.teamMu.Lock()
+	defer m.teamMu.Unlock()
+
+	item, err := m.teams.GetItem(teamId)
+	if err != nil {
+		return err
+	}
+
 	m.teams.RemoveItem(teamId)
+	m.summaries.update(item.(*entity.Team).Owner, addTeams(-1))
 	return nil
 }
 
@@ -265,7 +290,7 @@ func (m *AdminMemoryRepository) GetTeamMembership(ctx context.Context, key strin
 
 	item, err := m.members.GetItem(key)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
 	return item.(*entity.TeamMember), nil
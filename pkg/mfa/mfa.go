@@ -0,0 +1,338 @@
+package mfa
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zbitech/common/pkg/model/ztypes"
+)
+
+const (
+	DEFAULT_ISSUER             = "ZBI"
+	DEFAULT_CHALLENGE_LIFETIME = 5 * time.Minute
+	DEFAULT_RECOVERY_CODES     = 10
+
+	keySize          = 32
+	recoveryCodeSize = 10
+)
+
+var (
+	ErrMFARequired           = errors.New("a second factor is required")
+	ErrMFAEnrollmentRequired = errors.New("multi-factor authentication must be set up")
+	ErrMFANotEnrolled        = errors.New("multi-factor authentication is not set up")
+	ErrMFAAlreadyEnrolled    = errors.New("multi-factor authentication is already set up")
+	ErrInvalidCode           = errors.New("invalid authentication code")
+	ErrInvalidChallenge      = errors.New("invalid or expired authentication challenge")
+	ErrInvalidSecret         = errors.New("invalid totp secret")
+	ErrMFAUnavailable        = errors.New("multi-factor authentication is not configured")
+	ErrMFAUnsupported        = errors.New("repository does not store multi-factor enrollments")
+	ErrInvalidConfig         = errors.New("invalid mfa config")
+)
+
+// ChallengeError is returned when the password was correct but a second factor is needed. It wraps
+// ErrMFARequired, or ErrMFAEnrollmentRequired when the user must set up MFA first, and carries the challenge that
+// completes the login together with a code.
+type ChallengeError struct {
+	Err       error
+	Challenge string
+	Expires   time.Time
+}
+
+func (e *ChallengeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ChallengeError) Unwrap() error {
+	return e.Err
+}
+
+// Enrollment is the TOTP factor of a user. The secret is encrypted and only hashes of the recovery codes are kept.
+// LastStep is the last time step accepted so codes cannot be replayed. Required is kept when the factor is
+// removed, so the user has to enroll again.
+type Enrollment struct {
+	UserId        string    `json:"userid" bson:"_id"`
+	Secret        string    `json:"secret" bson:"secret"`
+	Confirmed     bool      `json:"confirmed" bson:"confirmed"`
+	RecoveryCodes []string  `json:"recoverycodes" bson:"recoverycodes"`
+	LastStep      int64     `json:"laststep" bson:"laststep"`
+	Required      bool      `json:"required" bson:"required"`
+	Updated       time.Time `json:"updated" bson:"updated"`
+}
+
+// Active reports whether logins need a code from this factor
+func (e *Enrollment) Active() bool {
+	return e.Confirmed && len(e.Secret) > 0
+}
+
+// Setup is returned once when a user enrolls. The secret and recovery codes cannot be read again.
+type Setup struct {
+	Secret        string
+	URI           string
+	RecoveryCodes []string
+}
+
+type Status struct {
+	Enrolled      bool
+	Required      bool
+	RecoveryCodes int
+}
+
+// Store keeps enrollments. Missing enrollments are reported with errs.ErrDBItemNotFound.
+type Store interface {
+	GetMFA(ctx context.Context, userId string) (*Enrollment, error)
+	PutMFA(ctx context.Context, e *Enrollment) error
+}
+
+// Service is implemented by IAM services with multi-factor authentication. When a login needs a second factor,
+// AuthenticateUser returns a ChallengeError and VerifyMFA exchanges its challenge and a code for the token.
+type Service interface {
+	// EnrollMFA starts setting up a factor for the user. It is confirmed with a code by ConfirmMFA or VerifyMFA.
+	EnrollMFA(ctx context.Context, userId string) (*Setup, error)
+	ConfirmMFA(ctx context.Context, userId, code string) error
+	DisableMFA(ctx context.Context, userId string) error
+	SetMFARequired(ctx context.Context, userId string, required bool) error
+	GetMFAStatus(ctx context.Context, userId string) (*Status, error)
+	RegenerateRecoveryCodes(ctx context.Context, userId string) ([]string, error)
+	// ChallengeUser returns the user of a challenge, so the API can let the user enroll during login
+	ChallengeUser(ctx context.Context, challenge string) (string, error)
+	// VerifyMFA checks a TOTP or recovery code for the challenge and returns an access token
+	VerifyMFA(ctx context.Context, challenge, code string) (*string, error)
+}
+
+// Policy sets who must use MFA. Keys is nil when no key is configured, in which case users cannot enroll.
+type Policy struct {
+	Keys              *Keys
+	Issuer            string
+	RequiredRoles     map[ztypes.Role]bool
+	ChallengeLifetime time.Duration
+	RecoveryCodes     int
+}
+
+func DefaultPolicy() Policy {
+	return Policy{Issuer: DEFAULT_ISSUER, RequiredRoles: map[ztypes.Role]bool{}, ChallengeLifetime: DEFAULT_CHALLENGE_LIFETIME,
+		RecoveryCodes: DEFAULT_RECOVERY_CODES}
+}
+
+// Required reports whether the user must use MFA, either by role or by the flag on the enrollment
+func (p Policy) Required(role ztypes.Role, e *Enrollment) bool {
+	return p.RequiredRoles[role] || (e != nil && e.Required)
+}
+
+// Config is the mfa section of iam.yaml. The key encrypts the TOTP secrets and signs login challenges. It is 32
+// bytes in base64, inline or in keyfile, and must not change while users are enrolled.
+type Config struct {
+	Key               string
+	KeyFile           string
+	Issuer            string
+	RequiredRoles     []string
+	ChallengeLifetime string
+	RecoveryCodes     int
+}
+
+func (c Config) Policy() (Policy, error) {
+
+	policy := DefaultPolicy()
+
+	encoded := c.Key
+	if len(c.KeyFile) > 0 {
+		data, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return policy, fmt.Errorf("%w - %s", ErrInvalidConfig, err)
+		}
+		encoded = string(data)
+	}
+
+	if len(strings.TrimSpace(encoded)) > 0 {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return policy, fmt.Errorf("%w - key is not base64", ErrInvalidConfig)
+		}
+
+		if policy.Keys, err = NewKeys(key); err != nil {
+			return policy, err
+		}
+	}
+
+	if len(c.Issuer) > 0 {
+		policy.Issuer = c.Issuer
+	}
+
+	for _, role := range c.RequiredRoles {
+		policy.RequiredRoles[ztypes.Role(role)] = true
+	}
+	if len(policy.RequiredRoles) > 0 && policy.Keys == nil {
+		return policy, fmt.Errorf("%w - required roles need a key", ErrInvalidConfig)
+	}
+
+	if len(c.ChallengeLifetime) > 0 {
+		lifetime, err := time.ParseDuration(c.ChallengeLifetime)
+		if err != nil || lifetime <= 0 {
+			return policy, fmt.Errorf("%w - challenge lifetime %q", ErrInvalidConfig, c.ChallengeLifetime)
+		}
+		policy.ChallengeLifetime = lifetime
+	}
+
+	if c.RecoveryCodes < 0 {
+		return policy, fmt.Errorf("%w - recovery codes must be positive", ErrInvalidConfig)
+	} else if c.RecoveryCodes > 0 {
+		policy.RecoveryCodes = c.RecoveryCodes
+	}
+
+	return policy, nil
+}
+
+// Keys encrypt TOTP secrets with AES-GCM and sign login challenges with a key derived from the same key
+type Keys struct {
+	aead         cipher.AEAD
+	challengeKey []byte
+}
+
+func NewKeys(key []byte) (*Keys, error) {
+
+	if len(key) != keySize {
+		return nil, fmt.Errorf("%w - key must be %d bytes", ErrInvalidConfig, keySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("zbi mfa challenge"))
+	return &Keys{aead: aead, challengeKey: mac.Sum(nil)}, nil
+}
+
+// Encrypt seals the secret of a user. The user id is bound to the ciphertext so it cannot be moved to another user.
+func (k *Keys) Encrypt(userId, secret string) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := k.aead.Seal(nonce, nonce, []byte(secret), []byte(userId))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keys) Decrypt(userId, encrypted string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < k.aead.NonceSize() {
+		return "", ErrInvalidSecret
+	}
+
+	size := k.aead.NonceSize()
+	secret, err := k.aead.Open(nil, data[:size], data[size:], []byte(userId))
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+	return string(secret), nil
+}
+
+type challenge struct {
+	UserId  string `json:"u"`
+	Expires int64  `json:"e"`
+}
+
+func (k *Keys) sign(payload string) string {
+	mac := hmac.New(sha256.New, k.challengeKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewChallenge returns a signed challenge that lets the user complete a login with a code until it expires
+func (k *Keys) NewChallenge(userId string, now time.Time, lifetime time.Duration) (string, time.Time, error) {
+	expires := now.Add(lifetime)
+	data, err := json.Marshal(challenge{UserId: userId, Expires: expires.Unix()})
+	if err != nil {
+		return "", expires, err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + k.sign(payload), expires, nil
+}
+
+// ParseChallenge returns the user of a challenge that is signed by these keys and has not expired
+func (k *Keys) ParseChallenge(value string, now time.Time) (string, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 || subtle.ConstantTimeCompare([]byte(k.sign(parts[0])), []byte(parts[1])) != 1 {
+		return "", ErrInvalidChallenge
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidChallenge
+	}
+
+	var c challenge
+	if err = json.Unmarshal(data, &c); err != nil || len(c.UserId) == 0 || now.Unix() >= c.Expires {
+		return "", ErrInvalidChallenge
+	}
+
+	return c.UserId, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// HashRecoveryCode returns the hash under which a recovery code is stored
+func HashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsRecoveryCode tells recovery codes from TOTP codes
+func IsRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == recoveryCodeSize
+}
+
+// NewRecoveryCodes returns count codes formatted as xxxxx-xxxxx together with their hashes
+func NewRecoveryCodes(count int) ([]string, []string, error) {
+	codes := make([]string, 0, count)
+	hashes := make([]string, 0, count)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := 0; i < count; i++ {
+		data := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(data); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(data))[:recoveryCodeSize]
+		codes = append(codes, code[:recoveryCodeSize/2]+"-"+code[recoveryCodeSize/2:])
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// UseRecoveryCode removes the code from the enrollment and reports whether it was valid
+func (e *Enrollment) UseRecoveryCode(code string) bool {
+	hash := HashRecoveryCode(code)
+	for index, stored := range e.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			e.RecoveryCodes = append(e.RecoveryCodes[:index:index], e.RecoveryCodes[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package mfa
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zbitech/common/pkg/model/ztypes"
+)
+
+// RFC 6238 test secret "12345678901234567890" in base32
+const testSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func testKeys(t *testing.T) *Keys {
+	keys, err := NewKeys([]byte(strings.Repeat("k", keySize)))
+	if err != nil {
+		t.Fatalf("Expected keys but got err - %s", err)
+	}
+	return keys
+}
+
+func Test_Code(t *testing.T) {
+	vectors := map[int64]string{59: "287082", 1111111109: "081804"}
+	for unix, expected := range vectors {
+		code, err := Code(testSecret, Step(time.Unix(unix, 0)))
+		if err != nil || code != expected {
+			t.Fatalf("Expected code %s at %d but got %s - %v", expected, unix, code, err)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err != ErrInvalidSecret {
+		t.Fatalf("Expected invalid secret error but got %v", err)
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok := Validate(testSecret, "081804", now, 0)
+	if !ok || step != Step(now) {
+		t.Fatalf("Expected code to be valid for step %d but got %d - %t", Step(now), step, ok)
+	}
+
+	if _, ok = Validate(testSecret, "081804", now, step); ok {
+		t.Fatalf("Expected replayed code to be refused")
+	}
+
+	previous, _ := Code(testSecret, Step(now)-1)
+	if _, ok = Validate(testSecret, previous, now, 0); !ok {
+		t.Fatalf("Expected code of the previous step to be accepted")
+	}
+
+	old, _ := Code(testSecret, Step(now)-2)
+	if _, ok = Validate(testSecret, old, now, 0); ok {
+		t.Fatalf("Expected code of two steps ago to be refused")
+	}
+
+	if _, ok = Validate(testSecret, "12345", now, 0); ok {
+		t.Fatalf("Expected short code to be refused")
+	}
+}
+
+func Test_NewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("Expected secret but got err - %s", err)
+	}
+	if _, err = Code(secret, 1); err != nil {
+		t.Fatalf("Expected code for the new secret but got err - %s", err)
+	}
+
+	uri := ProvisioningURI("ZBI", "tester", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/ZBI:tester?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("Expected provisioning uri for tester with the secret but got %s", uri)
+	}
+}
+
+func Test_Encrypt(t *testing.T) {
+	keys := testKeys(t)
+
+	sealed, err := keys.Encrypt("tester", testSecret)
+	if err != nil {
+		t.Fatalf("Expected sealed secret but got err - %s", err)
+	}
+	if strings.Contains(sealed, testSecret) {
+		t.Fatalf("Expected sealed secret not to contain the secret")
+	}
+
+	secret, err := keys.Decrypt("tester", sealed)
+	if err != nil || secret != testSecret {
+		t.Fatalf("Expected secret %s but got %s - %v", testSecret, secret, err)
+	}
+
+	if _, err = keys.Decrypt("other", sealed); err != ErrInvalidSecret {
+		t.Fatalf("Expected secrets to be bound to their user but got %v", err)
+	}
+}
+
+func Test_Challenge(t *testing.T) {
+	keys := testKeys(t)
+	now := time.Now()
+
+	challenge, expires, err := keys.NewChallenge("tester", now, time.Minute)
+	if err != nil || !expires.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Expected challenge expiring %s but got %s - %v", now.Add(time.Minute), expires, err)
+	}
+
+	userId, err := keys.ParseChallenge(challenge, now)
+	if err != nil || userId != "tester" {
+		t.Fatalf("Expected challenge of tester but got %s - %v", userId, err)
+	}
+
+	if _, err = keys.ParseChallenge(challenge, now.Add(time.Minute)); err != ErrInvalidChallenge {
+		t.Fatalf("Expected expired challenge to be invalid but got %v", err)
+	}
+
+	other, err := NewKeys([]byte(strings.Repeat("o", keySize)))
+	if err != nil {
+		t.Fatalf("Expected keys but got err - %s", err)
+	}
+	if _, err = other.ParseChallenge(challenge, now); err != ErrInvalidChallenge {
+		t.Fatalf("Expected challenge sealed with other keys to be invalid but got %v", err)
+	}
+
+	if _, err = keys.ParseChallenge(challenge+"x", now); err != ErrInvalidChallenge {
+		t.Fatalf("Expected altered challenge to be invalid but got %v", err)
+	}
+}
+
+func Test_RecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(3)
+	if err != nil || len(codes) != 3 {
+		t.Fatalf("Expected 3 recovery codes but got %d - %v", len(codes), err)
+	}
+	if !IsRecoveryCode(codes[0]) || IsRecoveryCode("123456") {
+		t.Fatalf("Expected only %s to be a recovery code", codes[0])
+	}
+
+	e := &Enrollment{RecoveryCodes: hashes}
+	if !e.UseRecoveryCode(strings.ToUpper(codes[1])) {
+		t.Fatalf("Expected recovery code %s to be accepted", codes[1])
+	}
+	if e.UseRecoveryCode(codes[1]) {
+		t.Fatalf("Expected recovery codes to be used once")
+	}
+	if len(e.RecoveryCodes) != 2 || e.RecoveryCodes[0] != hashes[0] {
+		t.Fatalf("Expected only the used recovery code to be removed but got %v", e.RecoveryCodes)
+	}
+}
+
+func Test_ConfigPolicy(t *testing.T) {
+	policy, err := Config{}.Policy()
+	if err != nil {
+		t.Fatalf("Expected policy but got err - %s", err)
+	}
+	if policy.Keys != nil || policy.Issuer != DEFAULT_ISSUER || policy.ChallengeLifetime != DEFAULT_CHALLENGE_LIFETIME {
+		t.Fatalf("Expected default policy without keys but got %v", policy)
+	}
+
+	if _, err = (Config{RequiredRoles: []string{"owner"}}).Policy(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected invalid config error for required roles without a key but got %v", err)
+	}
+
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", keySize)))
+	policy, err = Config{Key: key, RequiredRoles: []string{"owner"}, ChallengeLifetime: "2m"}.Policy()
+	if err != nil || policy.Keys == nil {
+		t.Fatalf("Expected policy with keys but got err - %v", err)
+	}
+	if !policy.Required(ztypes.ROLE_OWNER, nil) || policy.Required(ztypes.ROLE_USER, nil) {
+		t.Fatalf("Expected mfa to be required for owners only")
+	}
+	if !policy.Required(ztypes.ROLE_USER, &Enrollment{Required: true}) {
+		t.Fatalf("Expected mfa to be required for users enrolled as required")
+	}
+	if policy.ChallengeLifetime != 2*time.Minute {
+		t.Fatalf("Expected challenge lifetime of 2m but got %s", policy.ChallengeLifetime)
+	}
+
+	if _, err = (Config{Key: "c2hvcnQ="}).Policy(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected invalid config error for a short key but got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238 as understood by common authenticator apps
+const (
+	TOTP_PERIOD = 30 * time.Second
+	TOTP_DIGITS = 6
+	TOTP_SKEW   = 1
+
+	secretSize = 20
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random TOTP secret in base32 as entered in authenticator apps
+func NewSecret() (string, error) {
+	data := make([]byte, secretSize)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(data), nil
+}
+
+// Step returns the TOTP time step of t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(TOTP_PERIOD/time.Second)
+}
+
+// Code returns the TOTP code of the secret for a time step
+func Code(secret string, step int64) (string, error) {
+
+	key, err := secretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		modulus *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%modulus), nil
+}
+
+// Validate checks code against the steps around now and returns the matching step. Steps up to lastStep were
+// already used and are rejected so a code cannot be replayed.
+func Validate(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+
+	code = strings.TrimSpace(code)
+	if len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+
+	current := Step(now)
+	for step := current - TOTP_SKEW; step <= current+TOTP_SKEW; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// ProvisioningURI returns the otpauth URI that authenticator apps read from a QR code
+func ProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	params.Set("period", fmt.Sprint(int(TOTP_PERIOD/time.Second)))
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/zbitech/common/pkg/rctx"
 	"github.com/zbitech/common/pkg/vars"
 	"github.com/zbitech/repo/internal/helper"
+	"github.com/zbitech/repo/pkg/apikeys"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -30,42 +31,51 @@ func (m *AdminMongoRepository) RegisterUser(ctx context.Context, user *entity.Us
 	defer logger.LogComponentTime(ctx)
 
 	userColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
+	passColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_PASSWORD)
+	polColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USER_POLICY)
 
-	filter := bson.M{"userid": user.UserId}
-	userResult := userColl.FindOne(ctx, filter)
+	return m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
 
-	userErr := userResult.Err()
-	if userErr != nil {
+		filter := bson.M{"userid": user.UserId}
+		userErr := userColl.FindOne(ctx, filter).Err()
+		if userErr == nil {
+			return errs.ErrUserAlreadyExists
+		} else if userErr != mongo.ErrNoDocuments {
+			return handleMongoError(ctx, userErr)
+		}
 
-		if userErr == mongo.ErrNoDocuments {
-			user.Created = time.Now()
-			user.Active = true
-			user.LastUpdate = time.Now()
+		user.Created = time.Now()
+		user.Active = true
+		user.LastUpdate = time.Now()
 
-			iResult, err := userColl.InsertOne(ctx, user)
-			if err != nil {
-				logger.Errorf(ctx, "Error inserting user info - %s", err)
+		id, err := tx.InsertOne(ctx, userColl, user)
+		if err != nil {
+			logger.Errorf(ctx, "Error inserting user info - %s", err)
+			return errs.ErrDBItemInsertFailed
+		}
+
+		if pass != nil {
+			if _, err = tx.InsertOne(ctx, passColl, pass); err != nil {
+				logger.Errorf(ctx, "Error setting user password - %s", err)
 				return errs.ErrDBItemInsertFailed
 			}
+		}
 
-			if pass != nil {
-				passColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_PASSWORD)
-				_, err = passColl.InsertOne(ctx, pass)
-				if err != nil {
-					logger.Errorf(ctx, "Error setting user password - %s", err)
-					return errs.ErrDBItemInsertFailed
-				}
+		polErr := polColl.FindOne(ctx, filter).Err()
+		if polErr == mongo.ErrNoDocuments {
+			policy := entity.NewUserPolicy(user.UserId)
+			policy.Updated = time.Now()
+			if _, err = tx.InsertOne(ctx, polColl, policy); err != nil {
+				logger.Errorf(ctx, "Error setting user policy - %s", err)
+				return errs.ErrDBItemInsertFailed
 			}
-
-			logger.Infof(ctx, "Inserted user with id %s", iResult.InsertedID)
-			return nil
-
+		} else if polErr != nil {
+			return handleMongoError(ctx, polErr)
 		}
 
-		return handleMongoError(ctx, userErr)
-	} else {
-		return errs.ErrUserAlreadyExists
-	}
+		logger.Infof(ctx, "Inserted user with id %s", id)
+		return nil
+	})
 }
 
 func (m *AdminMongoRepository) DeactivateUser(ctx context.Context, userid string) error {
@@ -219,25 +229,14 @@ func (m *AdminMongoRepository) UpdatePassword(ctx context.Context, userid string
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdatePassword"), rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	//	hashedPass, err := fn.HashAndSaltPassword([]byte(password))
-	//	if err != nil {
-	//		logger.Errorf(ctx, "Error hashing password - %s", err)
-	//		return errs.ErrMarshalFailed
-	//	}
-
-	//	userPass := entity.NewUserPassword(userid, hashedPass)
-
-	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
-	filter := bson.M{"userid": userid}
-	//	updateFilter := bson.M{"$set": bson.M{"password": userPass}}
-	result := coll.FindOneAndUpdate(ctx, filter, password)
-	err := result.Err()
-
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return errs.ErrDBItemNotFound
-		}
+	userColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
+	if err := userColl.FindOne(ctx, bson.M{"userid": userid}).Err(); err != nil {
+		return handleMongoError(ctx, err)
+	}
 
+	passColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_PASSWORD)
+	if _, err := passColl.ReplaceOne(ctx, bson.M{"userid": userid}, password, options.Replace().SetUpsert(true)); err != nil {
+		logger.Errorf(ctx, "Password update failed - %s", err)
 		return errs.ErrDBItemUpdateFailed
 	}
 
@@ -314,37 +313,43 @@ func (m *AdminMongoRepository) GetAPIKey(ctx context.Context, apiKey string) (*e
 		rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
-	result := coll.FindOne(ctx, bson.M{"key": apiKey})
-	if result.Err() != nil {
-		return nil, handleMongoError(ctx, result.Err())
+	id, secret, err := apikeys.Parse(apiKey)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
 	}
 
-	item := entity.APIKey{}
-	if err := result.Decode(&item); err != nil {
-		return nil, errs.ErrMarshalFailed
+	record, err := m.GetAPIKeyRecord(ctx, id)
+	if err != nil {
+		return nil, err
 	}
 
-	return &item, nil
+	if err = record.Authenticate(secret, time.Now()); err != nil {
+		logger.Errorf(ctx, "Rejected api key %s - %s", id, err)
+		return nil, err
+	}
+
+	return record.APIKey(), nil
 }
 
+// CreateAPIKey returns the full key once. Only its id and the hash of its secret are stored.
 func (m *AdminMongoRepository) CreateAPIKey(ctx context.Context, user_id string) (*entity.APIKey, error) {
 
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminMongoRepository.CreateAPIKey"),
 		rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
-
-	apikey := entity.NewAPIKey(user_id, vars.HOURS_IN_YEAR)
-
-	_, err := coll.InsertOne(ctx, apikey)
+	record, key, err := apikeys.New(user_id, time.Now(), time.Duration(vars.HOURS_IN_YEAR)*time.Hour)
 	if err != nil {
-		logger.Errorf(ctx, "Unable to store API key - %s", err)
-		return nil, errs.ErrDBItemInsertFailed
+		return nil, err
+	}
+
+	if err = m.CreateAPIKeyRecord(ctx, record); err != nil {
+		return nil, err
 	}
 
-	return &apikey, nil
+	apikey := record.APIKey()
+	apikey.Key = key
+	return apikey, nil
 }
 
 func (m *AdminMongoRepository) DeleteAPIKey(ctx context.Context, apiKey string) error {
@@ -352,10 +357,18 @@ func (m *AdminMongoRepository) DeleteAPIKey(ctx context.Context, apiKey string)
 		rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
-	_, err := coll.DeleteOne(ctx, bson.M{"key": apiKey})
+	filter := bson.M{"key": apikeys.ID(apiKey)}
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
+	result, err := coll.DeleteOne(ctx, filter)
 	if err != nil {
 		return handleMongoError(ctx, err)
+	} else if result.DeletedCount == 0 {
+		return errs.ErrDBItemNotFound
+	}
+
+	coll = m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY_POLICY)
+	if _, err = coll.DeleteOne(ctx, filter); err != nil {
+		return handleMongoError(ctx, err)
 	}
 	return nil
 }
@@ -518,6 +531,7 @@ func (m *AdminMongoRepository) StoreAPIKeyPolicy(ctx context.Context, p entity.A
 
 	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY_POLICY)
 
+	p.Key = apikeys.ID(p.Key)
 	p.Updated = time.Now()
 	result := coll.FindOneAndReplace(ctx, bson.M{"key": p.Key}, p)
 	err := result.Err()
@@ -541,7 +555,7 @@ func (m *AdminMongoRepository) GetAPIKeyPolicy(ctx context.Context, key string)
 	defer logger.LogComponentTime(ctx)
 
 	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY_POLICY)
-	result := coll.FindOne(ctx, bson.M{"key": key})
+	result := coll.FindOne(ctx, bson.M{"key": apikeys.ID(key)})
 	err := result.Err()
 
 	if err != nil {
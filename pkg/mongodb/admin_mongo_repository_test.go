@@ -2,10 +2,13 @@ package mongodb
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/zbitech/common/pkg/utils"
 	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/internal/admintest"
 )
 
 func Test_LoadDatabase(t *testing.T) {
@@ -15,7 +18,12 @@ func Test_LoadDatabase(t *testing.T) {
 	conn.OpenConnection(ctx)
 	defer conn.CloseConnection(ctx)
 
-	LoadDatabase(ctx, conn, true)
+	report, err := LoadDatabase(ctx, conn, false)
+	if err != nil {
+		t.Fatalf("Expected database to load but got err - %s", err)
+	}
+
+	t.Logf("Seeded - %s", report)
 }
 
 func Test_GetUsers(t *testing.T) {
@@ -103,3 +111,34 @@ func Test_GetUserPolicy(t *testing.T) {
 
 	t.Logf("User Policy - %s", utils.MarshalObject(u_policy))
 }
+
+// Test_AdminStore runs the admin store checks against a throwaway database, which is dropped afterwards, so the
+// data in vars.MONGODB_NAME is left alone
+func Test_AdminStore(t *testing.T) {
+
+	ctx := context.Background()
+	conn := NewMongoDBConnection(vars.MONGODB_URL)
+	if err := conn.OpenConnection(ctx); err != nil {
+		t.Fatalf("Expected connection but got err - %s", err)
+	}
+
+	database := vars.MONGODB_NAME
+	vars.MONGODB_NAME = fmt.Sprintf("zbirepo_test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		defer conn.CloseConnection(ctx)
+		if err := conn.GetDatabase(vars.MONGODB_NAME).Drop(ctx); err != nil {
+			t.Errorf("Unable to drop database %s - %s", vars.MONGODB_NAME, err)
+		}
+		vars.MONGODB_NAME = database
+	})
+
+	admintest.Run(t, func(t *testing.T) admintest.Store {
+		if err := CreateCollections(ctx, conn); err != nil {
+			t.Fatalf("Expected collections to be created but got err - %s", err)
+		}
+		if err := PurgeCollections(ctx, conn); err != nil {
+			t.Fatalf("Expected collections to be purged but got err - %s", err)
+		}
+		return NewAdminMongoRepository(conn)
+	})
+}
@@ -0,0 +1,217 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MigrateAPIKeys hashes the API keys stored in plaintext by earlier versions and moves their policies to the new
+// ids. The keys keep working as they are. Hashes cannot be reversed, so the migration has no down step.
+func MigrateAPIKeys(ctx context.Context, db *mongo.Database) (int, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "MigrateAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	keys := db.Collection(MONGODB_COLL_APIKEY)
+	policies := db.Collection(MONGODB_COLL_APIKEY_POLICY)
+
+	cursor, err := keys.Find(ctx, bson.M{"hash": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+
+	var legacy []apikeys.Record
+	if err = cursor.All(ctx, &legacy); err != nil {
+		return 0, err
+	}
+
+	for _, old := range legacy {
+		record := apikeys.FromPlaintext(entity.APIKey{Key: old.Key, UserId: old.UserId, Created: old.Created, Expires: old.Expires})
+		if _, err = keys.ReplaceOne(ctx, bson.M{"key": old.Key}, record); err != nil {
+			logger.Errorf(ctx, "Unable to hash api key of %s - %s", old.UserId, err)
+			return 0, err
+		}
+
+		if _, err = policies.UpdateOne(ctx, bson.M{"key": old.Key}, bson.M{"$set": bson.M{"key": record.Key}}); err != nil {
+			logger.Errorf(ctx, "Unable to move api key policy of %s - %s", old.UserId, err)
+			return 0, err
+		}
+	}
+
+	if len(legacy) > 0 {
+		logger.Infof(ctx, "Hashed %d plaintext api keys", len(legacy))
+	}
+
+	return len(legacy), nil
+}
+
+func (m *AdminMongoRepository) CreateAPIKeyRecord(ctx context.Context, r *apikeys.Record) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
+	if _, err := coll.InsertOne(ctx, r); err != nil {
+		logger.Errorf(ctx, "Unable to store API key - %s", err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) GetAPIKeyRecord(ctx context.Context, id string) (*apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
+	result := coll.FindOne(ctx, bson.M{"key": id})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var record apikeys.Record
+	if err := result.Decode(&record); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &record, nil
+}
+
+func (m *AdminMongoRepository) DisableExpiredAPIKeys(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DisableExpiredAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
+	filter := bson.M{"disabled": bson.M{"$ne": true}, "expires": bson.M{"$gt": time.Time{}, "$lte": now}}
+	result, err := coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"disabled": true}})
+	if err != nil {
+		return 0, handleMongoError(ctx, err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// RotateAPIKeyRecord claims the key by setting its successor only while it has none, so concurrent rotations of
+// the same key cannot both succeed. The claim, the successor and its policy are written in one transaction, and on
+// a standalone server the claim is released if a later write fails.
+func (m *AdminMongoRepository) RotateAPIKeyRecord(ctx context.Context, id string, next *apikeys.Record, graceEnds time.Time) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	record, err := m.GetAPIKeyRecord(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	previous := record.Expires
+	if err = record.Supersede(next.Key, graceEnds); err != nil {
+		return err
+	}
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
+	policies := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY_POLICY)
+
+	return m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
+
+		filter := bson.M{"key": id, "successor": bson.M{"$exists": false}}
+		result, err := coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"successor": record.Successor, "expires": record.Expires}})
+		if err != nil {
+			return handleMongoError(ctx, err)
+		} else if result.MatchedCount == 0 {
+			return apikeys.ErrAPIKeyRotated
+		}
+
+		tx.OnRollback(func(ctx context.Context) error {
+			_, err := coll.UpdateOne(ctx, bson.M{"key": id}, bson.M{"$unset": bson.M{"successor": ""}, "$set": bson.M{"expires": previous}})
+			return err
+		})
+
+		if _, err = tx.InsertOne(ctx, coll, next); err != nil {
+			logger.Errorf(ctx, "Unable to store API key - %s", err)
+			return errs.ErrDBItemInsertFailed
+		}
+
+		var policy entity.APIKeyPolicy
+		if err = policies.FindOne(ctx, bson.M{"key": id}).Decode(&policy); err == mongo.ErrNoDocuments {
+			return nil
+		} else if err != nil {
+			return handleMongoError(ctx, err)
+		}
+
+		policy.Key = next.Key
+		policy.Updated = next.Created
+		if _, err = tx.InsertOne(ctx, policies, policy); err != nil {
+			return handleMongoError(ctx, err)
+		}
+
+		return nil
+	})
+}
+
+func (m *AdminMongoRepository) SetAPIKeyNetworks(ctx context.Context, id string, networks []string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetAPIKeyNetworks"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	update := bson.M{"$set": bson.M{"networks": networks}}
+	if len(networks) == 0 {
+		update = bson.M{"$unset": bson.M{"networks": ""}}
+	}
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
+	result, err := coll.UpdateOne(ctx, bson.M{"key": id}, update)
+	if err != nil {
+		return handleMongoError(ctx, err)
+	} else if result.MatchedCount == 0 {
+		return errs.ErrDBItemNotFound
+	}
+
+	return nil
+}
+
+// RecordAPIKeyUse adds the requests with $inc so concurrent writes are all counted
+func (m *AdminMongoRepository) RecordAPIKeyUse(ctx context.Context, id string, usage apikeys.Usage) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RecordAPIKeyUse"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	update := bson.M{"$set": bson.M{"usage.lastused": usage.LastUsed, "usage.lastip": usage.LastIP}, "$inc": bson.M{"usage.requests": usage.Requests}}
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
+	result, err := coll.UpdateOne(ctx, bson.M{"key": id}, update)
+	if err != nil {
+		return handleMongoError(ctx, err)
+	} else if result.MatchedCount == 0 {
+		return errs.ErrDBItemNotFound
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) GetUnusedAPIKeys(ctx context.Context, since time.Time) ([]apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUnusedAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	filter := bson.M{"$or": []bson.M{
+		{"usage.lastused": bson.M{"$lt": since}},
+		{"usage.lastused": bson.M{"$exists": false}, "created": bson.M{"$lt": since}},
+	}}
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return nil, handleMongoError(ctx, err)
+	}
+
+	unused := make([]apikeys.Record, 0)
+	if err = cursor.All(ctx, &unused); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return unused, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/zbitech/common/pkg/rctx"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/zbitech/common/pkg/logger"
@@ -17,6 +18,9 @@ type MongoDBConnection struct {
 	client *mongo.Client
 	uri    string
 	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	transactions *bool
 }
 
 func NewMongoDBConnection(conn_url string) *MongoDBConnection {
@@ -55,6 +59,10 @@ func (m *MongoDBConnection) CloseConnection(ctx context.Context) {
 		}
 	}
 	m.client = nil
+
+	m.mu.Lock()
+	m.transactions = nil
+	m.mu.Unlock()
 }
 
 func (m *MongoDBConnection) GetDatabase(database string) *mongo.Database {
@@ -0,0 +1,69 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/lockout"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (m *AdminMongoRepository) GetAttempts(ctx context.Context, key string) (*lockout.Attempts, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_LOGIN_ATTEMPTS)
+	result := coll.FindOne(ctx, bson.M{"_id": key})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var attempts lockout.Attempts
+	if err := result.Decode(&attempts); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &attempts, nil
+}
+
+func (m *AdminMongoRepository) PutAttempts(ctx context.Context, attempts *lockout.Attempts) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_LOGIN_ATTEMPTS)
+	if _, err := coll.ReplaceOne(ctx, bson.M{"_id": attempts.Key}, attempts, options.Replace().SetUpsert(true)); err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) DeleteAttempts(ctx context.Context, key string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_LOGIN_ATTEMPTS)
+	if _, err := coll.DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) PurgeExpiredAttempts(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_LOGIN_ATTEMPTS)
+	result, err := coll.DeleteMany(ctx, bson.M{"expires": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, handleMongoError(ctx, err)
+	}
+
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,44 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/mfa"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (m *AdminMongoRepository) GetMFA(ctx context.Context, userId string) (*mfa.Enrollment, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_MFA)
+	result := coll.FindOne(ctx, bson.M{"_id": userId})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var e mfa.Enrollment
+	if err := result.Decode(&e); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &e, nil
+}
+
+func (m *AdminMongoRepository) PutMFA(ctx context.Context, e *mfa.Enrollment) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_MFA)
+	if _, err := coll.ReplaceOne(ctx, bson.M{"_id": e.UserId}, e, options.Replace().SetUpsert(true)); err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	return nil
+}
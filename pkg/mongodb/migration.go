@@ -0,0 +1,412 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	MONGODB_COLL_SCHEMA = "schema_migrations"
+
+	ErrSchemaTooNew       = errors.New("database schema is newer than the repository")
+	ErrSchemaDirty        = errors.New("database schema was left dirty by a failed migration")
+	ErrMigrationConflict  = errors.New("database schema was changed by another migration")
+	ErrUnknownMigration   = errors.New("unknown schema version")
+	ErrMigrationIrregular = errors.New("migrations must have consecutive versions starting at 1")
+)
+
+const schemaDocumentId = "schema"
+
+// Migration moves the database from Version-1 to Version with Up and back with Down. Versions start at 1 and
+// must be consecutive.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// schemaVersion is the document recording the applied version in the metadata collection. Dirty is set while a
+// migration runs so that a failure part way through is not mistaken for a completed version.
+type schemaVersion struct {
+	Id      string    `bson:"_id"`
+	Version int       `bson:"version"`
+	Dirty   bool      `bson:"dirty"`
+	Updated time.Time `bson:"updated"`
+}
+
+type migrationStep struct {
+	migration Migration
+	up        bool
+}
+
+func (s migrationStep) target() int {
+	if s.up {
+		return s.migration.Version
+	}
+	return s.migration.Version - 1
+}
+
+func validateMigrations(migrations []Migration) error {
+	for index, migration := range migrations {
+		if migration.Version != index+1 || migration.Up == nil {
+			return fmt.Errorf("%w - found version %d at position %d", ErrMigrationIrregular, migration.Version, index+1)
+		}
+	}
+	return nil
+}
+
+// planMigrations returns the steps that move the schema from current to target
+func planMigrations(migrations []Migration, current, target int) ([]migrationStep, error) {
+
+	latest := len(migrations)
+	if current > latest {
+		return nil, fmt.Errorf("%w - database is at version %d and the latest known version is %d", ErrSchemaTooNew, current, latest)
+	}
+
+	if target < 0 || target > latest {
+		return nil, fmt.Errorf("%w %d", ErrUnknownMigration, target)
+	}
+
+	steps := make([]migrationStep, 0)
+	for version := current + 1; version <= target; version++ {
+		steps = append(steps, migrationStep{migration: migrations[version-1], up: true})
+	}
+
+	for version := current; version > target; version-- {
+		migration := migrations[version-1]
+		if migration.Down == nil {
+			return nil, fmt.Errorf("migration %d (%s) cannot be reverted", version, migration.Description)
+		}
+		steps = append(steps, migrationStep{migration: migration, up: false})
+	}
+
+	return steps, nil
+}
+
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+}
+
+func NewMigrator(db *mongo.Database, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+func (m *Migrator) LatestVersion() int {
+	return len(m.migrations)
+}
+
+// Version returns the applied schema version and whether the last migration failed
+func (m *Migrator) Version(ctx context.Context) (int, bool, error) {
+
+	var schema schemaVersion
+	err := m.db.Collection(MONGODB_COLL_SCHEMA).FindOne(ctx, bson.M{"_id": schemaDocumentId}).Decode(&schema)
+	if err == mongo.ErrNoDocuments {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	return schema.Version, schema.Dirty, nil
+}
+
+// Check fails when the database was migrated by a newer version of the repository or was left dirty by a failed
+// migration, before anything else touches it
+func (m *Migrator) Check(ctx context.Context) error {
+
+	current, dirty, err := m.Version(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to read schema version - %s", err)
+		return err
+	}
+
+	if current > m.LatestVersion() {
+		return fmt.Errorf("%w - database is at version %d and the latest known version is %d", ErrSchemaTooNew, current, m.LatestVersion())
+	}
+
+	if dirty {
+		return fmt.Errorf("%w at version %d", ErrSchemaDirty, current)
+	}
+
+	return nil
+}
+
+// Up applies every migration that has not been applied yet
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Migrate(ctx, m.LatestVersion())
+}
+
+// Migrate runs the up or down migrations needed to bring the schema to target
+func (m *Migrator) Migrate(ctx context.Context, target int) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "Migrator.Migrate"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if err := validateMigrations(m.migrations); err != nil {
+		return err
+	}
+
+	current, dirty, err := m.Version(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to read schema version - %s", err)
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("%w at version %d", ErrSchemaDirty, current)
+	}
+
+	steps, err := planMigrations(m.migrations, current, target)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to migrate schema - %s", err)
+		return err
+	}
+
+	for _, step := range steps {
+		if err = m.apply(ctx, current, step); err != nil {
+			return err
+		}
+		current = step.target()
+	}
+
+	logger.Infof(ctx, "Database schema is at version %d", current)
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, current int, step migrationStep) error {
+
+	direction, run := "up", step.migration.Up
+	if !step.up {
+		direction, run = "down", step.migration.Down
+	}
+
+	logger.Infof(ctx, "Running %s migration %d - %s", direction, step.migration.Version, step.migration.Description)
+
+	if err := m.begin(ctx, current); err != nil {
+		return err
+	}
+
+	if err := run(ctx, m.db); err != nil {
+		logger.Errorf(ctx, "Migration %d failed, schema left dirty at version %d - %s", step.migration.Version, current, err)
+		return err
+	}
+
+	return m.setVersion(ctx, step.target())
+}
+
+// begin marks the schema dirty if it is still at version. It fails when another instance has started or finished
+// a migration in the meantime.
+func (m *Migrator) begin(ctx context.Context, version int) error {
+
+	coll := m.db.Collection(MONGODB_COLL_SCHEMA)
+	update := bson.M{"$set": bson.M{"version": version, "dirty": true, "updated": time.Now()}}
+
+	var err error
+	if version == 0 {
+		_, err = coll.InsertOne(ctx, schemaVersion{Id: schemaDocumentId, Version: 0, Dirty: true, Updated: time.Now()})
+		if mongo.IsDuplicateKeyError(err) {
+			filter := bson.M{"_id": schemaDocumentId, "version": 0, "dirty": false}
+			err = coll.FindOneAndUpdate(ctx, filter, update).Err()
+		}
+	} else {
+		filter := bson.M{"_id": schemaDocumentId, "version": version, "dirty": false}
+		err = coll.FindOneAndUpdate(ctx, filter, update).Err()
+	}
+
+	if err == mongo.ErrNoDocuments {
+		return ErrMigrationConflict
+	}
+
+	return err
+}
+
+func (m *Migrator) setVersion(ctx context.Context, version int) error {
+
+	filter := bson.M{"_id": schemaDocumentId}
+	update := bson.M{"$set": bson.M{"version": version, "dirty": false, "updated": time.Now()}}
+	_, err := m.db.Collection(MONGODB_COLL_SCHEMA).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// RenameField renames a field in every document of a collection that has it
+func RenameField(ctx context.Context, db *mongo.Database, collection, from, to string) error {
+	filter := bson.M{from: bson.M{"$exists": true}}
+	_, err := db.Collection(collection).UpdateMany(ctx, filter, bson.M{"$rename": bson.M{from: to}})
+	return err
+}
+
+// BackfillField sets a field to value in every document of a collection where it is missing or null
+func BackfillField(ctx context.Context, db *mongo.Database, collection, field string, value interface{}) error {
+	filter := bson.M{field: nil}
+	_, err := db.Collection(collection).UpdateMany(ctx, filter, bson.M{"$set": bson.M{field: value}})
+	return err
+}
+
+// createSchema creates the collections of a migration with the indexes they had at that version
+func createSchema(ctx context.Context, db *mongo.Database, collections []MongoCollection) error {
+	for _, coll := range collections {
+		if err := CreateCollection(ctx, db, coll.Name, coll.Indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The index specs of each migration are copied rather than taken from COLLECTIONS, so that changing the current
+// indexes does not change what a released migration does. A change to COLLECTIONS needs a new migration.
+var (
+	migrationV1Collections = []MongoCollection{
+		{MONGODB_COLL_USERS, []MongoIndex{{Name: "userid", Order: 1, Unique: true}, {Name: "email", Order: 1, Unique: true}}},
+		{MONGODB_COLL_PASSWORD, []MongoIndex{{Name: "userid", Order: 1, Unique: true}}},
+		{MONGODB_COLL_APIKEY, []MongoIndex{{Name: "userid", Order: 1, Unique: true}, {Name: "key", Order: 1, Unique: true}, {Name: "expires", Order: 1}}},
+		{MONGODB_COLL_PROJECTS, []MongoIndex{{Name: "name", Order: 1, Unique: true}, {Name: "owner", Order: 1}, {Name: "team", Order: 1}}},
+		{MONGODB_COLL_INSTANCES, []MongoIndex{{Name: "project", Order: 1}, {Name: "name", Order: 1}, {Name: "owner", Order: 1}, {Name: "type", Order: 1}}},
+		{MONGODB_COLL_RESOURCES, []MongoIndex{{Name: "project", Order: 1}, {Name: "instance", Order: 1}, {Name: "type", Order: 1}}},
+		{MONGODB_COLL_INSTANCE_POLICY, []MongoIndex{{Name: "project", Order: 1}, {Name: "instance", Order: 1}}},
+		{MONGODB_COLL_USER_POLICY, []MongoIndex{{Name: "userid", Order: 1, Unique: true}}},
+		{MONGODB_COLL_APIKEY_POLICY, []MongoIndex{{Name: "key", Order: 1}}},
+		{MONGODB_COLL_TEAMS, []MongoIndex{{Name: "name", Order: 1}, {Name: "owner", Order: 1}}},
+		{MONGODB_COLL_TEAM_MEMBERS, []MongoIndex{{Name: "team", Order: 1}, {Name: "email", Order: 1}, {Name: "key", Order: 1, Unique: true}}},
+	}
+
+	migrationV3Collections = []MongoCollection{
+		{MONGODB_COLL_USERS, []MongoIndex{{Name: "userid_email", Fields: []MongoIndexFields{{"userid", 1}, {"email", 1}}, Unique: true}, {Name: "userid", Order: 1, Unique: true}, {Name: "email", Order: 1, Unique: true, PartialFilter: nonEmptyString("email")}}},
+		{MONGODB_COLL_PASSWORD, []MongoIndex{{Name: "userid", Order: 1, Unique: true}}},
+		{MONGODB_COLL_APIKEY, []MongoIndex{{Name: "key_userid", Fields: []MongoIndexFields{{"key", 1}, {"userid", 1}}, Unique: true}, {Name: "key", Order: 1, Unique: true}, {Name: "userid", Order: 1}, {Name: "expires", Order: 1}}},
+		{MONGODB_COLL_PROJECTS, []MongoIndex{{Name: "name_owner_team", Fields: []MongoIndexFields{{"name", 1}, {"owner", 1}, {"team", 1}}, Unique: true}, {Name: "owner", Order: 1}, {Name: "team", Order: 1}}},
+		{MONGODB_COLL_INSTANCES, []MongoIndex{{Name: "project_name_type_owner", Fields: []MongoIndexFields{{"project", 1}, {"name", 1}, {"type", 1}, {"owner", 1}}, Unique: true}, {Name: "owner", Order: 1}}},
+		{MONGODB_COLL_RESOURCES, []MongoIndex{{Name: "project_instance_type_name", Fields: []MongoIndexFields{{"project", 1}, {"instance", 1}, {"resource.type", 1}, {"resource.name", 1}}, Unique: true}, {Name: "level_project_instance", Fields: []MongoIndexFields{{"level", 1}, {"project", 1}, {"instance", 1}}}}},
+		{MONGODB_COLL_INSTANCE_POLICY, []MongoIndex{{Name: "project_instance", Fields: []MongoIndexFields{{"project", 1}, {"instance", 1}}, Unique: true}}},
+		{MONGODB_COLL_USER_POLICY, []MongoIndex{{Name: "userid", Order: 1, Unique: true}}},
+		{MONGODB_COLL_APIKEY_POLICY, []MongoIndex{{Name: "key", Order: 1, Unique: true}}},
+		{MONGODB_COLL_TEAMS, []MongoIndex{{Name: "teamid_owner", Fields: []MongoIndexFields{{"teamid", 1}, {"owner", 1}}, Unique: true}, {Name: "teamid", Order: 1, Unique: true}, {Name: "name", Order: 1}, {Name: "owner", Order: 1}}},
+		{MONGODB_COLL_TEAM_MEMBERS, []MongoIndex{{Name: "teamid_email_key", Fields: []MongoIndexFields{{"teamid", 1}, {"email", 1}, {"key", 1}}, Unique: true}, {Name: "email", Order: 1}, {Name: "key", Order: 1, Unique: true}}},
+	}
+
+	migrationV4Collections  = []MongoCollection{{MONGODB_COLL_SESSIONS, []MongoIndex{{Name: "userid_device", Fields: []MongoIndexFields{{"userid", 1}, {"device", 1}}}, {Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}}}
+	migrationV5Collections  = []MongoCollection{{MONGODB_COLL_REVOKED_TOKENS, []MongoIndex{{Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}}, {MONGODB_COLL_WATERMARKS, []MongoIndex{{Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}}}
+	migrationV6Collections  = []MongoCollection{{MONGODB_COLL_LOGIN_ATTEMPTS, []MongoIndex{{Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}}}
+	migrationV7Collections  = []MongoCollection{{MONGODB_COLL_PASSWORD_HIST, []MongoIndex{}}}
+	migrationV8Collections  = []MongoCollection{{MONGODB_COLL_RESET_TOKENS, []MongoIndex{{Name: "userid", Order: 1}, {Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}}}
+	migrationV9Collections  = []MongoCollection{{MONGODB_COLL_MFA, []MongoIndex{}}}
+	migrationV11Collections = []MongoCollection{{MONGODB_COLL_SERVICE_ACCOUNT, []MongoIndex{{Name: "teamid", Order: 1}}}}
+)
+
+// MIGRATIONS is the ordered history of the Mongo schema. New migrations are appended and existing ones are never
+// changed once released. Migrations without Down cannot be reverted.
+var MIGRATIONS = []Migration{
+	{
+		Version:     1,
+		Description: "create collections and indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV1Collections)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropCollections(ctx, db)
+		},
+	},
+	{
+		Version:     2,
+		Description: "backfill empty user memberships",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return BackfillField(ctx, db, MONGODB_COLL_USERS, "memberships", bson.A{})
+		},
+	},
+	{
+		Version:     3,
+		Description: "reconcile compound and ttl indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV3Collections)
+		},
+	},
+	{
+		Version:     4,
+		Description: "create sessions collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV4Collections)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(MONGODB_COLL_SESSIONS).Drop(ctx)
+		},
+	},
+	{
+		Version:     5,
+		Description: "create token revocation collections",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV5Collections)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection(MONGODB_COLL_WATERMARKS).Drop(ctx); err != nil {
+				return err
+			}
+			return db.Collection(MONGODB_COLL_REVOKED_TOKENS).Drop(ctx)
+		},
+	},
+	{
+		Version:     6,
+		Description: "create login attempts collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV6Collections)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(MONGODB_COLL_LOGIN_ATTEMPTS).Drop(ctx)
+		},
+	},
+	{
+		Version:     7,
+		Description: "create password history collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV7Collections)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(MONGODB_COLL_PASSWORD_HIST).Drop(ctx)
+		},
+	},
+	{
+		Version:     8,
+		Description: "create password reset tokens collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV8Collections)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(MONGODB_COLL_RESET_TOKENS).Drop(ctx)
+		},
+	},
+	{
+		Version:     9,
+		Description: "create mfa collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV9Collections)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(MONGODB_COLL_MFA).Drop(ctx)
+		},
+	},
+	{
+		Version:     10,
+		Description: "hash plaintext api keys",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := MigrateAPIKeys(ctx, db)
+			return err
+		},
+	},
+	{
+		Version:     11,
+		Description: "create service accounts collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createSchema(ctx, db, migrationV11Collections)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(MONGODB_COLL_SERVICE_ACCOUNT).Drop(ctx)
+		},
+	},
+}
@@ -0,0 +1,93 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func testMigrations(count int) []Migration {
+	noop := func(ctx context.Context, db *mongo.Database) error { return nil }
+
+	migrations := make([]Migration, count)
+	for index := range migrations {
+		migrations[index] = Migration{Version: index + 1, Up: noop, Down: noop}
+	}
+	return migrations
+}
+
+func Test_ValidateMigrations(t *testing.T) {
+
+	if err := validateMigrations(MIGRATIONS); err != nil {
+		t.Fatalf("Expected registered migrations to be valid but got %s", err)
+	}
+
+	migrations := testMigrations(3)
+	migrations[2].Version = 4
+	if err := validateMigrations(migrations); !errors.Is(err, ErrMigrationIrregular) {
+		t.Fatalf("Expected gap in versions to be rejected but got %v", err)
+	}
+}
+
+func Test_PlanMigrations(t *testing.T) {
+
+	migrations := testMigrations(3)
+
+	steps, err := planMigrations(migrations, 1, 3)
+	if err != nil || len(steps) != 2 || !steps[0].up || steps[0].target() != 2 || steps[1].target() != 3 {
+		t.Fatalf("Expected up migrations 2 and 3 but got %v - %v", steps, err)
+	}
+
+	steps, err = planMigrations(migrations, 3, 1)
+	if err != nil || len(steps) != 2 || steps[0].up || steps[0].target() != 2 || steps[1].target() != 1 {
+		t.Fatalf("Expected down migrations 3 and 2 but got %v - %v", steps, err)
+	}
+
+	if steps, err = planMigrations(migrations, 3, 3); err != nil || len(steps) != 0 {
+		t.Fatalf("Expected no migrations but got %v - %v", steps, err)
+	}
+
+	if _, err = planMigrations(migrations, 4, 3); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("Expected newer schema to be rejected but got %v", err)
+	}
+
+	if MIGRATIONS[1].Down != nil || MIGRATIONS[2].Down != nil {
+		t.Fatalf("Expected migrations 2 and 3 to be irreversible")
+	}
+
+	migrations[1].Down = nil
+	if _, err = planMigrations(migrations, 3, 0); err == nil {
+		t.Fatalf("Expected irreversible migration to be rejected")
+	}
+}
+
+// migratedCollections returns the collections and indexes that MIGRATIONS leave behind, which must match
+// COLLECTIONS
+func migratedCollections() []MongoCollection {
+	schemas := [][]MongoCollection{migrationV1Collections, migrationV3Collections, migrationV4Collections, migrationV5Collections,
+		migrationV6Collections, migrationV7Collections, migrationV8Collections, migrationV9Collections, migrationV11Collections}
+
+	collections := make([]MongoCollection, 0)
+	positions := make(map[string]int)
+	for _, schema := range schemas {
+		for _, coll := range schema {
+			if position, ok := positions[coll.Name]; ok {
+				collections[position] = coll
+			} else {
+				positions[coll.Name] = len(collections)
+				collections = append(collections, coll)
+			}
+		}
+	}
+	return collections
+}
+
+func Test_MigratedCollections(t *testing.T) {
+
+	if migrated := migratedCollections(); !reflect.DeepEqual(migrated, COLLECTIONS) {
+		t.Fatalf("Expected migrations to create the current collections and indexes, add a migration when COLLECTIONS change.\nmigrated: %v\ncurrent:  %v", migrated, COLLECTIONS)
+	}
+}
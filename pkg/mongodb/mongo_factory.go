@@ -8,6 +8,7 @@ import (
 	"github.com/zbitech/common/pkg/logger"
 	"github.com/zbitech/common/pkg/rctx"
 	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/seed"
 )
 
 type MongoRepositoryFactory struct {
@@ -26,10 +27,27 @@ func (r *MongoRepositoryFactory) Init(ctx context.Context, create_db, load_db bo
 	defer logger.LogComponentTime(ctx)
 
 	r.conn = NewMongoDBConnection(vars.AppConfig.Database.Mongodb.Url)
-	r.conn.OpenConnection(ctx)
+	if err := r.conn.OpenConnection(ctx); err != nil {
+		return err
+	}
+
+	// a database migrated by a newer repository is left alone, otherwise it is migrated before the current indexes
+	// are reconciled and admin.yaml is seeded
+	migrator := NewMigrator(r.conn.GetDatabase(vars.MONGODB_NAME), MIGRATIONS)
+	if err := migrator.Check(ctx); err != nil {
+		logger.Errorf(ctx, "Unable to use Mongo DB - %s", err)
+		return err
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		return err
+	}
+
 	if create_db {
 		logger.Infof(ctx, "Creating Mongo DB documents")
-		r.CreateDatabase(ctx, true, load_db)
+		if err := r.CreateDatabase(ctx, false, load_db); err != nil {
+			return err
+		}
 	}
 
 	r.project = NewProjectMongoRepository(r.conn)
@@ -81,7 +99,18 @@ func (r *MongoRepositoryFactory) CreateDatabase(ctx context.Context, purge, load
 	}
 
 	if load {
-		LoadDatabase(ctx, r.conn, true)
+		_, err = r.Seed(ctx, false)
 	}
-	return nil
+	return err
+}
+
+// Seed adds the missing items of admin.yaml and reports what was created, or would be created in a dry run
+func (r *MongoRepositoryFactory) Seed(ctx context.Context, dryRun bool) (*seed.Report, error) {
+	return LoadDatabase(ctx, r.conn, dryRun)
+}
+
+// Migrate brings the database schema to the latest version known to this repository. It fails without changing
+// anything when the database was migrated by a newer version.
+func (r *MongoRepositoryFactory) Migrate(ctx context.Context) error {
+	return NewMigrator(r.conn.GetDatabase(vars.MONGODB_NAME), MIGRATIONS).Up(ctx)
 }
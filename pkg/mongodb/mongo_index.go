@@ -0,0 +1,191 @@
+package mongodb
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/zbitech/common/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const MONGODB_ID_INDEX = "_id_"
+
+// indexSpec is the part of an index definition compared when reconciling. It decodes the documents returned by
+// listIndexes.
+type indexSpec struct {
+	Name               string `bson:"name"`
+	Keys               bson.D `bson:"key"`
+	Unique             bool   `bson:"unique,omitempty"`
+	Sparse             bool   `bson:"sparse,omitempty"`
+	ExpireAfterSeconds *int32 `bson:"expireAfterSeconds,omitempty"`
+	PartialFilter      bson.D `bson:"partialFilterExpression,omitempty"`
+}
+
+func ttl(seconds int32) *int32 {
+	return &seconds
+}
+
+// nonEmptyString is a partial filter for unique indexes on optional fields. Documents where the field is missing
+// or empty are left out, so they do not collide like in the bolt and sql repositories.
+func nonEmptyString(field string) bson.D {
+	return bson.D{{Key: field, Value: bson.D{{Key: "$gt", Value: ""}}}}
+}
+
+func (i MongoIndex) keys() bson.D {
+	if len(i.Fields) == 0 {
+		return bson.D{{Key: i.Name, Value: i.Order}}
+	}
+
+	keys := make(bson.D, 0, len(i.Fields))
+	for _, field := range i.Fields {
+		keys = append(keys, bson.E{Key: field.Name, Value: field.Order})
+	}
+	return keys
+}
+
+func (i MongoIndex) model() mongo.IndexModel {
+	opts := options.Index().SetName(i.Name).SetUnique(i.Unique)
+	if i.Sparse {
+		opts.SetSparse(true)
+	}
+	if i.ExpireAfterSeconds != nil {
+		opts.SetExpireAfterSeconds(*i.ExpireAfterSeconds)
+	}
+	if len(i.PartialFilter) > 0 {
+		opts.SetPartialFilterExpression(i.PartialFilter)
+	}
+	return mongo.IndexModel{Keys: i.keys(), Options: opts}
+}
+
+func (i MongoIndex) spec() indexSpec {
+	return indexSpec{
+		Name:               i.Name,
+		Keys:               i.keys(),
+		Unique:             i.Unique,
+		Sparse:             i.Sparse,
+		ExpireAfterSeconds: i.ExpireAfterSeconds,
+		PartialFilter:      i.PartialFilter,
+	}
+}
+
+// sameIndex reports whether two definitions build the same index. Numbers are compared by value because the
+// server may return the orders and filter values as doubles or 32 bit integers.
+func sameIndex(a, b indexSpec) bool {
+	if a.Name != b.Name || a.Unique != b.Unique || a.Sparse != b.Sparse {
+		return false
+	}
+
+	if (a.ExpireAfterSeconds == nil) != (b.ExpireAfterSeconds == nil) {
+		return false
+	}
+	if a.ExpireAfterSeconds != nil && *a.ExpireAfterSeconds != *b.ExpireAfterSeconds {
+		return false
+	}
+
+	return reflect.DeepEqual(normalize(a.Keys), normalize(b.Keys)) &&
+		reflect.DeepEqual(normalize(a.PartialFilter), normalize(b.PartialFilter))
+}
+
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case bson.D:
+		if len(v) == 0 {
+			return nil
+		}
+		doc := make(bson.D, 0, len(v))
+		for _, e := range v {
+			doc = append(doc, bson.E{Key: e.Key, Value: normalize(e.Value)})
+		}
+		return doc
+	case bson.A:
+		arr := make(bson.A, 0, len(v))
+		for _, e := range v {
+			arr = append(arr, normalize(e))
+		}
+		return arr
+	default:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.String {
+			return rv.String()
+		}
+		return v
+	}
+}
+
+// planIndexes compares the indexes of a collection with its definitions. It returns the names of the indexes to
+// drop, which are the stale ones and those whose definition changed, and the indexes to create.
+func planIndexes(existing []indexSpec, indexes []MongoIndex) ([]string, []MongoIndex) {
+
+	current := make(map[string]indexSpec, len(existing))
+	for _, spec := range existing {
+		current[spec.Name] = spec
+	}
+
+	wanted := make(map[string]bool, len(indexes))
+	drop := make([]string, 0)
+	create := make([]MongoIndex, 0)
+
+	for _, index := range indexes {
+		wanted[index.Name] = true
+		spec, ok := current[index.Name]
+		if ok && sameIndex(spec, index.spec()) {
+			continue
+		}
+		if ok {
+			drop = append(drop, index.Name)
+		}
+		create = append(create, index)
+	}
+
+	for _, spec := range existing {
+		if spec.Name != MONGODB_ID_INDEX && !wanted[spec.Name] {
+			drop = append(drop, spec.Name)
+		}
+	}
+
+	return drop, create
+}
+
+// ReconcileIndexes makes the indexes of coll match indexes. Missing indexes are created, changed ones are rebuilt
+// and indexes that are no longer defined are dropped. The _id index is never touched.
+func ReconcileIndexes(ctx context.Context, coll *mongo.Collection, indexes []MongoIndex) error {
+
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to list indexes of %s - %s", coll.Name(), err)
+		return err
+	}
+
+	var existing []indexSpec
+	if err = cursor.All(ctx, &existing); err != nil {
+		logger.Errorf(ctx, "Unable to read indexes of %s - %s", coll.Name(), err)
+		return err
+	}
+
+	drop, create := planIndexes(existing, indexes)
+
+	for _, name := range drop {
+		if _, err = coll.Indexes().DropOne(ctx, name); err != nil {
+			logger.Errorf(ctx, "Unable to drop index %s.%s - %s", coll.Name(), name, err)
+			return err
+		}
+		logger.Infof(ctx, "Dropped index %s.%s", coll.Name(), name)
+	}
+
+	for _, index := range create {
+		if _, err = coll.Indexes().CreateOne(ctx, index.model()); err != nil {
+			logger.Errorf(ctx, "Unable to create index %s.%s - %s", coll.Name(), index.Name, err)
+			return err
+		}
+		logger.Infof(ctx, "Created index %s.%s", coll.Name(), index.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package mongodb
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_IndexModel(t *testing.T) {
+
+	index := MongoIndex{Name: "teamid_email", Fields: []MongoIndexFields{{"teamid", 1}, {"email", -1}}, Unique: true,
+		ExpireAfterSeconds: ttl(60), PartialFilter: bson.D{{Key: "status", Value: "expired"}}}
+
+	model := index.model()
+	keys := model.Keys.(bson.D)
+	if len(keys) != 2 || keys[0].Key != "teamid" || keys[1].Key != "email" || keys[1].Value != -1 {
+		t.Fatalf("Expected ordered compound keys but got %v", keys)
+	}
+
+	if *model.Options.Name != "teamid_email" || !*model.Options.Unique || *model.Options.ExpireAfterSeconds != 60 ||
+		model.Options.Sparse != nil || model.Options.PartialFilterExpression == nil {
+		t.Fatalf("Expected index options to match definition but got %+v", model.Options)
+	}
+
+	single := MongoIndex{Name: "email", Order: 1}.keys()
+	if len(single) != 1 || single[0].Key != "email" || single[0].Value != 1 {
+		t.Fatalf("Expected single key on index name but got %v", single)
+	}
+}
+
+func Test_PlanIndexes(t *testing.T) {
+
+	indexes := []MongoIndex{
+		{Name: "userid_email", Fields: []MongoIndexFields{{"userid", 1}, {"email", 1}}, Unique: true},
+		{Name: "email", Order: 1},
+		{Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)},
+	}
+
+	existing := []indexSpec{
+		{Name: MONGODB_ID_INDEX, Keys: bson.D{{Key: "_id", Value: int32(1)}}},
+		{Name: "userid_email", Keys: bson.D{{Key: "userid", Value: float64(1)}, {Key: "email", Value: int32(1)}}, Unique: true},
+		{Name: "email", Keys: bson.D{{Key: "email", Value: int32(1)}}, Unique: true},
+		{Name: "userid", Keys: bson.D{{Key: "userid", Value: int32(1)}}, Unique: true},
+	}
+
+	drop, create := planIndexes(existing, indexes)
+	if len(drop) != 2 || drop[0] != "email" || drop[1] != "userid" {
+		t.Fatalf("Expected changed and stale indexes to be dropped but got %v", drop)
+	}
+
+	if len(create) != 2 || create[0].Name != "email" || create[1].Name != "expires" {
+		t.Fatalf("Expected changed and missing indexes to be created but got %v", create)
+	}
+
+	existing = []indexSpec{existing[0], existing[1],
+		{Name: "email", Keys: bson.D{{Key: "email", Value: int32(1)}}},
+		{Name: "expires", Keys: bson.D{{Key: "expires", Value: int32(1)}}, ExpireAfterSeconds: ttl(0)},
+	}
+
+	if drop, create = planIndexes(existing, indexes); len(drop) != 0 || len(create) != 0 {
+		t.Fatalf("Expected matching indexes to be kept but got drop %v and create %v", drop, create)
+	}
+}
+
+func Test_SameIndexPartialFilter(t *testing.T) {
+
+	a := indexSpec{Name: "expireson", Keys: bson.D{{Key: "expireson", Value: 1}}, PartialFilter: bson.D{{Key: "status", Value: "expired"}}}
+	b := indexSpec{Name: "expireson", Keys: bson.D{{Key: "expireson", Value: int32(1)}}, PartialFilter: bson.D{{Key: "status", Value: "expired"}}}
+	if !sameIndex(a, b) {
+		t.Fatalf("Expected equal partial filters to match")
+	}
+
+	b.PartialFilter = bson.D{{Key: "status", Value: "pending"}}
+	if sameIndex(a, b) {
+		t.Fatalf("Expected different partial filters not to match")
+	}
+
+	b.PartialFilter = nil
+	if sameIndex(a, b) {
+		t.Fatalf("Expected missing partial filter not to match")
+	}
+}
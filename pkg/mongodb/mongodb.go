@@ -3,17 +3,15 @@ package mongodb
 import (
 	"context"
 	"fmt"
-	"github.com/zbitech/common/pkg/model/config"
-	"github.com/zbitech/common/pkg/utils"
 	"time"
 
 	"github.com/zbitech/common/pkg/errs"
 	"github.com/zbitech/common/pkg/logger"
-	"github.com/zbitech/common/pkg/model/entity"
 	"github.com/zbitech/common/pkg/rctx"
 	"github.com/zbitech/common/pkg/vars"
 
 	//	"github.com/zbi/utils/internal/helper"
+	"github.com/zbitech/repo/pkg/seed"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -31,34 +29,69 @@ var (
 	MONGODB_COLL_APIKEY_POLICY   = "apikey_policy"
 	MONGODB_COLL_TEAMS           = "teams"
 	MONGODB_COLL_TEAM_MEMBERS    = "team_members"
+	MONGODB_COLL_SESSIONS        = "sessions"
+	MONGODB_COLL_REVOKED_TOKENS  = "revoked_tokens"
+	MONGODB_COLL_WATERMARKS      = "token_watermarks"
+	MONGODB_COLL_LOGIN_ATTEMPTS  = "login_attempts"
+	MONGODB_COLL_PASSWORD_HIST   = "password_history"
+	MONGODB_COLL_RESET_TOKENS    = "reset_tokens"
+	MONGODB_COLL_MFA             = "mfa"
+	MONGODB_COLL_SERVICE_ACCOUNT = "service_accounts"
 	//	MONGODB_COLL_ROLE            = "roles"
 	//	MONGODB_COLL_GLOBAL_POLICY   = "global_policy"
 	//	MONGODB_COLL_SUMMARY         = "summary"
 
-	USER_INDEXES            = []MongoIndex{{Name: "userid", Order: 1, Unique: true}, {Name: "email", Order: 1, Unique: true}}
+	USER_INDEXES            = []MongoIndex{{Name: "userid_email", Fields: []MongoIndexFields{{"userid", 1}, {"email", 1}}, Unique: true}, {Name: "userid", Order: 1, Unique: true}, {Name: "email", Order: 1, Unique: true, PartialFilter: nonEmptyString("email")}}
 	PASS_INDEXES            = []MongoIndex{{Name: "userid", Order: 1, Unique: true}}
-	APIKEY_INDEXES          = []MongoIndex{{Name: "userid", Order: 1, Unique: true}, {Name: "key", Order: 1, Unique: true}, {Name: "expires", Order: 1, Unique: false}}
-	INSTANCE_POLICY_INDEXES = []MongoIndex{{Name: "project", Order: 1, Unique: false}, {Name: "instance", Order: 1, Unique: false}}
+	APIKEY_INDEXES          = []MongoIndex{{Name: "key_userid", Fields: []MongoIndexFields{{"key", 1}, {"userid", 1}}, Unique: true}, {Name: "key", Order: 1, Unique: true}, {Name: "userid", Order: 1}, {Name: "expires", Order: 1}}
+	INSTANCE_POLICY_INDEXES = []MongoIndex{{Name: "project_instance", Fields: []MongoIndexFields{{"project", 1}, {"instance", 1}}, Unique: true}}
 	USER_POLICY_INDEXES     = []MongoIndex{{Name: "userid", Order: 1, Unique: true}}
-	APIKEY_POLICY_INDEXES   = []MongoIndex{{Name: "key", Order: 1, Unique: false}}
-	PROJECT_INDEXES         = []MongoIndex{{Name: "name", Order: 1, Unique: true}, {Name: "owner", Order: 1, Unique: false}, {Name: "team", Order: 1, Unique: false}}
-	INSTANCE_INDEXES        = []MongoIndex{{Name: "project", Order: 1, Unique: false}, {Name: "name", Order: 1, Unique: false}, {Name: "owner", Order: 1, Unique: false}, {Name: "type", Order: 1, Unique: false}}
-	RESOURCE_INDEXES        = []MongoIndex{{Name: "project", Order: 1, Unique: false}, {Name: "instance", Order: 1, Unique: false}, {Name: "type", Order: 1, Unique: false}}
-	TEAM_INDEXES            = []MongoIndex{{Name: "name", Order: 1, Unique: false}, {Name: "owner", Order: 1, Unique: false}}
-	TEAM_MEMBER_INDEXES     = []MongoIndex{{Name: "team", Order: 1, Unique: false}, {Name: "email", Order: 1, Unique: false}, {Name: "key", Order: 1, Unique: true}}
+	APIKEY_POLICY_INDEXES   = []MongoIndex{{Name: "key", Order: 1, Unique: true}}
+	PROJECT_INDEXES         = []MongoIndex{{Name: "name_owner_team", Fields: []MongoIndexFields{{"name", 1}, {"owner", 1}, {"team", 1}}, Unique: true}, {Name: "owner", Order: 1}, {Name: "team", Order: 1}}
+	INSTANCE_INDEXES        = []MongoIndex{{Name: "project_name_type_owner", Fields: []MongoIndexFields{{"project", 1}, {"name", 1}, {"type", 1}, {"owner", 1}}, Unique: true}, {Name: "owner", Order: 1}}
+	RESOURCE_INDEXES        = []MongoIndex{{Name: "project_instance_type_name", Fields: []MongoIndexFields{{"project", 1}, {"instance", 1}, {"resource.type", 1}, {"resource.name", 1}}, Unique: true}, {Name: "level_project_instance", Fields: []MongoIndexFields{{"level", 1}, {"project", 1}, {"instance", 1}}}}
+	TEAM_INDEXES            = []MongoIndex{{Name: "teamid_owner", Fields: []MongoIndexFields{{"teamid", 1}, {"owner", 1}}, Unique: true}, {Name: "teamid", Order: 1, Unique: true}, {Name: "name", Order: 1}, {Name: "owner", Order: 1}}
+	TEAM_MEMBER_INDEXES     = []MongoIndex{{Name: "teamid_email_key", Fields: []MongoIndexFields{{"teamid", 1}, {"email", 1}, {"key", 1}}, Unique: true}, {Name: "email", Order: 1}, {Name: "key", Order: 1, Unique: true}}
+	SESSION_INDEXES         = []MongoIndex{{Name: "userid_device", Fields: []MongoIndexFields{{"userid", 1}, {"device", 1}}}, {Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}
+	REVOKED_TOKEN_INDEXES   = []MongoIndex{{Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}
+	WATERMARK_INDEXES       = []MongoIndex{{Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}
+	LOGIN_ATTEMPT_INDEXES   = []MongoIndex{{Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}
+	PASS_HISTORY_INDEXES    = []MongoIndex{}
+	RESET_TOKEN_INDEXES     = []MongoIndex{{Name: "userid", Order: 1}, {Name: "expires", Order: 1, ExpireAfterSeconds: ttl(0)}}
+	MFA_INDEXES             = []MongoIndex{}
+	SERVICE_ACCT_INDEXES    = []MongoIndex{{Name: "teamid", Order: 1}}
+
+	COLLECTIONS = []MongoCollection{
+		{MONGODB_COLL_USERS, USER_INDEXES}, {MONGODB_COLL_PASSWORD, PASS_INDEXES}, {MONGODB_COLL_APIKEY, APIKEY_INDEXES},
+		{MONGODB_COLL_PROJECTS, PROJECT_INDEXES}, {MONGODB_COLL_INSTANCES, INSTANCE_INDEXES}, {MONGODB_COLL_RESOURCES, RESOURCE_INDEXES},
+		{MONGODB_COLL_INSTANCE_POLICY, INSTANCE_POLICY_INDEXES}, {MONGODB_COLL_USER_POLICY, USER_POLICY_INDEXES}, {MONGODB_COLL_APIKEY_POLICY, APIKEY_POLICY_INDEXES},
+		{MONGODB_COLL_TEAMS, TEAM_INDEXES}, {MONGODB_COLL_TEAM_MEMBERS, TEAM_MEMBER_INDEXES}, {MONGODB_COLL_SESSIONS, SESSION_INDEXES},
+		{MONGODB_COLL_REVOKED_TOKENS, REVOKED_TOKEN_INDEXES}, {MONGODB_COLL_WATERMARKS, WATERMARK_INDEXES},
+		{MONGODB_COLL_LOGIN_ATTEMPTS, LOGIN_ATTEMPT_INDEXES}, {MONGODB_COLL_PASSWORD_HIST, PASS_HISTORY_INDEXES},
+		{MONGODB_COLL_RESET_TOKENS, RESET_TOKEN_INDEXES}, {MONGODB_COLL_MFA, MFA_INDEXES},
+		{MONGODB_COLL_SERVICE_ACCOUNT, SERVICE_ACCT_INDEXES},
+	}
 	//	ROLE_INDEXES            = []MongoIndex{{Name: "name", Order: 1, Unique: true}}
 	//	ORGANIZATION_INDEXES    = []MongoIndex{{Name: "name", Order: 1, Unique: true}}
 	//	SUMMARY_INDEXES         = []MongoIndex{{Name: "type", Order: 1, Unique: false}}
 )
 
+// MongoIndex describes an index on a collection. The index is on the field Name unless Fields lists the keys of a
+// compound index, in which case Name only names the index. ExpireAfterSeconds makes it a TTL index and
+// PartialFilter limits it to the documents matching the filter.
 type MongoIndex struct {
-	//	Fields []struct {
-	//		Name  string
-	//		Order int
-	//	}
-	Name   string
-	Order  int
-	Unique bool
+	Name               string
+	Order              int
+	Fields             []MongoIndexFields
+	Unique             bool
+	Sparse             bool
+	ExpireAfterSeconds *int32
+	PartialFilter      bson.D
+}
+
+type MongoCollection struct {
+	Name    string
+	Indexes []MongoIndex
 }
 
 type MongoIndexFields struct {
@@ -75,28 +108,22 @@ func handleMongoError(ctx context.Context, err error) error {
 	return errs.ErrDBError
 }
 
-func CreateCollection(ctx context.Context, db *mongo.Database, collName string, indexes []MongoIndex, errs []error) {
+func CreateCollection(ctx context.Context, db *mongo.Database, collName string, indexes []MongoIndex) error {
 
-	opt := options.CreateCollection()
-	err := db.CreateCollection(ctx, collName, opt)
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": collName})
 	if err != nil {
-		logger.Errorf(ctx, "Unable to Create collection %s - %s", collName, err)
-		errs = append(errs, err)
+		logger.Errorf(ctx, "Unable to list collection %s - %s", collName, err)
+		return err
 	}
 
-	coll := db.Collection(collName)
-
-	for _, index := range indexes {
-		m_index := mongo.IndexModel{
-			Keys:    bson.M{index.Name: index.Order},
-			Options: options.Index().SetUnique(index.Unique).SetName(index.Name)}
-		_, err := coll.Indexes().CreateOne(ctx, m_index)
-		if err != nil {
-			logger.Errorf(ctx, "Unable to create index %s.%s - %s", collName, m_index.Keys, err)
-			errs = append(errs, err)
+	if len(names) == 0 {
+		if err = db.CreateCollection(ctx, collName, options.CreateCollection()); err != nil {
+			logger.Errorf(ctx, "Unable to Create collection %s - %s", collName, err)
+			return err
 		}
 	}
 
+	return ReconcileIndexes(ctx, db.Collection(collName), indexes)
 }
 
 func PurgeCollection(ctx context.Context, db *mongo.Database, collName string, errs []error) (int64, error) {
@@ -126,25 +153,17 @@ func DropCollection(ctx context.Context, db *mongo.Database, collName string, er
 }
 
 func CreateCollections(ctx context.Context, conn *MongoDBConnection) error {
+	return createCollections(ctx, conn.GetDatabase(vars.MONGODB_NAME))
+}
 
-	db := conn.GetDatabase(vars.MONGODB_NAME)
+func createCollections(ctx context.Context, db *mongo.Database) error {
 
 	errs := make([]error, 0)
-
-	CreateCollection(ctx, db, MONGODB_COLL_USERS, USER_INDEXES, errs)
-	CreateCollection(ctx, db, MONGODB_COLL_PASSWORD, PASS_INDEXES, errs)
-	CreateCollection(ctx, db, MONGODB_COLL_APIKEY, APIKEY_INDEXES, errs)
-
-	CreateCollection(ctx, db, MONGODB_COLL_PROJECTS, PROJECT_INDEXES, errs)
-	CreateCollection(ctx, db, MONGODB_COLL_INSTANCES, INSTANCE_INDEXES, errs)
-	CreateCollection(ctx, db, MONGODB_COLL_RESOURCES, RESOURCE_INDEXES, errs)
-
-	CreateCollection(ctx, db, MONGODB_COLL_INSTANCE_POLICY, INSTANCE_POLICY_INDEXES, errs)
-	CreateCollection(ctx, db, MONGODB_COLL_USER_POLICY, USER_POLICY_INDEXES, errs)
-	CreateCollection(ctx, db, MONGODB_COLL_APIKEY_POLICY, APIKEY_POLICY_INDEXES, errs)
-
-	CreateCollection(ctx, db, MONGODB_COLL_TEAMS, TEAM_INDEXES, errs)
-	CreateCollection(ctx, db, MONGODB_COLL_TEAM_MEMBERS, TEAM_MEMBER_INDEXES, errs)
+	for _, coll := range COLLECTIONS {
+		if err := CreateCollection(ctx, db, coll.Name, coll.Indexes); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
 	if len(errs) > 0 {
 		return fmt.Errorf("Create Error: %s", errs)
@@ -172,6 +191,14 @@ func PurgeCollections(ctx context.Context, conn *MongoDBConnection) error {
 
 	PurgeCollection(ctx, db, MONGODB_COLL_TEAMS, errs)
 	PurgeCollection(ctx, db, MONGODB_COLL_TEAM_MEMBERS, errs)
+	PurgeCollection(ctx, db, MONGODB_COLL_SESSIONS, errs)
+	PurgeCollection(ctx, db, MONGODB_COLL_REVOKED_TOKENS, errs)
+	PurgeCollection(ctx, db, MONGODB_COLL_WATERMARKS, errs)
+	PurgeCollection(ctx, db, MONGODB_COLL_LOGIN_ATTEMPTS, errs)
+	PurgeCollection(ctx, db, MONGODB_COLL_PASSWORD_HIST, errs)
+	PurgeCollection(ctx, db, MONGODB_COLL_RESET_TOKENS, errs)
+	PurgeCollection(ctx, db, MONGODB_COLL_MFA, errs)
+	PurgeCollection(ctx, db, MONGODB_COLL_SERVICE_ACCOUNT, errs)
 
 	if len(errs) > 0 {
 		return fmt.Errorf("Purge Error: %s", errs)
@@ -180,10 +207,12 @@ func PurgeCollections(ctx context.Context, conn *MongoDBConnection) error {
 }
 
 func DropCollections(ctx context.Context, conn *MongoDBConnection) error {
+	return dropCollections(ctx, conn.GetDatabase(vars.MONGODB_NAME))
+}
 
-	errs := make([]error, 0)
+func dropCollections(ctx context.Context, db *mongo.Database) error {
 
-	db := conn.GetDatabase(vars.MONGODB_NAME)
+	errs := make([]error, 0)
 
 	DropCollection(ctx, db, MONGODB_COLL_USERS, errs)
 	DropCollection(ctx, db, MONGODB_COLL_PASSWORD, errs)
@@ -199,6 +228,14 @@ func DropCollections(ctx context.Context, conn *MongoDBConnection) error {
 
 	DropCollection(ctx, db, MONGODB_COLL_TEAMS, errs)
 	DropCollection(ctx, db, MONGODB_COLL_TEAM_MEMBERS, errs)
+	DropCollection(ctx, db, MONGODB_COLL_SESSIONS, errs)
+	DropCollection(ctx, db, MONGODB_COLL_REVOKED_TOKENS, errs)
+	DropCollection(ctx, db, MONGODB_COLL_WATERMARKS, errs)
+	DropCollection(ctx, db, MONGODB_COLL_LOGIN_ATTEMPTS, errs)
+	DropCollection(ctx, db, MONGODB_COLL_PASSWORD_HIST, errs)
+	DropCollection(ctx, db, MONGODB_COLL_RESET_TOKENS, errs)
+	DropCollection(ctx, db, MONGODB_COLL_MFA, errs)
+	DropCollection(ctx, db, MONGODB_COLL_SERVICE_ACCOUNT, errs)
 
 	if len(errs) > 0 {
 		return fmt.Errorf("Drop Error: %s", errs)
@@ -206,76 +243,18 @@ func DropCollections(ctx context.Context, conn *MongoDBConnection) error {
 	return nil
 }
 
-func LoadDatabase(ctx context.Context, conn *MongoDBConnection, create_users bool) {
+// LoadDatabase adds the users, keys, teams and members defined in admin.yaml that are not stored yet
+func LoadDatabase(ctx context.Context, conn *MongoDBConnection, dryRun bool) (*seed.Report, error) {
 	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "LoadDatabase"),
 		rctx.Context(rctx.StartTime, time.Now()))
 	defer logger.LogComponentTime(ctx)
 
-	if create_users {
-		var adminConfig config.AdminConfig
-		config_path := fmt.Sprintf("%s/admin.yaml", vars.ASSET_PATH_DIRECTORY)
-		err := utils.ReadConfig(config_path, nil, &adminConfig)
-		if err != nil {
-			logger.Errorf(ctx, "Unable to load users & keys: %s", err)
-		} else {
-
-			userColl := conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
-			polColl := conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USER_POLICY)
-			passColl := conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_PASSWORD)
-
-			for _, user := range adminConfig.Users {
-				if user.Memberships == nil {
-					user.Memberships = make([]entity.UserTeam, 0)
-				}
-				user.Created = time.Now()
-				user.LastUpdate = time.Now()
-				user.Active = true
-
-				userid := user.UserId
-				password := adminConfig.Passwords[userid]
-				userPass := entity.NewUserPassword(userid, password)
-				userPolicy := entity.NewUserPolicy(user.UserId)
-
-				userColl.InsertOne(ctx, user)
-				passColl.InsertOne(ctx, userPass)
-				polColl.InsertOne(ctx, userPolicy)
-			}
-
-			apikeyColl := conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY)
-			keyPolColl := conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_APIKEY_POLICY)
-
-			for _, apikey := range adminConfig.Keys {
-				apikey.Created = time.Now()
-				apikey.Expires = apikey.Created.Add(time.Hour * 8760)
-				keyPolicy := entity.NewAPIKeyPolicy(apikey.Key, true)
-
-				apikeyColl.InsertOne(ctx, apikey)
-				keyPolColl.InsertOne(ctx, keyPolicy)
-			}
-
-			teamColl := conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_TEAMS)
-			for _, team := range adminConfig.Teams {
-				team.Created = time.Now()
-				team.LastUpdate = time.Now()
-				teamColl.InsertOne(ctx, team)
-			}
-
-			mbrColl := conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_TEAM_MEMBERS)
-			for _, mbr := range adminConfig.Members {
-				mbr.CreatedOn = time.Now()
-				mbr.LastUpdate = time.Now()
-				mbrColl.InsertOne(ctx, mbr)
-			}
-		}
+	adminConfig, err := seed.ReadAdminConfig(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	//	globalPolicy, err := helper.GetGlobalPolicy(vars.ASSET_PATH_DIRECTORY)
-	//	if err != nil {
-	//		logger.Fatalf(ctx, "Unable to load global policy - %s", err)
-	//	}
-
-	//	policy_coll := conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_GLOBAL_POLICY)
-	//	policy_coll.InsertOne(ctx, globalPolicy)
+	return seed.Load(ctx, NewAdminMongoRepository(conn), adminConfig, dryRun)
 }
 
 func CountDocuments(ctx context.Context, conn *MongoDBConnection, database, collectionName string, filter bson.M) int {
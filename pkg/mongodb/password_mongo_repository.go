@@ -0,0 +1,63 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/password"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (m *AdminMongoRepository) GetPassword(ctx context.Context, userId string) (*entity.UserPassword, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetPassword"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_PASSWORD)
+	result := coll.FindOne(ctx, bson.M{"userid": userId})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var pass entity.UserPassword
+	if err := result.Decode(&pass); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &pass, nil
+}
+
+func (m *AdminMongoRepository) GetPasswordHistory(ctx context.Context, userId string) (*password.History, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetPasswordHistory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_PASSWORD_HIST)
+	result := coll.FindOne(ctx, bson.M{"_id": userId})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var history password.History
+	if err := result.Decode(&history); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &history, nil
+}
+
+func (m *AdminMongoRepository) PutPasswordHistory(ctx context.Context, history *password.History) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutPasswordHistory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_PASSWORD_HIST)
+	if _, err := coll.ReplaceOne(ctx, bson.M{"_id": history.UserId}, history, options.Replace().SetUpsert(true)); err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	return nil
+}
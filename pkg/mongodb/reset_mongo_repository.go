@@ -0,0 +1,80 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/reset"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (m *AdminMongoRepository) CreateResetToken(ctx context.Context, t *reset.ResetToken) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
+		coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_RESET_TOKENS)
+		if _, err := coll.DeleteMany(ctx, bson.M{"userid": t.UserId}); err != nil {
+			return err
+		}
+
+		_, err := tx.InsertOne(ctx, coll, t)
+		return err
+	})
+
+	if err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) GetResetToken(ctx context.Context, hash string) (*reset.ResetToken, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_RESET_TOKENS)
+	result := coll.FindOne(ctx, bson.M{"_id": hash})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var t reset.ResetToken
+	if err := result.Decode(&t); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &t, nil
+}
+
+func (m *AdminMongoRepository) ConsumeResetToken(ctx context.Context, hash string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ConsumeResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_RESET_TOKENS)
+	result, err := coll.DeleteOne(ctx, bson.M{"_id": hash})
+	if err != nil {
+		return handleMongoError(ctx, err)
+	} else if result.DeletedCount == 0 {
+		return errs.ErrDBItemNotFound
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) PurgeExpiredResetTokens(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredResetTokens"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_RESET_TOKENS)
+	result, err := coll.DeleteMany(ctx, bson.M{"expires": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, handleMongoError(ctx, err)
+	}
+
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,87 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/revocation"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (m *AdminMongoRepository) RevokeToken(ctx context.Context, token *revocation.RevokedToken) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RevokeToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_REVOKED_TOKENS)
+	if _, err := coll.ReplaceOne(ctx, bson.M{"_id": token.Id}, token, options.Replace().SetUpsert(true)); err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "IsTokenRevoked"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_REVOKED_TOKENS)
+	count, err := coll.CountDocuments(ctx, bson.M{"_id": tokenId})
+	if err != nil {
+		return false, handleMongoError(ctx, err)
+	}
+
+	return count > 0, nil
+}
+
+func (m *AdminMongoRepository) SetWatermark(ctx context.Context, w *revocation.Watermark) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetWatermark"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_WATERMARKS)
+	if _, err := coll.ReplaceOne(ctx, bson.M{"_id": w.UserId}, w, options.Replace().SetUpsert(true)); err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) GetWatermark(ctx context.Context, userId string) (*revocation.Watermark, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetWatermark"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_WATERMARKS)
+	result := coll.FindOne(ctx, bson.M{"_id": userId})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var w revocation.Watermark
+	if err := result.Decode(&w); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &w, nil
+}
+
+// PurgeExpiredRevocations removes expired items without waiting for the TTL monitor, which runs once a minute
+func (m *AdminMongoRepository) PurgeExpiredRevocations(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredRevocations"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	for _, name := range []string{MONGODB_COLL_REVOKED_TOKENS, MONGODB_COLL_WATERMARKS} {
+		coll := m.conn.GetCollection(vars.MONGODB_NAME, name)
+		result, err := coll.DeleteMany(ctx, bson.M{"expires": bson.M{"$lte": now}})
+		if err != nil {
+			return 0, handleMongoError(ctx, err)
+		}
+		count += result.DeletedCount
+	}
+
+	return count, nil
+}
@@ -0,0 +1,135 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type seedDocument struct {
+	collection string
+	filter     bson.M
+	doc        interface{}
+}
+
+// upsertMissing inserts doc unless a document already matches filter. The insert is registered for rollback.
+func upsertMissing(ctx context.Context, tx *MongoTransaction, coll *mongo.Collection, filter bson.M, doc interface{}) error {
+
+	result, err := coll.UpdateOne(ctx, filter, bson.M{"$setOnInsert": doc}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	if result.UpsertedID != nil {
+		tx.OnRollback(func(ctx context.Context) error {
+			_, err := coll.DeleteOne(ctx, bson.M{"_id": result.UpsertedID})
+			return err
+		})
+	}
+
+	return nil
+}
+
+// seed decodes the stored item into stored and reports true if item exists. Otherwise it inserts item and its
+// dependents, unless dryRun is set.
+func (m *AdminMongoRepository) seed(ctx context.Context, item seedDocument, stored interface{}, dryRun bool, dependents ...seedDocument) (bool, error) {
+
+	found := false
+	err := m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
+
+		coll := m.conn.GetCollection(vars.MONGODB_NAME, item.collection)
+		err := coll.FindOne(ctx, item.filter).Decode(stored)
+		if err == nil {
+			found = true
+			return nil
+		} else if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		for _, document := range append([]seedDocument{item}, dependents...) {
+			coll = m.conn.GetCollection(vars.MONGODB_NAME, document.collection)
+			if err = upsertMissing(ctx, tx, coll, document.filter, document.doc); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if mongo.IsDuplicateKeyError(err) {
+		return false, errs.ErrDBKeyAlreadyExists
+	} else if err != nil {
+		return false, handleMongoError(ctx, err)
+	}
+
+	return found, nil
+}
+
+func (m *AdminMongoRepository) SeedUser(ctx context.Context, user *entity.User, pass *entity.UserPassword, policy *entity.UserPolicy, dryRun bool) (*entity.User, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	filter := bson.M{"userid": user.UserId}
+	var stored entity.User
+	found, err := m.seed(ctx, seedDocument{MONGODB_COLL_USERS, filter, user}, &stored, dryRun,
+		seedDocument{MONGODB_COLL_PASSWORD, filter, pass}, seedDocument{MONGODB_COLL_USER_POLICY, filter, policy})
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+func (m *AdminMongoRepository) SeedAPIKey(ctx context.Context, apikey *apikeys.Record, policy *entity.APIKeyPolicy, dryRun bool) (*apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	filter := bson.M{"key": apikey.Key}
+	var stored apikeys.Record
+	found, err := m.seed(ctx, seedDocument{MONGODB_COLL_APIKEY, filter, apikey}, &stored, dryRun,
+		seedDocument{MONGODB_COLL_APIKEY_POLICY, filter, policy})
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+func (m *AdminMongoRepository) SeedTeam(ctx context.Context, team *entity.Team, dryRun bool) (*entity.Team, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var stored entity.Team
+	found, err := m.seed(ctx, seedDocument{MONGODB_COLL_TEAMS, bson.M{"_id": team.TeamId}, team}, &stored, dryRun)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+func (m *AdminMongoRepository) SeedTeamMember(ctx context.Context, member *entity.TeamMember, dryRun bool) (*entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedTeamMember"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var stored entity.TeamMember
+	found, err := m.seed(ctx, seedDocument{MONGODB_COLL_TEAM_MEMBERS, bson.M{"key": member.Key}, member}, &stored, dryRun)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &stored, nil
+}
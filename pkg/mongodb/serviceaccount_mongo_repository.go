@@ -0,0 +1,122 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (m *AdminMongoRepository) CreateServiceAccount(ctx context.Context, sa *serviceaccount.ServiceAccount, user *entity.User) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	userColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
+	saColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SERVICE_ACCOUNT)
+	mbrColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_TEAM_MEMBERS)
+
+	return m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
+
+		userErr := userColl.FindOne(ctx, bson.M{"userid": user.UserId}).Err()
+		if userErr == nil {
+			return errs.ErrUserAlreadyExists
+		} else if userErr != mongo.ErrNoDocuments {
+			return handleMongoError(ctx, userErr)
+		}
+
+		if _, err := tx.InsertOne(ctx, userColl, user); err != nil {
+			logger.Errorf(ctx, "Error inserting service account user - %s", err)
+			return errs.ErrDBItemInsertFailed
+		}
+
+		if _, err := tx.InsertOne(ctx, saColl, sa); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return errs.ErrUserAlreadyExists
+			}
+			logger.Errorf(ctx, "Error inserting service account - %s", err)
+			return errs.ErrDBItemInsertFailed
+		}
+
+		if _, err := tx.InsertOne(ctx, mbrColl, sa.TeamMember()); err != nil {
+			logger.Errorf(ctx, "Error inserting service account team member - %s", err)
+			return errs.ErrDBItemInsertFailed
+		}
+
+		return nil
+	})
+}
+
+func (m *AdminMongoRepository) GetServiceAccount(ctx context.Context, id string) (*serviceaccount.ServiceAccount, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SERVICE_ACCOUNT)
+	result := coll.FindOne(ctx, bson.M{"_id": id})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var sa serviceaccount.ServiceAccount
+	if err := result.Decode(&sa); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &sa, nil
+}
+
+func (m *AdminMongoRepository) GetServiceAccounts(ctx context.Context, teamId string) ([]serviceaccount.ServiceAccount, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetServiceAccounts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SERVICE_ACCOUNT)
+	cursor, err := coll.Find(ctx, bson.M{"teamid": teamId})
+	if err != nil {
+		return nil, handleMongoError(ctx, err)
+	}
+
+	accounts := make([]serviceaccount.ServiceAccount, 0)
+	if err = cursor.All(ctx, &accounts); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return accounts, nil
+}
+
+func (m *AdminMongoRepository) DeleteServiceAccount(ctx context.Context, id string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	saColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SERVICE_ACCOUNT)
+	userColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
+	polColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USER_POLICY)
+	mbrColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_TEAM_MEMBERS)
+
+	return m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
+
+		count, err := tx.DeleteMany(ctx, saColl, bson.M{"_id": id})
+		if err != nil {
+			return handleMongoError(ctx, err)
+		} else if count == 0 {
+			return errs.ErrDBItemNotFound
+		}
+
+		for _, coll := range []*mongo.Collection{userColl, polColl} {
+			if _, err = tx.DeleteMany(ctx, coll, bson.M{"userid": id}); err != nil {
+				return handleMongoError(ctx, err)
+			}
+		}
+
+		if _, err = tx.DeleteMany(ctx, mbrColl, bson.M{"key": id}); err != nil {
+			return handleMongoError(ctx, err)
+		}
+
+		return nil
+	})
+}
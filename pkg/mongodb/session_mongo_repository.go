@@ -0,0 +1,137 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/session"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (m *AdminMongoRepository) CreateSession(ctx context.Context, s *session.Session) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
+		coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SESSIONS)
+		if _, err := coll.DeleteMany(ctx, bson.M{"userid": s.UserId, "device": s.Device}); err != nil {
+			return err
+		}
+
+		_, err := tx.InsertOne(ctx, coll, s)
+		return err
+	})
+
+	if err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) GetSession(ctx context.Context, sessionId string) (*session.Session, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SESSIONS)
+	result := coll.FindOne(ctx, bson.M{"_id": sessionId})
+	if result.Err() != nil {
+		return nil, handleMongoError(ctx, result.Err())
+	}
+
+	var s session.Session
+	if err := result.Decode(&s); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return &s, nil
+}
+
+func (m *AdminMongoRepository) RotateSession(ctx context.Context, tokenHash string, next *session.Session) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SESSIONS)
+	result, err := coll.ReplaceOne(ctx, bson.M{"_id": next.Id, "tokenhash": tokenHash}, next)
+	if err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	if result.MatchedCount == 0 {
+		count, err := coll.CountDocuments(ctx, bson.M{"_id": next.Id})
+		if err != nil {
+			return handleMongoError(ctx, err)
+		} else if count == 0 {
+			return errs.ErrDBItemNotFound
+		}
+		return session.ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) GetSessions(ctx context.Context, userId string) ([]session.Session, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SESSIONS)
+	items, err := coll.Find(ctx, bson.M{"userid": userId})
+	if err != nil {
+		return nil, handleMongoError(ctx, err)
+	}
+
+	sessions := make([]session.Session, 0)
+	if err = items.All(ctx, &sessions); err != nil {
+		return nil, errs.ErrMarshalFailed
+	}
+
+	return sessions, nil
+}
+
+func (m *AdminMongoRepository) DeleteSession(ctx context.Context, sessionId string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SESSIONS)
+	result, err := coll.DeleteOne(ctx, bson.M{"_id": sessionId})
+	if err != nil {
+		return handleMongoError(ctx, err)
+	}
+
+	if result.DeletedCount == 0 {
+		return errs.ErrDBItemNotFound
+	}
+
+	return nil
+}
+
+func (m *AdminMongoRepository) DeleteSessions(ctx context.Context, userId string) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SESSIONS)
+	result, err := coll.DeleteMany(ctx, bson.M{"userid": userId})
+	if err != nil {
+		return 0, handleMongoError(ctx, err)
+	}
+
+	return result.DeletedCount, nil
+}
+
+// PurgeExpiredSessions removes expired sessions without waiting for the TTL monitor, which runs once a minute
+func (m *AdminMongoRepository) PurgeExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	coll := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_SESSIONS)
+	result, err := coll.DeleteMany(ctx, bson.M{"expires": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, handleMongoError(ctx, err)
+	}
+
+	return result.DeletedCount, nil
+}
@@ -59,20 +59,40 @@ func (m *AdminMongoRepository) CreateTeam(ctx context.Context, team entity.Team)
 	defer logger.LogComponentTime(ctx)
 
 	collection := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_TEAMS)
-	filter := bson.M{"_id": team.TeamId}
-	result := collection.FindOne(ctx, filter)
+	userColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
 
-	err := result.Err()
-	if err == mongo.ErrNoDocuments {
-		_, err = collection.InsertOne(ctx, team)
-	}
+	return m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
 
-	if err != nil {
-		logger.Errorf(ctx, "Unable to insert team %s - %s", utils.MarshalObject(team), err)
-		return errs.ErrDBItemInsertFailed
-	}
+		filter := bson.M{"_id": team.TeamId}
+		err := collection.FindOne(ctx, filter).Err()
+		if err == nil {
+			return nil
+		} else if err != mongo.ErrNoDocuments {
+			return handleMongoError(ctx, err)
+		}
 
-	return nil
+		if _, err = tx.InsertOne(ctx, collection, team); err != nil {
+			logger.Errorf(ctx, "Unable to insert team %s - %s", utils.MarshalObject(team), err)
+			return errs.ErrDBItemInsertFailed
+		}
+
+		membership := entity.UserTeam{TeamId: team.TeamId}
+		ownerFilter := bson.M{"userid": team.Owner, "memberships.teamid": bson.M{"$ne": team.TeamId}}
+		result, err := userColl.UpdateOne(ctx, ownerFilter, bson.M{"$push": bson.M{"memberships": membership}})
+		if err != nil {
+			logger.Errorf(ctx, "Unable to add team %s to owner %s - %s", team.TeamId, team.Owner, err)
+			return errs.ErrDBItemInsertFailed
+		}
+
+		if result.ModifiedCount > 0 {
+			tx.OnRollback(func(ctx context.Context) error {
+				_, err := userColl.UpdateOne(ctx, bson.M{"userid": team.Owner}, bson.M{"$pull": bson.M{"memberships": bson.M{"teamid": team.TeamId}}})
+				return err
+			})
+		}
+
+		return nil
+	})
 }
 
 func (m *AdminMongoRepository) GetTeams(ctx context.Context) ([]entity.Team, error) {
@@ -326,16 +346,59 @@ func (m *AdminMongoRepository) DeleteTeam(ctx context.Context, teamId string) er
 	defer logger.LogComponentTime(ctx)
 
 	collection := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_TEAMS)
-	filter := bson.M{"_id": teamId}
+	mbrColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_TEAM_MEMBERS)
+	userColl := m.conn.GetCollection(vars.MONGODB_NAME, MONGODB_COLL_USERS)
 
-	result := collection.FindOneAndDelete(ctx, filter)
-	err := result.Err()
+	return m.conn.RunTransaction(ctx, func(ctx context.Context, tx *MongoTransaction) error {
 
-	if err != nil {
-		return handleMongoError(ctx, err)
-	}
+		var team bson.Raw
+		if err := collection.FindOneAndDelete(ctx, bson.M{"_id": teamId}).Decode(&team); err != nil {
+			return handleMongoError(ctx, err)
+		}
 
-	return nil
+		tx.OnRollback(func(ctx context.Context) error {
+			_, err := collection.InsertOne(ctx, team)
+			return err
+		})
+
+		if _, err := tx.DeleteMany(ctx, mbrColl, bson.M{"teamid": teamId}); err != nil {
+			return handleMongoError(ctx, err)
+		}
+
+		userFilter := bson.M{"memberships.teamid": teamId}
+		var users []entity.User
+		if !tx.Transactional() {
+			cursor, err := userColl.Find(ctx, userFilter)
+			if err != nil {
+				return handleMongoError(ctx, err)
+			}
+			if err = cursor.All(ctx, &users); err != nil {
+				return handleMongoError(ctx, err)
+			}
+		}
+
+		_, err := userColl.UpdateMany(ctx, userFilter, bson.M{"$pull": bson.M{"memberships": bson.M{"teamid": teamId}}})
+		if err != nil {
+			return handleMongoError(ctx, err)
+		}
+
+		tx.OnRollback(func(ctx context.Context) error {
+			for _, user := range users {
+				for _, membership := range user.Memberships {
+					if membership.TeamId != teamId {
+						continue
+					}
+					_, err := userColl.UpdateOne(ctx, bson.M{"userid": user.UserId}, bson.M{"$push": bson.M{"memberships": membership}})
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+
+		return nil
+	})
 }
 
 func (m *AdminMongoRepository) GetAllMemberships(ctx context.Context) ([]entity.TeamMember, error) {
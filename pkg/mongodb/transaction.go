@@ -0,0 +1,145 @@
+package mongodb
+
+import (
+	"context"
+
+	"github.com/zbitech/common/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoTransaction is passed to the function run by RunTransaction. Writes that span several documents register
+// how to undo themselves with OnRollback; the undo functions only run when the server cannot run transactions.
+type MongoTransaction struct {
+	transactional bool
+	undo          []func(ctx context.Context) error
+}
+
+// Transactional reports whether the writes run in a server transaction
+func (t *MongoTransaction) Transactional() bool {
+	return t.transactional
+}
+
+// OnRollback registers a compensating write that runs if the transaction fails on a standalone server
+func (t *MongoTransaction) OnRollback(undo func(ctx context.Context) error) {
+	if !t.transactional {
+		t.undo = append(t.undo, undo)
+	}
+}
+
+// InsertOne inserts doc into coll and registers its removal as the compensating write
+func (t *MongoTransaction) InsertOne(ctx context.Context, coll *mongo.Collection, doc interface{}) (interface{}, error) {
+	result, err := coll.InsertOne(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	t.OnRollback(func(ctx context.Context) error {
+		_, err := coll.DeleteOne(ctx, bson.M{"_id": result.InsertedID})
+		return err
+	})
+
+	return result.InsertedID, nil
+}
+
+// DeleteMany deletes the documents of coll that match filter and registers their reinsertion as the compensating
+// write. The documents are only read when the server cannot run transactions.
+func (t *MongoTransaction) DeleteMany(ctx context.Context, coll *mongo.Collection, filter interface{}) (int64, error) {
+	var docs []interface{}
+	if !t.transactional {
+		cursor, err := coll.Find(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+
+		var raw []bson.Raw
+		if err = cursor.All(ctx, &raw); err != nil {
+			return 0, err
+		}
+		for _, doc := range raw {
+			docs = append(docs, doc)
+		}
+	}
+
+	result, err := coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(docs) > 0 {
+		t.OnRollback(func(ctx context.Context) error {
+			_, err := coll.InsertMany(ctx, docs)
+			return err
+		})
+	}
+
+	return result.DeletedCount, nil
+}
+
+// rollback runs the compensating writes in reverse order. Failures are logged and do not stop the remaining writes.
+func (t *MongoTransaction) rollback(ctx context.Context) {
+	for index := len(t.undo) - 1; index >= 0; index-- {
+		if err := t.undo[index](ctx); err != nil {
+			logger.Errorf(ctx, "Unable to roll back write - %s", err)
+		}
+	}
+	t.undo = nil
+}
+
+// supportsTransactions reports whether the server is a replica set member or a mongos, which are the deployments
+// that accept multi-document transactions. The answer is cached for the life of the connection.
+func (m *MongoDBConnection) supportsTransactions(ctx context.Context) bool {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.transactions != nil {
+		return *m.transactions
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := m.client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to determine server topology, transactions are disabled - %s", err)
+		return false
+	}
+
+	supported := len(hello.SetName) > 0 || hello.Msg == "isdbgrid"
+	m.transactions = &supported
+	if !supported {
+		logger.Infof(ctx, "Mongo-DB server is standalone, multi-document writes use compensating rollback")
+	}
+
+	return supported
+}
+
+// RunTransaction runs fn in a transaction when the server supports them. On a standalone server fn runs without a
+// session and, if it fails, the compensating writes registered with the MongoTransaction are applied.
+func (m *MongoDBConnection) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx *MongoTransaction) error) error {
+
+	if !m.supportsTransactions(ctx) {
+		tx := &MongoTransaction{transactional: false}
+		if err := fn(ctx, tx); err != nil {
+			tx.rollback(ctx)
+			return err
+		}
+		return nil
+	}
+
+	session, err := m.client.StartSession()
+	if err != nil {
+		logger.Errorf(ctx, "Unable to start session - %s", err)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc, &MongoTransaction{transactional: true})
+	})
+
+	return err
+}
@@ -0,0 +1,41 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_TransactionRollback(t *testing.T) {
+
+	ctx := context.Background()
+	order := make([]int, 0)
+
+	tx := &MongoTransaction{}
+	for index := 1; index <= 3; index++ {
+		step := index
+		tx.OnRollback(func(ctx context.Context) error {
+			order = append(order, step)
+			if step == 2 {
+				return errors.New("undo failed")
+			}
+			return nil
+		})
+	}
+
+	tx.rollback(ctx)
+	if len(order) != 3 || order[0] != 3 || order[1] != 2 || order[2] != 1 {
+		t.Fatalf("Expected compensating writes to run in reverse order but got %v", order)
+	}
+
+	tx = &MongoTransaction{transactional: true}
+	tx.OnRollback(func(ctx context.Context) error {
+		t.Fatalf("Expected compensating write not to run inside a transaction")
+		return nil
+	})
+
+	tx.rollback(ctx)
+	if len(tx.undo) != 0 {
+		t.Fatalf("Expected no compensating writes inside a transaction")
+	}
+}
@@ -0,0 +1,250 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/zbitech/common/pkg/model/entity"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	DEFAULT_MIN_LENGTH  = 8
+	DEFAULT_MIN_CLASSES = 2
+	DEFAULT_HISTORY     = 5
+	DEFAULT_COST        = bcrypt.DefaultCost
+)
+
+var (
+	ErrPasswordTooShort    = errors.New("password is too short")
+	ErrPasswordTooSimple   = errors.New("password does not mix enough kinds of characters")
+	ErrPasswordBreached    = errors.New("password appears in a list of breached passwords")
+	ErrPasswordReused      = errors.New("password was used recently")
+	ErrPasswordExpired     = errors.New("password has expired and must be changed")
+	ErrPasswordUnsupported = errors.New("repository does not store password history")
+	ErrPasswordHashed      = errors.New("password must be given in plaintext, not as a hash")
+	ErrInvalidPolicy       = errors.New("invalid password policy")
+)
+
+// History holds the hashes of the recent passwords of a user, newest first, and when the password last changed
+type History struct {
+	UserId  string    `json:"userid" bson:"_id"`
+	Hashes  []string  `json:"hashes" bson:"hashes"`
+	Changed time.Time `json:"changed" bson:"changed"`
+}
+
+// Add records hash as the current password and keeps the last size hashes
+func (h *History) Add(hash string, size int, now time.Time) {
+	h.Hashes = append([]string{hash}, h.Hashes...)
+	if len(h.Hashes) > size {
+		h.Hashes = h.Hashes[:size]
+	}
+	h.Changed = now
+}
+
+// Store gives access to stored passwords and their history. Missing items are reported with errs.ErrDBItemNotFound.
+type Store interface {
+	GetPassword(ctx context.Context, userId string) (*entity.UserPassword, error)
+	GetPasswordHistory(ctx context.Context, userId string) (*History, error)
+	PutPasswordHistory(ctx context.Context, history *History) error
+}
+
+// Service is implemented by IAM services that take plaintext passwords and apply a Policy to them
+type Service interface {
+	// SetPassword replaces the password of the user, as an admin would
+	SetPassword(ctx context.Context, userId, password string) error
+	// UpdatePassword replaces the password of the user after checking the current one. It also works once the
+	// password has expired.
+	UpdatePassword(ctx context.Context, userId, current, next string) error
+}
+
+// Policy sets the passwords that are accepted and how they are hashed. Breached holds the upper case SHA-1 hex
+// digests of passwords known from breaches.
+type Policy struct {
+	MinLength  int
+	MinClasses int
+	Breached   map[string]bool
+	History    int
+	MaxAge     time.Duration
+	Cost       int
+}
+
+func DefaultPolicy() Policy {
+	return Policy{MinLength: DEFAULT_MIN_LENGTH, MinClasses: DEFAULT_MIN_CLASSES, History: DEFAULT_HISTORY, Cost: DEFAULT_COST}
+}
+
+// Config is the password section of iam.yaml. Unset values keep their defaults; maxage is a duration such as
+// 2160h and is unlimited when unset.
+type Config struct {
+	MinLength    int
+	MinClasses   int
+	BreachedFile string
+	History      int
+	MaxAge       string
+	Cost         int
+}
+
+func (c Config) Policy() (Policy, error) {
+
+	policy := DefaultPolicy()
+
+	if c.MinLength < 0 || c.MinClasses < 0 || c.MinClasses > 4 || c.History < 0 {
+		return policy, fmt.Errorf("%w - lengths and counts must be positive and at most 4 classes", ErrInvalidPolicy)
+	}
+	if c.MinLength > 0 {
+		policy.MinLength = c.MinLength
+	}
+	if c.MinClasses > 0 {
+		policy.MinClasses = c.MinClasses
+	}
+	if c.History > 0 {
+		policy.History = c.History
+	}
+
+	if c.Cost != 0 {
+		if c.Cost < bcrypt.MinCost || c.Cost > bcrypt.MaxCost {
+			return policy, fmt.Errorf("%w - cost must be between %d and %d", ErrInvalidPolicy, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		policy.Cost = c.Cost
+	}
+
+	if len(c.MaxAge) > 0 {
+		maxAge, err := time.ParseDuration(c.MaxAge)
+		if err != nil || maxAge <= 0 {
+			return policy, fmt.Errorf("%w - max age %q", ErrInvalidPolicy, c.MaxAge)
+		}
+		policy.MaxAge = maxAge
+	}
+
+	if len(c.BreachedFile) > 0 {
+		breached, err := ReadBreached(c.BreachedFile)
+		if err != nil {
+			return policy, fmt.Errorf("%w - %s", ErrInvalidPolicy, err)
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+func digest(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// ReadBreached reads a list of breached passwords, one per line. A line is either the password or its SHA-1 hex
+// digest, optionally followed by :count as in the Have I Been Pwned downloads. Blank lines and lines starting
+// with # are skipped.
+func ReadBreached(path string) (map[string]bool, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if value := strings.SplitN(line, ":", 2)[0]; isDigest(value) {
+			breached[strings.ToUpper(value)] = true
+		} else {
+			breached[digest(line)] = true
+		}
+	}
+
+	return breached, scanner.Err()
+}
+
+func isDigest(value string) bool {
+	if len(value) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+func classes(password string) int {
+	var upper, lower, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return upper + lower + digit + other
+}
+
+// Validate checks a new plaintext password against the policy and the hashes of the user's recent passwords
+func (p Policy) Validate(password string, history *History) error {
+
+	if length := len([]rune(password)); length < p.MinLength {
+		return fmt.Errorf("%w - at least %d characters are required", ErrPasswordTooShort, p.MinLength)
+	}
+
+	if classes(password) < p.MinClasses {
+		return fmt.Errorf("%w - use at least %d of upper case, lower case, digits and symbols", ErrPasswordTooSimple, p.MinClasses)
+	}
+
+	if p.Breached[digest(password)] {
+		return ErrPasswordBreached
+	}
+
+	if history != nil {
+		for index, hash := range history.Hashes {
+			if index >= p.History {
+				break
+			}
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+				return fmt.Errorf("%w - the last %d passwords cannot be reused", ErrPasswordReused, p.History)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Hash hashes a plaintext password with the policy cost
+func (p Policy) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// IsHash reports whether value is a bcrypt hash rather than a plaintext password
+func IsHash(value string) bool {
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil
+}
+
+// NeedsRehash reports whether hash was made with a lower cost than the policy requires
+func (p Policy) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < p.Cost
+}
+
+// Expired reports whether the password recorded in history is older than the maximum age
+func (p Policy) Expired(history *History, now time.Time) bool {
+	return p.MaxAge > 0 && history != nil && now.Sub(history.Changed) > p.MaxAge
+}
@@ -0,0 +1,105 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_PolicyValidate(t *testing.T) {
+	policy := Policy{MinLength: 8, MinClasses: 3, History: 2, Cost: bcrypt.MinCost,
+		Breached: map[string]bool{digest("Password1"): true}}
+
+	rejected := map[string]error{"Ab1": ErrPasswordTooShort, "abcdefgh1": ErrPasswordTooSimple, "Password1": ErrPasswordBreached}
+	for password, expected := range rejected {
+		if err := policy.Validate(password, nil); !errors.Is(err, expected) {
+			t.Fatalf("Expected %s for %s but got %v", expected, password, err)
+		}
+	}
+	if err := policy.Validate("Correct-horse1", nil); err != nil {
+		t.Fatalf("Expected password to be accepted but got err - %s", err)
+	}
+
+	old, _ := policy.Hash("Correct-horse1")
+	older, _ := policy.Hash("Battery-staple2")
+	oldest, _ := policy.Hash("Tr0ub4dor&3")
+	history := &History{UserId: "tester", Hashes: []string{old, older, oldest}}
+
+	for _, password := range []string{"Correct-horse1", "Battery-staple2"} {
+		if err := policy.Validate(password, history); !errors.Is(err, ErrPasswordReused) {
+			t.Fatalf("Expected reused error for %s but got %v", password, err)
+		}
+	}
+	if err := policy.Validate("Tr0ub4dor&3", history); err != nil {
+		t.Fatalf("Expected only the last 2 passwords to be kept but got err - %s", err)
+	}
+}
+
+func Test_PolicyRehashAndExpiry(t *testing.T) {
+	policy := Policy{Cost: bcrypt.MinCost + 1, MaxAge: time.Hour}
+
+	weak, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if !IsHash(string(weak)) || IsHash("secret") {
+		t.Fatalf("Expected only the bcrypt hash to be a hash")
+	}
+	if !policy.NeedsRehash(string(weak)) {
+		t.Fatalf("Expected hash with a lower cost to need a rehash")
+	}
+
+	strong, err := policy.Hash("secret")
+	if err != nil {
+		t.Fatalf("Expected hash but got err - %s", err)
+	}
+	if policy.NeedsRehash(strong) {
+		t.Fatalf("Expected hash with the policy cost not to need a rehash")
+	}
+
+	now := time.Now()
+	history := &History{UserId: "tester"}
+	history.Add(strong, 1, now.Add(-2*time.Hour))
+	if !policy.Expired(history, now) {
+		t.Fatalf("Expected password older than the maximum age to be expired")
+	}
+	if policy.Expired(nil, now) {
+		t.Fatalf("Expected password without history not to be expired")
+	}
+
+	history.Add(string(weak), 1, now)
+	if !reflect.DeepEqual(history.Hashes, []string{string(weak)}) {
+		t.Fatalf("Expected history to keep the latest hash but got %v", history.Hashes)
+	}
+	if policy.Expired(history, now) {
+		t.Fatalf("Expected new password not to be expired")
+	}
+}
+
+func Test_ConfigPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := "# breached passwords\nletmein\n" + digest("qwerty123") + ":42\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Expected breached file but got err - %s", err)
+	}
+
+	policy, err := Config{MinLength: 10, BreachedFile: path, MaxAge: "720h"}.Policy()
+	if err != nil {
+		t.Fatalf("Expected policy but got err - %s", err)
+	}
+	if policy.MinLength != 10 || policy.MinClasses != DEFAULT_MIN_CLASSES || policy.MaxAge != 720*time.Hour {
+		t.Fatalf("Expected configured policy but got %v", policy)
+	}
+	if !policy.Breached[digest("letmein")] || !policy.Breached[digest("qwerty123")] {
+		t.Fatalf("Expected plain and hashed breached passwords to be loaded")
+	}
+
+	invalid := []Config{{MaxAge: "soon"}, {Cost: 64}, {BreachedFile: filepath.Join(t.TempDir(), "missing.txt")}}
+	for _, cfg := range invalid {
+		if _, err = cfg.Policy(); !errors.Is(err, ErrInvalidPolicy) {
+			t.Fatalf("Expected invalid policy error for %v but got %v", cfg, err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package reset
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+)
+
+const (
+	DEFAULT_LIFETIME = time.Hour
+	NOTIFIER_LOG     = "log"
+	NOTIFIER_FILE    = "file"
+
+	secretSize = 32
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	ErrResetTokenExpired = errors.New("password reset token has expired")
+	ErrResetUnsupported  = errors.New("repository does not store password reset tokens")
+	ErrNoNotifier        = errors.New("no password reset notifier configured")
+	ErrInvalidConfig     = errors.New("invalid password reset config")
+)
+
+// ResetToken allows a user to set a new password once. Only the hash of the token is stored.
+type ResetToken struct {
+	Hash    string    `json:"hash" bson:"_id"`
+	UserId  string    `json:"userid" bson:"userid"`
+	Created time.Time `json:"created" bson:"created"`
+	Expires time.Time `json:"expires" bson:"expires"`
+}
+
+func (t *ResetToken) Expired(now time.Time) bool {
+	return !now.Before(t.Expires)
+}
+
+// Store keeps reset tokens. Missing tokens are reported with errs.ErrDBItemNotFound.
+type Store interface {
+	// CreateResetToken stores t and removes the earlier reset tokens of the user
+	CreateResetToken(ctx context.Context, t *ResetToken) error
+	GetResetToken(ctx context.Context, hash string) (*ResetToken, error)
+	// ConsumeResetToken deletes the token. It fails with errs.ErrDBItemNotFound if the token was already used,
+	// so only one caller can use a token.
+	ConsumeResetToken(ctx context.Context, hash string) error
+	PurgeExpiredResetTokens(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Service is implemented by IAM services that let users reset a forgotten password
+type Service interface {
+	// RequestPasswordReset sends a reset token to the user. Unknown and inactive users are ignored without an
+	// error, so the result does not reveal which users exist.
+	RequestPasswordReset(ctx context.Context, userId string) error
+	// CompletePasswordReset uses the token to replace the password of its user
+	CompletePasswordReset(ctx context.Context, token, password string) error
+	PurgeExpiredResetTokens(ctx context.Context) (int64, error)
+}
+
+// Notifier delivers reset tokens to users, usually by email with a link to the reset page of the API
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, user *entity.User, token string, expires time.Time) error
+}
+
+// Config is the reset section of iam.yaml. The lifetime is a duration such as 30m and defaults to an hour. The
+// notifier is log or file for local use; without one, resets fail until the API sets its own notifier.
+type Config struct {
+	Lifetime string
+	Notifier string
+	File     string
+}
+
+// TokenLifetime returns how long reset tokens are valid
+func (c Config) TokenLifetime() (time.Duration, error) {
+	if len(c.Lifetime) == 0 {
+		return DEFAULT_LIFETIME, nil
+	}
+
+	lifetime, err := time.ParseDuration(c.Lifetime)
+	if err != nil || lifetime <= 0 {
+		return 0, fmt.Errorf("%w - lifetime %q", ErrInvalidConfig, c.Lifetime)
+	}
+	return lifetime, nil
+}
+
+// NewNotifier returns the configured notifier, or nil when none is configured
+func (c Config) NewNotifier() (Notifier, error) {
+	switch c.Notifier {
+	case "":
+		return nil, nil
+	case NOTIFIER_LOG:
+		return &FileNotifier{}, nil
+	case NOTIFIER_FILE:
+		if len(c.File) == 0 {
+			return nil, fmt.Errorf("%w - the file notifier needs a file", ErrInvalidConfig)
+		}
+		return &FileNotifier{Path: c.File}, nil
+	default:
+		return nil, fmt.Errorf("%w - notifier %q, expected %s or %s", ErrInvalidConfig, c.Notifier, NOTIFIER_LOG, NOTIFIER_FILE)
+	}
+}
+
+// HashToken returns the hash under which a reset token is stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewToken creates a reset token for the user and returns it with the secret to send to the user
+func NewToken(userId string, now time.Time, lifetime time.Duration) (*ResetToken, string, error) {
+	data := make([]byte, secretSize)
+	if _, err := rand.Read(data); err != nil {
+		return nil, "", err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(data)
+	return &ResetToken{Hash: HashToken(secret), UserId: userId, Created: now, Expires: now.Add(lifetime)}, secret, nil
+}
+
+// FileNotifier is meant for local use. It appends each reset token as a JSON line to the file at Path, or logs it
+// when Path is empty. Anyone who can read the file or log can reset passwords.
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+type notification struct {
+	UserId  string    `json:"userid"`
+	Email   string    `json:"email"`
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+func (n *FileNotifier) SendPasswordReset(ctx context.Context, user *entity.User, token string, expires time.Time) error {
+
+	if len(n.Path) == 0 {
+		logger.Infof(ctx, "Password reset token for %s (%s): %s - expires %s", user.UserId, user.Email, token, expires)
+		return nil
+	}
+
+	data, err := json.Marshal(notification{UserId: user.UserId, Email: user.Email, Token: token, Expires: expires})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,99 @@
+package reset
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zbitech/common/pkg/model/entity"
+)
+
+func Test_NewToken(t *testing.T) {
+	now := time.Now()
+	token, secret, err := NewToken("tester", now, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected reset token but got err - %s", err)
+	}
+	if token.Hash != HashToken(secret) || strings.Contains(token.Hash, secret) {
+		t.Fatalf("Expected token to keep only the hash of the secret")
+	}
+	if token.Expired(now) || !token.Expired(now.Add(time.Hour)) {
+		t.Fatalf("Expected token to expire after an hour")
+	}
+
+	if _, other, _ := NewToken("tester", now, time.Hour); other == secret {
+		t.Fatalf("Expected a different secret but got %s again", secret)
+	}
+}
+
+func Test_ConfigNotifier(t *testing.T) {
+	lifetime, err := Config{}.TokenLifetime()
+	if err != nil || lifetime != DEFAULT_LIFETIME {
+		t.Fatalf("Expected default lifetime but got %s - %v", lifetime, err)
+	}
+
+	if _, err = (Config{Lifetime: "-1m"}).TokenLifetime(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected invalid config error for a negative lifetime but got %v", err)
+	}
+
+	notifier, err := Config{}.NewNotifier()
+	if err != nil || notifier != nil {
+		t.Fatalf("Expected no notifier but got %v - %v", notifier, err)
+	}
+
+	for _, cfg := range []Config{{Notifier: NOTIFIER_FILE}, {Notifier: "smtp"}} {
+		if _, err = cfg.NewNotifier(); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("Expected invalid config error for %v but got %v", cfg, err)
+		}
+	}
+}
+
+func Test_FileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resets.log")
+	notifier, err := Config{Notifier: NOTIFIER_FILE, File: path}.NewNotifier()
+	if err != nil {
+		t.Fatalf("Expected file notifier but got err - %s", err)
+	}
+
+	user := &entity.User{UserId: "tester", Email: "tester@zbi.io"}
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	for _, token := range []string{"first", "second"} {
+		if err = notifier.SendPasswordReset(context.Background(), user, token, expires); err != nil {
+			t.Fatalf("Expected reset to be sent but got err - %s", err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected notification file but got err - %s", err)
+	}
+	defer file.Close()
+
+	tokens := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var n notification
+		if err = json.Unmarshal(scanner.Bytes(), &n); err != nil {
+			t.Fatalf("Expected notification but got err - %s", err)
+		}
+		if n.Email != "tester@zbi.io" || !expires.Equal(n.Expires) {
+			t.Fatalf("Expected notification to tester@zbi.io expiring %s but got %s expiring %s", expires, n.Email, n.Expires)
+		}
+		tokens = append(tokens, n.Token)
+	}
+	if !reflect.DeepEqual(tokens, []string{"first", "second"}) {
+		t.Fatalf("Expected tokens first and second but got %v", tokens)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected file readable only by the owner but got %v - %v", info, err)
+	}
+}
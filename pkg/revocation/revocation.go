@@ -0,0 +1,90 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+)
+
+var (
+	ErrTokenRevoked          = errors.New("token has been revoked")
+	ErrTokenNotRevocable     = errors.New("token has no id and cannot be revoked")
+	ErrUserInactive          = errors.New("user is not active")
+	ErrRevocationUnsupported = errors.New("repository does not store revoked tokens")
+)
+
+// RevokedToken is a token rejected before it expires, such as the token of a user who logged out. It is kept until
+// the token expires.
+type RevokedToken struct {
+	Id      string    `json:"id" bson:"_id"`
+	UserId  string    `json:"userid" bson:"userid"`
+	Revoked time.Time `json:"revoked" bson:"revoked"`
+	Expires time.Time `json:"expires" bson:"expires"`
+}
+
+// Watermark rejects every token of a user issued before IssuedBefore. It is set when a user is deactivated or
+// changes password and kept until Expires, when the tokens it covers have expired.
+type Watermark struct {
+	UserId       string    `json:"userid" bson:"_id"`
+	IssuedBefore time.Time `json:"issuedbefore" bson:"issuedbefore"`
+	Expires      time.Time `json:"expires" bson:"expires"`
+}
+
+// NewWatermark creates a watermark for the tokens issued to the user before now. Token issue times have second
+// precision, so the watermark is truncated to the second and tokens issued within the same second are accepted.
+func NewWatermark(userId string, now time.Time, lifetime time.Duration) *Watermark {
+	before := now.Truncate(time.Second)
+	return &Watermark{UserId: userId, IssuedBefore: before, Expires: before.Add(lifetime)}
+}
+
+// Store keeps revoked tokens and watermarks. Missing items are reported with errs.ErrDBItemNotFound.
+type Store interface {
+	RevokeToken(ctx context.Context, token *RevokedToken) error
+	IsTokenRevoked(ctx context.Context, tokenId string) (bool, error)
+	// SetWatermark stores w, replacing the earlier watermark of the user
+	SetWatermark(ctx context.Context, w *Watermark) error
+	GetWatermark(ctx context.Context, userId string) (*Watermark, error)
+	// PurgeExpiredRevocations removes the revoked tokens and watermarks that expired by now
+	PurgeExpiredRevocations(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Service is implemented by IAM services that revoke tokens
+type Service interface {
+	// RevokeToken rejects the token with claims until it expires
+	RevokeToken(ctx context.Context, claims jwt.Claims) error
+	// RevokeUserTokens rejects every token issued to the user so far and ends the user's sessions
+	RevokeUserTokens(ctx context.Context, userId string) error
+	PurgeExpiredRevocations(ctx context.Context) (int64, error)
+}
+
+// Check returns ErrTokenRevoked if the token was revoked by id or was issued before the user's watermark
+func Check(ctx context.Context, store Store, userId, tokenId string, issuedAt time.Time) error {
+
+	if len(tokenId) > 0 {
+		revoked, err := store.IsTokenRevoked(ctx, tokenId)
+		if err != nil {
+			return err
+		} else if revoked {
+			logger.Debugf(ctx, "Token %s of %s is revoked", tokenId, userId)
+			return ErrTokenRevoked
+		}
+	}
+
+	w, err := store.GetWatermark(ctx, userId)
+	if errors.Is(err, errs.ErrDBItemNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if issuedAt.Before(w.IssuedBefore) {
+		logger.Debugf(ctx, "Token of %s issued at %s precedes watermark %s", userId, issuedAt, w.IssuedBefore)
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/config"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/pkg/apikeys"
+)
+
+type Action string
+type Kind string
+
+const (
+	SEED_CREATED    Action = "created"
+	SEED_UNCHANGED  Action = "unchanged"
+	SEED_CONFLICTED Action = "conflicted"
+
+	SEED_USER        Kind = "user"
+	SEED_APIKEY      Kind = "apikey"
+	SEED_TEAM        Kind = "team"
+	SEED_TEAM_MEMBER Kind = "member"
+
+	// API keys loaded from admin.yaml are valid for a year
+	SEED_APIKEY_HOURS = 8760
+)
+
+// Store writes seed items to a repository. Each method returns the stored item when one already exists under the
+// same key and writes nothing. Otherwise it inserts the item with its dependents, unless dryRun is set, and returns
+// nil. Inserts rejected by a unique constraint fail with errs.ErrDBKeyAlreadyExists.
+type Store interface {
+	SeedUser(ctx context.Context, user *entity.User, pass *entity.UserPassword, policy *entity.UserPolicy, dryRun bool) (*entity.User, error)
+	SeedAPIKey(ctx context.Context, apikey *apikeys.Record, policy *entity.APIKeyPolicy, dryRun bool) (*apikeys.Record, error)
+	SeedTeam(ctx context.Context, team *entity.Team, dryRun bool) (*entity.Team, error)
+	SeedTeamMember(ctx context.Context, member *entity.TeamMember, dryRun bool) (*entity.TeamMember, error)
+}
+
+// Seeder is implemented by the repository factories that can load admin.yaml
+type Seeder interface {
+	Seed(ctx context.Context, dryRun bool) (*Report, error)
+}
+
+type Result struct {
+	Kind   Kind
+	Id     string
+	Action Action
+	Reason string
+}
+
+// Report lists what seeding did, or would do in a dry run, with every item of admin.yaml
+type Report struct {
+	DryRun  bool
+	Results []Result
+}
+
+func (r *Report) add(kind Kind, id string, action Action, reason string) {
+	r.Results = append(r.Results, Result{Kind: kind, Id: id, Action: action, Reason: reason})
+}
+
+// Count returns the number of items of kind with action. An empty kind counts all kinds.
+func (r *Report) Count(kind Kind, action Action) int {
+	count := 0
+	for _, result := range r.Results {
+		if (len(kind) == 0 || result.Kind == kind) && result.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *Report) Conflicts() []Result {
+	conflicts := make([]Result, 0)
+	for _, result := range r.Results {
+		if result.Action == SEED_CONFLICTED {
+			conflicts = append(conflicts, result)
+		}
+	}
+	return conflicts
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	if r.DryRun {
+		b.WriteString("dry run - ")
+	}
+
+	fmt.Fprintf(&b, "%d created, %d unchanged, %d conflicted", r.Count("", SEED_CREATED), r.Count("", SEED_UNCHANGED), r.Count("", SEED_CONFLICTED))
+	for _, conflict := range r.Conflicts() {
+		fmt.Fprintf(&b, "; %s %s: %s", conflict.Kind, conflict.Id, conflict.Reason)
+	}
+	return b.String()
+}
+
+// ReadAdminConfig reads the seed data from admin.yaml in the asset directory
+func ReadAdminConfig(ctx context.Context) (*config.AdminConfig, error) {
+
+	var adminConfig config.AdminConfig
+	configPath := fmt.Sprintf("%s/admin.yaml", vars.ASSET_PATH_DIRECTORY)
+	if err := utils.ReadConfig(configPath, nil, &adminConfig); err != nil {
+		logger.Errorf(ctx, "Unable to load default admin data: %s", err)
+		return nil, errs.ErrDBError
+	}
+
+	return &adminConfig, nil
+}
+
+// Load writes the users, keys, teams and members of adminConfig that are missing from store. Items that already
+// exist are never overwritten; they are reported unchanged when they agree with adminConfig and conflicted when
+// they do not. Nothing is written when dryRun is set.
+func Load(ctx context.Context, store Store, adminConfig *config.AdminConfig, dryRun bool) (*Report, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "seed.Load"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	report := &Report{DryRun: dryRun, Results: make([]Result, 0)}
+	now := time.Now()
+
+	for _, u := range adminConfig.Users {
+		user := u
+		if user.Memberships == nil {
+			user.Memberships = make([]entity.UserTeam, 0)
+		}
+		user.Created = now
+		user.LastUpdate = now
+		user.Active = true
+
+		userPass := entity.NewUserPassword(user.UserId, adminConfig.Passwords[user.UserId])
+		userPolicy := entity.NewUserPolicy(user.UserId)
+		userPolicy.Updated = now
+
+		existing, err := store.SeedUser(ctx, &user, &userPass, &userPolicy, dryRun)
+		if err = report.record(SEED_USER, user.UserId, err, existing != nil, func() bool {
+			return existing.Email == user.Email && existing.Role == user.Role
+		}); err != nil {
+			return report, err
+		}
+	}
+
+	for _, k := range adminConfig.Keys {
+		k.Created = now
+		k.Expires = now.Add(time.Hour * SEED_APIKEY_HOURS)
+		apikey := apikeys.FromPlaintext(k)
+
+		keyPolicy := entity.NewAPIKeyPolicy(apikey.Key, true)
+		keyPolicy.Updated = now
+
+		existing, err := store.SeedAPIKey(ctx, apikey, &keyPolicy, dryRun)
+		if err = report.record(SEED_APIKEY, apikey.Key, err, existing != nil, func() bool {
+			return existing.UserId == apikey.UserId
+		}); err != nil {
+			return report, err
+		}
+	}
+
+	for _, t := range adminConfig.Teams {
+		team := t
+		team.Created = now
+		team.LastUpdate = now
+
+		existing, err := store.SeedTeam(ctx, &team, dryRun)
+		if err = report.record(SEED_TEAM, team.TeamId, err, existing != nil, func() bool {
+			return existing.Name == team.Name && existing.Owner == team.Owner
+		}); err != nil {
+			return report, err
+		}
+	}
+
+	for _, mb := range adminConfig.Members {
+		member := mb
+		member.CreatedOn = now
+		member.LastUpdate = now
+
+		existing, err := store.SeedTeamMember(ctx, &member, dryRun)
+		if err = report.record(SEED_TEAM_MEMBER, member.Key, err, existing != nil, func() bool {
+			return existing.TeamId == member.TeamId && existing.Email == member.Email
+		}); err != nil {
+			return report, err
+		}
+	}
+
+	logger.Infof(ctx, "Seeded database - %s", report)
+	return report, nil
+}
+
+// record adds the outcome of seeding one item. Unique constraint violations are conflicts; other errors are
+// returned.
+func (r *Report) record(kind Kind, id string, err error, exists bool, same func() bool) error {
+	switch {
+	case errors.Is(err, errs.ErrDBKeyAlreadyExists):
+		r.add(kind, id, SEED_CONFLICTED, "violates a unique constraint")
+	case err != nil:
+		return fmt.Errorf("unable to seed %s %s: %w", kind, id, err)
+	case !exists:
+		r.add(kind, id, SEED_CREATED, "")
+	case same():
+		r.add(kind, id, SEED_UNCHANGED, "")
+	default:
+		r.add(kind, id, SEED_CONFLICTED, "differs from the stored "+string(kind))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package serviceaccount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/model/ztypes"
+)
+
+// Service accounts are users named svc.<team>.<name>. The prefix is reserved, so a person cannot register an
+// account that would be mistaken for one.
+const (
+	PREFIX = "svc."
+
+	// EMAIL_DOMAIN is reserved by RFC 2606, so mail to service accounts is never delivered
+	EMAIL_DOMAIN = "service-account.invalid"
+
+	// MEMBER_STATUS is the status of the team membership of an account. Accounts are members from their creation
+	// and are never invited.
+	MEMBER_STATUS ztypes.InvitationStatus = "serviceaccount"
+)
+
+var (
+	ErrServiceAccountsUnsupported = errors.New("repository does not store service accounts")
+	ErrInvalidName                = errors.New("invalid service account name")
+	ErrReservedUserId             = errors.New("user id is reserved for service accounts")
+	ErrPasswordLogin              = errors.New("service accounts cannot log in with a password")
+
+	namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+)
+
+// ServiceAccount is owned by a team rather than a person, so its keys keep working when people leave. It is
+// stored with a user of the same id that holds its API keys and policies but has no password, and with a team
+// member of the same key that makes it a member of its team.
+type ServiceAccount struct {
+	Id          string    `json:"id" bson:"_id"`
+	TeamId      string    `json:"teamid" bson:"teamid"`
+	Name        string    `json:"name" bson:"name"`
+	Description string    `json:"description" bson:"description"`
+	CreatedBy   string    `json:"createdby" bson:"createdby"`
+	Created     time.Time `json:"created" bson:"created"`
+}
+
+// Summary counts the service accounts of a team and their keys. They are not part of the ResourceSummary of the
+// team owner, which only counts the owner's own keys.
+type Summary struct {
+	TeamId               string
+	TotalServiceAccounts int
+	TotalAPIKeys         int
+}
+
+// IsServiceAccount reports whether the user id names a service account
+func IsServiceAccount(userId string) bool {
+	return strings.HasPrefix(userId, PREFIX)
+}
+
+// New returns the account called name of the team and the user that holds its keys
+func New(teamId, name, description, createdBy string, now time.Time) (*ServiceAccount, *entity.User, error) {
+	if !namePattern.MatchString(name) {
+		return nil, nil, fmt.Errorf("%w %q - use lowercase letters, digits and dashes", ErrInvalidName, name)
+	} else if len(teamId) == 0 {
+		return nil, nil, fmt.Errorf("%w - no team", ErrInvalidName)
+	}
+
+	id := PREFIX + teamId + "." + name
+	sa := &ServiceAccount{Id: id, TeamId: teamId, Name: name, Description: description, CreatedBy: createdBy, Created: now}
+	user := &entity.User{UserId: id, Email: email(id), Name: name, Role: ztypes.ROLE_USER, Active: true,
+		Created: now, LastUpdate: now, Memberships: []entity.UserTeam{{TeamId: teamId, Key: id}}}
+
+	return sa, user, nil
+}
+
+// TeamMember returns the membership of the account in its team. Its key is the account id, which the user of the
+// account lists in its memberships.
+func (s *ServiceAccount) TeamMember() *entity.TeamMember {
+	return &entity.TeamMember{Key: s.Id, TeamId: s.TeamId, Email: email(s.Id), Role: ztypes.ROLE_USER, Status: MEMBER_STATUS,
+		CreatedOn: s.Created, LastUpdate: s.Created}
+}
+
+// IsJoined reports whether member has joined its team. The membership of a service account is joined when it is
+// created.
+func IsJoined(member *entity.TeamMember) bool {
+	return member.IsJoined() || member.Status == MEMBER_STATUS
+}
+
+func email(id string) string {
+	return id + "@" + EMAIL_DOMAIN
+}
+
+// Store keeps service accounts. Missing accounts are reported with errs.ErrDBItemNotFound.
+type Store interface {
+	// CreateServiceAccount stores the account together with its user and team member. It fails with
+	// errs.ErrUserAlreadyExists when the id is taken.
+	CreateServiceAccount(ctx context.Context, sa *ServiceAccount, user *entity.User) error
+	GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error)
+	GetServiceAccounts(ctx context.Context, teamId string) ([]ServiceAccount, error)
+	// DeleteServiceAccount removes the account, its user and its team member
+	DeleteServiceAccount(ctx context.Context, id string) error
+}
+
+// Service is implemented by IAM services with service accounts
+type Service interface {
+	CreateServiceAccount(ctx context.Context, teamId, name, description string) (*ServiceAccount, error)
+	GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error)
+	GetServiceAccounts(ctx context.Context, teamId string) ([]ServiceAccount, error)
+	// DeleteServiceAccount removes the account with its API keys
+	DeleteServiceAccount(ctx context.Context, id string) error
+	GetServiceAccountSummary(ctx context.Context, teamId string) (*Summary, error)
+}
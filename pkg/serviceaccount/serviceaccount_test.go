@@ -0,0 +1,49 @@
+package serviceaccount
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_New(t *testing.T) {
+	now := time.Now()
+	sa, user, err := New("team1", "ci-deploy", "deploys from ci", "owner", now)
+	if err != nil {
+		t.Fatalf("Expected service account but got err - %s", err)
+	}
+	if sa.Id != "svc.team1.ci-deploy" || user.UserId != sa.Id {
+		t.Fatalf("Expected service account and user svc.team1.ci-deploy but got %s and %s", sa.Id, user.UserId)
+	}
+	if user.Email != "svc.team1.ci-deploy@service-account.invalid" {
+		t.Fatalf("Expected reserved email but got %s", user.Email)
+	}
+	if !user.Active {
+		t.Fatalf("Expected service account user to be active")
+	}
+	if len(user.Memberships) != 1 || user.Memberships[0].TeamId != "team1" {
+		t.Fatalf("Expected membership of team1 but got %v", user.Memberships)
+	}
+	if !IsServiceAccount(user.UserId) || IsServiceAccount("owner") {
+		t.Fatalf("Expected only %s to be a service account", user.UserId)
+	}
+
+	member := sa.TeamMember()
+	if member.Key != user.Memberships[0].Key || member.TeamId != "team1" || member.Email != user.Email {
+		t.Fatalf("Expected member %s of team1 with %s but got %s of %s with %s", user.Memberships[0].Key, user.Email,
+			member.Key, member.TeamId, member.Email)
+	}
+	if !IsJoined(member) {
+		t.Fatalf("Expected service account member to count as joined")
+	}
+
+	for _, name := range []string{"", "CI", "-ci", "ci deploy", "ci.deploy"} {
+		if _, _, err = New("team1", name, "", "owner", now); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("Expected invalid name error for %q but got %v", name, err)
+		}
+	}
+
+	if _, _, err = New("", "ci", "", "owner", now); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("Expected invalid name error without a team but got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/id"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+)
+
+const (
+	// refresh tokens are <session id>.<secret>; the secret has no separator and only its hash is stored
+	tokenSeparator = "."
+	secretSize     = 32
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used - session revoked")
+	ErrSessionsUnsupported = errors.New("repository does not store sessions")
+)
+
+// Session is a login on one device. It holds the hash of the refresh token that may be exchanged next; each
+// exchange replaces the token, so a refresh token is used once.
+type Session struct {
+	Id        string    `json:"id" bson:"_id"`
+	UserId    string    `json:"userid" bson:"userid"`
+	Device    string    `json:"device" bson:"device"`
+	TokenHash string    `json:"tokenhash" bson:"tokenhash"`
+	Created   time.Time `json:"created" bson:"created"`
+	LastUsed  time.Time `json:"lastused" bson:"lastused"`
+	Expires   time.Time `json:"expires" bson:"expires"`
+}
+
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.Expires)
+}
+
+// Tokens are returned when a user logs in or refreshes a session
+type Tokens struct {
+	AccessToken    string
+	AccessExpires  time.Time
+	RefreshToken   string
+	RefreshExpires time.Time
+	SessionId      string
+}
+
+// Store keeps sessions. Missing sessions are reported with errs.ErrDBItemNotFound.
+type Store interface {
+	// CreateSession stores s and removes the other sessions of the user on the same device
+	CreateSession(ctx context.Context, s *Session) error
+	GetSession(ctx context.Context, sessionId string) (*Session, error)
+	// RotateSession replaces the session with next if its token hash is still tokenHash. Otherwise it fails with
+	// ErrRefreshTokenReused.
+	RotateSession(ctx context.Context, tokenHash string, next *Session) error
+	GetSessions(ctx context.Context, userId string) ([]Session, error)
+	DeleteSession(ctx context.Context, sessionId string) error
+	DeleteSessions(ctx context.Context, userId string) (int64, error)
+	PurgeExpiredSessions(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Service is implemented by IAM services that issue refresh tokens
+type Service interface {
+	Login(ctx context.Context, userId, password, device string) (*Tokens, error)
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+	GetSessions(ctx context.Context, userId string) ([]Session, error)
+	RevokeSession(ctx context.Context, userId, sessionId string) error
+	RevokeSessions(ctx context.Context, userId string) (int64, error)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() (string, error) {
+	data := make([]byte, secretSize)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// parseToken returns the session id and secret hash of a refresh token
+func parseToken(token string) (string, string, error) {
+	index := strings.LastIndex(token, tokenSeparator)
+	if index <= 0 || index == len(token)-1 {
+		return "", "", ErrInvalidRefreshToken
+	}
+	return token[:index], hashSecret(token[index+1:]), nil
+}
+
+// issue gives s a new secret and returns the refresh token for it
+func issue(s *Session) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	s.TokenHash = hashSecret(secret)
+	return s.Id + tokenSeparator + secret, nil
+}
+
+// Start creates a session for the user on device and returns it with its first refresh token
+func Start(ctx context.Context, store Store, userId, device string, lifetime time.Duration) (*Session, string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "session.Start"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	now := time.Now()
+	s := &Session{Id: id.GenerateRequestID(), UserId: userId, Device: device, Created: now, LastUsed: now, Expires: now.Add(lifetime)}
+	token, err := issue(s)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = store.CreateSession(ctx, s); err != nil {
+		return nil, "", err
+	}
+
+	logger.Infof(ctx, "Started session %s for %s on %s", s.Id, userId, device)
+	return s, token, nil
+}
+
+// Refresh exchanges a refresh token for a new one and extends the session. A token that was already exchanged
+// revokes the session, since either the user or someone who stole the token holds its replacement.
+func Refresh(ctx context.Context, store Store, refreshToken string, lifetime time.Duration) (*Session, string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "session.Refresh"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	sessionId, tokenHash, err := parseToken(refreshToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s, err := store.GetSession(ctx, sessionId)
+	if errors.Is(err, errs.ErrDBItemNotFound) {
+		return nil, "", ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if s.Expired(now) {
+		_ = store.DeleteSession(ctx, s.Id)
+		return nil, "", ErrRefreshTokenExpired
+	}
+
+	next := *s
+	next.LastUsed = now
+	next.Expires = now.Add(lifetime)
+	token, err := issue(&next)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(s.TokenHash), []byte(tokenHash)) == 1 {
+		err = store.RotateSession(ctx, tokenHash, &next)
+	} else {
+		err = ErrRefreshTokenReused
+	}
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		logger.Errorf(ctx, "Refresh token of session %s for %s was reused - revoking session", s.Id, s.UserId)
+		_ = store.DeleteSession(ctx, s.Id)
+		return nil, "", ErrRefreshTokenReused
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	return &next, token, nil
+}
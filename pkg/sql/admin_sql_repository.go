@@ -0,0 +1,419 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/id"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo/internal/helper"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type AdminSQLRepository struct {
+	conn *SQLConnection
+}
+
+func NewAdminSQLRepository(conn *SQLConnection) *AdminSQLRepository {
+	return &AdminSQLRepository{conn: conn}
+}
+
+func (m *AdminSQLRepository) RegisterUser(ctx context.Context, user *entity.User, pass *entity.UserPassword) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RegisterUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		exists, err := USERS.Exists(ctx, q, user.UserId)
+		if err != nil {
+			return err
+		} else if exists {
+			return errs.ErrUserAlreadyExists
+		}
+
+		user.Created = time.Now()
+		user.Active = true
+		user.LastUpdate = time.Now()
+
+		if err = USERS.Insert(ctx, q, user, user.UserId, user.Email); err != nil {
+			logger.Errorf(ctx, "Error inserting user info - %s", err)
+			return err
+		}
+
+		if pass != nil {
+			if err = PASSWORDS.Put(ctx, q, pass, user.UserId); err != nil {
+				logger.Errorf(ctx, "Error setting user password - %s", err)
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err == errs.ErrUserAlreadyExists {
+		return err
+	} else if err != nil {
+		return errs.ErrDBItemInsertFailed
+	}
+
+	logger.Infof(ctx, "Inserted user with id %s", user.UserId)
+	return nil
+}
+
+func (m *AdminSQLRepository) GetUsers(ctx context.Context) []entity.User {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUsers"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	users := make([]entity.User, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return USERS.Find(ctx, q, nil, nil, func(doc bson.Raw) error {
+			var user entity.User
+			if err := bson.Unmarshal(doc, &user); err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Got an error instead of list of users - %s", err)
+		return nil
+	}
+
+	return users
+}
+
+func (m *AdminSQLRepository) GetUser(ctx context.Context, userId string) (*entity.User, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	user := entity.User{}
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return USERS.Decode(ctx, q, &user, userId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &user, nil
+}
+
+func (m *AdminSQLRepository) UpdateUser(ctx context.Context, user *entity.User) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdateUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		exists, err := USERS.Exists(ctx, q, user.UserId)
+		if err != nil {
+			return err
+		} else if !exists {
+			return errs.ErrDBItemNotFound
+		}
+
+		user.LastUpdate = time.Now()
+		return USERS.Put(ctx, q, user, user.UserId, user.Email)
+	})
+
+	if err != nil {
+		if err == errs.ErrDBItemNotFound {
+			return err
+		}
+
+		logger.Errorf(ctx, "User update failed - %s", err)
+		return errs.ErrDBItemUpdateFailed
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) UpdatePassword(ctx context.Context, userid string, password *entity.UserPassword) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdatePassword"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		exists, err := USERS.Exists(ctx, q, userid)
+		if err != nil {
+			return err
+		} else if !exists {
+			return errs.ErrDBItemNotFound
+		}
+
+		return PASSWORDS.Put(ctx, q, password, userid)
+	})
+
+	if err != nil {
+		if err == errs.ErrDBItemNotFound {
+			return err
+		}
+
+		logger.Errorf(ctx, "Password update failed - %s", err)
+		return errs.ErrDBItemUpdateFailed
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) AuthenticateUser(ctx context.Context, userId, password string) (*string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AuthenticateUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	user := entity.User{}
+	pass := entity.UserPassword{}
+
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		if err := USERS.Decode(ctx, q, &user, userId); err != nil {
+			return err
+		}
+
+		return PASSWORDS.Decode(ctx, q, &pass, userId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	if id.ValidatePassword(pass.Password, []byte(password)) {
+		return helper.GenerateJwtToken(user)
+	}
+
+	return nil, errs.ErrAuthFailed
+}
+
+func (m *AdminSQLRepository) GetAPIKeys(ctx context.Context, userId string) ([]string, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	keys := make([]string, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return APIKEYS.Find(ctx, q, []string{"userid"}, []interface{}{userId}, func(doc bson.Raw) error {
+			var apikey entity.APIKey
+			if err := bson.Unmarshal(doc, &apikey); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			keys = append(keys, apikey.Key)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return keys, nil
+}
+
+func (m *AdminSQLRepository) GetAPIKey(ctx context.Context, apiKey string) (*entity.APIKey, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminSQLRepository.GetAPIKey"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	id, secret, err := apikeys.Parse(apiKey)
+	if err != nil {
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	record, err := m.GetAPIKeyRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = record.Authenticate(secret, time.Now()); err != nil {
+		logger.Errorf(ctx, "Rejected api key %s - %s", id, err)
+		return nil, err
+	}
+
+	return record.APIKey(), nil
+}
+
+// CreateAPIKey returns the full key once. Only its id and the hash of its secret are stored.
+func (m *AdminSQLRepository) CreateAPIKey(ctx context.Context, user_id string) (*entity.APIKey, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminSQLRepository.CreateAPIKey"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	record, key, err := apikeys.New(user_id, time.Now(), time.Duration(vars.HOURS_IN_YEAR)*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.CreateAPIKeyRecord(ctx, record); err != nil {
+		return nil, err
+	}
+
+	apikey := record.APIKey()
+	apikey.Key = key
+	return apikey, nil
+}
+
+func (m *AdminSQLRepository) DeleteAPIKey(ctx context.Context, apiKey string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminSQLRepository.DeleteAPIKey"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	id := apikeys.ID(apiKey)
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		count, err := APIKEYS.Delete(ctx, q, []string{"key"}, id)
+		if err != nil {
+			return err
+		} else if count == 0 {
+			return errs.ErrDBItemNotFound
+		}
+
+		_, err = APIKEY_POLICY.Delete(ctx, q, []string{"key"}, id)
+		return err
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) StoreUserPolicy(ctx context.Context, p entity.UserPolicy) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminSQLRepository.StoreUserPolicy"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	p.Updated = time.Now()
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return USER_POLICY.Put(ctx, q, p, p.UserId)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetUserPolicy(ctx context.Context, userId string) (*entity.UserPolicy, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUserPolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := entity.UserPolicy{}
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return USER_POLICY.Decode(ctx, q, &item, userId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &item, nil
+}
+
+func (m *AdminSQLRepository) StoreInstancePolicy(ctx context.Context, p entity.InstancePolicy) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "StoreInstancePolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.StoreInstancePolicies(ctx, []entity.InstancePolicy{p})
+}
+
+func (m *AdminSQLRepository) StoreInstancePolicies(ctx context.Context, p []entity.InstancePolicy) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "StoreInstancePolicies"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		for _, i := range p {
+			if err := INSTANCE_POLICY.Put(ctx, q, i, i.Project, i.Instance); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetInstanceMethodPolicy(ctx context.Context, project, instance, methodName string) (*entity.MethodPolicy, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstanceMethodPolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.GetInstancePolicy(ctx, project, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return item.GetMethodByName(methodName), nil
+}
+
+func (m *AdminSQLRepository) GetInstancePolicy(ctx context.Context, project, instance string) (*entity.InstancePolicy, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "AdminSQLRepository.GetInstancePolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := entity.InstancePolicy{}
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return INSTANCE_POLICY.Decode(ctx, q, &item, project, instance)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &item, nil
+}
+
+func (m *AdminSQLRepository) GetInstanceMethodPolicies(ctx context.Context, project, instance, methodCategory string) ([]entity.MethodPolicy, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstanceMethodPolicies"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item, err := m.GetInstancePolicy(ctx, project, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return item.GetMethodsByCategory(methodCategory), nil
+}
+
+func (m *AdminSQLRepository) StoreAPIKeyPolicy(ctx context.Context, p entity.APIKeyPolicy) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "StoreAPIKeyPolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	p.Key = apikeys.ID(p.Key)
+	p.Updated = time.Now()
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return APIKEY_POLICY.Put(ctx, q, p, p.Key)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetAPIKeyPolicy(ctx context.Context, key string) (*entity.APIKeyPolicy, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKeyPolicy"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := entity.APIKeyPolicy{}
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return APIKEY_POLICY.Decode(ctx, q, &item, apikeys.ID(key))
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &item, nil
+}
@@ -0,0 +1,257 @@
+package sql
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/model/config"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/repo/internal/admintest"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"github.com/zbitech/repo/pkg/seed"
+)
+
+func newTestConnection(t *testing.T) *SQLConnection {
+
+	ctx := context.Background()
+	conn, err := NewSQLConnection(SQL_DRIVER_SQLITE, filepath.Join(t.TempDir(), "zbirepo.sqlite"))
+	if err != nil {
+		t.Fatalf("Expected connection but got err - %s", err)
+	}
+
+	if err = conn.OpenConnection(ctx); err != nil {
+		t.Fatalf("Expected connection but got err - %s", err)
+	}
+	t.Cleanup(func() { conn.CloseConnection(ctx) })
+
+	if err = CreateTables(ctx, conn); err != nil {
+		t.Fatalf("Expected tables to be created but got err - %s", err)
+	}
+
+	return conn
+}
+
+func Test_AdminStore(t *testing.T) {
+	admintest.Run(t, func(t *testing.T) admintest.Store {
+		return NewAdminSQLRepository(newTestConnection(t))
+	})
+}
+
+func Test_RegisterUser(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminSQLRepository(newTestConnection(t))
+
+	user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+	pass := entity.NewUserPassword(user.UserId, "password")
+	if err := repo.RegisterUser(ctx, &user, &pass); err != nil {
+		t.Fatalf("Expected user to be registered but got err - %s", err)
+	}
+
+	if err := repo.RegisterUser(ctx, &user, &pass); err != errs.ErrUserAlreadyExists {
+		t.Fatalf("Expected duplicate registration to fail but got %v", err)
+	}
+
+	other := entity.User{UserId: "other", Email: user.Email}
+	if err := repo.RegisterUser(ctx, &other, nil); err == nil {
+		t.Fatalf("Expected registration with a duplicate email to fail")
+	}
+
+	stored, err := repo.GetUser(ctx, user.UserId)
+	if err != nil || stored.Email != user.Email {
+		t.Fatalf("Expected registered user but got %v - %s", stored, err)
+	}
+
+	// users without an email do not collide on the unique email constraint
+	for _, userId := range []string{"noemail1", "noemail2"} {
+		if err := repo.RegisterUser(ctx, &entity.User{UserId: userId}, nil); err != nil {
+			t.Fatalf("Expected user without email to be registered but got err - %s", err)
+		}
+	}
+
+	if users := repo.GetUsers(ctx); len(users) != 3 {
+		t.Fatalf("Expected three users but got %d", len(users))
+	}
+}
+
+func Test_UpdateUser(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminSQLRepository(newTestConnection(t))
+
+	user := entity.User{UserId: "tester", Email: "tester@zbitech.local"}
+	if err := repo.UpdateUser(ctx, &user); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected update of unknown user to fail but got %v", err)
+	}
+
+	if err := repo.RegisterUser(ctx, &user, nil); err != nil {
+		t.Fatalf("Expected user to be registered but got err - %s", err)
+	}
+
+	user.Email = "changed@zbitech.local"
+	if err := repo.UpdateUser(ctx, &user); err != nil {
+		t.Fatalf("Expected user to be updated but got err - %s", err)
+	}
+
+	stored, err := repo.GetUser(ctx, user.UserId)
+	if err != nil || stored.Email != user.Email {
+		t.Fatalf("Expected updated user but got %v - %s", stored, err)
+	}
+}
+
+func Test_APIKeys(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminSQLRepository(newTestConnection(t))
+
+	apikey, err := repo.CreateAPIKey(ctx, "tester")
+	if err != nil {
+		t.Fatalf("Expected api key but got err - %s", err)
+	}
+
+	keys, err := repo.GetAPIKeys(ctx, "tester")
+	if err != nil || len(keys) != 1 || keys[0] != apikeys.ID(apikey.Key) {
+		t.Fatalf("Expected [%s] but got %v - %s", apikeys.ID(apikey.Key), keys, err)
+	}
+
+	stored, err := repo.GetAPIKey(ctx, apikey.Key)
+	if err != nil || stored.Key != keys[0] || stored.UserId != "tester" {
+		t.Fatalf("Expected the key by its id but got %v - %v", stored, err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, apikey.Key+"x"); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected a wrong secret to be rejected but got %v", err)
+	}
+
+	if err = repo.DeleteAPIKey(ctx, apikey.Key); err != nil {
+		t.Fatalf("Expected api key to be deleted but got err - %s", err)
+	}
+
+	if _, err = repo.GetAPIKey(ctx, apikey.Key); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected deleted api key to be missing but got %v", err)
+	}
+}
+
+func Test_TeamMembers(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminSQLRepository(newTestConnection(t))
+
+	member := entity.TeamMember{Key: "key", TeamId: "team", Email: "member@zbitech.local"}
+	if err := repo.AddTeamMember(ctx, "team", member); err != nil {
+		t.Fatalf("Expected member to be added but got err - %s", err)
+	}
+
+	duplicate := entity.TeamMember{Key: "other", TeamId: "team", Email: member.Email}
+	if err := repo.AddTeamMember(ctx, "team", duplicate); err != errs.ErrDBKeyAlreadyExists {
+		t.Fatalf("Expected duplicate member to fail but got %v", err)
+	}
+
+	if err := repo.UpdateTeamMemberStatus(ctx, "team", "key", ztypes.EXPIRED_INVITATION); err != nil {
+		t.Fatalf("Expected member status to be updated but got err - %s", err)
+	}
+
+	count, err := repo.PurgeExpiredInvitations(ctx)
+	if err != nil || count != 1 {
+		t.Fatalf("Expected one expired invitation to be purged but got %d - %s", count, err)
+	}
+
+	if members, _ := repo.GetTeamMembers(ctx, "team"); len(members) != 0 {
+		t.Fatalf("Expected no members but got %d", len(members))
+	}
+}
+
+func Test_Rebind(t *testing.T) {
+
+	query := "SELECT document FROM users WHERE userid = ? AND email = ?"
+	if rebound := dialects[SQL_DRIVER_POSTGRES].rebind(query); rebound != "SELECT document FROM users WHERE userid = $1 AND email = $2" {
+		t.Fatalf("Expected numbered placeholders but got %s", rebound)
+	}
+
+	if rebound := dialects[SQL_DRIVER_SQLITE].rebind(query); rebound != query {
+		t.Fatalf("Expected query to be unchanged but got %s", rebound)
+	}
+}
+
+func Test_SeedDatabase(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewAdminSQLRepository(newTestConnection(t))
+
+	adminConfig := &config.AdminConfig{
+		Users:     []entity.User{{UserId: "admin", Email: "admin@zbitech.local"}},
+		Passwords: map[string]string{"admin": "password"},
+		Keys:      []entity.APIKey{{Key: "key", UserId: "admin"}},
+		Teams:     []entity.Team{{TeamId: "team", Name: "team", Owner: "admin"}},
+		Members:   []entity.TeamMember{{Key: "member", TeamId: "team", Email: "member@zbitech.local"}},
+	}
+
+	report, err := seed.Load(ctx, repo, adminConfig, true)
+	if err != nil || report.Count("", seed.SEED_CREATED) != 4 {
+		t.Fatalf("Expected dry run to report 4 items to create but got %v - %v", report, err)
+	}
+
+	if users := repo.GetUsers(ctx); len(users) != 0 {
+		t.Fatalf("Expected dry run not to write but got %d users", len(users))
+	}
+
+	if report, err = seed.Load(ctx, repo, adminConfig, false); err != nil || report.Count("", seed.SEED_CREATED) != 4 {
+		t.Fatalf("Expected 4 items created but got %v - %v", report, err)
+	}
+
+	if _, err = repo.GetAPIKeyPolicy(ctx, "key"); err != nil {
+		t.Fatalf("Expected seeded api key policy but got err - %s", err)
+	}
+
+	adminConfig.Users[0].Email = "changed@zbitech.local"
+	report, err = seed.Load(ctx, repo, adminConfig, false)
+	if err != nil || report.Count("", seed.SEED_UNCHANGED) != 3 || report.Count(seed.SEED_USER, seed.SEED_CONFLICTED) != 1 {
+		t.Fatalf("Expected reseeding to create nothing but got %v - %v", report, err)
+	}
+
+	if user, err := repo.GetUser(ctx, "admin"); err != nil || user.Email != "admin@zbitech.local" {
+		t.Fatalf("Expected stored user to be kept but got %v - %v", user, err)
+	}
+}
+
+func Test_MigrateAPIKeys(t *testing.T) {
+
+	ctx := context.Background()
+	conn := newTestConnection(t)
+	repo := NewAdminSQLRepository(conn)
+
+	legacy := entity.NewAPIKey("tester", 1)
+	err := conn.Update(ctx, func(q *sqlQuerier) error {
+		if err := APIKEYS.Insert(ctx, q, legacy, legacy.Key, legacy.UserId); err != nil {
+			return err
+		}
+		return APIKEY_POLICY.Insert(ctx, q, entity.NewAPIKeyPolicy(legacy.Key, true), legacy.Key)
+	})
+	if err != nil {
+		t.Fatalf("Expected plaintext key to be stored but got err - %s", err)
+	}
+
+	if count, err := MigrateAPIKeys(ctx, conn); err != nil || count != 1 {
+		t.Fatalf("Expected 1 key to be hashed but got %d - %v", count, err)
+	}
+
+	if count, err := MigrateAPIKeys(ctx, conn); err != nil || count != 0 {
+		t.Fatalf("Expected no keys left to hash but got %d - %v", count, err)
+	}
+
+	stored, err := repo.GetAPIKey(ctx, legacy.Key)
+	if err != nil || stored.Key != apikeys.ID(legacy.Key) || stored.Key == legacy.Key {
+		t.Fatalf("Expected the plaintext key to keep working under a new id but got %v - %v", stored, err)
+	}
+
+	if policy, err := repo.GetAPIKeyPolicy(ctx, stored.Key); err != nil || policy.Key != stored.Key {
+		t.Fatalf("Expected the policy to move to the new id but got %v - %v", policy, err)
+	}
+
+	if keys, err := repo.GetAPIKeys(ctx, "tester"); err != nil || len(keys) != 1 || keys[0] != stored.Key {
+		t.Fatalf("Expected only the new id but got %v - %v", keys, err)
+	}
+}
@@ -0,0 +1,258 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/apikeys"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MigrateAPIKeys hashes the API keys stored in plaintext by earlier versions and moves their policies to the new
+// ids. The keys keep working as they are.
+func MigrateAPIKeys(ctx context.Context, conn *SQLConnection) (int, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "MigrateAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	count := 0
+	err := conn.Update(ctx, func(q *sqlQuerier) error {
+		legacy := make([]apikeys.Record, 0)
+		err := APIKEYS.Find(ctx, q, nil, nil, func(doc bson.Raw) error {
+			var record apikeys.Record
+			if err := bson.Unmarshal(doc, &record); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			if !record.Hashed() {
+				legacy = append(legacy, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, old := range legacy {
+			record := apikeys.FromPlaintext(entity.APIKey{Key: old.Key, UserId: old.UserId, Created: old.Created, Expires: old.Expires})
+			if _, err = APIKEYS.Delete(ctx, q, []string{"key"}, old.Key); err != nil {
+				return err
+			}
+			if err = APIKEYS.Insert(ctx, q, record, record.Key, record.UserId); err != nil {
+				return err
+			}
+
+			var policy entity.APIKeyPolicy
+			if err = APIKEY_POLICY.Decode(ctx, q, &policy, old.Key); err == errs.ErrDBItemNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			policy.Key = record.Key
+			if _, err = APIKEY_POLICY.Delete(ctx, q, []string{"key"}, old.Key); err != nil {
+				return err
+			}
+			if err = APIKEY_POLICY.Put(ctx, q, policy, policy.Key); err != nil {
+				return err
+			}
+		}
+
+		count = len(legacy)
+		return nil
+	})
+
+	if err != nil {
+		return 0, handleSQLError(ctx, err)
+	}
+
+	if count > 0 {
+		logger.Infof(ctx, "Hashed %d plaintext api keys", count)
+	}
+
+	return count, nil
+}
+
+func (m *AdminSQLRepository) CreateAPIKeyRecord(ctx context.Context, r *apikeys.Record) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return APIKEYS.Insert(ctx, q, r, r.Key, r.UserId)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Unable to store API key - %s", err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetAPIKeyRecord(ctx context.Context, id string) (*apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var record apikeys.Record
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return APIKEYS.Decode(ctx, q, &record, id)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &record, nil
+}
+
+// DisableExpiredAPIKeys scans the keys since the expiry is only kept in the stored document
+func (m *AdminSQLRepository) DisableExpiredAPIKeys(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DisableExpiredAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		expired := make([]apikeys.Record, 0)
+		err := APIKEYS.Find(ctx, q, nil, nil, func(doc bson.Raw) error {
+			var record apikeys.Record
+			if err := bson.Unmarshal(doc, &record); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			if !record.Disabled && record.Expired(now) {
+				expired = append(expired, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for index := range expired {
+			expired[index].Disabled = true
+			if err = APIKEYS.Put(ctx, q, expired[index], expired[index].Key, expired[index].UserId); err != nil {
+				return err
+			}
+		}
+
+		count = int64(len(expired))
+		return nil
+	})
+
+	if err != nil {
+		return 0, handleSQLError(ctx, err)
+	}
+
+	return count, nil
+}
+
+// RotateAPIKeyRecord locks the key while it sets its successor, so concurrent rotations of the same key cannot
+// both succeed
+func (m *AdminSQLRepository) RotateAPIKeyRecord(ctx context.Context, id string, next *apikeys.Record, graceEnds time.Time) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateAPIKeyRecord"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		var record apikeys.Record
+		if err := APIKEYS.Lock(ctx, q, &record, id); err != nil {
+			return err
+		}
+
+		if err := record.Supersede(next.Key, graceEnds); err != nil {
+			return err
+		}
+
+		if err := APIKEYS.Put(ctx, q, record, record.Key, record.UserId); err != nil {
+			return err
+		}
+
+		if err := APIKEYS.Insert(ctx, q, next, next.Key, next.UserId); err != nil {
+			return err
+		}
+
+		var policy entity.APIKeyPolicy
+		if err := APIKEY_POLICY.Decode(ctx, q, &policy, id); err == errs.ErrDBItemNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		policy.Key = next.Key
+		policy.Updated = next.Created
+		return APIKEY_POLICY.Put(ctx, q, policy, policy.Key)
+	})
+
+	if err == apikeys.ErrAPIKeyRotated {
+		return err
+	} else if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+// updateAPIKeyRecord applies update to the stored key in one transaction
+func (m *AdminSQLRepository) updateAPIKeyRecord(ctx context.Context, id string, update func(r *apikeys.Record)) error {
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		var record apikeys.Record
+		if err := APIKEYS.Lock(ctx, q, &record, id); err != nil {
+			return err
+		}
+
+		update(&record)
+		return APIKEYS.Put(ctx, q, record, record.Key, record.UserId)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) SetAPIKeyNetworks(ctx context.Context, id string, networks []string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetAPIKeyNetworks"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateAPIKeyRecord(ctx, id, func(r *apikeys.Record) {
+		r.Networks = networks
+	})
+}
+
+// RecordAPIKeyUse adds usage with the row locked, since usage is kept in the stored document and writes of the same
+// key would otherwise lose requests
+func (m *AdminSQLRepository) RecordAPIKeyUse(ctx context.Context, id string, usage apikeys.Usage) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RecordAPIKeyUse"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateAPIKeyRecord(ctx, id, func(r *apikeys.Record) {
+		r.Usage.Add(usage)
+	})
+}
+
+// GetUnusedAPIKeys scans the keys since usage is only kept in the stored document
+func (m *AdminSQLRepository) GetUnusedAPIKeys(ctx context.Context, since time.Time) ([]apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUnusedAPIKeys"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	unused := make([]apikeys.Record, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return APIKEYS.Find(ctx, q, nil, nil, func(doc bson.Raw) error {
+			var record apikeys.Record
+			if err := bson.Unmarshal(doc, &record); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			if record.UnusedSince(since) {
+				unused = append(unused, record)
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return unused, nil
+}
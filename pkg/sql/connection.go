@@ -0,0 +1,190 @@
+package sql
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	SQL_DRIVER_SQLITE   = "sqlite"
+	SQL_DRIVER_POSTGRES = "postgres"
+)
+
+// sqlDialect holds what differs between the supported databases
+type sqlDialect struct {
+	types       map[string]string
+	numbered    bool
+	singleConns bool
+	lockRows    string
+}
+
+var dialects = map[string]*sqlDialect{
+	SQL_DRIVER_SQLITE: {
+		types: map[string]string{SQL_TYPE_TEXT: "TEXT", SQL_TYPE_TIMESTAMP: "TIMESTAMP", SQL_TYPE_DOCUMENT: "BLOB"},
+		// sqlite allows a single writer so connections are serialized rather than failing with SQLITE_BUSY
+		singleConns: true,
+	},
+	SQL_DRIVER_POSTGRES: {
+		types:    map[string]string{SQL_TYPE_TEXT: "TEXT", SQL_TYPE_TIMESTAMP: "TIMESTAMP WITH TIME ZONE", SQL_TYPE_DOCUMENT: "BYTEA"},
+		numbered: true,
+		// rows read before they are changed are locked so concurrent transactions cannot overwrite each other
+		lockRows: " FOR UPDATE",
+	},
+}
+
+func (d *sqlDialect) columnType(name string) string {
+	return d.types[name]
+}
+
+// rebind replaces ? placeholders with $n for databases that number their parameters
+func (d *sqlDialect) rebind(query string) string {
+
+	if !d.numbered {
+		return query
+	}
+
+	var builder strings.Builder
+	count := 0
+	for _, r := range query {
+		if r == '?' {
+			count++
+			builder.WriteString("$" + strconv.Itoa(count))
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+
+	return builder.String()
+}
+
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (gosql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*gosql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *gosql.Row
+}
+
+// sqlQuerier runs queries written with ? placeholders against a database or transaction
+type sqlQuerier struct {
+	executor sqlExecutor
+	dialect  *sqlDialect
+}
+
+func (q *sqlQuerier) exec(ctx context.Context, query string, args ...interface{}) (gosql.Result, error) {
+	return q.executor.ExecContext(ctx, q.dialect.rebind(query), args...)
+}
+
+func (q *sqlQuerier) query(ctx context.Context, query string, args ...interface{}) (*gosql.Rows, error) {
+	return q.executor.QueryContext(ctx, q.dialect.rebind(query), args...)
+}
+
+func (q *sqlQuerier) queryRow(ctx context.Context, query string, args ...interface{}) *gosql.Row {
+	return q.executor.QueryRowContext(ctx, q.dialect.rebind(query), args...)
+}
+
+type SQLConnection struct {
+	db         *gosql.DB
+	driver     string
+	dataSource string
+	dialect    *sqlDialect
+}
+
+func NewSQLConnection(driver, dataSource string) (*SQLConnection, error) {
+
+	dialect, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sql driver %s", driver)
+	}
+
+	return &SQLConnection{
+		db:         nil,
+		driver:     driver,
+		dataSource: dataSource,
+		dialect:    dialect,
+	}, nil
+}
+
+func (c *SQLConnection) OpenConnection(ctx context.Context) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "OpenConnection"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	if c.db != nil {
+		return nil
+	}
+
+	logger.Infof(ctx, "Opening %s database", c.driver)
+	if c.driver == SQL_DRIVER_SQLITE && !strings.HasPrefix(c.dataSource, "file:") && c.dataSource != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(c.dataSource), 0700); err != nil {
+			return err
+		}
+	}
+
+	db, err := gosql.Open(c.driver, c.dataSource)
+	if err != nil {
+		logger.Errorf(ctx, "Unable to open %s database - %s", c.driver, err)
+		return err
+	}
+
+	if c.dialect.singleConns {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err = db.PingContext(ctx); err != nil {
+		logger.Errorf(ctx, "Unable to connect to %s database - %s", c.driver, err)
+		db.Close()
+		return err
+	}
+
+	c.db = db
+	return nil
+}
+
+func (c *SQLConnection) CloseConnection(ctx context.Context) {
+	logger.Infof(ctx, "Closing %s database", c.driver)
+
+	if c.db != nil {
+		if err := c.db.Close(); err != nil {
+			logger.Errorf(ctx, "Error while closing %s database - %s", c.driver, err)
+		}
+	}
+	c.db = nil
+}
+
+// View runs fn outside of a transaction
+func (c *SQLConnection) View(ctx context.Context, fn func(q *sqlQuerier) error) error {
+	if c.db == nil {
+		return errs.ErrDBError
+	}
+	return fn(&sqlQuerier{executor: c.db, dialect: c.dialect})
+}
+
+// Update runs fn in a transaction that is committed when fn succeeds
+func (c *SQLConnection) Update(ctx context.Context, fn func(q *sqlQuerier) error) error {
+	if c.db == nil {
+		return errs.ErrDBError
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(&sqlQuerier{executor: tx, dialect: c.dialect}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
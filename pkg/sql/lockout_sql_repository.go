@@ -0,0 +1,79 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/lockout"
+)
+
+func (m *AdminSQLRepository) GetAttempts(ctx context.Context, key string) (*lockout.Attempts, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var attempts lockout.Attempts
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return LOGIN_ATTEMPTS.Decode(ctx, q, &attempts, key)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &attempts, nil
+}
+
+func (m *AdminSQLRepository) PutAttempts(ctx context.Context, attempts *lockout.Attempts) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return LOGIN_ATTEMPTS.Put(ctx, q, attempts, attempts.Key, attempts.Expires.UTC())
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) DeleteAttempts(ctx context.Context, key string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		_, err := LOGIN_ATTEMPTS.Delete(ctx, q, LOGIN_ATTEMPTS.Keys, key)
+		return err
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) PurgeExpiredAttempts(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredAttempts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		result, err := q.exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE expires <= ?", LOGIN_ATTEMPTS.Name), now.UTC())
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		return err
+	})
+
+	if err != nil {
+		return 0, handleSQLError(ctx, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,41 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/mfa"
+)
+
+func (m *AdminSQLRepository) GetMFA(ctx context.Context, userId string) (*mfa.Enrollment, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var e mfa.Enrollment
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return MFA.Decode(ctx, q, &e, userId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &e, nil
+}
+
+func (m *AdminSQLRepository) PutMFA(ctx context.Context, e *mfa.Enrollment) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutMFA"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return MFA.Put(ctx, q, e, e.UserId)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
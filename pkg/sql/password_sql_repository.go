@@ -0,0 +1,58 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/password"
+)
+
+func (m *AdminSQLRepository) GetPassword(ctx context.Context, userId string) (*entity.UserPassword, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetPassword"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var pass entity.UserPassword
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return PASSWORDS.Decode(ctx, q, &pass, userId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &pass, nil
+}
+
+func (m *AdminSQLRepository) GetPasswordHistory(ctx context.Context, userId string) (*password.History, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetPasswordHistory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var history password.History
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return PASSWORD_HISTORY.Decode(ctx, q, &history, userId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &history, nil
+}
+
+func (m *AdminSQLRepository) PutPasswordHistory(ctx context.Context, history *password.History) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PutPasswordHistory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return PASSWORD_HISTORY.Put(ctx, q, history, history.UserId)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
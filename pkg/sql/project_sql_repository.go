@@ -0,0 +1,446 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	RESOURCE_LEVEL_PROJECT  = "project"
+	RESOURCE_LEVEL_INSTANCE = "instance"
+)
+
+// sqlResource matches the document stored by the Mongo repository for kubernetes resources
+type sqlResource struct {
+	Id       string                    `bson:"_id"`
+	Project  string                    `bson:"project"`
+	Instance string                    `bson:"instance"`
+	Level    string                    `bson:"level"`
+	Resource entity.KubernetesResource `bson:"resource"`
+}
+
+type ProjectSQLRepository struct {
+	conn *SQLConnection
+}
+
+func NewProjectSQLRepository(conn *SQLConnection) *ProjectSQLRepository {
+	return &ProjectSQLRepository{conn: conn}
+}
+
+func (m *ProjectSQLRepository) findProjects(ctx context.Context, columns []string, values ...interface{}) ([]entity.Project, error) {
+
+	projects := make([]entity.Project, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return PROJECTS.Find(ctx, q, columns, values, func(doc bson.Raw) error {
+			var project entity.Project
+			if err := bson.Unmarshal(doc, &project); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			projects = append(projects, project)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return projects, nil
+}
+
+func putProject(ctx context.Context, q *sqlQuerier, project *entity.Project) error {
+	return PROJECTS.Put(ctx, q, project, project.Name, project.Owner, project.TeamId)
+}
+
+func (m *ProjectSQLRepository) GetProjects(ctx context.Context) ([]entity.Project, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjects"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findProjects(ctx, nil)
+}
+
+func (m *ProjectSQLRepository) CreateProject(ctx context.Context, project *entity.Project) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateProject"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	logger.Infof(ctx, "Saving project %s - %s", project.GetName(), utils.MarshalObject(project))
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return PROJECTS.Insert(ctx, q, project, project.Name, project.Owner, project.TeamId)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Project insert failed - %s", err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *ProjectSQLRepository) UpdateProject(ctx context.Context, project *entity.Project) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdateProject"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	logger.Infof(ctx, "Updating project %s - %s", project.GetName(), utils.MarshalObject(project))
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		exists, err := PROJECTS.Exists(ctx, q, project.Name)
+		if err != nil {
+			return err
+		} else if !exists {
+			return errs.ErrDBItemNotFound
+		}
+
+		return putProject(ctx, q, project)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Project update failed - %s", err)
+		return errs.ErrDBItemUpdateFailed
+	}
+
+	return nil
+}
+
+func (m *ProjectSQLRepository) GetProject(ctx context.Context, name string) (*entity.Project, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectByName"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var project entity.Project
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return PROJECTS.Decode(ctx, q, &project, name)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &project, nil
+}
+
+func (m *ProjectSQLRepository) GetProjectsByOwner(ctx context.Context, owner string) ([]entity.Project, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectsByOwner"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findProjects(ctx, []string{"owner"}, owner)
+}
+
+func (m *ProjectSQLRepository) GetProjectsByTeam(ctx context.Context, team string) ([]entity.Project, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectsByTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findProjects(ctx, []string{"team"}, team)
+}
+
+func (m *ProjectSQLRepository) UpdateProjectStatus(ctx context.Context, project string, status string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ProjectSQLRepository.UpdateProjectStatus"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		var item entity.Project
+		if err := PROJECTS.Decode(ctx, q, &item, project); err != nil {
+			return err
+		}
+
+		item.Status = status
+		item.Timestamp = time.Now()
+		return putProject(ctx, q, &item)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *ProjectSQLRepository) findInstances(ctx context.Context, columns []string, values ...interface{}) ([]entity.Instance, error) {
+
+	docs := make([]bson.Raw, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return INSTANCES.Find(ctx, q, columns, values, func(doc bson.Raw) error {
+			docs = append(docs, doc)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	instances := make([]entity.Instance, 0, len(docs))
+	for _, doc := range docs {
+		instance, err := vars.ManagerFactory.GetProjectDataManager(ctx).UnmarshalBSONInstance(ctx, doc)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, *instance)
+	}
+
+	return instances, nil
+}
+
+func (m *ProjectSQLRepository) GetInstances(ctx context.Context) ([]entity.Instance, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstances"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findInstances(ctx, nil)
+}
+
+func (m *ProjectSQLRepository) CreateInstance(ctx context.Context, instance *entity.Instance) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateInstance"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return INSTANCES.Insert(ctx, q, instance, instance.Project, instance.Name, instance.InstanceType, instance.Owner)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	logger.Infof(ctx, "Saved instance %s - %s", instance.Name, utils.MarshalObject(instance))
+
+	return nil
+}
+
+func (m *ProjectSQLRepository) GetInstance(ctx context.Context, project, name string) (*entity.Instance, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstanceByName"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var doc bson.Raw
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		var err error
+		doc, err = INSTANCES.Get(ctx, q, project, name)
+		return err
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Unable to find instance %s in project %s - %s", name, project, err)
+		return nil, errs.ErrDBItemNotFound
+	}
+
+	return vars.ManagerFactory.GetProjectDataManager(ctx).UnmarshalBSONInstance(ctx, doc)
+}
+
+func (m *ProjectSQLRepository) GetInstancesByProject(ctx context.Context, project string) ([]entity.Instance, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstancesByProject"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findInstances(ctx, []string{"project"}, project)
+}
+
+func (m *ProjectSQLRepository) GetInstancesByOwner(ctx context.Context, owner string) ([]entity.Instance, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstancesByOwner"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findInstances(ctx, []string{"owner"}, owner)
+}
+
+func (m *ProjectSQLRepository) UpdateInstanceStatus(ctx context.Context, project, name, status string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "UpdateInstanceStatus"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	// the instance document may carry fields specific to its type, so only the status fields of the stored document
+	// are changed rather than round tripping it through entity.Instance
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		data, err := INSTANCES.Get(ctx, q, project, name)
+		if err != nil {
+			return err
+		}
+
+		var doc bson.D
+		if err = bson.Unmarshal(data, &doc); err != nil {
+			return errs.ErrMarshalFailed
+		}
+
+		doc = setField(doc, "status", status)
+		doc = setField(doc, "timestamp", time.Now())
+
+		update, err := bson.Marshal(doc)
+		if err != nil {
+			return errs.ErrMarshalFailed
+		}
+
+		_, err = q.exec(ctx, "UPDATE instances SET document = ? WHERE project = ? AND name = ?", update, project, name)
+		return err
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func setField(doc bson.D, key string, value interface{}) bson.D {
+	for index := range doc {
+		if doc[index].Key == key {
+			doc[index].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+func (m *ProjectSQLRepository) findResources(ctx context.Context, level, project, instance string) ([]entity.KubernetesResource, error) {
+
+	resources := make([]entity.KubernetesResource, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		columns := []string{"level", "project", "instance"}
+		return RESOURCES.Find(ctx, q, columns, []interface{}{level, project, instance}, func(doc bson.Raw) error {
+			var resource sqlResource
+			if err := bson.Unmarshal(doc, &resource); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			resources = append(resources, resource.Resource)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return resources, nil
+}
+
+// saveResource stores resource or, if it already exists, updates its state and timestamp
+func (m *ProjectSQLRepository) saveResource(ctx context.Context, parent SQLTable, parentKeys []interface{}, item sqlResource) error {
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		exists, err := parent.Exists(ctx, q, parentKeys...)
+		if err != nil {
+			return err
+		} else if !exists {
+			return errs.ErrDBItemNotFound
+		}
+
+		var current sqlResource
+		err = RESOURCES.Decode(ctx, q, &current, item.Id)
+		if err == nil {
+			current.Resource.State = item.Resource.State
+			current.Resource.Timestamp = item.Resource.Timestamp
+			item = current
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		return RESOURCES.Put(ctx, q, item, item.Id, item.Project, item.Instance, item.Level, item.Resource.Type, item.Resource.Name)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Save of kubernetes resource %s failed - %s", utils.MarshalObject(item.Resource), err)
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *ProjectSQLRepository) GetProjectResources(ctx context.Context, project string) ([]entity.KubernetesResource, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetProjectResources"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findResources(ctx, RESOURCE_LEVEL_PROJECT, project, "")
+}
+
+func (m *ProjectSQLRepository) SaveProjectResource(ctx context.Context, project string, resource *entity.KubernetesResource) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveProjectResource"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := sqlResource{Id: resource.Id, Project: project, Level: RESOURCE_LEVEL_PROJECT, Resource: *resource}
+	return m.saveResource(ctx, PROJECTS, []interface{}{project}, item)
+}
+
+func (m *ProjectSQLRepository) SaveProjectResources(ctx context.Context, project string, resources []entity.KubernetesResource) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveProjectResources"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	for index := range resources {
+		if err := m.SaveProjectResource(ctx, project, &resources[index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *ProjectSQLRepository) GetInstanceResources(ctx context.Context, project, instance string) ([]entity.KubernetesResource, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetInstanceResources"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findResources(ctx, RESOURCE_LEVEL_INSTANCE, project, instance)
+}
+
+func (m *ProjectSQLRepository) SaveInstanceResource(ctx context.Context, project, instance string, resource *entity.KubernetesResource) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveInstanceResource"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	item := sqlResource{Id: resource.Id, Project: project, Instance: instance, Level: RESOURCE_LEVEL_INSTANCE, Resource: *resource}
+	return m.saveResource(ctx, INSTANCES, []interface{}{project, instance}, item)
+}
+
+func (m *ProjectSQLRepository) SaveInstanceResources(ctx context.Context, project, instance string, resources []entity.KubernetesResource) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SaveInstanceResources"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	for index := range resources {
+		if err := m.SaveInstanceResource(ctx, project, instance, &resources[index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *ProjectSQLRepository) GetUserSummary(ctx context.Context, userId string) (*entity.ResourceSummary, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetUserSummary"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	summary := entity.ResourceSummary{}
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		var err error
+		if summary.TotalAPIKeys, err = APIKEYS.Count(ctx, q, []string{"userid"}, userId); err != nil {
+			return err
+		}
+		if summary.TotalProjects, err = PROJECTS.Count(ctx, q, []string{"owner"}, userId); err != nil {
+			return err
+		}
+		if summary.TotalInstances, err = INSTANCES.Count(ctx, q, []string{"owner"}, userId); err != nil {
+			return err
+		}
+		summary.TotalTeams, err = TEAMS.Count(ctx, q, []string{"owner"}, userId)
+		return err
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Unable to count user resources - %s", err)
+		return nil, errs.ErrDBError
+	}
+
+	return &summary, nil
+}
@@ -0,0 +1,58 @@
+package sql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/utils"
+)
+
+func Test_GetUserSummary(t *testing.T) {
+
+	ctx := context.Background()
+	conn := newTestConnection(t)
+	projRepo := NewProjectSQLRepository(conn)
+	adminRepo := NewAdminSQLRepository(conn)
+
+	projRepo.CreateProject(ctx, &entity.Project{Name: "project", Owner: "owner"})
+	projRepo.CreateInstance(ctx, &entity.Instance{Project: "project", Name: "instance", Owner: "owner"})
+	adminRepo.CreateTeam(ctx, entity.Team{TeamId: "team", Owner: "owner"})
+	adminRepo.CreateAPIKey(ctx, "owner")
+
+	summary, err := projRepo.GetUserSummary(ctx, "owner")
+	if err != nil || summary.TotalProjects != 1 || summary.TotalInstances != 1 || summary.TotalTeams != 1 || summary.TotalAPIKeys != 1 {
+		t.Fatalf("Expected one of each resource but got %s - %v", utils.MarshalObject(summary), err)
+	}
+}
+
+func Test_ProjectResources(t *testing.T) {
+
+	ctx := context.Background()
+	repo := NewProjectSQLRepository(newTestConnection(t))
+
+	resource := entity.KubernetesResource{Id: "resource", Name: "resource", State: "pending"}
+	if err := repo.SaveProjectResource(ctx, "project", &resource); err != errs.ErrDBItemNotFound {
+		t.Fatalf("Expected resource of unknown project to fail but got %v", err)
+	}
+
+	repo.CreateProject(ctx, &entity.Project{Name: "project", Owner: "owner"})
+	if err := repo.SaveProjectResource(ctx, "project", &resource); err != nil {
+		t.Fatalf("Expected resource to be saved but got err - %s", err)
+	}
+
+	resource.State = "running"
+	if err := repo.SaveProjectResource(ctx, "project", &resource); err != nil {
+		t.Fatalf("Expected resource to be updated but got err - %s", err)
+	}
+
+	resources, err := repo.GetProjectResources(ctx, "project")
+	if err != nil || len(resources) != 1 || resources[0].State != "running" {
+		t.Fatalf("Expected updated resource but got %s - %v", utils.MarshalObject(resources), err)
+	}
+
+	if resources, _ = repo.GetInstanceResources(ctx, "project", "instance"); len(resources) != 0 {
+		t.Fatalf("Expected no instance resources but got %d", len(resources))
+	}
+}
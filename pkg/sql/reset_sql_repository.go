@@ -0,0 +1,88 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/reset"
+)
+
+func (m *AdminSQLRepository) CreateResetToken(ctx context.Context, t *reset.ResetToken) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		if _, err := RESET_TOKENS.Delete(ctx, q, []string{"userid"}, t.UserId); err != nil {
+			return err
+		}
+		return RESET_TOKENS.Insert(ctx, q, t, t.Hash, t.UserId, t.Expires.UTC())
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetResetToken(ctx context.Context, hash string) (*reset.ResetToken, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var t reset.ResetToken
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return RESET_TOKENS.Decode(ctx, q, &t, hash)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &t, nil
+}
+
+func (m *AdminSQLRepository) ConsumeResetToken(ctx context.Context, hash string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "ConsumeResetToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		count, err := RESET_TOKENS.Delete(ctx, q, []string{"hash"}, hash)
+		if err != nil {
+			return err
+		} else if count == 0 {
+			return errs.ErrDBItemNotFound
+		}
+		return nil
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) PurgeExpiredResetTokens(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredResetTokens"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		result, err := q.exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE expires <= ?", RESET_TOKENS.Name), now.UTC())
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		return err
+	})
+
+	if err != nil {
+		return 0, handleSQLError(ctx, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,102 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/revocation"
+)
+
+func (m *AdminSQLRepository) RevokeToken(ctx context.Context, token *revocation.RevokedToken) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RevokeToken"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return REVOKED_TOKENS.Put(ctx, q, token, token.Id, token.UserId, token.Expires.UTC())
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "IsTokenRevoked"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var revoked bool
+	err := m.conn.View(ctx, func(q *sqlQuerier) (err error) {
+		revoked, err = REVOKED_TOKENS.Exists(ctx, q, tokenId)
+		return err
+	})
+
+	if err != nil {
+		return false, handleSQLError(ctx, err)
+	}
+
+	return revoked, nil
+}
+
+func (m *AdminSQLRepository) SetWatermark(ctx context.Context, w *revocation.Watermark) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SetWatermark"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		return WATERMARKS.Put(ctx, q, w, w.UserId, w.Expires.UTC())
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetWatermark(ctx context.Context, userId string) (*revocation.Watermark, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetWatermark"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var w revocation.Watermark
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return WATERMARKS.Decode(ctx, q, &w, userId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &w, nil
+}
+
+func (m *AdminSQLRepository) PurgeExpiredRevocations(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredRevocations"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		for _, table := range []SQLTable{REVOKED_TOKENS, WATERMARKS} {
+			result, err := q.exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE expires <= ?", table.Name), now.UTC())
+			if err != nil {
+				return err
+			}
+
+			purged, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			count += purged
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, handleSQLError(ctx, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,148 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/apikeys"
+)
+
+// run returns the transaction used to seed: none for a dry run
+func (m *AdminSQLRepository) run(dryRun bool) func(ctx context.Context, fn func(q *sqlQuerier) error) error {
+	if dryRun {
+		return m.conn.View
+	}
+	return m.conn.Update
+}
+
+// insertMissing stores a dependent item unless one is already stored under its key, the first of values
+func insertMissing(ctx context.Context, q *sqlQuerier, table SQLTable, item interface{}, values ...interface{}) error {
+	exists, err := table.Exists(ctx, q, values[0])
+	if err != nil || exists {
+		return err
+	}
+	return table.Insert(ctx, q, item, values...)
+}
+
+func (m *AdminSQLRepository) SeedUser(ctx context.Context, user *entity.User, pass *entity.UserPassword, policy *entity.UserPolicy, dryRun bool) (*entity.User, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedUser"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var existing *entity.User
+	err := m.run(dryRun)(ctx, func(q *sqlQuerier) error {
+		var stored entity.User
+		if err := USERS.Decode(ctx, q, &stored, user.UserId); err == nil {
+			existing = &stored
+			return nil
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		if err := USERS.Insert(ctx, q, user, user.UserId, user.Email); err != nil {
+			return err
+		}
+		if err := insertMissing(ctx, q, PASSWORDS, pass, user.UserId); err != nil {
+			return err
+		}
+		return insertMissing(ctx, q, USER_POLICY, policy, user.UserId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return existing, nil
+}
+
+func (m *AdminSQLRepository) SeedAPIKey(ctx context.Context, apikey *apikeys.Record, policy *entity.APIKeyPolicy, dryRun bool) (*apikeys.Record, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedAPIKey"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var existing *apikeys.Record
+	err := m.run(dryRun)(ctx, func(q *sqlQuerier) error {
+		var stored apikeys.Record
+		if err := APIKEYS.Decode(ctx, q, &stored, apikey.Key); err == nil {
+			existing = &stored
+			return nil
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		if err := APIKEYS.Insert(ctx, q, apikey, apikey.Key, apikey.UserId); err != nil {
+			return err
+		}
+		return insertMissing(ctx, q, APIKEY_POLICY, policy, apikey.Key)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return existing, nil
+}
+
+func (m *AdminSQLRepository) SeedTeam(ctx context.Context, team *entity.Team, dryRun bool) (*entity.Team, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var existing *entity.Team
+	err := m.run(dryRun)(ctx, func(q *sqlQuerier) error {
+		var stored entity.Team
+		if err := TEAMS.Decode(ctx, q, &stored, team.TeamId); err == nil {
+			existing = &stored
+			return nil
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+		return TEAMS.Insert(ctx, q, team, team.TeamId, team.Owner)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return existing, nil
+}
+
+func (m *AdminSQLRepository) SeedTeamMember(ctx context.Context, member *entity.TeamMember, dryRun bool) (*entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SeedTeamMember"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var existing *entity.TeamMember
+	err := m.run(dryRun)(ctx, func(q *sqlQuerier) error {
+		var stored entity.TeamMember
+		if err := TEAM_MEMBERS.Decode(ctx, q, &stored, member.Key); err == nil {
+			existing = &stored
+			return nil
+		} else if err != errs.ErrDBItemNotFound {
+			return err
+		}
+
+		if dryRun {
+			return nil
+		}
+		return TEAM_MEMBERS.Insert(ctx, q, member, member.Key, member.TeamId, member.Email, member.Status, member.ExpiresOn)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return existing, nil
+}
@@ -0,0 +1,114 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/serviceaccount"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (m *AdminSQLRepository) CreateServiceAccount(ctx context.Context, sa *serviceaccount.ServiceAccount, user *entity.User) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		if exists, err := USERS.Exists(ctx, q, user.UserId); err != nil {
+			return err
+		} else if exists {
+			return errs.ErrUserAlreadyExists
+		}
+
+		if err := USERS.Insert(ctx, q, user, user.UserId, user.Email); err != nil {
+			return err
+		}
+
+		if err := SERVICE_ACCOUNTS.Insert(ctx, q, sa, sa.Id, sa.TeamId); err != nil {
+			return err
+		}
+
+		member := sa.TeamMember()
+		return TEAM_MEMBERS.Insert(ctx, q, member, member.Key, member.TeamId, member.Email, member.Status, member.ExpiresOn)
+	})
+
+	if err == errs.ErrUserAlreadyExists {
+		return err
+	} else if err != nil {
+		logger.Errorf(ctx, "Unable to store service account %s - %s", sa.Id, err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetServiceAccount(ctx context.Context, id string) (*serviceaccount.ServiceAccount, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var sa serviceaccount.ServiceAccount
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return SERVICE_ACCOUNTS.Decode(ctx, q, &sa, id)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &sa, nil
+}
+
+func (m *AdminSQLRepository) GetServiceAccounts(ctx context.Context, teamId string) ([]serviceaccount.ServiceAccount, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetServiceAccounts"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	accounts := make([]serviceaccount.ServiceAccount, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return SERVICE_ACCOUNTS.Find(ctx, q, []string{"teamid"}, []interface{}{teamId}, func(doc bson.Raw) error {
+			var sa serviceaccount.ServiceAccount
+			if err := bson.Unmarshal(doc, &sa); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			accounts = append(accounts, sa)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return accounts, nil
+}
+
+func (m *AdminSQLRepository) DeleteServiceAccount(ctx context.Context, id string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteServiceAccount"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		count, err := SERVICE_ACCOUNTS.Delete(ctx, q, []string{"id"}, id)
+		if err != nil {
+			return err
+		} else if count == 0 {
+			return errs.ErrDBItemNotFound
+		}
+
+		for _, table := range []SQLTable{USERS, USER_POLICY} {
+			if _, err = table.Delete(ctx, q, []string{"userid"}, id); err != nil {
+				return err
+			}
+		}
+
+		_, err = TEAM_MEMBERS.Delete(ctx, q, []string{"key"}, id)
+		return err
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/session"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// sessionValues returns the keys and columns of a session row. Times are stored in UTC so they compare in order.
+func sessionValues(s *session.Session) []interface{} {
+	return []interface{}{s.Id, s.UserId, s.Device, s.TokenHash, s.Expires.UTC()}
+}
+
+func (m *AdminSQLRepository) CreateSession(ctx context.Context, s *session.Session) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		if _, err := SESSIONS.Delete(ctx, q, []string{"userid", "device"}, s.UserId, s.Device); err != nil {
+			return err
+		}
+		return SESSIONS.Insert(ctx, q, s, sessionValues(s)...)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetSession(ctx context.Context, sessionId string) (*session.Session, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var s session.Session
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return SESSIONS.Decode(ctx, q, &s, sessionId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &s, nil
+}
+
+func (m *AdminSQLRepository) RotateSession(ctx context.Context, tokenHash string, next *session.Session) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "RotateSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		swapped, err := SESSIONS.Swap(ctx, q, "tokenhash", tokenHash, next, sessionValues(next)...)
+		if err != nil {
+			return err
+		}
+
+		if swapped {
+			return nil
+		}
+
+		if exists, err := SESSIONS.Exists(ctx, q, next.Id); err != nil {
+			return err
+		} else if !exists {
+			return errs.ErrDBItemNotFound
+		}
+		return session.ErrRefreshTokenReused
+	})
+
+	if err == session.ErrRefreshTokenReused {
+		return err
+	} else if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetSessions(ctx context.Context, userId string) ([]session.Session, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	sessions := make([]session.Session, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return SESSIONS.Find(ctx, q, []string{"userid"}, []interface{}{userId}, func(doc bson.Raw) error {
+			var s session.Session
+			if err := bson.Unmarshal(doc, &s); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			sessions = append(sessions, s)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return sessions, nil
+}
+
+func (m *AdminSQLRepository) DeleteSession(ctx context.Context, sessionId string) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteSession"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) (err error) {
+		count, err = SESSIONS.Delete(ctx, q, SESSIONS.Keys, sessionId)
+		return err
+	})
+
+	if err == nil && count == 0 {
+		err = errs.ErrDBItemNotFound
+	}
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) DeleteSessions(ctx context.Context, userId string) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "DeleteSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) (err error) {
+		count, err = SESSIONS.Delete(ctx, q, []string{"userid"}, userId)
+		return err
+	})
+
+	if err != nil {
+		return 0, handleSQLError(ctx, err)
+	}
+
+	return count, nil
+}
+
+func (m *AdminSQLRepository) PurgeExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredSessions"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		result, err := q.exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE expires <= ?", SESSIONS.Name), now.UTC())
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		return err
+	})
+
+	if err != nil {
+		return 0, handleSQLError(ctx, err)
+	}
+
+	return count, nil
+}
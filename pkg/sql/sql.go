@@ -0,0 +1,440 @@
+package sql
+
+import (
+	"context"
+	gosql "database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/repo/pkg/seed"
+	"go.mongodb.org/mongo-driver/bson"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	SQL_TABLE_PROJECTS        = "projects"
+	SQL_TABLE_INSTANCES       = "instances"
+	SQL_TABLE_RESOURCES       = "k8s_resources"
+	SQL_TABLE_USERS           = "users"
+	SQL_TABLE_PASSWORD        = "passwords"
+	SQL_TABLE_INSTANCE_POLICY = "instance_policy"
+	SQL_TABLE_USER_POLICY     = "user_policy"
+	SQL_TABLE_APIKEY          = "apikeys"
+	SQL_TABLE_APIKEY_POLICY   = "apikey_policy"
+	SQL_TABLE_TEAMS           = "teams"
+	SQL_TABLE_TEAM_MEMBERS    = "team_members"
+	SQL_TABLE_SESSIONS        = "sessions"
+	SQL_TABLE_REVOKED_TOKENS  = "revoked_tokens"
+	SQL_TABLE_WATERMARKS      = "token_watermarks"
+	SQL_TABLE_LOGIN_ATTEMPTS  = "login_attempts"
+	SQL_TABLE_PASSWORD_HIST   = "password_history"
+	SQL_TABLE_RESET_TOKENS    = "reset_tokens"
+	SQL_TABLE_MFA             = "mfa"
+	SQL_TABLE_SERVICE_ACCOUNT = "service_accounts"
+
+	// the primary keys already enforce the compound unique indexes of zbirepo.js, as each of them includes the key of
+	// its table. Emails, unique like in the bolt and mongo repositories, and resources have constraints of their own.
+	USERS           = SQLTable{Name: SQL_TABLE_USERS, Keys: []string{"userid"}, Columns: []SQLColumn{{Name: "email"}}, Unique: [][]string{{"email"}}}
+	PASSWORDS       = SQLTable{Name: SQL_TABLE_PASSWORD, Keys: []string{"userid"}}
+	APIKEYS         = SQLTable{Name: SQL_TABLE_APIKEY, Keys: []string{"key"}, Columns: []SQLColumn{{Name: "userid"}}, Indexes: [][]string{{"userid"}}}
+	INSTANCE_POLICY = SQLTable{Name: SQL_TABLE_INSTANCE_POLICY, Keys: []string{"project", "instance"}}
+	USER_POLICY     = SQLTable{Name: SQL_TABLE_USER_POLICY, Keys: []string{"userid"}}
+	APIKEY_POLICY   = SQLTable{Name: SQL_TABLE_APIKEY_POLICY, Keys: []string{"key"}}
+	PROJECTS        = SQLTable{Name: SQL_TABLE_PROJECTS, Keys: []string{"name"}, Columns: []SQLColumn{{Name: "owner"}, {Name: "team"}},
+		Indexes: [][]string{{"owner"}, {"team"}}}
+	INSTANCES = SQLTable{Name: SQL_TABLE_INSTANCES, Keys: []string{"project", "name"}, Columns: []SQLColumn{{Name: "type"}, {Name: "owner"}},
+		Indexes: [][]string{{"owner"}}}
+	RESOURCES = SQLTable{Name: SQL_TABLE_RESOURCES, Keys: []string{"id"},
+		Columns: []SQLColumn{{Name: "project"}, {Name: "instance"}, {Name: "level"}, {Name: "type"}, {Name: "name"}},
+		Unique:  [][]string{{"project", "instance", "type", "name"}}, Indexes: [][]string{{"level", "project", "instance"}}}
+	TEAMS        = SQLTable{Name: SQL_TABLE_TEAMS, Keys: []string{"teamid"}, Columns: []SQLColumn{{Name: "owner"}}, Indexes: [][]string{{"owner"}}}
+	TEAM_MEMBERS = SQLTable{Name: SQL_TABLE_TEAM_MEMBERS, Keys: []string{"key"},
+		Columns: []SQLColumn{{Name: "teamid"}, {Name: "email"}, {Name: "status"}, {Name: "expireson", Type: SQL_TYPE_TIMESTAMP}},
+		Indexes: [][]string{{"teamid", "email"}, {"email"}}}
+
+	SESSIONS = SQLTable{Name: SQL_TABLE_SESSIONS, Keys: []string{"id"},
+		Columns: []SQLColumn{{Name: "userid"}, {Name: "device"}, {Name: "tokenhash"}, {Name: "expires", Type: SQL_TYPE_TIMESTAMP}},
+		Indexes: [][]string{{"userid", "device"}, {"expires"}}}
+	REVOKED_TOKENS = SQLTable{Name: SQL_TABLE_REVOKED_TOKENS, Keys: []string{"id"},
+		Columns: []SQLColumn{{Name: "userid"}, {Name: "expires", Type: SQL_TYPE_TIMESTAMP}}, Indexes: [][]string{{"expires"}}}
+	WATERMARKS = SQLTable{Name: SQL_TABLE_WATERMARKS, Keys: []string{"userid"},
+		Columns: []SQLColumn{{Name: "expires", Type: SQL_TYPE_TIMESTAMP}}, Indexes: [][]string{{"expires"}}}
+	LOGIN_ATTEMPTS = SQLTable{Name: SQL_TABLE_LOGIN_ATTEMPTS, Keys: []string{"key"},
+		Columns: []SQLColumn{{Name: "expires", Type: SQL_TYPE_TIMESTAMP}}, Indexes: [][]string{{"expires"}}}
+	PASSWORD_HISTORY = SQLTable{Name: SQL_TABLE_PASSWORD_HIST, Keys: []string{"userid"}}
+	RESET_TOKENS     = SQLTable{Name: SQL_TABLE_RESET_TOKENS, Keys: []string{"hash"},
+		Columns: []SQLColumn{{Name: "userid"}, {Name: "expires", Type: SQL_TYPE_TIMESTAMP}}, Indexes: [][]string{{"userid"}, {"expires"}}}
+	MFA              = SQLTable{Name: SQL_TABLE_MFA, Keys: []string{"userid"}}
+	SERVICE_ACCOUNTS = SQLTable{Name: SQL_TABLE_SERVICE_ACCOUNT, Keys: []string{"id"}, Columns: []SQLColumn{{Name: "teamid"}},
+		Indexes: [][]string{{"teamid"}}}
+
+	TABLES = []SQLTable{USERS, PASSWORDS, APIKEYS, INSTANCE_POLICY, USER_POLICY, APIKEY_POLICY, PROJECTS, INSTANCES, RESOURCES, TEAMS, TEAM_MEMBERS, SESSIONS, REVOKED_TOKENS, WATERMARKS, LOGIN_ATTEMPTS, PASSWORD_HISTORY, RESET_TOKENS, MFA, SERVICE_ACCOUNTS}
+)
+
+const (
+	SQL_TYPE_TEXT      = "text"
+	SQL_TYPE_TIMESTAMP = "timestamp"
+	SQL_TYPE_DOCUMENT  = "document"
+
+	// every table keeps the full entity as a BSON document next to the columns used for lookups and constraints
+	documentColumn = "document"
+)
+
+// SQLTable describes a table holding entity documents. Keys form the primary key and Columns are copied out of
+// the entity so they can be queried and constrained.
+type SQLTable struct {
+	Name    string
+	Keys    []string
+	Columns []SQLColumn
+	Unique  [][]string
+	Indexes [][]string
+}
+
+type SQLColumn struct {
+	Name string
+	Type string
+}
+
+func (t SQLTable) columns() []string {
+	columns := append([]string{}, t.Keys...)
+	for _, column := range t.Columns {
+		columns = append(columns, column.Name)
+	}
+	return append(columns, documentColumn)
+}
+
+func (t SQLTable) createStatements(d *sqlDialect) []string {
+
+	definitions := make([]string, 0, len(t.Keys)+len(t.Columns)+len(t.Unique)+2)
+	for _, key := range t.Keys {
+		definitions = append(definitions, fmt.Sprintf("%s %s NOT NULL", key, d.columnType(SQL_TYPE_TEXT)))
+	}
+
+	for _, column := range t.Columns {
+		columnType := column.Type
+		if len(columnType) == 0 {
+			columnType = SQL_TYPE_TEXT
+		}
+		definitions = append(definitions, fmt.Sprintf("%s %s", column.Name, d.columnType(columnType)))
+	}
+
+	definitions = append(definitions, fmt.Sprintf("%s %s NOT NULL", documentColumn, d.columnType(SQL_TYPE_DOCUMENT)))
+	definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(t.Keys, ", ")))
+
+	// unique constraints are indexes so that new constraints also reach tables created by earlier versions
+	statements := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", t.Name, strings.Join(definitions, ", "))}
+	for _, unique := range t.Unique {
+		statements = append(statements, fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_%s_key ON %s (%s)",
+			t.Name, strings.Join(unique, "_"), t.Name, strings.Join(unique, ", ")))
+	}
+	for _, index := range t.Indexes {
+		statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s_idx ON %s (%s)",
+			t.Name, strings.Join(index, "_"), t.Name, strings.Join(index, ", ")))
+	}
+
+	return statements
+}
+
+// arguments returns the values of the keys and columns to store. Empty values of columns that are unique on their
+// own are stored as NULL, which the constraint does not compare, so items without the value do not collide.
+func (t SQLTable) arguments(values []interface{}) []interface{} {
+	args := append([]interface{}{}, values...)
+	for index, column := range t.Columns {
+		position := len(t.Keys) + index
+		if position < len(args) && args[position] == "" && t.unique(column.Name) {
+			args[position] = nil
+		}
+	}
+	return args
+}
+
+func (t SQLTable) unique(column string) bool {
+	for _, unique := range t.Unique {
+		if len(unique) == 1 && unique[0] == column {
+			return true
+		}
+	}
+	return false
+}
+
+func (t SQLTable) where(columns []string) string {
+	conditions := make([]string, len(columns))
+	for index, column := range columns {
+		conditions[index] = fmt.Sprintf("%s = ?", column)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// Insert adds a row for item. values holds the keys followed by the columns of the table.
+func (t SQLTable) Insert(ctx context.Context, q *sqlQuerier, item interface{}, values ...interface{}) error {
+
+	data, err := bson.Marshal(item)
+	if err != nil {
+		return errs.ErrMarshalFailed
+	}
+
+	columns := t.columns()
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.Name, strings.Join(columns, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+
+	_, err = q.exec(ctx, query, append(t.arguments(values), data)...)
+	return err
+}
+
+// Put inserts or replaces the row for item. values holds the keys followed by the columns of the table.
+func (t SQLTable) Put(ctx context.Context, q *sqlQuerier, item interface{}, values ...interface{}) error {
+
+	data, err := bson.Marshal(item)
+	if err != nil {
+		return errs.ErrMarshalFailed
+	}
+
+	columns := t.columns()
+	updates := make([]string, 0, len(columns)-len(t.Keys))
+	for _, column := range columns[len(t.Keys):] {
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", column, column))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s", t.Name,
+		strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "),
+		strings.Join(t.Keys, ", "), strings.Join(updates, ", "))
+
+	_, err = q.exec(ctx, query, append(t.arguments(values), data)...)
+	return err
+}
+
+// Swap replaces the row for item only while column still holds expected and reports whether it did. values holds
+// the keys followed by the columns of the table.
+func (t SQLTable) Swap(ctx context.Context, q *sqlQuerier, column string, expected interface{}, item interface{}, values ...interface{}) (bool, error) {
+
+	data, err := bson.Marshal(item)
+	if err != nil {
+		return false, errs.ErrMarshalFailed
+	}
+
+	columns := t.columns()
+	updates := make([]string, 0, len(columns)-len(t.Keys))
+	for _, name := range columns[len(t.Keys):] {
+		updates = append(updates, fmt.Sprintf("%s = ?", name))
+	}
+
+	conditions := append(append([]string{}, t.Keys...), column)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", t.Name, strings.Join(updates, ", "), t.where(conditions))
+
+	values = t.arguments(values)
+	args := make([]interface{}, 0, len(values)+2)
+	args = append(append(args, values[len(t.Keys):]...), data)
+	args = append(append(args, values[:len(t.Keys)]...), expected)
+	result, err := q.exec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+func (t SQLTable) Exists(ctx context.Context, q *sqlQuerier, keys ...interface{}) (bool, error) {
+
+	_, err := t.Get(ctx, q, keys...)
+	if err == errs.ErrDBItemNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Get returns the document stored under keys
+func (t SQLTable) Get(ctx context.Context, q *sqlQuerier, keys ...interface{}) (bson.Raw, error) {
+	return t.get(ctx, q, "", keys...)
+}
+
+func (t SQLTable) get(ctx context.Context, q *sqlQuerier, lock string, keys ...interface{}) (bson.Raw, error) {
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s%s", documentColumn, t.Name, t.where(t.Keys), lock)
+
+	var data []byte
+	if err := q.queryRow(ctx, query, keys...).Scan(&data); err != nil {
+		if err == gosql.ErrNoRows {
+			return nil, errs.ErrDBItemNotFound
+		}
+		return nil, err
+	}
+
+	return bson.Raw(data), nil
+}
+
+// Decode unmarshals the document stored under keys into item
+func (t SQLTable) Decode(ctx context.Context, q *sqlQuerier, item interface{}, keys ...interface{}) error {
+
+	data, err := t.Get(ctx, q, keys...)
+	if err != nil {
+		return err
+	}
+
+	if err = bson.Unmarshal(data, item); err != nil {
+		return errs.ErrMarshalFailed
+	}
+
+	return nil
+}
+
+// Lock unmarshals the document stored under keys into item and keeps other transactions from changing it until
+// this one ends. It is used to read a row that is about to be changed.
+func (t SQLTable) Lock(ctx context.Context, q *sqlQuerier, item interface{}, keys ...interface{}) error {
+
+	data, err := t.get(ctx, q, q.dialect.lockRows, keys...)
+	if err != nil {
+		return err
+	}
+
+	if err = bson.Unmarshal(data, item); err != nil {
+		return errs.ErrMarshalFailed
+	}
+
+	return nil
+}
+
+// Delete removes the rows matching the columns and values and returns how many were removed
+func (t SQLTable) Delete(ctx context.Context, q *sqlQuerier, columns []string, values ...interface{}) (int64, error) {
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", t.Name, t.where(columns))
+	result, err := q.exec(ctx, query, values...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Find calls fn with every document matching the columns and values. All rows are returned when no columns are
+// given.
+func (t SQLTable) Find(ctx context.Context, q *sqlQuerier, columns []string, values []interface{}, fn func(doc bson.Raw) error) error {
+
+	query := fmt.Sprintf("SELECT %s FROM %s", documentColumn, t.Name)
+	if len(columns) > 0 {
+		query = fmt.Sprintf("%s WHERE %s", query, t.where(columns))
+	}
+
+	rows, err := q.query(ctx, query, values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return err
+		}
+
+		if err = fn(bson.Raw(data)); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Count returns the number of rows matching the columns and values
+func (t SQLTable) Count(ctx context.Context, q *sqlQuerier, columns []string, values ...interface{}) (int, error) {
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", t.Name, t.where(columns))
+
+	var count int
+	if err := q.queryRow(ctx, query, values...).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func isUniqueViolation(err error) bool {
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+
+	return false
+}
+
+func handleSQLError(ctx context.Context, err error) error {
+	logger.Errorf(ctx, "Database error - %s", err)
+	if err == errs.ErrDBItemNotFound || err == errs.ErrDBKeyAlreadyExists || err == errs.ErrMarshalFailed {
+		return err
+	}
+
+	if err == gosql.ErrNoRows {
+		return errs.ErrDBItemNotFound
+	}
+
+	if isUniqueViolation(err) {
+		return errs.ErrDBKeyAlreadyExists
+	}
+
+	return errs.ErrDBError
+}
+
+func CreateTables(ctx context.Context, conn *SQLConnection) error {
+
+	return conn.Update(ctx, func(q *sqlQuerier) error {
+		for _, table := range TABLES {
+			for _, statement := range table.createStatements(conn.dialect) {
+				if _, err := q.exec(ctx, statement); err != nil {
+					logger.Errorf(ctx, "Unable to create table %s - %s", table.Name, err)
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func DropTables(ctx context.Context, conn *SQLConnection) error {
+
+	return conn.Update(ctx, func(q *sqlQuerier) error {
+		for _, table := range TABLES {
+			if _, err := q.exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", table.Name)); err != nil {
+				logger.Errorf(ctx, "Unable to drop table %s - %s", table.Name, err)
+				return err
+			}
+
+			logger.Infof(ctx, "Dropped table %s", table.Name)
+		}
+
+		return nil
+	})
+}
+
+// LoadDatabase adds the users, keys, teams and members defined in admin.yaml that are not stored yet
+func LoadDatabase(ctx context.Context, conn *SQLConnection, dryRun bool) (*seed.Report, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "LoadDatabase"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	adminConfig, err := seed.ReadAdminConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return seed.Load(ctx, NewAdminSQLRepository(conn), adminConfig, dryRun)
+}
+
+func putTeamMember(ctx context.Context, q *sqlQuerier, member entity.TeamMember) error {
+	return TEAM_MEMBERS.Put(ctx, q, member, member.Key, member.TeamId, member.Email, member.Status, member.ExpiresOn)
+}
@@ -0,0 +1,135 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zbitech/common/interfaces"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/utils"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo"
+	"github.com/zbitech/repo/pkg/seed"
+)
+
+// SQLConfig selects the database driver and its data source. It is read from sql.yaml in the asset directory and
+// defaults to a SQLite database in zbirepo.sqlite in the same directory.
+type SQLConfig struct {
+	Driver     string
+	DataSource string
+}
+
+func ReadSQLConfig(ctx context.Context) (SQLConfig, error) {
+
+	cfg := SQLConfig{Driver: SQL_DRIVER_SQLITE, DataSource: fmt.Sprintf("%s/zbirepo.sqlite", vars.ASSET_PATH_DIRECTORY)}
+
+	configPath := fmt.Sprintf("%s/sql.yaml", vars.ASSET_PATH_DIRECTORY)
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return cfg, nil
+	}
+
+	if err := utils.ReadConfig(configPath, nil, &cfg); err != nil {
+		logger.Errorf(ctx, "Unable to read sql config: %s", err)
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+type SQLRepositoryFactory struct {
+	project interfaces.ProjectRepositoryIF
+	admin   interfaces.AdminRepositoryIF
+	conn    *SQLConnection
+}
+
+func init() {
+	repo.RegisterRepositoryFactory("sql", NewSQLRepositoryFactory)
+}
+
+func NewSQLRepositoryFactory() interfaces.RepositoryFactoryIF {
+	return &SQLRepositoryFactory{}
+}
+
+func (r *SQLRepositoryFactory) Init(ctx context.Context, create_db, load_db bool) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "SQLRepositoryFactory"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	cfg, err := ReadSQLConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if r.conn, err = NewSQLConnection(cfg.Driver, cfg.DataSource); err != nil {
+		return err
+	}
+
+	if err = r.conn.OpenConnection(ctx); err != nil {
+		return err
+	}
+
+	if create_db {
+		logger.Infof(ctx, "Creating SQL tables")
+		err = r.CreateDatabase(ctx, false, load_db)
+	} else {
+		err = CreateTables(ctx, r.conn)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = MigrateAPIKeys(ctx, r.conn); err != nil {
+		return err
+	}
+
+	r.project = NewProjectSQLRepository(r.conn)
+	r.admin = NewAdminSQLRepository(r.conn)
+
+	return nil
+}
+
+func (r *SQLRepositoryFactory) OpenConnection(ctx context.Context) error {
+	return r.conn.OpenConnection(ctx)
+}
+
+func (r *SQLRepositoryFactory) CloseConnection(ctx context.Context) error {
+	r.conn.CloseConnection(ctx)
+	return nil
+}
+
+func (r *SQLRepositoryFactory) GetProjectRepository() interfaces.ProjectRepositoryIF {
+	return r.project
+}
+
+func (r *SQLRepositoryFactory) GetAdminRepository() interfaces.AdminRepositoryIF {
+	return r.admin
+}
+
+func (r *SQLRepositoryFactory) CreateDatabase(ctx context.Context, purge, load bool) error {
+
+	if purge {
+		if err := DropTables(ctx, r.conn); err != nil {
+			return err
+		}
+	}
+
+	if err := CreateTables(ctx, r.conn); err != nil {
+		return err
+	}
+
+	if load {
+		_, err := r.Seed(ctx, false)
+		return err
+	}
+
+	return nil
+}
+
+// Seed adds the missing items of admin.yaml and reports what was created, or would be created in a dry run
+func (r *SQLRepositoryFactory) Seed(ctx context.Context, dryRun bool) (*seed.Report, error) {
+	return LoadDatabase(ctx, r.conn, dryRun)
+}
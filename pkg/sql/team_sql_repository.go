@@ -0,0 +1,363 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/zbitech/common/pkg/errs"
+	"github.com/zbitech/common/pkg/logger"
+	"github.com/zbitech/common/pkg/model/entity"
+	"github.com/zbitech/common/pkg/model/ztypes"
+	"github.com/zbitech/common/pkg/rctx"
+	"github.com/zbitech/common/pkg/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (m *AdminSQLRepository) findMembers(ctx context.Context, columns []string, values ...interface{}) ([]entity.TeamMember, error) {
+
+	members := make([]entity.TeamMember, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return TEAM_MEMBERS.Find(ctx, q, columns, values, func(doc bson.Raw) error {
+			var member entity.TeamMember
+			if err := bson.Unmarshal(doc, &member); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			members = append(members, member)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return members, nil
+}
+
+func (m *AdminSQLRepository) GetExpiringInvitations(ctx context.Context, date time.Time) ([]entity.TeamMember, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetExpiringInvitations"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findMembers(ctx, []string{"expireson"}, date)
+}
+
+func (m *AdminSQLRepository) PurgeExpiredInvitations(ctx context.Context) (int64, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "PurgeExpiredInvitations"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var count int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		var err error
+		count, err = TEAM_MEMBERS.Delete(ctx, q, []string{"status"}, ztypes.EXPIRED_INVITATION)
+		return err
+	})
+
+	if err != nil {
+		return 0, handleSQLError(ctx, err)
+	}
+
+	return count, nil
+}
+
+func (m *AdminSQLRepository) CreateTeam(ctx context.Context, team entity.Team) error {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "CreateTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		exists, err := TEAMS.Exists(ctx, q, team.TeamId)
+		if err != nil || exists {
+			return err
+		}
+
+		return TEAMS.Insert(ctx, q, team, team.TeamId, team.Owner)
+	})
+
+	if err != nil {
+		logger.Errorf(ctx, "Unable to insert team %s - %s", utils.MarshalObject(team), err)
+		return errs.ErrDBItemInsertFailed
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) findTeams(ctx context.Context, columns []string, values ...interface{}) ([]entity.Team, error) {
+
+	teams := make([]entity.Team, 0)
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return TEAMS.Find(ctx, q, columns, values, func(doc bson.Raw) error {
+			var team entity.Team
+			if err := bson.Unmarshal(doc, &team); err != nil {
+				return errs.ErrMarshalFailed
+			}
+			teams = append(teams, team)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return teams, nil
+}
+
+func (m *AdminSQLRepository) GetTeams(ctx context.Context) ([]entity.Team, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeams"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findTeams(ctx, nil)
+}
+
+func (m *AdminSQLRepository) GetTeam(ctx context.Context, teamId string) (*entity.Team, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	team := entity.Team{}
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return TEAMS.Decode(ctx, q, &team, teamId)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &team, nil
+}
+
+func (m *AdminSQLRepository) GetTeamByOwner(ctx context.Context, userid string) (*entity.Team, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "GetTeamByOwner"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	teams, err := m.findTeams(ctx, []string{"owner"}, userid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(teams) == 0 {
+		return nil, handleSQLError(ctx, errs.ErrDBItemNotFound)
+	}
+
+	return &teams[0], nil
+}
+
+func (m *AdminSQLRepository) GetTeamMembers(ctx context.Context, teamId string) ([]entity.TeamMember, error) {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMembers"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findMembers(ctx, []string{"teamid"}, teamId)
+}
+
+func (m *AdminSQLRepository) AddTeamMember(ctx context.Context, teamId string, member entity.TeamMember) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "setTeamMembers"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		count, err := TEAM_MEMBERS.Count(ctx, q, []string{"teamid", "email"}, teamId, member.Email)
+		if err != nil {
+			return err
+		} else if count > 0 {
+			return errs.ErrDBKeyAlreadyExists
+		}
+
+		return TEAM_MEMBERS.Insert(ctx, q, member, member.Key, member.TeamId, member.Email, member.Status, member.ExpiresOn)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	logger.Infof(ctx, "Inserted new member: %s, key: %s, team: %s", member.Email, member.Key, member.TeamId)
+	return nil
+}
+
+func (m *AdminSQLRepository) RemoveTeamMembers(ctx context.Context, teamId string, keys []string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "removeTeamMembers"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	var deleted int64
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		for _, key := range keys {
+			count, err := TEAM_MEMBERS.Delete(ctx, q, []string{"key"}, key)
+			if err != nil {
+				return err
+			}
+			deleted += count
+		}
+		return nil
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	logger.Infof(ctx, "Deleted %d members from team %s", deleted, teamId)
+
+	if deleted != int64(len(keys)) {
+		return errs.ErrDBItemDeleteFailed
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) RemoveTeamMember(ctx context.Context, teamId string, key string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "removeTeamMember"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.RemoveTeamMembers(ctx, teamId, []string{key})
+}
+
+// updateTeamMember applies change to the member with key in teamId and stores the result
+func (m *AdminSQLRepository) updateTeamMember(ctx context.Context, teamId, key string, change func(member *entity.TeamMember)) error {
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		member := entity.TeamMember{}
+		if err := TEAM_MEMBERS.Decode(ctx, q, &member, key); err != nil {
+			return err
+		}
+
+		if member.TeamId != teamId {
+			return errs.ErrDBItemNotFound
+		}
+
+		change(&member)
+		member.LastUpdate = time.Now()
+
+		return putTeamMember(ctx, q, member)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) UpdateTeamMemberEmail(ctx context.Context, teamId, key, email string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "updateTeamMemberEmail"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateTeamMember(ctx, teamId, key, func(member *entity.TeamMember) {
+		member.Email = email
+	})
+}
+
+func (m *AdminSQLRepository) UpdateTeamMemberRole(ctx context.Context, teamId, key string, role ztypes.Role) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "updateTeamMemberRole"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateTeamMember(ctx, teamId, key, func(member *entity.TeamMember) {
+		member.Role = role
+	})
+}
+
+func (m *AdminSQLRepository) UpdateTeamMemberStatus(ctx context.Context, teamId, key string, status ztypes.InvitationStatus) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "updateTeamMemberStatus"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.updateTeamMember(ctx, teamId, key, func(member *entity.TeamMember) {
+		member.Status = status
+	})
+}
+
+func (m *AdminSQLRepository) UpdateTeam(ctx context.Context, team entity.Team) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "updateTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		exists, err := TEAMS.Exists(ctx, q, team.TeamId)
+		if err != nil {
+			return err
+		} else if !exists {
+			return errs.ErrDBItemNotFound
+		}
+
+		return TEAMS.Put(ctx, q, team, team.TeamId, team.Owner)
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) DeleteTeam(ctx context.Context, teamId string) error {
+
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "deleteTeam"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	err := m.conn.Update(ctx, func(q *sqlQuerier) error {
+		count, err := TEAMS.Delete(ctx, q, []string{"teamid"}, teamId)
+		if err == nil && count == 0 {
+			return errs.ErrDBItemNotFound
+		}
+		return err
+	})
+
+	if err != nil {
+		return handleSQLError(ctx, err)
+	}
+
+	return nil
+}
+
+func (m *AdminSQLRepository) GetAllMemberships(ctx context.Context) ([]entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMemberships"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findMembers(ctx, nil)
+}
+
+func (m *AdminSQLRepository) GetTeamMemberships(ctx context.Context, email string) ([]entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMemberships"), rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	return m.findMembers(ctx, []string{"email"}, email)
+}
+
+func (m *AdminSQLRepository) GetTeamMembership(ctx context.Context, key string) (*entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMembership"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	member := entity.TeamMember{}
+	err := m.conn.View(ctx, func(q *sqlQuerier) error {
+		return TEAM_MEMBERS.Decode(ctx, q, &member, key)
+	})
+
+	if err != nil {
+		return nil, handleSQLError(ctx, err)
+	}
+
+	return &member, nil
+}
+
+func (m *AdminSQLRepository) GetTeamMembershipByEmail(ctx context.Context, teamId, email string) (*entity.TeamMember, error) {
+	ctx = rctx.BuildContext(ctx, rctx.Context(rctx.Component, "getTeamMembershipByEmail"),
+		rctx.Context(rctx.StartTime, time.Now()))
+	defer logger.LogComponentTime(ctx)
+
+	members, err := m.findMembers(ctx, []string{"teamid", "email"}, teamId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) == 0 {
+		return nil, handleSQLError(ctx, errs.ErrDBItemNotFound)
+	}
+
+	return &members[0], nil
+}
@@ -2,7 +2,11 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
+
 	"github.com/zbitech/repo/pkg/iam"
 	"github.com/zbitech/repo/pkg/memory"
 	"github.com/zbitech/repo/pkg/mongodb"
@@ -12,22 +16,121 @@ import (
 	"github.com/zbitech/common/pkg/vars"
 )
 
-func NewRepositoryFactory(ctx context.Context) interfaces.RepositoryFactoryIF {
+const DEFAULT_AUTHORIZATION_FACTORY = "basic"
+
+var (
+	ErrUnknownRepositoryFactory    = errors.New("unknown repository factory")
+	ErrUnknownAuthorizationFactory = errors.New("unknown authorization factory")
+
+	factoriesMu            sync.RWMutex
+	repositoryFactories    = make(map[string]func() interfaces.RepositoryFactoryIF)
+	authorizationFactories = make(map[string]func() interfaces.AuthorizationFactoryIF)
+)
+
+// The mongo and memory factories are always available, as they were before the registry. The bolt and sql
+// factories register themselves when their packages are imported, so callers only link the backends they use:
+//
+//	import _ "github.com/zbitech/repo/pkg/sql"
+func init() {
+	RegisterRepositoryFactory("mongo", mongodb.NewMongoRepositoryFactory)
+	RegisterRepositoryFactory("memory", memory.NewMemoryRepositoryFactory)
+	RegisterAuthorizationFactory(DEFAULT_AUTHORIZATION_FACTORY, iam.NewBasicAuthorizationFactory)
+}
+
+// RegisterRepositoryFactory makes a repository factory available under name so it can be selected with
+// Database.Factory. It panics if factory is nil or name is already registered.
+func RegisterRepositoryFactory(name string, factory func() interfaces.RepositoryFactoryIF) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("repo: RegisterRepositoryFactory factory is nil")
+	}
+
+	if _, dup := repositoryFactories[name]; dup {
+		panic("repo: RegisterRepositoryFactory called twice for " + name)
+	}
+
+	repositoryFactories[name] = factory
+}
+
+// RegisterAuthorizationFactory makes an authorization factory available under name. It panics if factory is nil
+// or name is already registered.
+func RegisterAuthorizationFactory(name string, factory func() interfaces.AuthorizationFactoryIF) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("repo: RegisterAuthorizationFactory factory is nil")
+	}
+
+	if _, dup := authorizationFactories[name]; dup {
+		panic("repo: RegisterAuthorizationFactory called twice for " + name)
+	}
+
+	authorizationFactories[name] = factory
+}
+
+// RepositoryFactories returns the sorted names of the registered repository factories
+func RepositoryFactories() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(repositoryFactories))
+	for name := range repositoryFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AuthorizationFactories returns the sorted names of the registered authorization factories
+func AuthorizationFactories() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(authorizationFactories))
+	for name := range authorizationFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func NewRepositoryFactory(ctx context.Context) (interfaces.RepositoryFactoryIF, error) {
 
 	dbFactory := vars.AppConfig.Database.Factory
 
 	logger.Infof(ctx, "Creating db factory for %s", dbFactory)
 
-	switch dbFactory {
-	case "mongo":
-		return mongodb.NewMongoRepositoryFactory()
-	case "memory":
-		return memory.NewMemoryRepositoryFactory()
+	factoriesMu.RLock()
+	factory, ok := repositoryFactories[dbFactory]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownRepositoryFactory, dbFactory)
 	}
 
-	panic(fmt.Errorf("Unknown database type %s", dbFactory))
+	return factory(), nil
 }
 
-func NewAuthorizationFactory(ctx context.Context) interfaces.AuthorizationFactoryIF {
-	return iam.NewBasicAuthorizationFactory()
+// NewAuthorizationFactory creates the authorization factory registered under name. The basic factory is used when
+// name is empty.
+func NewAuthorizationFactory(ctx context.Context, name string) (interfaces.AuthorizationFactoryIF, error) {
+
+	if len(name) == 0 {
+		name = DEFAULT_AUTHORIZATION_FACTORY
+	}
+
+	logger.Infof(ctx, "Creating authorization factory for %s", name)
+
+	factoriesMu.RLock()
+	factory, ok := authorizationFactories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownAuthorizationFactory, name)
+	}
+
+	return factory(), nil
 }
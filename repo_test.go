@@ -0,0 +1,68 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zbitech/common/interfaces"
+	"github.com/zbitech/common/pkg/vars"
+	"github.com/zbitech/repo"
+	"github.com/zbitech/repo/pkg/memory"
+)
+
+func Test_NewRepositoryFactory(t *testing.T) {
+
+	ctx := context.Background()
+	factory := vars.AppConfig.Database.Factory
+	defer func() { vars.AppConfig.Database.Factory = factory }()
+
+	for _, name := range []string{"mongo", "memory"} {
+		vars.AppConfig.Database.Factory = name
+		if f, err := repo.NewRepositoryFactory(ctx); err != nil || f == nil {
+			t.Fatalf("Expected %s factory but got %v - %v", name, f, err)
+		}
+	}
+
+	vars.AppConfig.Database.Factory = "unknown"
+	if _, err := repo.NewRepositoryFactory(ctx); !errors.Is(err, repo.ErrUnknownRepositoryFactory) {
+		t.Fatalf("Expected unknown factory error but got %v", err)
+	}
+}
+
+func Test_RegisterRepositoryFactory(t *testing.T) {
+
+	ctx := context.Background()
+	factory := vars.AppConfig.Database.Factory
+	defer func() { vars.AppConfig.Database.Factory = factory }()
+
+	created := false
+	repo.RegisterRepositoryFactory("custom", func() interfaces.RepositoryFactoryIF {
+		created = true
+		return memory.NewMemoryRepositoryFactory()
+	})
+
+	vars.AppConfig.Database.Factory = "custom"
+	if _, err := repo.NewRepositoryFactory(ctx); err != nil || !created {
+		t.Fatalf("Expected custom factory to be created but got err - %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected duplicate registration to panic")
+		}
+	}()
+	repo.RegisterRepositoryFactory("custom", memory.NewMemoryRepositoryFactory)
+}
+
+func Test_NewAuthorizationFactory(t *testing.T) {
+
+	ctx := context.Background()
+	if f, err := repo.NewAuthorizationFactory(ctx, ""); err != nil || f == nil {
+		t.Fatalf("Expected basic factory but got %v - %v", f, err)
+	}
+
+	if _, err := repo.NewAuthorizationFactory(ctx, "unknown"); !errors.Is(err, repo.ErrUnknownAuthorizationFactory) {
+		t.Fatalf("Expected unknown factory error but got %v", err)
+	}
+}